@@ -0,0 +1,249 @@
+// Package audit keeps an append-only, hash chained log of administrative and
+// data-changing actions
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+// ErrChainBroken is returned when an entry does not match its hash or the
+// hash of the entry before it
+var ErrChainBroken = errors.New("audit hash chain is broken")
+
+// Log is an append-only audit log. Entries are kept in memory and, when the
+// log is opened from a file, appended to it as JSON lines.
+type Log struct {
+	mu      sync.Mutex
+	entries []models.AuditEntry
+	file    *os.File
+}
+
+// Filter selects audit entries; zero fields match everything
+type Filter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Offset     int
+}
+
+// NewLog creates an in-memory audit log
+func NewLog() *Log {
+	return &Log{}
+}
+
+// Open loads the audit log stored at path, verifying its hash chain, and
+// appends new entries to it
+func Open(path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry models.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("corrupt audit log entry %d: %w", len(l.entries)+1, err)
+		}
+		l.entries = append(l.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if seq, err := l.verify(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("entry %d: %w", seq, err)
+	}
+	return l, nil
+}
+
+// Close closes the file backing the log, if any
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Append assigns the entry its sequence number and chain hashes, computes the
+// field changes between Before and After and adds it to the log
+func (l *Log) Append(entry models.AuditEntry) (models.AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Sequence = int64(len(l.entries)) + 1
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	entry.Timestamp = entry.Timestamp.UTC()
+	entry.Changes = Diff(entry.Before, entry.After)
+	entry.PrevHash = ""
+	if len(l.entries) > 0 {
+		entry.PrevHash = l.entries[len(l.entries)-1].Hash
+	}
+
+	hash, err := hashEntry(entry)
+	if err != nil {
+		return entry, err
+	}
+	entry.Hash = hash
+
+	if l.file != nil {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return entry, err
+		}
+		if _, err := l.file.Write(append(line, '\n')); err != nil {
+			return entry, err
+		}
+	}
+
+	l.entries = append(l.entries, entry)
+	return entry, nil
+}
+
+// Query returns the entries matching the filter, newest first, and the
+// number of matching entries before limit and offset are applied
+func (l *Log) Query(f Filter) ([]models.AuditEntry, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	matched := make([]models.AuditEntry, 0)
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		e := l.entries[i]
+		if f.Actor != "" && e.Actor != f.Actor && e.ActorID.String() != f.Actor {
+			continue
+		}
+		if f.Action != "" && e.Action != f.Action {
+			continue
+		}
+		if f.TargetType != "" && e.TargetType != f.TargetType {
+			continue
+		}
+		if f.TargetID != "" && e.TargetID != f.TargetID {
+			continue
+		}
+		if !f.From.IsZero() && e.Timestamp.Before(f.From) {
+			continue
+		}
+		if !f.To.IsZero() && e.Timestamp.After(f.To) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+
+	total := len(matched)
+	if f.Offset > 0 {
+		matched = matched[min(f.Offset, len(matched)):]
+	}
+	if f.Limit > 0 && len(matched) > f.Limit {
+		matched = matched[:f.Limit]
+	}
+	return matched, total
+}
+
+// Len returns the number of entries in the log
+func (l *Log) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// Verify walks the hash chain and returns the sequence number of the first
+// entry that does not match, with ErrChainBroken
+func (l *Log) Verify() (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.verify()
+}
+
+func (l *Log) verify() (int64, error) {
+	prev := ""
+	for i, entry := range l.entries {
+		if entry.Sequence != int64(i)+1 || entry.PrevHash != prev {
+			return int64(i) + 1, ErrChainBroken
+		}
+		hash, err := hashEntry(entry)
+		if err != nil || hash != entry.Hash {
+			return int64(i) + 1, ErrChainBroken
+		}
+		prev = entry.Hash
+	}
+	return 0, nil
+}
+
+// Diff lists the top-level fields that differ between two JSON objects
+func Diff(before, after json.RawMessage) []models.AuditChange {
+	var b, a map[string]json.RawMessage
+	if len(before) > 0 {
+		json.Unmarshal(before, &b)
+	}
+	if len(after) > 0 {
+		json.Unmarshal(after, &a)
+	}
+
+	fields := make(map[string]bool)
+	for k := range b {
+		fields[k] = true
+	}
+	for k := range a {
+		fields[k] = true
+	}
+
+	changes := make([]models.AuditChange, 0)
+	for field := range fields {
+		if bytes.Equal(b[field], a[field]) {
+			continue
+		}
+		changes = append(changes, models.AuditChange{Field: field, Before: b[field], After: a[field]})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// hashEntry computes the chain hash of an entry, which covers every field
+// except the hash itself
+func hashEntry(entry models.AuditEntry) (string, error) {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
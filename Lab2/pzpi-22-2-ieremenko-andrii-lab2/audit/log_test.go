@@ -0,0 +1,172 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+// appendEntries appends n entries to l
+func appendEntries(t *testing.T, l *Log, n int) []models.AuditEntry {
+	t.Helper()
+	var entries []models.AuditEntry
+	for range n {
+		entry, err := l.Append(models.AuditEntry{Action: "room.update", TargetType: "room", Actor: "admin",
+			Before: json.RawMessage(`{"name":"Flat"}`), After: json.RawMessage(`{"name":"Flat 2"}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAppend(t *testing.T) {
+	l := NewLog()
+	entries := appendEntries(t, l, 3)
+	for i, entry := range entries {
+		if entry.Sequence != int64(i)+1 || entry.Hash == "" {
+			t.Errorf("entry %d: got sequence %d and hash %q", i, entry.Sequence, entry.Hash)
+		}
+		if i > 0 && entry.PrevHash != entries[i-1].Hash {
+			t.Errorf("entry %d does not chain to the one before", i)
+		}
+	}
+	if entries[0].PrevHash != "" {
+		t.Errorf("got the first entry chained to %q", entries[0].PrevHash)
+	}
+	if changes := entries[0].Changes; len(changes) != 1 || changes[0].Field != "name" {
+		t.Errorf("got changes %+v, want the name", changes)
+	}
+	if seq, err := l.Verify(); err != nil {
+		t.Errorf("entry %d: %v", seq, err)
+	}
+}
+
+func TestVerifyTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(entries []models.AuditEntry) []models.AuditEntry
+		want   int64
+	}{
+		{"changed field", func(e []models.AuditEntry) []models.AuditEntry { e[1].Actor = "mallory"; return e }, 2},
+		{"changed state", func(e []models.AuditEntry) []models.AuditEntry { e[2].After = json.RawMessage(`{}`); return e }, 3},
+		{"rehashed entry", func(e []models.AuditEntry) []models.AuditEntry {
+			e[0].Action = "room.create"
+			e[0].Hash, _ = hashEntry(e[0])
+			return e
+		}, 2},
+		{"removed entry", func(e []models.AuditEntry) []models.AuditEntry { return append(e[:1], e[2:]...) }, 2},
+		{"reordered entries", func(e []models.AuditEntry) []models.AuditEntry { e[1], e[2] = e[2], e[1]; return e }, 2},
+		{"removed last entry", func(e []models.AuditEntry) []models.AuditEntry { return e[:2] }, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLog()
+			appendEntries(t, l, 3)
+			l.entries = tt.tamper(l.entries)
+			seq, err := l.Verify()
+			if tt.want == 0 {
+				if err != nil {
+					t.Fatalf("got %v at entry %d", err, seq)
+				}
+				return
+			}
+			if !errors.Is(err, ErrChainBroken) || seq != tt.want {
+				t.Errorf("got %v at entry %d, want the chain broken at %d", err, seq, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := appendEntries(t, l, 2)
+	l.Close()
+
+	// Reopening loads the entries and continues the chain
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 2 {
+		t.Fatalf("got %d entries, want 2", l.Len())
+	}
+	next := appendEntries(t, l, 1)[0]
+	if next.Sequence != 3 || next.PrevHash != first[1].Hash {
+		t.Errorf("got sequence %d chained to %q, want 3 chained to the last entry", next.Sequence, next.PrevHash)
+	}
+	l.Close()
+
+	// Blank lines are skipped
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, append(data, '\n'), 0o600)
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 3 {
+		t.Errorf("got %d entries, want 3", l.Len())
+	}
+	l.Close()
+}
+
+func TestOpenTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		want   string
+	}{
+		{"changed entry", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], `"actor":"admin"`, `"actor":"mallory"`, 1)
+			return lines
+		}, "entry 2: " + ErrChainBroken.Error()},
+		{"removed entry", func(lines []string) []string { return append(lines[:0], lines[1:]...) }, "entry 1: " + ErrChainBroken.Error()},
+		{"corrupt entry", func(lines []string) []string { lines[2] = "{"; return lines }, "corrupt audit log entry 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.log")
+			l, err := Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			appendEntries(t, l, 3)
+			l.Close()
+
+			data, _ := os.ReadFile(path)
+			lines := tt.tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+			os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+
+			if l, err := Open(path); err == nil || !strings.Contains(err.Error(), tt.want) {
+				if l != nil {
+					l.Close()
+				}
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	changes := Diff(json.RawMessage(`{"name":"Flat","area":50,"gone":true}`), json.RawMessage(`{"name":"Flat","area":60,"new":1}`))
+	var fields []string
+	for _, c := range changes {
+		fields = append(fields, c.Field)
+	}
+	if strings.Join(fields, ",") != "area,gone,new" {
+		t.Errorf("got changes of %v, want area, gone and new", fields)
+	}
+	if Diff(nil, nil) != nil || Diff(json.RawMessage(`{"a":1}`), json.RawMessage(`{"a":1}`)) != nil {
+		t.Error("got changes between equal objects")
+	}
+}
@@ -9,7 +9,13 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey = []byte("your-secret-key") // In production, use environment variable
+var jwtKey = []byte("your-secret-key") // replaced by SetSecret at startup
+
+// SetSecret sets the key tokens are signed with. Tokens signed with the
+// previous key are no longer accepted.
+func SetSecret(secret []byte) {
+	jwtKey = secret
+}
 
 // Claims represents the JWT claims
 type Claims struct {
@@ -18,13 +24,18 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-// GenerateToken generates a new JWT token for the user
-func GenerateToken(user *models.User) (string, error) {
+// TokenTTL is how long a token stays valid
+var TokenTTL = 24 * time.Hour
+
+// GenerateToken generates a new JWT token for the user. The session ID is
+// carried in the jti claim so that the token can be revoked.
+func GenerateToken(user *models.User, sessionID string) (string, error) {
 	claims := &Claims{
 		UserID:   user.ID.String(),
 		Username: user.Username,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
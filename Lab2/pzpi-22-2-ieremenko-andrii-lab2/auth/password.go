@@ -0,0 +1,33 @@
+package auth
+
+import "golang.org/x/crypto/bcrypt"
+
+// ErrPasswordTooLong is returned for passwords bcrypt cannot hash
+var ErrPasswordTooLong = bcrypt.ErrPasswordTooLong
+
+// PasswordCost is the bcrypt cost passwords are hashed with
+var PasswordCost = bcrypt.DefaultCost
+
+// unknownUserHash is compared with when a user does not exist, so that
+// logging in as them takes as long as with a wrong password
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+
+// HashPassword returns the bcrypt hash of a password, which is what is kept
+// of it in memory and on disk
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches a hash made by
+// HashPassword. An empty hash matches no password.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
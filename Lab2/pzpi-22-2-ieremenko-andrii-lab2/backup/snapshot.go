@@ -0,0 +1,296 @@
+// Package backup encodes point-in-time snapshots of the server state and
+// keeps them on disk
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// magic starts every backup file
+	magic = "HMBK"
+	// formatVersion is the version of the snapshot layout written by Encode
+	formatVersion = 1
+	// flagEncrypted marks a payload sealed with AES-GCM
+	flagEncrypted = 1
+)
+
+var (
+	// ErrInvalidFormat is returned for data that is not a backup file
+	ErrInvalidFormat = errors.New("not a backup file")
+	// ErrKeyRequired is returned when decoding an encrypted backup without a key
+	ErrKeyRequired = errors.New("backup is encrypted and no key is configured")
+)
+
+// User is a user record including the bcrypt hash of its password, which
+// models.User never serializes
+type User struct {
+	models.User
+	PasswordHash string `json:"password_hash"`
+	// Password is the plaintext password kept by snapshots taken before
+	// passwords were hashed. It is never written, and is hashed when such a
+	// snapshot is restored.
+	Password string `json:"password,omitempty"`
+}
+
+// Device is a device record including the hashes of its API keys and its
+// OMS key, which models.Device never serializes
+type Device struct {
+	models.Device
+	Keys   []DeviceKey `json:"keys"`
+	OMSKey string      `json:"oms_key,omitempty"`
+}
+
+// DeviceKey is an API key record including its hash
+type DeviceKey struct {
+	models.DeviceKey
+	Hash string `json:"hash"`
+}
+
+// Snapshot is the complete state of the server at one point in time
+type Snapshot struct {
+	Version   int                    `json:"version"`
+	CreatedAt time.Time              `json:"created_at"`
+	Users     []User                 `json:"users"`
+	Roles     []models.Role          `json:"roles"`
+	Rooms     []models.Room          `json:"rooms"`
+	Metrics   []models.Metric        `json:"metrics"`
+	Readings  []models.MetricReading `json:"readings"`
+	Devices   []Device               `json:"devices,omitempty"`
+	// Retention holds the retention policies, and Rollups the readings
+	// compacted by them
+	Retention []models.RetentionPolicy `json:"retention,omitempty"`
+	Rollups   []models.Rollup          `json:"rollups,omitempty"`
+	// Outbox holds the events waiting for durable subscribers. They are
+	// delivered when the state is loaded, but not when a backup is restored.
+	Outbox []models.OutboxEvent `json:"outbox,omitempty"`
+	// Sessions holds the logins that have not expired, so that tokens stay
+	// valid across restarts. Only the state file has them, not backups.
+	Sessions []models.Session `json:"sessions,omitempty"`
+}
+
+// NewSnapshot creates an empty snapshot taken at the given time
+func NewSnapshot(takenAt time.Time) *Snapshot {
+	return &Snapshot{Version: formatVersion, CreatedAt: takenAt}
+}
+
+// ParseKey decodes a hex encoded AES-256 key; an empty string means no key
+func ParseKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid backup key: want 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// Encode writes the snapshot as gzip compressed JSON, sealed with AES-GCM
+// when a key is given
+func Encode(w io.Writer, snap *Snapshot, key []byte) error {
+	var payload bytes.Buffer
+	zw := gzip.NewWriter(&payload)
+	if err := json.NewEncoder(zw).Encode(snap); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	flags := byte(0)
+	data := payload.Bytes()
+	if key != nil {
+		gcm, err := newGCM(key)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		data = gcm.Seal(nonce, nonce, data, []byte(magic))
+		flags |= flagEncrypted
+	}
+
+	if _, err := w.Write(append([]byte(magic), formatVersion, flags)); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// Decode reads a backup file and validates the snapshot it contains
+func Decode(data []byte, key []byte) (*Snapshot, error) {
+	if len(data) < len(magic)+2 || string(data[:len(magic)]) != magic {
+		return nil, ErrInvalidFormat
+	}
+	if version := data[len(magic)]; version != formatVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", version)
+	}
+	flags := data[len(magic)+1]
+	payload := data[len(magic)+2:]
+
+	if flags&flagEncrypted != 0 {
+		if key == nil {
+			return nil, ErrKeyRequired
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(payload) < gcm.NonceSize() {
+			return nil, ErrInvalidFormat
+		}
+		nonce, sealed := payload[:gcm.NonceSize()], payload[gcm.NonceSize():]
+		if payload, err = gcm.Open(nil, nonce, sealed, []byte(magic)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt backup: %w", err)
+		}
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress backup: %w", err)
+	}
+	defer zr.Close()
+
+	var snap Snapshot
+	if err := json.NewDecoder(zr).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("failed to decode backup: %w", err)
+	}
+
+	if err := snap.Validate(); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// Validate checks that the snapshot is internally consistent: identifiers
+// are unique, the built-in roles are there and every metric and reading
+// refers to a record in the snapshot
+func (snap *Snapshot) Validate() error {
+	if snap.Version != formatVersion {
+		return fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	users := make(map[uuid.UUID]bool, len(snap.Users))
+	usernames := make(map[string]bool, len(snap.Users))
+	for _, u := range snap.Users {
+		if users[u.ID] || usernames[u.Username] {
+			return fmt.Errorf("duplicate user %s", u.Username)
+		}
+		users[u.ID] = true
+		usernames[u.Username] = true
+	}
+
+	roles := make(map[string]bool, len(snap.Roles))
+	for _, role := range snap.Roles {
+		if role.Name == "" || roles[role.Name] {
+			return fmt.Errorf("duplicate or unnamed role %q", role.Name)
+		}
+		roles[role.Name] = true
+	}
+	// The server relies on the built-in roles, giving new users the user role
+	for _, name := range []string{"admin", "user"} {
+		if !roles[name] {
+			return fmt.Errorf("built-in role %q is missing", name)
+		}
+	}
+	for _, u := range snap.Users {
+		for _, role := range u.Roles {
+			if !roles[role.Name] {
+				return fmt.Errorf("user %s has unknown role %q", u.Username, role.Name)
+			}
+		}
+	}
+
+	rooms := make(map[uuid.UUID]bool, len(snap.Rooms))
+	for _, room := range snap.Rooms {
+		if rooms[room.ID] {
+			return fmt.Errorf("duplicate room %s", room.ID)
+		}
+		rooms[room.ID] = true
+	}
+
+	metrics := make(map[uuid.UUID]bool, len(snap.Metrics))
+	for _, metric := range snap.Metrics {
+		if metrics[metric.ID] {
+			return fmt.Errorf("duplicate metric %s", metric.ID)
+		}
+		if metric.RoomID != uuid.Nil && !rooms[metric.RoomID] {
+			return fmt.Errorf("metric %s refers to unknown room %s", metric.ID, metric.RoomID)
+		}
+		metrics[metric.ID] = true
+	}
+
+	readings := make(map[uuid.UUID]bool, len(snap.Readings))
+	for _, reading := range snap.Readings {
+		if readings[reading.ID] {
+			return fmt.Errorf("duplicate reading %s", reading.ID)
+		}
+		if !metrics[reading.MetricID] {
+			return fmt.Errorf("reading %s refers to unknown metric %s", reading.ID, reading.MetricID)
+		}
+		readings[reading.ID] = true
+	}
+
+	policies := make(map[uuid.UUID]bool, len(snap.Retention))
+	for _, policy := range snap.Retention {
+		if policies[policy.ID] {
+			return fmt.Errorf("duplicate retention policy %s", policy.ID)
+		}
+		if policy.MetricID != nil && !metrics[*policy.MetricID] {
+			return fmt.Errorf("retention policy %s refers to unknown metric %s", policy.ID, *policy.MetricID)
+		}
+		policies[policy.ID] = true
+	}
+
+	for _, rollup := range snap.Rollups {
+		if !metrics[rollup.MetricID] {
+			return fmt.Errorf("rollup refers to unknown metric %s", rollup.MetricID)
+		}
+		if rollup.Resolution != models.ResolutionHour && rollup.Resolution != models.ResolutionDay {
+			return fmt.Errorf("rollup of metric %s has invalid resolution %q", rollup.MetricID, rollup.Resolution)
+		}
+	}
+
+	devices := make(map[uuid.UUID]bool, len(snap.Devices))
+	for _, device := range snap.Devices {
+		if devices[device.ID] {
+			return fmt.Errorf("duplicate device %s", device.ID)
+		}
+		for _, id := range device.MetricIDs {
+			if !metrics[id] {
+				return fmt.Errorf("device %s refers to unknown metric %s", device.ID, id)
+			}
+		}
+		devices[device.ID] = true
+	}
+
+	return nil
+}
+
+// newGCM creates an AES-GCM cipher for the key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,193 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotFound is returned for an unknown backup ID
+	ErrNotFound = errors.New("backup not found")
+	// ErrChecksumMismatch is returned when a stored backup has been altered
+	ErrChecksumMismatch = errors.New("backup checksum mismatch")
+)
+
+// Store keeps backup files and their metadata in a directory
+type Store struct {
+	mu  sync.Mutex
+	dir string
+	key []byte
+}
+
+// NewStore creates a store in dir; backups are encrypted when key is set
+func NewStore(dir string, key []byte) *Store {
+	return &Store{dir: dir, key: key}
+}
+
+// Save encodes the snapshot and writes it to the store
+func (st *Store) Save(snap *Snapshot, scheduled bool) (*models.Backup, error) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, snap, st.key); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	meta := &models.Backup{
+		ID:        uuid.New(),
+		CreatedAt: snap.CreatedAt,
+		Size:      int64(buf.Len()),
+		Checksum:  hex.EncodeToString(sum[:]),
+		Encrypted: st.key != nil,
+		Scheduled: scheduled,
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := os.MkdirAll(st.dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	// The data file is written first so that a listed backup always has one
+	if err := writeFileAtomic(st.dataPath(meta.ID), buf.Bytes()); err != nil {
+		return nil, err
+	}
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(st.metaPath(meta.ID), metaJSON); err != nil {
+		os.Remove(st.dataPath(meta.ID))
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// List returns the stored backups, newest first
+func (st *Store) List() ([]models.Backup, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	entries, err := os.ReadDir(st.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []models.Backup{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]models.Backup, 0)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(st.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var meta models.Backup
+		if err := json.Unmarshal(data, &meta); err != nil {
+			continue
+		}
+		backups = append(backups, meta)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+// Read returns the metadata and contents of a backup after verifying its
+// checksum
+func (st *Store) Read(id uuid.UUID) (*models.Backup, []byte, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	metaJSON, err := os.ReadFile(st.metaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var meta models.Backup
+	if err := json.Unmarshal(metaJSON, &meta); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := os.ReadFile(st.dataPath(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != meta.Checksum {
+		return nil, nil, ErrChecksumMismatch
+	}
+
+	return &meta, data, nil
+}
+
+// Decode decodes backup data with the key of the store
+func (st *Store) Decode(data []byte) (*Snapshot, error) {
+	return Decode(data, st.key)
+}
+
+// Prune deletes the oldest scheduled backups so that at most retain of
+// them are kept. Backups taken on demand are never pruned.
+func (st *Store) Prune(retain int) error {
+	backups, err := st.List()
+	if err != nil {
+		return err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	kept := 0
+	for _, meta := range backups {
+		if !meta.Scheduled {
+			continue
+		}
+		if kept < retain {
+			kept++
+			continue
+		}
+		if err := os.Remove(st.metaPath(meta.ID)); err != nil {
+			return err
+		}
+		if err := os.Remove(st.dataPath(meta.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *Store) dataPath(id uuid.UUID) string {
+	return filepath.Join(st.dir, id.String()+".hmbk")
+}
+
+func (st *Store) metaPath(id uuid.UUID) string {
+	return filepath.Join(st.dir, id.String()+".json")
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
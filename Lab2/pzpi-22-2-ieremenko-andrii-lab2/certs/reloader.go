@@ -0,0 +1,139 @@
+// Package certs serves TLS with certificates that can be replaced while the
+// server runs, e.g. when they are renewed by an ACME client.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkInterval is how often the files are checked for changes
+const checkInterval = 10 * time.Second
+
+// Reloader holds a server certificate, and optionally the CA pool client
+// certificates are verified against, loaded from files. The files are
+// loaded again when they change or when Reload is called.
+type Reloader struct {
+	certFile, keyFile, clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  [3]time.Time
+	checked   time.Time
+}
+
+// NewReloader loads the certificate and key, and the client CA certificates
+// if clientCAFile is not empty
+func NewReloader(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the files again. The previous certificates stay in use if
+// they cannot be loaded.
+func (r *Reloader) Reload() error {
+	modTimes, err := r.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return errors.New("failed to load client CA: no certificates found")
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.checked = time.Now()
+	return nil
+}
+
+// stat returns the modification times of the files
+func (r *Reloader) stat() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, name := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// maybeReload reloads the files if they have changed since they were last
+// checked, at most every checkInterval
+func (r *Reloader) maybeReload() {
+	r.mu.RLock()
+	due := time.Since(r.checked) >= checkInterval
+	r.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	r.mu.Lock()
+	r.checked = time.Now()
+	previous := r.modTimes
+	r.mu.Unlock()
+
+	// Files being replaced may be missing or half written for a moment;
+	// the next check picks them up
+	modTimes, err := r.stat()
+	if err != nil || modTimes == previous {
+		return
+	}
+	r.Reload()
+}
+
+// TLSConfig returns a server configuration presenting the current
+// certificate. With client CAs configured, clients may present a
+// certificate, which is then verified; requests without one are left to
+// other authentication.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.maybeReload()
+
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if r.clientCAs != nil {
+				config.ClientCAs = r.clientCAs
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return config, nil
+		},
+	}
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// CreateBackup takes a backup of the server state; administrators only
+func (c *Client) CreateBackup(ctx context.Context) (*models.Backup, error) {
+	var backup models.Backup
+	if err := c.do(ctx, http.MethodPost, "/admin/backups", nil, nil, &backup); err != nil {
+		return nil, err
+	}
+	return &backup, nil
+}
+
+// ListBackups returns the stored backups, newest first; administrators only
+func (c *Client) ListBackups(ctx context.Context) (*models.BackupListResponse, error) {
+	var backups models.BackupListResponse
+	if err := c.do(ctx, http.MethodGet, "/admin/backups", nil, nil, &backups); err != nil {
+		return nil, err
+	}
+	return &backups, nil
+}
+
+// DownloadBackup writes a stored backup file to w; administrators only
+func (c *Client) DownloadBackup(ctx context.Context, id uuid.UUID, w io.Writer) error {
+	resp, err := c.stream(ctx, http.MethodGet, "/admin/backups/"+id.String(), nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to download backup: %w", err)
+	}
+	return nil
+}
+
+// RestoreBackup replaces the server state with a stored backup;
+// administrators only
+func (c *Client) RestoreBackup(ctx context.Context, id uuid.UUID) (*models.RestoreResponse, error) {
+	return c.restore(ctx, url.Values{"backup_id": {id.String()}}, nil)
+}
+
+// RestoreBackupFile replaces the server state with a backup file read from
+// r; administrators only
+func (c *Client) RestoreBackupFile(ctx context.Context, r io.Reader) (*models.RestoreResponse, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup file: %w", err)
+	}
+	return c.restore(ctx, nil, data)
+}
+
+func (c *Client) restore(ctx context.Context, query url.Values, data []byte) (*models.RestoreResponse, error) {
+	resp, err := c.stream(ctx, http.MethodPost, "/admin/restore", query, data, "application/octet-stream")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var restored models.RestoreResponse
+	if err := json.NewDecoder(resp.Body).Decode(&restored); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &restored, nil
+}
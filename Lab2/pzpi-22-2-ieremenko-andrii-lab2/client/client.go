@@ -1,120 +1,334 @@
+// Package client is a Go client for the household metrics API.
+//
+// Every call takes a context. Errors returned by the server are *APIError
+// values that match ErrNotFound, ErrUnauthorized and the other class errors
+// with errors.Is. After Login the client logs in again by itself when its
+// token expires, and idempotent calls are retried with backoff on network
+// errors and temporary server failures.
 package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
-	"strings"
+	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/golang-jwt/jwt/v5"
 )
 
+// refreshMargin is how long before its expiry a token is replaced
+const refreshMargin = time.Minute
+
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
-	token      string
+	retry      RetryPolicy
+
+	mu          sync.Mutex
+	token       string
+	expiresAt   time.Time
+	credentials *models.LoginRequest // kept to log in again when the token expires
+	certAuth    bool                 // a client certificate authenticates requests without a token
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient makes the client send requests with httpClient
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetry sets how idempotent calls are retried
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithTLSConfig makes the client connect with the given TLS settings, e.g.
+// to trust a private CA. A client certificate in config authenticates the
+// client as a meter gateway, so that no login is needed.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+		c.httpClient.Transport = transport
+		c.certAuth = len(config.Certificates) > 0 || config.GetClientCertificate != nil
+	}
 }
 
-func NewClient(baseURL string) *Client {
-	return &Client{
+// WithToken makes the client authenticate with a token obtained elsewhere,
+// or with a device API key. Such a token is not refreshed.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.setToken(token)
+	}
+}
+
+func NewClient(baseURL string, opts ...Option) *Client {
+	c := &Client{
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Timeout: time.Second * 10,
 		},
+		retry: DefaultRetryPolicy,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-func (c *Client) Register(req models.RegisterRequest) (*models.AuthResponse, error) {
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
+// Token returns the token the client authenticates with
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
 
-	resp, err := c.httpClient.Post(
-		fmt.Sprintf("%s/register", c.baseURL),
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+func (c *Client) Register(ctx context.Context, req models.RegisterRequest) (*models.AuthResponse, error) {
+	var authResp models.AuthResponse
+	if err := c.send(ctx, http.MethodPost, "/register", nil, req, &authResp, false); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
+	c.mu.Lock()
+	c.credentials = &models.LoginRequest{Username: req.Username, Password: req.Password}
+	c.mu.Unlock()
+	c.setToken(authResp.Token)
+	return &authResp, nil
+}
 
+func (c *Client) Login(ctx context.Context, req models.LoginRequest) (*models.AuthResponse, error) {
 	var authResp models.AuthResponse
-	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if err := c.send(ctx, http.MethodPost, "/login", nil, req, &authResp, false); err != nil {
+		return nil, err
 	}
 
-	c.token = authResp.Token
+	c.mu.Lock()
+	c.credentials = &req
+	c.mu.Unlock()
+	c.setToken(authResp.Token)
 	return &authResp, nil
 }
 
-func (c *Client) Login(req models.LoginRequest) (*models.AuthResponse, error) {
-	jsonData, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+// setToken stores a token along with its expiry
+func (c *Client) setToken(token string) {
+	var expiresAt time.Time
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err == nil && claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
 	}
 
-	resp, err := c.httpClient.Post(
-		fmt.Sprintf("%s/login", c.baseURL),
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+	c.expiresAt = expiresAt
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+// refresh logs in again with the credentials of the last login, if any
+func (c *Client) refresh(ctx context.Context) (bool, error) {
+	c.mu.Lock()
+	credentials := c.credentials
+	c.mu.Unlock()
+	if credentials == nil {
+		return false, nil
 	}
 
 	var authResp models.AuthResponse
-	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if err := c.send(ctx, http.MethodPost, "/login", nil, credentials, &authResp, false); err != nil {
+		return false, fmt.Errorf("failed to refresh token: %w", err)
 	}
+	c.setToken(authResp.Token)
+	return true, nil
+}
 
-	c.token = authResp.Token
-	return &authResp, nil
+// authToken returns the token for a request, refreshing it when it is about
+// to expire
+func (c *Client) authToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	token, expiresAt, canRefresh, certAuth := c.token, c.expiresAt, c.credentials != nil, c.certAuth
+	c.mu.Unlock()
+
+	if token == "" && !canRefresh {
+		if certAuth {
+			return "", nil
+		}
+		return "", fmt.Errorf("not authenticated")
+	}
+	if canRefresh && (token == "" || (!expiresAt.IsZero() && time.Until(expiresAt) < refreshMargin)) {
+		if _, err := c.refresh(ctx); err != nil {
+			return "", err
+		}
+		return c.Token(), nil
+	}
+	return token, nil
 }
 
-func (c *Client) GetUser() (*models.User, error) {
-	if c.token == "" {
-		return nil, fmt.Errorf("not authenticated")
+// RequestOption adjusts a single request
+type RequestOption func(*http.Request)
+
+// IfMatch makes a change apply only to the given version of a resource; the
+// server answers ErrPreconditionFailed when it has changed since
+func IfMatch(version int64) RequestOption {
+	return func(req *http.Request) {
+		req.Header.Set("If-Match", `"`+strconv.FormatInt(version, 10)+`"`)
+	}
+}
+
+// do sends an authenticated request, logging in again once if the token
+// has been rejected
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}, opts ...RequestOption) error {
+	err := c.send(ctx, method, path, query, body, out, true, opts...)
+	if !errors.Is(err, ErrUnauthorized) {
+		return err
+	}
+
+	refreshed, refreshErr := c.refresh(ctx)
+	if refreshErr != nil || !refreshed {
+		return err
 	}
+	return c.send(ctx, method, path, query, body, out, true, opts...)
+}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/user", c.baseURL), nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// send sends a request, retrying idempotent ones as the retry policy allows,
+// and decodes the response into out
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body, out interface{}, authenticated bool, opts ...RequestOption) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
 	}
 
-	// Ensure the token is properly formatted with "Bearer " prefix
-	token := c.token
-	if !strings.HasPrefix(token, "Bearer ") {
-		token = "Bearer " + token
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
 	}
-	req.Header.Set("Authorization", token)
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+	attempts := 1
+	if idempotent(method) && c.retry.MaxAttempts > 1 {
+		attempts = c.retry.MaxAttempts
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Accept", "application/json")
+		if authenticated {
+			token, err := c.authToken(ctx)
+			if err != nil {
+				return err
+			}
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		for _, opt := range opts {
+			opt(req)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if attempt < attempts && ctx.Err() == nil {
+				if err := c.retry.wait(ctx, attempt, 0); err != nil {
+					return err
+				}
+				continue
+			}
+			return fmt.Errorf("failed to send request: %w", err)
+		}
+
+		if resp.StatusCode >= 400 {
+			apiErr := decodeError(resp)
+			resp.Body.Close()
+			if attempt < attempts && retryable(resp.StatusCode) {
+				if err := c.retry.wait(ctx, attempt, retryAfter(resp)); err != nil {
+					return err
+				}
+				continue
+			}
+			return apiErr
+		}
+
+		defer resp.Body.Close()
+		if out == nil {
+			io.Copy(io.Discard, resp.Body)
+			return nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		return nil
 	}
+}
 
-	var user models.User
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+// stream sends an authenticated request with a raw body and returns the
+// response for the caller to read and close. A rejected token is replaced
+// once, as in do.
+func (c *Client) stream(ctx context.Context, method, path string, query url.Values, body []byte, contentType string) (*http.Response, error) {
+	return c.streamWith(ctx, c.httpClient, method, path, query, body, contentType)
+}
+
+// streamWith is stream sending the request with httpClient
+func (c *Client) streamWith(ctx context.Context, httpClient *http.Client, method, path string, query url.Values, body []byte, contentType string, opts ...RequestOption) (*http.Response, error) {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
 	}
 
-	return &user, nil
+	for refreshed := false; ; refreshed = true {
+		req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		token, err := c.authToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		for _, opt := range opts {
+			opt(req)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send request: %w", err)
+		}
+		if resp.StatusCode < 400 {
+			return resp, nil
+		}
+
+		apiErr := decodeError(resp)
+		resp.Body.Close()
+		if refreshed || !errors.Is(apiErr, ErrUnauthorized) {
+			return nil, apiErr
+		}
+		if ok, refreshErr := c.refresh(ctx); refreshErr != nil || !ok {
+			return nil, apiErr
+		}
+	}
 }
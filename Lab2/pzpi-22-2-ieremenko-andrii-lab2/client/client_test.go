@@ -0,0 +1,232 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/client"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/server"
+	"github.com/google/uuid"
+)
+
+// newServer starts the real server handler on httptest, with an
+// administrator named admin
+func newServer(t *testing.T) string {
+	t.Helper()
+	s := server.NewServer()
+	if err := s.EnsureAdmin("admin", "secret123", "admin@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// newUser logs in as the administrator, or registers a user of another name,
+// and returns a client logged in as it
+func newUser(t *testing.T, url, username string) *client.Client {
+	t.Helper()
+	c := client.NewClient(url, client.WithRetry(client.NoRetry))
+	var err error
+	if username == "admin" {
+		_, err = c.Login(context.Background(), models.LoginRequest{Username: username, Password: "secret123"})
+	} else {
+		_, err = c.Register(context.Background(), models.RegisterRequest{
+			Username: username,
+			Password: "secret123",
+			Email:    username + "@example.com",
+		})
+	}
+	if err != nil {
+		t.Fatalf("logging in as %s: %v", username, err)
+	}
+	return c
+}
+
+func TestRoomsMetricsAndReadings(t *testing.T) {
+	ctx := context.Background()
+	c := newUser(t, newServer(t), "admin")
+
+	room, err := c.CreateRoom(ctx, models.CreateRoomRequest{Name: "Flat 1", Building: "A", Area: 50})
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	rooms, err := c.ListRooms(ctx)
+	if err != nil || rooms.Total != 1 || rooms.Rooms[0].ID != room.ID {
+		t.Fatalf("ListRooms: %+v, %v", rooms, err)
+	}
+
+	metric, err := c.CreateMetric(ctx, models.CreateMetricRequest{Name: "Cold water", Unit: "m3", Kind: "water", RoomID: room.ID})
+	if err != nil {
+		t.Fatalf("CreateMetric: %v", err)
+	}
+	start := time.Now().Add(-time.Hour)
+	for i, value := range []float64{1.5, 2, 2.5} {
+		v := value
+		at := start.Add(time.Duration(i) * time.Minute)
+		if _, err := c.AddReading(ctx, metric.ID, models.AddReadingRequest{Value: &v, Timestamp: at}); err != nil {
+			t.Fatalf("AddReading: %v", err)
+		}
+	}
+	readings, err := c.GetReadings(ctx, metric.ID)
+	if err != nil || readings.Total != 3 {
+		t.Fatalf("GetReadings: %+v, %v", readings, err)
+	}
+
+	series, err := c.GetReadingSeries(ctx, metric.ID, client.SeriesQuery{Resolution: models.ResolutionHour})
+	if err != nil {
+		t.Fatalf("GetReadingSeries: %v", err)
+	}
+	total := 0.0
+	for _, p := range series.Points {
+		total += p.Sum
+	}
+	if total != 6 {
+		t.Errorf("series sums to %v, want 6", total)
+	}
+
+	correlation, err := c.CalculateCorrelation(ctx, models.CorrelationRequest{
+		Metric1ID: metric.ID,
+		Metric2ID: metric.ID,
+		StartTime: start.Add(-time.Minute),
+		EndTime:   time.Now(),
+	})
+	if err != nil || correlation.Metric1Name != "Cold water" {
+		t.Fatalf("CalculateCorrelation: %+v, %v", correlation, err)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	ctx := context.Background()
+	c := newUser(t, newServer(t), "admin")
+
+	room, err := c.CreateRoom(ctx, models.CreateRoomRequest{Name: "Flat 1"})
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	name := "Flat 2"
+	updated, err := c.UpdateRoom(ctx, room.ID, models.UpdateRoomRequest{Name: &name}, client.IfMatch(room.Version))
+	if err != nil {
+		t.Fatalf("UpdateRoom: %v", err)
+	}
+
+	// Someone holding the first version must not overwrite the update
+	_, err = c.UpdateRoom(ctx, room.ID, models.UpdateRoomRequest{Name: &name}, client.IfMatch(room.Version))
+	if !errors.Is(err, client.ErrPreconditionFailed) {
+		t.Fatalf("stale UpdateRoom: got %v, want ErrPreconditionFailed", err)
+	}
+	if err := c.DeleteRoom(ctx, room.ID, client.IfMatch(room.Version)); !errors.Is(err, client.ErrPreconditionFailed) {
+		t.Fatalf("stale DeleteRoom: got %v, want ErrPreconditionFailed", err)
+	}
+	if err := c.DeleteRoom(ctx, room.ID, client.IfMatch(updated.Version)); err != nil {
+		t.Fatalf("DeleteRoom: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	url := newServer(t)
+	admin := newUser(t, url, "admin")
+
+	_, err := admin.GetRoom(ctx, uuid.New())
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("GetRoom of an unknown room: got %v", err)
+	}
+	if apiErr.Status != http.StatusNotFound || apiErr.Code != "room_not_found" || apiErr.RequestID == "" {
+		t.Errorf("got problem %+v", apiErr.Problem)
+	}
+
+	_, err = admin.CreateRoom(ctx, models.CreateRoomRequest{Area: -1})
+	if !errors.Is(err, client.ErrBadRequest) || !errors.As(err, &apiErr) || apiErr.Details == nil {
+		t.Errorf("CreateRoom of an invalid room: got %v", err)
+	}
+
+	resident := newUser(t, url, "resident")
+	if _, err := resident.CreateRoom(ctx, models.CreateRoomRequest{Name: "Flat"}); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("CreateRoom as a resident: got %v, want ErrForbidden", err)
+	}
+	if _, err := resident.ListUsers(ctx); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("ListUsers as a resident: got %v, want ErrForbidden", err)
+	}
+
+	stranger := client.NewClient(url, client.WithToken("not-a-token"), client.WithRetry(client.NoRetry))
+	if _, err := stranger.ListRooms(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("ListRooms with a bad token: got %v, want ErrUnauthorized", err)
+	}
+	if _, err := stranger.Login(ctx, models.LoginRequest{Username: "admin", Password: "wrong"}); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Login with a wrong password: got %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	ctx := context.Background()
+	url := newServer(t)
+	c := newUser(t, url, "admin")
+	first := c.Token()
+
+	// Revoking the session of the client from elsewhere rejects its token;
+	// the client logs in again by itself
+	other := client.NewClient(url)
+	if _, err := other.Login(ctx, models.LoginRequest{Username: "admin", Password: "secret123"}); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	sessions, err := other.ListSessions(ctx)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	for _, session := range sessions.Sessions {
+		if !session.Current {
+			if err := other.RevokeSession(ctx, session.ID); err != nil {
+				t.Fatalf("RevokeSession: %v", err)
+			}
+		}
+	}
+
+	user, err := c.CurrentUser(ctx)
+	if err != nil || user.Username != "admin" {
+		t.Fatalf("CurrentUser after the session was revoked: %+v, %v", user, err)
+	}
+	if c.Token() == first {
+		t.Error("token was not replaced")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"rooms": [], "total": 0}`))
+	}))
+	t.Cleanup(ts.Close)
+
+	policy := client.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	c := client.NewClient(ts.URL, client.WithToken("token"), client.WithRetry(policy))
+	if _, err := c.ListRooms(ctx); err != nil {
+		t.Fatalf("ListRooms: %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("got %d calls, want 3", n)
+	}
+
+	// Creating is not idempotent, so it is tried once
+	calls.Store(0)
+	if _, err := c.CreateRoom(ctx, models.CreateRoomRequest{Name: "Flat"}); !errors.Is(err, client.ErrServer) {
+		t.Errorf("CreateRoom: got %v, want ErrServer", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("got %d calls, want 1", n)
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// CreateDevice registers a meter; administrators only
+func (c *Client) CreateDevice(ctx context.Context, req models.CreateDeviceRequest) (*models.Device, error) {
+	var device models.Device
+	if err := c.do(ctx, http.MethodPost, "/devices", nil, req, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// ListDevices returns all devices with their health
+func (c *Client) ListDevices(ctx context.Context) (*models.DeviceListResponse, error) {
+	var devices models.DeviceListResponse
+	if err := c.do(ctx, http.MethodGet, "/devices", nil, nil, &devices); err != nil {
+		return nil, err
+	}
+	return &devices, nil
+}
+
+// GetDevice returns a device with its keys and health
+func (c *Client) GetDevice(ctx context.Context, id uuid.UUID) (*models.Device, error) {
+	var device models.Device
+	if err := c.do(ctx, http.MethodGet, "/devices/"+id.String(), nil, nil, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// UpdateDevice changes a device; administrators only. Pass
+// IfMatch(device.Version) to keep from overwriting changes made by others.
+func (c *Client) UpdateDevice(ctx context.Context, id uuid.UUID, req models.UpdateDeviceRequest, opts ...RequestOption) (*models.Device, error) {
+	var device models.Device
+	if err := c.do(ctx, http.MethodPatch, "/devices/"+id.String(), nil, req, &device, opts...); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// DeleteDevice removes a device, and with it its keys; administrators only
+func (c *Client) DeleteDevice(ctx context.Context, id uuid.UUID, opts ...RequestOption) error {
+	return c.do(ctx, http.MethodDelete, "/devices/"+id.String(), nil, nil, nil, opts...)
+}
+
+// CreateDeviceKey issues an API key for a device; administrators only. The
+// key is only returned here. A meter passes it to WithToken.
+func (c *Client) CreateDeviceKey(ctx context.Context, deviceID uuid.UUID, name string) (*models.DeviceKeyResponse, error) {
+	var key models.DeviceKeyResponse
+	req := models.CreateDeviceKeyRequest{Name: name}
+	if err := c.do(ctx, http.MethodPost, "/devices/"+deviceID.String()+"/keys", nil, req, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RevokeDeviceKey revokes an API key of a device; administrators only
+func (c *Client) RevokeDeviceKey(ctx context.Context, deviceID, keyID uuid.UUID) error {
+	return c.do(ctx, http.MethodDelete, "/devices/"+deviceID.String()+"/keys/"+keyID.String(), nil, nil, nil)
+}
+
+// ModbusStatus returns how polling each configured Modbus device goes;
+// administrators only
+func (c *Client) ModbusStatus(ctx context.Context) (*models.ModbusStatusResponse, error) {
+	var status models.ModbusStatusResponse
+	if err := c.do(ctx, http.MethodGet, "/admin/modbus", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+// Errors that an *APIError matches with errors.Is, by the class of its status
+var (
+	ErrBadRequest         = errors.New("bad request")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForbidden          = errors.New("forbidden")
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrServer             = errors.New("server error")
+)
+
+// APIError is an error response of the server
+type APIError struct {
+	models.Problem
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Detail)
+	}
+	return fmt.Sprintf("%s: %s (status %d)", e.Code, e.Detail, e.Status)
+}
+
+// Is reports whether the error falls in the class of target, so that callers
+// can write errors.Is(err, client.ErrNotFound)
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.Status == http.StatusBadRequest || e.Status == http.StatusUnprocessableEntity
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.Status == http.StatusForbidden
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrConflict:
+		return e.Status == http.StatusConflict
+	case ErrPreconditionFailed:
+		return e.Status == http.StatusPreconditionFailed
+	case ErrServer:
+		return e.Status >= 500
+	}
+	return false
+}
+
+// decodeError builds an *APIError from an error response. Responses that are
+// not problem+json, e.g. from a proxy, keep their body as the detail.
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	apiErr := &APIError{}
+	if err := json.Unmarshal(body, &apiErr.Problem); err != nil || apiErr.Status == 0 {
+		apiErr.Problem = models.Problem{Detail: strings.TrimSpace(string(body))}
+	}
+	apiErr.Status = resp.StatusCode
+	if apiErr.Title == "" {
+		apiErr.Title = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
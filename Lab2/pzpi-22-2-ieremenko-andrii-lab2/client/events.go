@@ -0,0 +1,18 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+// EventStatus returns the subscribers of the event bus and the events
+// waiting for durable ones; administrators only
+func (c *Client) EventStatus(ctx context.Context) (*models.EventBusStatus, error) {
+	var status models.EventBusStatus
+	if err := c.do(ctx, http.MethodGet, "/admin/events", nil, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
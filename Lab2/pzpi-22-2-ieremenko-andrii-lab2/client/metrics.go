@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// CreateMetric creates a metric in a room; administrators only
+func (c *Client) CreateMetric(ctx context.Context, req models.CreateMetricRequest) (*models.Metric, error) {
+	var metric models.Metric
+	if err := c.do(ctx, http.MethodPost, "/metrics", nil, req, &metric); err != nil {
+		return nil, err
+	}
+	return &metric, nil
+}
+
+// ListMetrics returns all metrics
+func (c *Client) ListMetrics(ctx context.Context) (*models.MetricListResponse, error) {
+	var metrics models.MetricListResponse
+	if err := c.do(ctx, http.MethodGet, "/metrics", nil, nil, &metrics); err != nil {
+		return nil, err
+	}
+	return &metrics, nil
+}
+
+// GetMetric returns a metric with its readings
+func (c *Client) GetMetric(ctx context.Context, id uuid.UUID) (*models.MetricWithReadings, error) {
+	var metric models.MetricWithReadings
+	if err := c.do(ctx, http.MethodGet, "/metrics/"+id.String(), nil, nil, &metric); err != nil {
+		return nil, err
+	}
+	return &metric, nil
+}
+
+// UpdateMetric changes a metric; administrators only. Pass
+// IfMatch(metric.Version) to keep from overwriting changes made by others.
+func (c *Client) UpdateMetric(ctx context.Context, id uuid.UUID, req models.UpdateMetricRequest, opts ...RequestOption) (*models.Metric, error) {
+	var metric models.Metric
+	if err := c.do(ctx, http.MethodPatch, "/metrics/"+id.String(), nil, req, &metric, opts...); err != nil {
+		return nil, err
+	}
+	return &metric, nil
+}
+
+// DeleteMetric moves a metric to the trash; administrators only
+func (c *Client) DeleteMetric(ctx context.Context, id uuid.UUID, opts ...RequestOption) error {
+	return c.do(ctx, http.MethodDelete, "/metrics/"+id.String(), nil, nil, nil, opts...)
+}
+
+// AddReading records a reading of a metric
+func (c *Client) AddReading(ctx context.Context, metricID uuid.UUID, req models.AddReadingRequest) (*models.MetricReading, error) {
+	var reading models.MetricReading
+	if err := c.do(ctx, http.MethodPost, "/metrics/"+metricID.String()+"/readings", nil, req, &reading); err != nil {
+		return nil, err
+	}
+	return &reading, nil
+}
+
+// SendTelegram records the readings an M-Bus telegram carries for the
+// metrics of its meter
+func (c *Client) SendTelegram(ctx context.Context, req models.TelegramRequest) (*models.TelegramResponse, error) {
+	var response models.TelegramResponse
+	if err := c.do(ctx, http.MethodPost, "/metrics/telegrams", nil, req, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// GetReadings returns the readings of a metric that are kept raw
+func (c *Client) GetReadings(ctx context.Context, metricID uuid.UUID) (*models.ReadingListResponse, error) {
+	var readings models.ReadingListResponse
+	if err := c.do(ctx, http.MethodGet, "/metrics/"+metricID.String()+"/readings", nil, nil, &readings); err != nil {
+		return nil, err
+	}
+	return &readings, nil
+}
+
+// SeriesQuery selects the range GetReadingSeries returns; empty fields take
+// the server defaults
+type SeriesQuery struct {
+	From, To   time.Time
+	Resolution string // raw, hour or day; chosen for the range if empty
+}
+
+// GetReadingSeries returns the readings of a metric over a range, raw or
+// rolled up, from whichever tier keeps them
+func (c *Client) GetReadingSeries(ctx context.Context, metricID uuid.UUID, q SeriesQuery) (*models.SeriesResponse, error) {
+	query := url.Values{}
+	if !q.From.IsZero() {
+		query.Set("from", q.From.Format(time.RFC3339))
+	}
+	if !q.To.IsZero() {
+		query.Set("to", q.To.Format(time.RFC3339))
+	}
+	if q.Resolution != "" {
+		query.Set("resolution", q.Resolution)
+	}
+
+	var series models.SeriesResponse
+	if err := c.do(ctx, http.MethodGet, "/metrics/"+metricID.String()+"/series", query, nil, &series); err != nil {
+		return nil, err
+	}
+	return &series, nil
+}
+
+// CorrectReading changes the value or timestamp of a reading, keeping the
+// previous one as a revision; administrators only
+func (c *Client) CorrectReading(ctx context.Context, metricID, readingID uuid.UUID, req models.CorrectReadingRequest) (*models.MetricReading, error) {
+	var reading models.MetricReading
+	path := "/metrics/" + metricID.String() + "/readings/" + readingID.String()
+	if err := c.do(ctx, http.MethodPatch, path, nil, req, &reading); err != nil {
+		return nil, err
+	}
+	return &reading, nil
+}
+
+// CalculateCorrelation correlates the readings of two metrics over a period
+func (c *Client) CalculateCorrelation(ctx context.Context, req models.CorrelationRequest) (*models.CorrelationResponse, error) {
+	var correlation models.CorrelationResponse
+	if err := c.do(ctx, http.MethodPost, "/metrics/correlation", nil, req, &correlation); err != nil {
+		return nil, err
+	}
+	return &correlation, nil
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// CurrentUser returns the profile of the logged in user
+func (c *Client) CurrentUser(ctx context.Context) (*models.User, error) {
+	var user models.User
+	if err := c.do(ctx, http.MethodGet, "/users/me", nil, nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ChangePassword changes the password of the logged in user, ending the
+// other sessions of the user. The client keeps logging in with the new one.
+func (c *Client) ChangePassword(ctx context.Context, req models.ChangePasswordRequest) error {
+	if err := c.do(ctx, http.MethodPost, "/users/me/password", nil, req, nil); err != nil {
+		return err
+	}
+	c.setPassword(req.NewPassword)
+	return nil
+}
+
+// ExportAccount returns everything the server holds about the logged in user
+func (c *Client) ExportAccount(ctx context.Context) (*models.AccountExport, error) {
+	var export models.AccountExport
+	if err := c.do(ctx, http.MethodGet, "/users/me/export", nil, nil, &export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// DeleteAccount deletes the logged in user and returns the data held about
+// them. The client is logged out afterwards.
+func (c *Client) DeleteAccount(ctx context.Context, req models.DeleteAccountRequest) (*models.AccountExport, error) {
+	var export models.AccountExport
+	if err := c.do(ctx, http.MethodDelete, "/users/me", nil, req, &export); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.token, c.credentials = "", nil
+	c.mu.Unlock()
+	return &export, nil
+}
+
+// MyRooms returns the rooms owned by the logged in user
+func (c *Client) MyRooms(ctx context.Context) (*models.RoomListResponse, error) {
+	var rooms models.RoomListResponse
+	if err := c.do(ctx, http.MethodGet, "/users/me/rooms", nil, nil, &rooms); err != nil {
+		return nil, err
+	}
+	return &rooms, nil
+}
+
+// MyMetrics returns the metrics in the rooms owned by the logged in user
+func (c *Client) MyMetrics(ctx context.Context) (*models.MetricListResponse, error) {
+	var metrics models.MetricListResponse
+	if err := c.do(ctx, http.MethodGet, "/users/me/metrics", nil, nil, &metrics); err != nil {
+		return nil, err
+	}
+	return &metrics, nil
+}
+
+// ListSessions returns the active sessions of the logged in user
+func (c *Client) ListSessions(ctx context.Context) (*models.SessionListResponse, error) {
+	var sessions models.SessionListResponse
+	if err := c.do(ctx, http.MethodGet, "/users/me/sessions", nil, nil, &sessions); err != nil {
+		return nil, err
+	}
+	return &sessions, nil
+}
+
+// RevokeSession ends a session of the logged in user
+func (c *Client) RevokeSession(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, http.MethodDelete, "/users/me/sessions/"+id.String(), nil, nil, nil)
+}
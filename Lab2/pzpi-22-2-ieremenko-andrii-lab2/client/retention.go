@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// ListRetentionPolicies returns the retention policies; administrators only
+func (c *Client) ListRetentionPolicies(ctx context.Context) (*models.RetentionPolicyListResponse, error) {
+	var policies models.RetentionPolicyListResponse
+	if err := c.do(ctx, http.MethodGet, "/admin/retention", nil, nil, &policies); err != nil {
+		return nil, err
+	}
+	return &policies, nil
+}
+
+// CreateRetentionPolicy creates a retention policy for a metric, a kind of
+// metrics or every metric; administrators only
+func (c *Client) CreateRetentionPolicy(ctx context.Context, req models.CreateRetentionPolicyRequest) (*models.RetentionPolicy, error) {
+	var policy models.RetentionPolicy
+	if err := c.do(ctx, http.MethodPost, "/admin/retention", nil, req, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// DeleteRetentionPolicy deletes a retention policy; administrators only
+func (c *Client) DeleteRetentionPolicy(ctx context.Context, id uuid.UUID) error {
+	return c.do(ctx, http.MethodDelete, "/admin/retention/"+id.String(), nil, nil, nil)
+}
+
+// CompactReadings applies the retention policies now; administrators only
+func (c *Client) CompactReadings(ctx context.Context) (*models.CompactionResult, error) {
+	var result models.CompactionResult
+	if err := c.do(ctx, http.MethodPost, "/admin/retention/compact", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
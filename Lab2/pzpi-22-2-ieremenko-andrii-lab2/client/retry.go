@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy describes how idempotent calls are retried on network errors
+// and temporary server failures. The delay doubles with every attempt up to
+// MaxDelay, of which between half and all is waited. A Retry-After header of
+// the server takes precedence.
+type RetryPolicy struct {
+	MaxAttempts int // including the first; 1 disables retries
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy of new clients
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// NoRetry disables retries
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// wait sleeps before the attempt after the given one, or returns early with
+// the error of ctx
+func (p RetryPolicy) wait(ctx context.Context, attempt int, after time.Duration) error {
+	delay := after
+	if delay <= 0 {
+		delay = p.BaseDelay << (attempt - 1)
+		if p.MaxDelay > 0 && (delay > p.MaxDelay || delay <= 0) {
+			delay = p.MaxDelay
+		}
+		// Equal jitter keeps half the delay and randomizes the rest, so that
+		// clients that failed together do not retry together
+		if delay > 0 {
+			delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// idempotent reports whether a request can be sent again without changing
+// the outcome
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether an error status is likely temporary
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the delay the server asked for in Retry-After
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,27 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 20 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+	for attempt, want := range map[int]time.Duration{1: 20 * time.Millisecond, 2: 40 * time.Millisecond, 4: 40 * time.Millisecond} {
+		start := time.Now()
+		if err := policy.wait(context.Background(), attempt, 0); err != nil {
+			t.Fatal(err)
+		}
+		// At least half the delay is waited, whatever the jitter
+		if waited := time.Since(start); waited < want/2 {
+			t.Errorf("attempt %d waited %v, want at least %v", attempt, waited, want/2)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := (RetryPolicy{BaseDelay: time.Hour}).wait(ctx, 1, 0); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// CreateRoom creates a room; administrators only
+func (c *Client) CreateRoom(ctx context.Context, req models.CreateRoomRequest) (*models.Room, error) {
+	var room models.Room
+	if err := c.do(ctx, http.MethodPost, "/rooms", nil, req, &room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// ListRooms returns all rooms
+func (c *Client) ListRooms(ctx context.Context) (*models.RoomListResponse, error) {
+	var rooms models.RoomListResponse
+	if err := c.do(ctx, http.MethodGet, "/rooms", nil, nil, &rooms); err != nil {
+		return nil, err
+	}
+	return &rooms, nil
+}
+
+// GetRoom returns a room
+func (c *Client) GetRoom(ctx context.Context, id uuid.UUID) (*models.Room, error) {
+	var room models.Room
+	if err := c.do(ctx, http.MethodGet, "/rooms/"+id.String(), nil, nil, &room); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// UpdateRoom changes a room; administrators only. Pass IfMatch(room.Version)
+// to keep from overwriting changes made by others.
+func (c *Client) UpdateRoom(ctx context.Context, id uuid.UUID, req models.UpdateRoomRequest, opts ...RequestOption) (*models.Room, error) {
+	var room models.Room
+	if err := c.do(ctx, http.MethodPatch, "/rooms/"+id.String(), nil, req, &room, opts...); err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// DeleteRoom moves a room and its metrics to the trash; administrators only
+func (c *Client) DeleteRoom(ctx context.Context, id uuid.UUID, opts ...RequestOption) error {
+	return c.do(ctx, http.MethodDelete, "/rooms/"+id.String(), nil, nil, nil, opts...)
+}
+
+// BenchmarkQuery selects what GetRoomBenchmark compares; empty fields take
+// the server defaults
+type BenchmarkQuery struct {
+	Kind      string // required: electricity, water, gas or heat
+	Scope     string // building or complex
+	Normalize string // area or occupant
+	From, To  time.Time
+}
+
+// GetRoomBenchmark compares the consumption of a room with its peers
+func (c *Client) GetRoomBenchmark(ctx context.Context, id uuid.UUID, q BenchmarkQuery) (*models.BenchmarkResponse, error) {
+	query := url.Values{"kind": {q.Kind}}
+	if q.Scope != "" {
+		query.Set("scope", q.Scope)
+	}
+	if q.Normalize != "" {
+		query.Set("normalize", q.Normalize)
+	}
+	if !q.From.IsZero() {
+		query.Set("from", q.From.Format(time.RFC3339))
+	}
+	if !q.To.IsZero() {
+		query.Set("to", q.To.Format(time.RFC3339))
+	}
+
+	var benchmark models.BenchmarkResponse
+	if err := c.do(ctx, http.MethodGet, "/rooms/"+id.String()+"/benchmark", query, nil, &benchmark); err != nil {
+		return nil, err
+	}
+	return &benchmark, nil
+}
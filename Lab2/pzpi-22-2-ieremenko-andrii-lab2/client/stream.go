@@ -0,0 +1,113 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// WatchReadings follows the readings of a metric as they are recorded or
+// corrected, calling handle with each event until ctx is done or handle
+// returns an error. A dropped stream is resumed after the last event
+// received, backing off as the retry policy does; a gap event tells that
+// events were missed meanwhile. Errors of the server, such as an unknown
+// metric, end the watch.
+func (c *Client) WatchReadings(ctx context.Context, metricID uuid.UUID, handle func(models.StreamEvent) error) error {
+	// The stream lasts as long as the watch, so the timeout of the client
+	// does not apply
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+
+	var lastID uint64
+	resume := func(req *http.Request) {
+		req.Header.Set("Accept", "text/event-stream")
+		if lastID != 0 {
+			req.Header.Set("Last-Event-ID", strconv.FormatUint(lastID, 10))
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.streamWith(ctx, &httpClient, http.MethodGet, "/metrics/"+metricID.String()+"/stream", nil, nil, "", resume)
+		if err != nil {
+			var apiErr *APIError
+			if ctx.Err() != nil || (errors.As(err, &apiErr) && !retryable(apiErr.Status)) {
+				return err
+			}
+		} else {
+			received, err := readEvents(resp.Body, func(e models.StreamEvent) error {
+				if e.ID != 0 {
+					lastID = e.ID
+				}
+				// The server ends the stream with an error event, e.g. when
+				// it shuts down; the watch resumes
+				if e.Type == models.StreamError {
+					return nil
+				}
+				return handle(e)
+			})
+			resp.Body.Close()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil && !errors.Is(err, errStreamRead) {
+				return err
+			}
+			if received {
+				attempt = 1
+			}
+		}
+		if err := c.retry.wait(ctx, attempt, 0); err != nil {
+			return err
+		}
+	}
+}
+
+// errStreamRead wraps errors reading a stream, as opposed to those of the
+// handler
+var errStreamRead = errors.New("failed to read stream")
+
+// readEvents reads Server-Sent Events until the stream ends, calling handle
+// with each. It reports whether any event was received.
+func readEvents(r io.Reader, handle func(models.StreamEvent) error) (bool, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+
+	received := false
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			// Only the data matters; it repeats the ID and type of the event
+			if value, ok := strings.CutPrefix(line, "data:"); ok {
+				data.WriteString(strings.TrimPrefix(value, " "))
+			}
+			continue
+		}
+		if data.Len() == 0 {
+			continue
+		}
+
+		var e models.StreamEvent
+		if err := json.Unmarshal([]byte(data.String()), &e); err != nil {
+			return received, fmt.Errorf("%w: %v", errStreamRead, err)
+		}
+		data.Reset()
+		received = true
+		if err := handle(e); err != nil {
+			return received, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return received, fmt.Errorf("%w: %v", errStreamRead, err)
+	}
+	return received, nil
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// ListUsers returns all users; administrators only
+func (c *Client) ListUsers(ctx context.Context) (*models.UserListResponse, error) {
+	var users models.UserListResponse
+	if err := c.do(ctx, http.MethodGet, "/users", nil, nil, &users); err != nil {
+		return nil, err
+	}
+	return &users, nil
+}
+
+// UpdateUser changes the email, password or roles of a user; administrators only
+func (c *Client) UpdateUser(ctx context.Context, id uuid.UUID, req models.UpdateUserRequest, opts ...RequestOption) (*models.User, error) {
+	var user models.User
+	if err := c.do(ctx, http.MethodPatch, "/users/"+id.String(), nil, req, &user, opts...); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateCurrentUser changes the email or password of the logged in user.
+// Changing the password requires CurrentPassword; the client keeps logging
+// in with the new one.
+func (c *Client) UpdateCurrentUser(ctx context.Context, req models.UpdateUserRequest, opts ...RequestOption) (*models.User, error) {
+	var user models.User
+	if err := c.do(ctx, http.MethodPatch, "/users/me", nil, req, &user, opts...); err != nil {
+		return nil, err
+	}
+
+	if req.Password != nil {
+		c.setPassword(*req.Password)
+	}
+	return &user, nil
+}
+
+// setPassword replaces the password the client logs in again with
+func (c *Client) setPassword(password string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.credentials != nil {
+		c.credentials = &models.LoginRequest{Username: c.credentials.Username, Password: password}
+	}
+}
+
+// CreateRole creates a role; administrators only
+func (c *Client) CreateRole(ctx context.Context, req models.CreateRoleRequest) (*models.Role, error) {
+	var role models.Role
+	if err := c.do(ctx, http.MethodPost, "/roles", nil, req, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
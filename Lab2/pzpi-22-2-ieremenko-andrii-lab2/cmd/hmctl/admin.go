@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+func init() {
+	commands["users list"] = command{
+		usage: "",
+		help:  "list users",
+		run:   listUsers,
+	}
+	commands["users update"] = command{
+		usage: "ID [-email EMAIL] [-password PASSWORD] [-roles ROLE,...] [-if-match VERSION]",
+		help:  "change the email, password or roles of a user",
+		run:   updateUser,
+	}
+	commands["roles create"] = command{
+		usage: "-name NAME [-description TEXT] [-permissions PERMISSION,...]",
+		help:  "create a role",
+		run:   createRole,
+	}
+	commands["backups list"] = command{
+		usage: "",
+		help:  "list stored backups",
+		run:   listBackups,
+	}
+	commands["backups create"] = command{
+		usage: "",
+		help:  "take a backup",
+		run:   createBackup,
+	}
+	commands["backups download"] = command{
+		usage: "ID [-out FILE]",
+		help:  "download a backup file",
+		run:   downloadBackup,
+	}
+	commands["backups restore"] = command{
+		usage: "ID | -file FILE",
+		help:  "replace the server state with a backup",
+		run:   restoreBackup,
+	}
+	commands["events status"] = command{
+		usage: "",
+		help:  "show the event bus subscribers and how their deliveries go",
+		run:   eventStatus,
+	}
+	commands["retention list"] = command{
+		usage: "",
+		help:  "list the retention policies",
+		run:   listRetentionPolicies,
+	}
+	commands["retention create"] = command{
+		usage: "[-metric ID | -kind KIND] [-raw DAYS] [-hourly DAYS] [-daily DAYS]",
+		help:  "create a retention policy for a metric, a kind or, naming neither, every metric; 0 days keeps a tier forever",
+		run:   createRetentionPolicy,
+	}
+	commands["retention delete"] = command{
+		usage: "ID",
+		help:  "delete a retention policy",
+		run:   deleteRetentionPolicy,
+	}
+	commands["retention compact"] = command{
+		usage: "",
+		help:  "apply the retention policies now",
+		run:   compactReadings,
+	}
+}
+
+func usersTable(users []models.User) table {
+	t := table{header: []string{"id", "username", "email", "roles", "version"}}
+	for _, u := range users {
+		roles := make([]string, len(u.Roles))
+		for i, role := range u.Roles {
+			roles[i] = role.Name
+		}
+		t.rows = append(t.rows, []string{
+			u.ID.String(), u.Username, u.Email, strings.Join(roles, ","), strconv.FormatInt(u.Version, 10),
+		})
+	}
+	return t
+}
+
+func listUsers(ctx context.Context, a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	users, err := a.client.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(users, usersTable(users.Users))
+}
+
+func updateUser(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("users update", flag.ContinueOnError)
+	email := fs.String("email", "", "email address")
+	password := fs.String("password", "", "new password")
+	roles := fs.String("roles", "", "comma separated names of all roles of the user")
+	ifMatch := fs.Int64("if-match", 0, "only change this version of the user")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(rest)
+	if err != nil {
+		return err
+	}
+
+	// Only the flags given are changed
+	var req models.UpdateUserRequest
+	if isSet(fs, "email") {
+		req.Email = email
+	}
+	if isSet(fs, "password") {
+		req.Password = password
+	}
+	if isSet(fs, "roles") {
+		names := splitList(*roles)
+		req.Roles = &names
+	}
+
+	user, err := a.client.UpdateUser(ctx, id, req, versionOption(fs, *ifMatch)...)
+	if err != nil {
+		return err
+	}
+	return a.print(user, usersTable([]models.User{*user}))
+}
+
+func createRole(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("roles create", flag.ContinueOnError)
+	name := fs.String("name", "", "name")
+	description := fs.String("description", "", "description")
+	permissions := fs.String("permissions", "", "comma separated permissions")
+	if rest, err := parseArgs(fs, args); err != nil || len(rest) != 0 || *name == "" {
+		return errUsage
+	}
+
+	role, err := a.client.CreateRole(ctx, models.CreateRoleRequest{
+		Name:        *name,
+		Description: *description,
+		Permissions: splitList(*permissions),
+	})
+	if err != nil {
+		return err
+	}
+	return a.print(role, table{
+		header: []string{"id", "name", "description", "permissions"},
+		rows:   [][]string{{role.ID.String(), role.Name, role.Description, strings.Join(role.Permissions, ",")}},
+	})
+}
+
+func backupsTable(backups []models.Backup) table {
+	t := table{header: []string{"id", "created_at", "size", "encrypted", "scheduled", "checksum"}}
+	for _, b := range backups {
+		t.rows = append(t.rows, []string{
+			b.ID.String(), formatTime(b.CreatedAt), strconv.FormatInt(b.Size, 10),
+			strconv.FormatBool(b.Encrypted), strconv.FormatBool(b.Scheduled), b.Checksum,
+		})
+	}
+	return t
+}
+
+func listBackups(ctx context.Context, a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	backups, err := a.client.ListBackups(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(backups, backupsTable(backups.Backups))
+}
+
+func createBackup(ctx context.Context, a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	backup, err := a.client.CreateBackup(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(backup, backupsTable([]models.Backup{*backup}))
+}
+
+func downloadBackup(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("backups download", flag.ContinueOnError)
+	out := fs.String("out", "", "file to write (default ID.hmbk, - for standard output)")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(rest)
+	if err != nil {
+		return err
+	}
+
+	if *out == "-" {
+		return a.client.DownloadBackup(ctx, id, a.stdout)
+	}
+	if *out == "" {
+		*out = id.String() + ".hmbk"
+	}
+
+	// The file is only put in place once the download is complete
+	tmp, err := os.CreateTemp(filepath.Dir(*out), ".hmbk-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := a.client.DownloadBackup(ctx, id, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), *out); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Backup written to %s\n", *out)
+	return nil
+}
+
+func restoreBackup(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("backups restore", flag.ContinueOnError)
+	file := fs.String("file", "", "backup file to upload, - for standard input")
+	rest, err := parseArgs(fs, args)
+	if err != nil || (len(rest) == 1) == (*file != "") || len(rest) > 1 {
+		return errUsage
+	}
+
+	var restored *models.RestoreResponse
+	if *file != "" {
+		var in io.Reader = a.stdin
+		if *file != "-" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+		restored, err = a.client.RestoreBackupFile(ctx, in)
+	} else {
+		var id uuid.UUID
+		if id, err = parseID(rest); err != nil {
+			return err
+		}
+		restored, err = a.client.RestoreBackup(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.print(restored, table{
+		header: []string{"taken_at", "users", "roles", "rooms", "metrics", "readings"},
+		rows: [][]string{{
+			formatTime(restored.TakenAt), strconv.Itoa(restored.Users), strconv.Itoa(restored.Roles),
+			strconv.Itoa(restored.Rooms), strconv.Itoa(restored.Metrics), strconv.Itoa(restored.Readings),
+		}},
+	})
+}
+
+func eventStatus(ctx context.Context, a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	status, err := a.client.EventStatus(ctx)
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"subscriber", "event", "mode", "queued", "delivered", "failed", "dropped"}}
+	for _, sub := range status.Subscribers {
+		t.rows = append(t.rows, []string{
+			sub.Name, sub.EventType, sub.Mode, strconv.Itoa(sub.Queued), strconv.FormatInt(sub.Delivered, 10),
+			strconv.FormatInt(sub.Failed, 10), strconv.FormatInt(sub.Dropped, 10),
+		})
+	}
+	return a.print(status, t)
+}
+
+func retentionTable(policies []models.RetentionPolicy) table {
+	t := table{header: []string{"id", "metric", "kind", "raw_days", "hourly_days", "daily_days", "created_at"}}
+	for _, p := range policies {
+		metric := ""
+		if p.MetricID != nil {
+			metric = p.MetricID.String()
+		}
+		t.rows = append(t.rows, []string{
+			p.ID.String(), metric, p.Kind, strconv.Itoa(p.RawDays), strconv.Itoa(p.HourlyDays),
+			strconv.Itoa(p.DailyDays), formatTime(p.CreatedAt),
+		})
+	}
+	return t
+}
+
+func listRetentionPolicies(ctx context.Context, a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	policies, err := a.client.ListRetentionPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(policies, retentionTable(policies.Policies))
+}
+
+func createRetentionPolicy(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("retention create", flag.ContinueOnError)
+	metric := fs.String("metric", "", "metric the policy applies to")
+	kind := fs.String("kind", "", "kind of metrics the policy applies to")
+	raw := fs.Int("raw", 0, "days readings are kept raw")
+	hourly := fs.Int("hourly", 0, "days readings are kept rolled up by hour")
+	daily := fs.Int("daily", 0, "days readings are kept rolled up by day")
+	if rest, err := parseArgs(fs, args); err != nil || len(rest) != 0 {
+		return errUsage
+	}
+
+	req := models.CreateRetentionPolicyRequest{Kind: *kind, RawDays: *raw, HourlyDays: *hourly, DailyDays: *daily}
+	if *metric != "" {
+		id, err := uuid.Parse(*metric)
+		if err != nil {
+			return fmt.Errorf("invalid metric ID %q", *metric)
+		}
+		req.MetricID = &id
+	}
+
+	policy, err := a.client.CreateRetentionPolicy(ctx, req)
+	if err != nil {
+		return err
+	}
+	return a.print(policy, retentionTable([]models.RetentionPolicy{*policy}))
+}
+
+func deleteRetentionPolicy(ctx context.Context, a *app, args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+	return a.client.DeleteRetentionPolicy(ctx, id)
+}
+
+func compactReadings(ctx context.Context, a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	result, err := a.client.CompactReadings(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(result, table{
+		header: []string{"metrics", "raw_rolled_up", "hourly_rolled_up", "daily_deleted"},
+		rows: [][]string{{
+			strconv.Itoa(result.Metrics), strconv.Itoa(result.RawRolledUp),
+			strconv.Itoa(result.HourlyRolledUp), strconv.Itoa(result.DailyDeleted),
+		}},
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// config is what hmctl remembers between runs
+type config struct {
+	Server   string `json:"server,omitempty"`
+	Username string `json:"username,omitempty"`
+	Token    string `json:"token,omitempty"` // issued by Server at the last login
+}
+
+// defaultConfigPath returns hmctl/config.json in the user config directory
+func defaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find config directory: %w", err)
+	}
+	return filepath.Join(dir, "hmctl", "config.json"), nil
+}
+
+// loadConfig reads the config file; a missing one is empty
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// save writes the config file, readable only by its owner as it holds a token
+func (c *config) save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+// tokenFor returns the cached token if it was issued by server
+func (c *config) tokenFor(server string) string {
+	if c.Server != server {
+		return ""
+	}
+	return c.Token
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+func init() {
+	commands["devices list"] = command{
+		usage: "",
+		help:  "list devices and their health",
+		run:   listDevices,
+	}
+	commands["devices create"] = command{
+		usage: "-serial SERIAL -metrics METRIC_ID,... [-model MODEL] [-installed DATE] [-calibration-due DATE] [-oms-key HEX]",
+		help:  "register a meter",
+		run:   createDevice,
+	}
+	commands["devices key"] = command{
+		usage: "DEVICE_ID [-name NAME]",
+		help:  "issue an API key for a device; it is only shown once",
+		run:   createDeviceKey,
+	}
+	commands["devices revoke-key"] = command{
+		usage: "DEVICE_ID KEY_ID",
+		help:  "revoke an API key of a device",
+		run:   revokeDeviceKey,
+	}
+	commands["devices modbus"] = command{
+		usage: "",
+		help:  "show how polling the Modbus devices goes",
+		run:   modbusStatus,
+	}
+}
+
+func devicesTable(devices []models.Device) table {
+	t := table{header: []string{"id", "serial", "model", "health", "last_seen", "calibration_due", "metrics", "keys"}}
+	for _, d := range devices {
+		keys := 0
+		for _, k := range d.Keys {
+			if k.RevokedAt == nil {
+				keys++
+			}
+		}
+		t.rows = append(t.rows, []string{
+			d.ID.String(), d.Serial, d.Model, d.Health, formatTimePtr(d.LastSeenAt), formatTimePtr(d.CalibrationDue),
+			strconv.Itoa(len(d.MetricIDs)), strconv.Itoa(keys),
+		})
+	}
+	return t
+}
+
+// formatTimePtr formats an optional time
+func formatTimePtr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return formatTime(*t)
+}
+
+func modbusTable(devices []models.ModbusDeviceStatus) table {
+	t := table{header: []string{"name", "host", "unit", "serial", "state", "last_success", "failures", "next_poll", "error"}}
+	for _, d := range devices {
+		t.rows = append(t.rows, []string{
+			d.Name, d.Host, strconv.Itoa(int(d.UnitID)), d.Serial, d.State, formatTimePtr(d.LastSuccessAt),
+			strconv.Itoa(d.ConsecutiveFailures), formatTimePtr(d.NextPollAt), d.LastError,
+		})
+	}
+	return t
+}
+
+func listDevices(ctx context.Context, a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	devices, err := a.client.ListDevices(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(devices, devicesTable(devices.Devices))
+}
+
+func createDevice(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("devices create", flag.ContinueOnError)
+	serial := fs.String("serial", "", "serial number of the meter")
+	model := fs.String("model", "", "model of the meter")
+	metrics := fs.String("metrics", "", "comma separated IDs of the metrics the meter records")
+	installed := fs.String("installed", "", "date the meter was installed")
+	calibrationDue := fs.String("calibration-due", "", "date the meter is due for calibration")
+	omsKey := fs.String("oms-key", "", "AES key of the meter's encrypted M-Bus telegrams, 32 hex digits")
+	if rest, err := parseArgs(fs, args); err != nil || len(rest) != 0 || *serial == "" || *metrics == "" {
+		return errUsage
+	}
+
+	req := models.CreateDeviceRequest{Serial: *serial, Model: *model, OMSKey: *omsKey}
+	for _, s := range splitList(*metrics) {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return fmt.Errorf("invalid metric ID %q", s)
+		}
+		req.MetricIDs = append(req.MetricIDs, id)
+	}
+	if *installed != "" {
+		t, err := parseTime(*installed)
+		if err != nil {
+			return err
+		}
+		req.InstalledAt = &t
+	}
+	if *calibrationDue != "" {
+		t, err := parseTime(*calibrationDue)
+		if err != nil {
+			return err
+		}
+		req.CalibrationDue = &t
+	}
+
+	device, err := a.client.CreateDevice(ctx, req)
+	if err != nil {
+		return err
+	}
+	return a.print(device, devicesTable([]models.Device{*device}))
+}
+
+func createDeviceKey(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("devices key", flag.ContinueOnError)
+	name := fs.String("name", "", "what the key is for")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(rest)
+	if err != nil {
+		return err
+	}
+
+	key, err := a.client.CreateDeviceKey(ctx, id, *name)
+	if err != nil {
+		return err
+	}
+	if a.format == formatTable {
+		fmt.Fprintln(os.Stderr, "Store the key now; it is not shown again.")
+	}
+	return a.print(key, table{
+		header: []string{"id", "name", "key"},
+		rows:   [][]string{{key.ID.String(), key.Name, key.Key}},
+	})
+}
+
+func revokeDeviceKey(ctx context.Context, a *app, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	ids := make([]uuid.UUID, len(args))
+	for i, arg := range args {
+		id, err := uuid.Parse(arg)
+		if err != nil {
+			return fmt.Errorf("invalid ID %q", arg)
+		}
+		ids[i] = id
+	}
+
+	if err := a.client.RevokeDeviceKey(ctx, ids[0], ids[1]); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Key %s revoked\n", ids[1])
+	return nil
+}
+
+func modbusStatus(ctx context.Context, a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	status, err := a.client.ModbusStatus(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(status, modbusTable(status.Devices))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+func init() {
+	commands["login"] = command{
+		usage: "-u USERNAME [-p PASSWORD]",
+		help:  "log in and cache the token in the config file",
+		run:   login,
+	}
+	commands["logout"] = command{
+		usage: "",
+		help:  "end the session and forget the cached token",
+		run:   logout,
+	}
+	commands["whoami"] = command{
+		usage: "",
+		help:  "show the logged in user",
+		run:   whoami,
+	}
+}
+
+func login(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	username := fs.String("u", a.cfg.Username, "username")
+	password := fs.String("p", "", "password (default $HMCTL_PASSWORD, or read from standard input)")
+	if rest, err := parseArgs(fs, args); err != nil || len(rest) != 0 || *username == "" {
+		return errUsage
+	}
+
+	if *password == "" {
+		*password = os.Getenv("HMCTL_PASSWORD")
+	}
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(a.stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	resp, err := a.client.Login(ctx, models.LoginRequest{Username: *username, Password: *password})
+	if err != nil {
+		return err
+	}
+
+	a.cfg.Server = a.server
+	a.cfg.Username = *username
+	a.cfg.Token = resp.Token
+	if err := a.cfg.save(a.cfgPath); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Logged in to %s as %s\n", a.server, *username)
+	return nil
+}
+
+func logout(ctx context.Context, a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	if a.cfg.Token == "" {
+		return nil
+	}
+
+	// The token is forgotten even if the server cannot be told
+	sessions, err := a.client.ListSessions(ctx)
+	if err == nil {
+		for _, session := range sessions.Sessions {
+			if session.Current {
+				err = a.client.RevokeSession(ctx, session.ID)
+			}
+		}
+	}
+
+	a.cfg.Token = ""
+	if saveErr := a.cfg.save(a.cfgPath); saveErr != nil {
+		return saveErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hmctl: session not ended on the server: %v\n", err)
+	}
+	return nil
+}
+
+func whoami(ctx context.Context, a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	user, err := a.client.CurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(user, usersTable([]models.User{*user}))
+}
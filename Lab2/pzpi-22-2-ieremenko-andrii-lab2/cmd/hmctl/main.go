@@ -0,0 +1,229 @@
+// Command hmctl manages the household metrics service from the command line.
+//
+// Usage:
+//
+//	hmctl [-server URL] [-config FILE] [-o table|json|csv] <command> [arguments]
+//
+// Run hmctl without arguments for the list of commands. "hmctl login" caches
+// a token in the config file. Scripts run by cron can instead set
+// HMCTL_USERNAME and HMCTL_PASSWORD, with which hmctl logs in by itself and
+// keeps its token fresh, or HMCTL_TOKEN. HMCTL_SERVER sets the server.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/client"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+// defaultServer is used when neither the flags, the environment nor the
+// config file name a server
+const defaultServer = "http://localhost:8080"
+
+// errUsage marks errors in the command line, which exit with status 2
+var errUsage = errors.New("usage")
+
+// app holds what every command needs
+type app struct {
+	cfg     *config
+	cfgPath string
+	server  string
+	format  string
+	client  *client.Client
+	stdin   io.Reader
+	stdout  io.Writer
+}
+
+// command is a subcommand of hmctl
+type command struct {
+	usage string // arguments and flags, after the command name
+	help  string
+	run   func(ctx context.Context, a *app, args []string) error
+}
+
+// commands maps "group action" and single word command names to commands
+var commands = map[string]command{}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:])
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hmctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("hmctl", flag.ContinueOnError)
+	fs.Usage = func() { usage(fs.Output()) }
+	server := fs.String("server", "", "URL of the server (default $HMCTL_SERVER, the config file or "+defaultServer+")")
+	cfgPath := fs.String("config", "", "config file (default $HMCTL_CONFIG or hmctl/config.json in the user config directory)")
+	format := fs.String("o", formatTable, "output format: table, json or csv")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return errUsage
+	}
+
+	switch *format {
+	case formatTable, formatJSON, formatCSV:
+	default:
+		fmt.Fprintf(os.Stderr, "hmctl: unknown output format %q\n", *format)
+		return errUsage
+	}
+
+	name, rest, ok := lookup(fs.Args())
+	if !ok {
+		usage(os.Stderr)
+		return errUsage
+	}
+	cmd := commands[name]
+
+	a := &app{
+		cfgPath: *cfgPath,
+		format:  *format,
+		stdin:   os.Stdin,
+		stdout:  os.Stdout,
+	}
+	if err := a.setup(ctx, *server); err != nil {
+		return err
+	}
+
+	err := cmd.run(ctx, a, rest)
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: hmctl %s %s\n", name, cmd.usage)
+	}
+	return err
+}
+
+// lookup finds the command named by the first one or two arguments
+func lookup(args []string) (string, []string, bool) {
+	if len(args) >= 2 {
+		if _, ok := commands[args[0]+" "+args[1]]; ok {
+			return args[0] + " " + args[1], args[2:], true
+		}
+	}
+	if len(args) >= 1 {
+		if _, ok := commands[args[0]]; ok {
+			return args[0], args[1:], true
+		}
+	}
+	return "", nil, false
+}
+
+// setup loads the config file and creates the client
+func (a *app) setup(ctx context.Context, server string) error {
+	if a.cfgPath == "" {
+		a.cfgPath = os.Getenv("HMCTL_CONFIG")
+	}
+	if a.cfgPath == "" {
+		path, err := defaultConfigPath()
+		if err != nil {
+			return err
+		}
+		a.cfgPath = path
+	}
+
+	cfg, err := loadConfig(a.cfgPath)
+	if err != nil {
+		return err
+	}
+	a.cfg = cfg
+
+	a.server = firstOf(server, os.Getenv("HMCTL_SERVER"), cfg.Server, defaultServer)
+
+	var opts []client.Option
+	if token := firstOf(os.Getenv("HMCTL_TOKEN"), cfg.tokenFor(a.server)); token != "" {
+		opts = append(opts, client.WithToken(token))
+	}
+	tlsConfig, err := tlsFromEnv()
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		opts = append(opts, client.WithTLSConfig(tlsConfig))
+	}
+	a.client = client.NewClient(strings.TrimRight(a.server, "/"), opts...)
+
+	username, password := os.Getenv("HMCTL_USERNAME"), os.Getenv("HMCTL_PASSWORD")
+	if username != "" && password != "" {
+		if _, err := a.client.Login(ctx, models.LoginRequest{Username: username, Password: password}); err != nil {
+			return fmt.Errorf("failed to log in as %s: %w", username, err)
+		}
+	}
+	return nil
+}
+
+// tlsFromEnv returns the TLS settings given by HMCTL_CACERT, a CA file the
+// server certificate is verified against, and HMCTL_CERT and HMCTL_KEY, a
+// client certificate to authenticate as a meter gateway. It returns nil when
+// none of them are set.
+func tlsFromEnv() (*tls.Config, error) {
+	caFile, certFile, keyFile := os.Getenv("HMCTL_CACERT"), os.Getenv("HMCTL_CERT"), os.Getenv("HMCTL_KEY")
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// firstOf returns the first non-empty value
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: hmctl [-server URL] [-config FILE] [-o table|json|csv] <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-18s %s\n", name, commands[name].help)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+func init() {
+	commands["metrics list"] = command{
+		usage: "[-mine] [-room ROOM_ID]",
+		help:  "list metrics",
+		run:   listMetrics,
+	}
+	commands["metrics get"] = command{
+		usage: "ID",
+		help:  "show a metric",
+		run:   getMetric,
+	}
+	commands["metrics create"] = command{
+		usage: "-name NAME -unit UNIT -room ROOM_ID [-kind KIND] [-description TEXT] [-serial SERIAL]",
+		help:  "create a metric",
+		run:   createMetric,
+	}
+	commands["metrics update"] = command{
+		usage: "ID [-name NAME] [-unit UNIT] [-room ROOM_ID] [-kind KIND] [-description TEXT] [-serial SERIAL] [-if-match VERSION]",
+		help:  "change a metric",
+		run:   updateMetric,
+	}
+	commands["metrics delete"] = command{
+		usage: "ID [-if-match VERSION]",
+		help:  "move a metric to the trash",
+		run:   deleteMetric,
+	}
+	commands["correlate"] = command{
+		usage: "METRIC1_ID METRIC2_ID [-from TIME] [-to TIME]",
+		help:  "correlate the readings of two metrics, over the last 30 days by default",
+		run:   correlate,
+	}
+}
+
+func metricsTable(metrics []models.Metric) table {
+	t := table{header: []string{"id", "name", "kind", "unit", "meter_serial", "room", "version"}}
+	for _, m := range metrics {
+		t.rows = append(t.rows, []string{
+			m.ID.String(), m.Name, m.Kind, m.Unit, m.MeterSerial, m.RoomID.String(), strconv.FormatInt(m.Version, 10),
+		})
+	}
+	return t
+}
+
+func listMetrics(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("metrics list", flag.ContinueOnError)
+	mine := fs.Bool("mine", false, "only metrics in rooms owned by the logged in user")
+	room := fs.String("room", "", "only metrics in this room")
+	if rest, err := parseArgs(fs, args); err != nil || len(rest) != 0 {
+		return errUsage
+	}
+
+	list := a.client.ListMetrics
+	if *mine {
+		list = a.client.MyMetrics
+	}
+	metrics, err := list(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *room != "" {
+		roomID, err := uuid.Parse(*room)
+		if err != nil {
+			return fmt.Errorf("invalid room ID %q", *room)
+		}
+		filtered := metrics.Metrics[:0]
+		for _, m := range metrics.Metrics {
+			if m.RoomID == roomID {
+				filtered = append(filtered, m)
+			}
+		}
+		metrics.Metrics, metrics.Total = filtered, len(filtered)
+	}
+
+	return a.print(metrics, metricsTable(metrics.Metrics))
+}
+
+func getMetric(ctx context.Context, a *app, args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	metric, err := a.client.GetMetric(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.print(metric, metricsTable([]models.Metric{metric.Metric}))
+}
+
+// metricFlags binds the flags shared by metrics create and metrics update
+type metricFlags struct {
+	fs                                    *flag.FlagSet
+	name, unit, kind, description, serial *string
+	room                                  *string
+}
+
+func newMetricFlags(name string) *metricFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	return &metricFlags{
+		fs:          fs,
+		name:        fs.String("name", "", "name"),
+		unit:        fs.String("unit", "", "unit of the readings, e.g. kWh"),
+		kind:        fs.String("kind", "", "electricity, water, gas or heat"),
+		description: fs.String("description", "", "description"),
+		serial:      fs.String("serial", "", "serial number of the meter"),
+		room:        fs.String("room", "", "ID of the room"),
+	}
+}
+
+func (f *metricFlags) roomID() (uuid.UUID, error) {
+	id, err := uuid.Parse(*f.room)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid room ID %q", *f.room)
+	}
+	return id, nil
+}
+
+func createMetric(ctx context.Context, a *app, args []string) error {
+	f := newMetricFlags("metrics create")
+	if rest, err := parseArgs(f.fs, args); err != nil || len(rest) != 0 || *f.name == "" || *f.unit == "" || *f.room == "" {
+		return errUsage
+	}
+	roomID, err := f.roomID()
+	if err != nil {
+		return err
+	}
+
+	metric, err := a.client.CreateMetric(ctx, models.CreateMetricRequest{
+		Name:        *f.name,
+		Description: *f.description,
+		Unit:        *f.unit,
+		Kind:        *f.kind,
+		MeterSerial: *f.serial,
+		RoomID:      roomID,
+	})
+	if err != nil {
+		return err
+	}
+	return a.print(metric, metricsTable([]models.Metric{*metric}))
+}
+
+func updateMetric(ctx context.Context, a *app, args []string) error {
+	f := newMetricFlags("metrics update")
+	ifMatch := f.fs.Int64("if-match", 0, "only change this version of the metric")
+	rest, err := parseArgs(f.fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(rest)
+	if err != nil {
+		return err
+	}
+
+	// Only the flags given are changed
+	var req models.UpdateMetricRequest
+	if isSet(f.fs, "name") {
+		req.Name = f.name
+	}
+	if isSet(f.fs, "unit") {
+		req.Unit = f.unit
+	}
+	if isSet(f.fs, "kind") {
+		req.Kind = f.kind
+	}
+	if isSet(f.fs, "description") {
+		req.Description = f.description
+	}
+	if isSet(f.fs, "serial") {
+		req.MeterSerial = f.serial
+	}
+	if isSet(f.fs, "room") {
+		roomID, err := f.roomID()
+		if err != nil {
+			return err
+		}
+		req.RoomID = &roomID
+	}
+
+	metric, err := a.client.UpdateMetric(ctx, id, req, versionOption(f.fs, *ifMatch)...)
+	if err != nil {
+		return err
+	}
+	return a.print(metric, metricsTable([]models.Metric{*metric}))
+}
+
+func deleteMetric(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("metrics delete", flag.ContinueOnError)
+	ifMatch := fs.Int64("if-match", 0, "only delete this version of the metric")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(rest)
+	if err != nil {
+		return err
+	}
+
+	return a.client.DeleteMetric(ctx, id, versionOption(fs, *ifMatch)...)
+}
+
+func correlate(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("correlate", flag.ContinueOnError)
+	from := fs.String("from", "", "period start (default 30 days ago)")
+	to := fs.String("to", "", "period end (default now)")
+	rest, err := parseArgs(fs, args)
+	if err != nil || len(rest) != 2 {
+		return errUsage
+	}
+
+	req := models.CorrelationRequest{
+		StartTime: time.Now().AddDate(0, 0, -30),
+		EndTime:   time.Now(),
+	}
+	if req.Metric1ID, err = uuid.Parse(rest[0]); err != nil {
+		return fmt.Errorf("invalid ID %q", rest[0])
+	}
+	if req.Metric2ID, err = uuid.Parse(rest[1]); err != nil {
+		return fmt.Errorf("invalid ID %q", rest[1])
+	}
+	if *from != "" {
+		if req.StartTime, err = parseTime(*from); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if req.EndTime, err = parseTime(*to); err != nil {
+			return err
+		}
+	}
+
+	c, err := a.client.CalculateCorrelation(ctx, req)
+	if err != nil {
+		return err
+	}
+	return a.print(c, table{
+		header: []string{"metric1", "metric2", "from", "to", "correlation", "message"},
+		rows: [][]string{{
+			c.Metric1Name, c.Metric2Name, formatTime(c.StartTime), formatTime(c.EndTime), formatFloat(c.Correlation), c.Message,
+		}},
+	})
+}
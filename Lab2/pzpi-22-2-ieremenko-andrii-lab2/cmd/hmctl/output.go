@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Output formats
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+// table is the tabular form of a result, used by the table and CSV formats
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// print writes v as JSON, or t as a table or CSV
+func (a *app) print(v interface{}, t table) error {
+	switch a.format {
+	case formatJSON:
+		enc := json.NewEncoder(a.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+
+	case formatCSV:
+		w := csv.NewWriter(a.stdout)
+		w.Write(t.header)
+		w.WriteAll(t.rows)
+		return w.Error()
+
+	default:
+		w := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(t.header, "\t")))
+		for _, row := range t.rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	}
+}
+
+// stream writes results as they arrive: JSON as one object per line, tables
+// and CSV with the header before the first row. Table columns are aligned
+// within each batch written between calls to flush.
+type stream struct {
+	a      *app
+	header []string
+	csv    *csv.Writer
+	tab    *tabwriter.Writer
+}
+
+func (a *app) stream(header []string) *stream {
+	s := &stream{a: a, header: header}
+	switch a.format {
+	case formatCSV:
+		s.csv = csv.NewWriter(a.stdout)
+		s.csv.Write(header)
+		s.csv.Flush()
+	case formatTable:
+		s.tab = tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(s.tab, strings.ToUpper(strings.Join(header, "\t")))
+	}
+	return s
+}
+
+// write outputs a single result
+func (s *stream) write(v interface{}, row []string) error {
+	switch {
+	case s.csv != nil:
+		s.csv.Write(row)
+		s.csv.Flush()
+		return s.csv.Error()
+	case s.tab != nil:
+		_, err := fmt.Fprintln(s.tab, strings.Join(row, "\t"))
+		return err
+	default:
+		return json.NewEncoder(s.a.stdout).Encode(v)
+	}
+}
+
+// flush writes out the table rows written so far
+func (s *stream) flush() error {
+	if s.tab == nil {
+		return nil
+	}
+	return s.tab.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatID(id uuid.UUID) string {
+	if id == uuid.Nil {
+		return ""
+	}
+	return id.String()
+}
+
+// parseTime accepts RFC 3339 timestamps and plain dates
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use RFC 3339 or YYYY-MM-DD", value)
+}
+
+// parseArgs parses flags that may come before, after or between the
+// positional arguments, which it returns
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	fs.SetOutput(io.Discard)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			fmt.Fprintf(os.Stderr, "hmctl: %v\n", err)
+			return nil, errUsage
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// isSet reports whether a flag was given on the command line
+func isSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// parseID parses the single positional argument of a command as an ID
+func parseID(args []string) (uuid.UUID, error) {
+	if len(args) != 1 {
+		return uuid.Nil, errUsage
+	}
+	id, err := uuid.Parse(args[0])
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid ID %q", args[0])
+	}
+	return id, nil
+}
+
+// splitList splits a comma separated flag value
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
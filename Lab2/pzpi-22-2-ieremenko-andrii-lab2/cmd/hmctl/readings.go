@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/client"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+func init() {
+	commands["readings list"] = command{
+		usage: "METRIC_ID [-from TIME] [-to TIME]",
+		help:  "list the readings of a metric",
+		run:   listReadings,
+	}
+	commands["readings series"] = command{
+		usage: "METRIC_ID [-from TIME] [-to TIME] [-resolution raw|hour|day]",
+		help:  "show the readings of a metric over a range, rolled up as they are kept",
+		run:   readingSeries,
+	}
+	commands["readings push"] = command{
+		usage: "[METRIC_ID] [-file FILE]",
+		help:  "record readings read as CSV from standard input or a file",
+		run:   pushReadings,
+	}
+	commands["readings telegram"] = command{
+		usage: "HEX [-received TIME]",
+		help:  "record the readings of an M-Bus telegram and show its records",
+		run:   sendTelegram,
+	}
+	commands["readings tail"] = command{
+		usage: "METRIC_ID [-n COUNT]",
+		help:  "print the latest readings of a metric and follow new and corrected ones",
+		run:   tailReadings,
+	}
+	commands["readings correct"] = command{
+		usage: "METRIC_ID READING_ID [-value VALUE] [-timestamp TIME] -reason TEXT",
+		help:  "correct a reading, keeping the previous value as a revision",
+		run:   correctReading,
+	}
+}
+
+var readingHeader = []string{"id", "metric", "timestamp", "value", "revisions"}
+
+func readingRow(r models.MetricReading) []string {
+	return []string{r.ID.String(), r.MetricID.String(), formatTime(r.Timestamp), formatFloat(r.Value), strconv.Itoa(len(r.Revisions))}
+}
+
+func readingsTable(readings []models.MetricReading) table {
+	t := table{header: readingHeader}
+	for _, r := range readings {
+		t.rows = append(t.rows, readingRow(r))
+	}
+	return t
+}
+
+// sortReadings orders readings by time, oldest first
+func sortReadings(readings []models.MetricReading) {
+	sort.SliceStable(readings, func(i, j int) bool {
+		return readings[i].Timestamp.Before(readings[j].Timestamp)
+	})
+}
+
+func listReadings(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("readings list", flag.ContinueOnError)
+	from := fs.String("from", "", "only readings taken at or after")
+	to := fs.String("to", "", "only readings taken before")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(rest)
+	if err != nil {
+		return err
+	}
+
+	var start, end time.Time
+	if *from != "" {
+		if start, err = parseTime(*from); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if end, err = parseTime(*to); err != nil {
+			return err
+		}
+	}
+
+	readings, err := a.client.GetReadings(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	selected := readings.Readings[:0]
+	for _, r := range readings.Readings {
+		if (start.IsZero() || !r.Timestamp.Before(start)) && (end.IsZero() || r.Timestamp.Before(end)) {
+			selected = append(selected, r)
+		}
+	}
+	sortReadings(selected)
+	readings.Readings, readings.Total = selected, len(selected)
+
+	return a.print(readings, readingsTable(readings.Readings))
+}
+
+func readingSeries(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("readings series", flag.ContinueOnError)
+	from := fs.String("from", "", "range start, a day before the end by default")
+	to := fs.String("to", "", "range end, now by default")
+	resolution := fs.String("resolution", "", "raw, hour or day; chosen for the range by default")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(rest)
+	if err != nil {
+		return err
+	}
+
+	q := client.SeriesQuery{Resolution: *resolution}
+	if *from != "" {
+		if q.From, err = parseTime(*from); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if q.To, err = parseTime(*to); err != nil {
+			return err
+		}
+	}
+
+	series, err := a.client.GetReadingSeries(ctx, id, q)
+	if err != nil {
+		return err
+	}
+	t := table{header: []string{"start", "resolution", "count", "sum", "min", "max", "avg"}}
+	for _, p := range series.Points {
+		t.rows = append(t.rows, []string{
+			formatTime(p.Start), p.Resolution, strconv.Itoa(p.Count),
+			formatFloat(p.Sum), formatFloat(p.Min), formatFloat(p.Max), formatFloat(p.Avg),
+		})
+	}
+	return a.print(series, t)
+}
+
+// pushReadings records readings from CSV records of the form value[,timestamp]
+// when a metric is given, and metric_id,value[,timestamp] otherwise. A header
+// line is skipped, and readings without a timestamp are taken now. Every
+// record is tried; the command fails if any of them did.
+func pushReadings(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("readings push", flag.ContinueOnError)
+	file := fs.String("file", "-", "CSV file to read, - for standard input")
+	rest, err := parseArgs(fs, args)
+	if err != nil || len(rest) > 1 {
+		return errUsage
+	}
+
+	var metricID uuid.UUID
+	if len(rest) == 1 {
+		if metricID, err = parseID(rest); err != nil {
+			return err
+		}
+	}
+
+	in := a.stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	r := csv.NewReader(in)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	out := a.stream(readingHeader)
+	failed := 0
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		id, req, err := parseReadingRecord(record, metricID)
+		if err != nil {
+			// A header is recognized by not parsing
+			if line == 1 {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "hmctl: line %d: %v\n", line, err)
+			failed++
+			continue
+		}
+
+		reading, err := a.client.AddReading(ctx, id, req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "hmctl: line %d: %v\n", line, err)
+			failed++
+			continue
+		}
+		if err := out.write(reading, readingRow(*reading)); err != nil {
+			return err
+		}
+	}
+
+	if err := out.flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d readings were not recorded", failed)
+	}
+	return nil
+}
+
+// parseReadingRecord parses a CSV record read by readings push
+func parseReadingRecord(record []string, metricID uuid.UUID) (uuid.UUID, models.AddReadingRequest, error) {
+	var req models.AddReadingRequest
+
+	if metricID == uuid.Nil {
+		if len(record) < 2 {
+			return uuid.Nil, req, fmt.Errorf("expected metric_id,value[,timestamp]")
+		}
+		id, err := uuid.Parse(strings.TrimSpace(record[0]))
+		if err != nil {
+			return uuid.Nil, req, fmt.Errorf("invalid metric ID %q", record[0])
+		}
+		metricID, record = id, record[1:]
+	}
+
+	if len(record) < 1 || len(record) > 2 {
+		return uuid.Nil, req, fmt.Errorf("expected value[,timestamp]")
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+	if err != nil {
+		return uuid.Nil, req, fmt.Errorf("invalid value %q", record[0])
+	}
+	req.Value = &value
+
+	if len(record) == 2 && strings.TrimSpace(record[1]) != "" {
+		if req.Timestamp, err = parseTime(strings.TrimSpace(record[1])); err != nil {
+			return uuid.Nil, req, err
+		}
+	}
+	return metricID, req, nil
+}
+
+func tailReadings(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("readings tail", flag.ContinueOnError)
+	count := fs.Int("n", 10, "number of earlier readings to print first")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return errUsage
+	}
+	id, err := parseID(rest)
+	if err != nil {
+		return err
+	}
+
+	out := a.stream(readingHeader)
+	seen := make(map[uuid.UUID]bool)
+	// catchUp prints the readings not printed yet, the latest count of them
+	// at most
+	catchUp := func(count int) error {
+		readings, err := a.client.GetReadings(ctx, id)
+		if err != nil {
+			return err
+		}
+		var fresh []models.MetricReading
+		for _, r := range readings.Readings {
+			if !seen[r.ID] {
+				seen[r.ID] = true
+				fresh = append(fresh, r)
+			}
+		}
+		sortReadings(fresh)
+		if count >= 0 && len(fresh) > count {
+			fresh = fresh[len(fresh)-count:]
+		}
+		for _, r := range fresh {
+			if err := out.write(r, readingRow(r)); err != nil {
+				return err
+			}
+		}
+		return out.flush()
+	}
+	if err := catchUp(*count); err != nil {
+		return err
+	}
+
+	err = a.client.WatchReadings(ctx, id, func(e models.StreamEvent) error {
+		switch e.Type {
+		case models.StreamGap:
+			return catchUp(-1)
+		case models.StreamReading, models.StreamReadingCorrected:
+			// Corrections are printed again with their new value
+			if seen[e.Reading.ID] && e.Type == models.StreamReading {
+				return nil
+			}
+			seen[e.Reading.ID] = true
+			if err := out.write(*e.Reading, readingRow(*e.Reading)); err != nil {
+				return err
+			}
+			return out.flush()
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func correctReading(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("readings correct", flag.ContinueOnError)
+	value := fs.Float64("value", 0, "corrected value")
+	timestamp := fs.String("timestamp", "", "corrected time the reading was taken")
+	reason := fs.String("reason", "", "why the reading is corrected")
+	rest, err := parseArgs(fs, args)
+	if err != nil || len(rest) != 2 || *reason == "" {
+		return errUsage
+	}
+
+	metricID, err := uuid.Parse(rest[0])
+	if err != nil {
+		return fmt.Errorf("invalid ID %q", rest[0])
+	}
+	readingID, err := uuid.Parse(rest[1])
+	if err != nil {
+		return fmt.Errorf("invalid ID %q", rest[1])
+	}
+
+	req := models.CorrectReadingRequest{Reason: *reason}
+	if isSet(fs, "value") {
+		req.Value = value
+	}
+	if *timestamp != "" {
+		t, err := parseTime(*timestamp)
+		if err != nil {
+			return err
+		}
+		req.Timestamp = &t
+	}
+
+	reading, err := a.client.CorrectReading(ctx, metricID, readingID, req)
+	if err != nil {
+		return err
+	}
+	return a.print(reading, readingsTable([]models.MetricReading{*reading}))
+}
+
+func telegramTable(t *models.TelegramResponse) table {
+	tb := table{header: []string{"quantity", "value", "unit", "function", "storage", "tariff", "subunit", "metric"}}
+	for _, r := range t.Records {
+		value := formatTimePtr(r.Time)
+		if r.Value != nil {
+			value = formatFloat(*r.Value)
+		}
+		metrics := make([]string, len(r.MetricIDs))
+		for i, id := range r.MetricIDs {
+			metrics[i] = id.String()
+		}
+		tb.rows = append(tb.rows, []string{
+			r.Quantity, value, r.Unit, r.Function, strconv.Itoa(r.Storage), strconv.Itoa(r.Tariff), strconv.Itoa(r.Subunit), strings.Join(metrics, ","),
+		})
+	}
+	return tb
+}
+
+func sendTelegram(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("readings telegram", flag.ContinueOnError)
+	received := fs.String("received", "", "when the gateway received the telegram (default now)")
+	rest, err := parseArgs(fs, args)
+	if err != nil || len(rest) != 1 {
+		return errUsage
+	}
+
+	req := models.TelegramRequest{Telegram: rest[0]}
+	if *received != "" {
+		if req.ReceivedAt, err = parseTime(*received); err != nil {
+			return err
+		}
+	}
+
+	telegram, err := a.client.SendTelegram(ctx, req)
+	if err != nil {
+		return err
+	}
+	if telegram.Duplicate {
+		fmt.Fprintln(os.Stderr, "telegram was received before; nothing was recorded")
+	}
+	return a.print(telegram, telegramTable(telegram))
+}
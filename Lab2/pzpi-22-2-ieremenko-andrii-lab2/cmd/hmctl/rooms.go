@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/client"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+func init() {
+	commands["rooms list"] = command{
+		usage: "[-mine]",
+		help:  "list rooms",
+		run:   listRooms,
+	}
+	commands["rooms get"] = command{
+		usage: "ID",
+		help:  "show a room",
+		run:   getRoom,
+	}
+	commands["rooms create"] = command{
+		usage: "-name NAME [-description TEXT] [-building NAME] [-complex NAME] [-area M2] [-occupants N] [-owner USER_ID]",
+		help:  "create a room",
+		run:   createRoom,
+	}
+	commands["rooms update"] = command{
+		usage: "ID [-name NAME] [-description TEXT] [-building NAME] [-complex NAME] [-area M2] [-occupants N] [-owner USER_ID] [-if-match VERSION]",
+		help:  "change a room",
+		run:   updateRoom,
+	}
+	commands["rooms delete"] = command{
+		usage: "ID [-if-match VERSION]",
+		help:  "move a room and its metrics to the trash",
+		run:   deleteRoom,
+	}
+	commands["benchmark"] = command{
+		usage: "ROOM_ID -kind KIND [-scope building|complex] [-normalize area|occupant] [-from TIME] [-to TIME]",
+		help:  "compare the consumption of a room with its peers",
+		run:   benchmark,
+	}
+}
+
+func roomsTable(rooms []models.Room) table {
+	t := table{header: []string{"id", "name", "building", "complex", "area", "occupants", "owner", "version"}}
+	for _, r := range rooms {
+		t.rows = append(t.rows, []string{
+			r.ID.String(), r.Name, r.Building, r.Complex, formatFloat(r.Area),
+			strconv.Itoa(r.Occupants), formatID(r.OwnerID), strconv.FormatInt(r.Version, 10),
+		})
+	}
+	return t
+}
+
+func listRooms(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("rooms list", flag.ContinueOnError)
+	mine := fs.Bool("mine", false, "only rooms owned by the logged in user")
+	if rest, err := parseArgs(fs, args); err != nil || len(rest) != 0 {
+		return errUsage
+	}
+
+	list := a.client.ListRooms
+	if *mine {
+		list = a.client.MyRooms
+	}
+	rooms, err := list(ctx)
+	if err != nil {
+		return err
+	}
+	return a.print(rooms, roomsTable(rooms.Rooms))
+}
+
+func getRoom(ctx context.Context, a *app, args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	room, err := a.client.GetRoom(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.print(room, roomsTable([]models.Room{*room}))
+}
+
+// roomFlags binds the flags shared by rooms create and rooms update
+type roomFlags struct {
+	fs                                   *flag.FlagSet
+	name, description, building, complex *string
+	area                                 *float64
+	occupants                            *int
+	owner                                *string
+}
+
+func newRoomFlags(name string) *roomFlags {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	return &roomFlags{
+		fs:          fs,
+		name:        fs.String("name", "", "name"),
+		description: fs.String("description", "", "description"),
+		building:    fs.String("building", "", "building"),
+		complex:     fs.String("complex", "", "residential complex"),
+		area:        fs.Float64("area", 0, "floor area in m²"),
+		occupants:   fs.Int("occupants", 0, "number of occupants"),
+		owner:       fs.String("owner", "", "ID of the owning user"),
+	}
+}
+
+func (f *roomFlags) ownerID() (uuid.UUID, error) {
+	if *f.owner == "" {
+		return uuid.Nil, nil
+	}
+	id, err := uuid.Parse(*f.owner)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid owner ID %q", *f.owner)
+	}
+	return id, nil
+}
+
+func createRoom(ctx context.Context, a *app, args []string) error {
+	f := newRoomFlags("rooms create")
+	if rest, err := parseArgs(f.fs, args); err != nil || len(rest) != 0 || *f.name == "" {
+		return errUsage
+	}
+	owner, err := f.ownerID()
+	if err != nil {
+		return err
+	}
+
+	room, err := a.client.CreateRoom(ctx, models.CreateRoomRequest{
+		Name:        *f.name,
+		Description: *f.description,
+		Building:    *f.building,
+		Complex:     *f.complex,
+		Area:        *f.area,
+		Occupants:   *f.occupants,
+		OwnerID:     owner,
+	})
+	if err != nil {
+		return err
+	}
+	return a.print(room, roomsTable([]models.Room{*room}))
+}
+
+func updateRoom(ctx context.Context, a *app, args []string) error {
+	f := newRoomFlags("rooms update")
+	ifMatch := f.fs.Int64("if-match", 0, "only change this version of the room")
+	rest, err := parseArgs(f.fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(rest)
+	if err != nil {
+		return err
+	}
+
+	// Only the flags given are changed
+	var req models.UpdateRoomRequest
+	if isSet(f.fs, "name") {
+		req.Name = f.name
+	}
+	if isSet(f.fs, "description") {
+		req.Description = f.description
+	}
+	if isSet(f.fs, "building") {
+		req.Building = f.building
+	}
+	if isSet(f.fs, "complex") {
+		req.Complex = f.complex
+	}
+	if isSet(f.fs, "area") {
+		req.Area = f.area
+	}
+	if isSet(f.fs, "occupants") {
+		req.Occupants = f.occupants
+	}
+	if isSet(f.fs, "owner") {
+		owner, err := f.ownerID()
+		if err != nil {
+			return err
+		}
+		req.OwnerID = &owner
+	}
+
+	room, err := a.client.UpdateRoom(ctx, id, req, versionOption(f.fs, *ifMatch)...)
+	if err != nil {
+		return err
+	}
+	return a.print(room, roomsTable([]models.Room{*room}))
+}
+
+func deleteRoom(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("rooms delete", flag.ContinueOnError)
+	ifMatch := fs.Int64("if-match", 0, "only delete this version of the room")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := parseID(rest)
+	if err != nil {
+		return err
+	}
+
+	return a.client.DeleteRoom(ctx, id, versionOption(fs, *ifMatch)...)
+}
+
+func benchmark(ctx context.Context, a *app, args []string) error {
+	fs := flag.NewFlagSet("benchmark", flag.ContinueOnError)
+	kind := fs.String("kind", "", "electricity, water, gas or heat")
+	scope := fs.String("scope", "", "compare within the building or the complex")
+	normalize := fs.String("normalize", "", "normalize by area or occupant")
+	from := fs.String("from", "", "period start")
+	to := fs.String("to", "", "period end")
+	rest, err := parseArgs(fs, args)
+	if err != nil || *kind == "" {
+		return errUsage
+	}
+	id, err := parseID(rest)
+	if err != nil {
+		return err
+	}
+
+	q := client.BenchmarkQuery{Kind: *kind, Scope: *scope, Normalize: *normalize}
+	if *from != "" {
+		if q.From, err = parseTime(*from); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if q.To, err = parseTime(*to); err != nil {
+			return err
+		}
+	}
+
+	b, err := a.client.GetRoomBenchmark(ctx, id, q)
+	if err != nil {
+		return err
+	}
+	return a.print(b, table{
+		header: []string{"room", "kind", "scope", "normalize", "from", "to", "peers", "value", "percentile_rank"},
+		rows: [][]string{{
+			b.RoomID.String(), b.Kind, b.Scope, b.Normalize, formatTime(b.StartTime), formatTime(b.EndTime),
+			strconv.Itoa(b.PeerCount), formatFloat(b.Value), formatFloat(b.PercentileRank),
+		}},
+	})
+}
+
+// versionOption returns IfMatch for the -if-match flag, if given
+func versionOption(fs *flag.FlagSet, version int64) []client.RequestOption {
+	if !isSet(fs, "if-match") {
+		return nil
+	}
+	return []client.RequestOption{client.IfMatch(version)}
+}
@@ -0,0 +1,130 @@
+// Command modbus-sim simulates Modbus TCP meters for trying out the poller
+// without hardware.
+//
+// Usage:
+//
+//	modbus-sim [-addr :5020] [-jitter 0.02] UNIT:TABLE:ADDRESS:TYPE=VALUE...
+//
+// Each argument sets a register of a unit, for example 1:input:0:float32=21.5
+// for a temperature in input registers 0 and 1 of unit 1. With -jitter the
+// values wander by up to that fraction every second, like a real meter.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/modbus"
+)
+
+// value is a simulated register value
+type value struct {
+	unitID byte
+	reg    modbus.Register
+	value  float64
+}
+
+func main() {
+	fs := flag.NewFlagSet("modbus-sim", flag.ContinueOnError)
+	addr := fs.String("addr", ":5020", "address to listen on")
+	jitter := fs.Float64("jitter", 0, "fraction by which values wander every second")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		os.Exit(2)
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: modbus-sim [-addr ADDR] [-jitter FRACTION] UNIT:TABLE:ADDRESS:TYPE=VALUE...")
+		os.Exit(2)
+	}
+
+	sim := modbus.NewSimulator()
+	values := make([]value, 0, fs.NArg())
+	for _, arg := range fs.Args() {
+		v, err := parseValue(arg)
+		if err != nil {
+			log.Fatalf("Invalid register %q: %v", arg, err)
+		}
+		if err := sim.SetValue(v.unitID, v.reg, v.value); err != nil {
+			log.Fatalf("Invalid register %q: %v", arg, err)
+		}
+		values = append(values, v)
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *addr, err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		sim.Close()
+	}()
+
+	if *jitter > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				for _, v := range values {
+					sim.SetValue(v.unitID, v.reg, v.value*(1+*jitter*(2*rand.Float64()-1)))
+				}
+			}
+		}()
+	}
+
+	log.Printf("Simulating %d registers on %s", len(values), ln.Addr())
+	if err := sim.Serve(ln); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseValue reads UNIT:TABLE:ADDRESS:TYPE=VALUE
+func parseValue(arg string) (value, error) {
+	spec, raw, ok := strings.Cut(arg, "=")
+	parts := strings.Split(spec, ":")
+	if !ok || len(parts) != 4 {
+		return value{}, errors.New("want UNIT:TABLE:ADDRESS:TYPE=VALUE")
+	}
+
+	unitID, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		return value{}, fmt.Errorf("invalid unit: %w", err)
+	}
+	address, err := strconv.ParseUint(parts[2], 10, 16)
+	if err != nil {
+		return value{}, fmt.Errorf("invalid address: %w", err)
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return value{}, fmt.Errorf("invalid value: %w", err)
+	}
+
+	reg := modbus.Register{
+		Table:   modbus.Table(parts[1]),
+		Address: uint16(address),
+		Type:    modbus.DataType(parts[3]),
+	}
+	if err := reg.Validate(); err != nil {
+		return value{}, err
+	}
+	return value{unitID: byte(unitID), reg: reg, value: f}, nil
+}
@@ -0,0 +1,550 @@
+// Package config loads the server configuration. Settings are read from a
+// JSON file, then from environment variables and last from command-line
+// flags, each overriding the one before:
+//
+//	server -config /etc/hm/config.json -addr :9090
+//
+// Every setting has an environment variable; run the server with -h for the
+// flags. Secrets such as JWT_SECRET, ADMIN_PASSWORD and BACKUP_KEY have no
+// flag, as command lines are visible to other users of the machine.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/modbus"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/mqtt"
+	"github.com/google/uuid"
+)
+
+// Config is the configuration of the server
+type Config struct {
+	Addr    string  `json:"addr"` // address of the API listener
+	TLS     TLS     `json:"tls"`
+	Storage Storage `json:"storage"`
+	JWT     JWT     `json:"jwt"`
+	Admin   Admin   `json:"admin"`
+	CORS    CORS    `json:"cors"`
+	Limits  Limits  `json:"limits"`
+	Backup  Backup  `json:"backup"`
+	Metrics Metrics `json:"metrics"`
+	MQTT    MQTT    `json:"mqtt"`
+	Modbus  Modbus  `json:"modbus"`
+
+	Observability Observability `json:"observability"`
+	Events        Events        `json:"events"`
+
+	AuditLog       string   `json:"audit_log"`       // file the audit log is appended to; in memory if empty
+	TrashRetention Duration `json:"trash_retention"` // how long deleted rooms and metrics can be restored
+	// CompactionInterval is how often the retention policies are applied
+	CompactionInterval Duration `json:"compaction_interval"`
+}
+
+// TLS configures HTTPS; the API is served over plain HTTP when no
+// certificate is given. The files are loaded again when they change.
+//
+// With a client CA, meter gateways may authenticate with a client
+// certificate issued by it instead of a user login. Each gateway is known by
+// the subject of its certificate and may only access its own metrics.
+type TLS struct {
+	CertFile     string    `json:"cert_file"`
+	KeyFile      string    `json:"key_file"`
+	ClientCAFile string    `json:"client_ca_file"`
+	Gateways     []Gateway `json:"gateways"`
+}
+
+// Gateway is a meter gateway authenticating with a client certificate
+type Gateway struct {
+	Subject string      `json:"subject"` // common name or full distinguished name of the certificate
+	Metrics []uuid.UUID `json:"metrics"` // metrics the gateway may read and record readings of
+}
+
+// Enabled reports whether HTTPS is configured
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Storage configures where the state is kept. The DSN is "memory", keeping
+// nothing across restarts, or "file:PATH", loading the state from PATH at
+// start and saving it there every FlushInterval and on shutdown.
+type Storage struct {
+	DSN           string   `json:"dsn"`
+	FlushInterval Duration `json:"flush_interval"`
+}
+
+// File returns the path of the state file, or "" when the state is only
+// kept in memory
+func (s Storage) File() string {
+	path, ok := strings.CutPrefix(s.DSN, "file:")
+	if !ok {
+		return ""
+	}
+	return path
+}
+
+// JWT configures the tokens issued at login. Without a secret a random one
+// is generated, so tokens do not outlive the process.
+type JWT struct {
+	Secret string   `json:"secret"`
+	TTL    Duration `json:"ttl"`
+}
+
+// Admin is an administrator added at start unless a user of that name
+// exists. Users registering themselves only get the user role, so on a new
+// server this is the one who grants others.
+type Admin struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+// CORS configures which browser origins may call the API; none may when
+// AllowedOrigins is empty. "*" allows every origin.
+type CORS struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+	MaxAge         Duration `json:"max_age"` // how long browsers may cache a preflight response
+}
+
+// Limits bounds requests and how long the server waits for them
+type Limits struct {
+	MaxBodySize       int64    `json:"max_body_size"` // largest JSON request body, in bytes
+	MaxHeaderBytes    int      `json:"max_header_bytes"`
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	ReadTimeout       Duration `json:"read_timeout"`
+	WriteTimeout      Duration `json:"write_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout"`
+	ShutdownTimeout   Duration `json:"shutdown_timeout"` // how long in-flight requests and jobs may finish on shutdown
+}
+
+// Backup configures where backups are written and how often they are taken
+type Backup struct {
+	Dir      string   `json:"dir"`
+	Key      string   `json:"key"`      // hex encoded AES-256 key; backups and the state file are encrypted with it
+	Interval Duration `json:"interval"` // scheduled backups are off when zero
+	Retain   int      `json:"retain"`   // number of scheduled backups kept
+}
+
+// Metrics configures an additional listener serving only the metric
+// endpoints, e.g. for meters on a separate network
+type Metrics struct {
+	Addr  string `json:"addr"`
+	Token string `json:"token"` // shared bearer token accepted instead of a user login
+}
+
+// Observability configures a listener for Prometheus, separate from the API
+// since /metrics there is the household metric API. It serves operational
+// metrics and the latest value of every household metric, and optionally
+// receives readings by remote write.
+type Observability struct {
+	Addr        string      `json:"addr"`
+	Token       string      `json:"token"` // bearer token scrapes and remote writes must send; required for remote write
+	RemoteWrite RemoteWrite `json:"remote_write"`
+}
+
+// RemoteWrite configures the Prometheus remote write receiver, which
+// requires the observability token. Series are recorded to the metric of
+// their metric_id label if a route maps it, else of the first route matching
+// their labels; other series are dropped.
+type RemoteWrite struct {
+	Enabled bool                      `json:"enabled"`
+	Routes  []models.RemoteWriteRoute `json:"routes"`
+}
+
+// MQTT configures ingestion of readings that meters publish over MQTT. The
+// server subscribes to the topics of Routes on Broker or, with ListenAddr,
+// runs a broker of its own that meters publish to.
+type MQTT struct {
+	Broker     string             `json:"broker"`      // URL such as tcp://localhost:1883 or tls://broker:8883
+	ListenAddr string             `json:"listen_addr"` // address of the embedded broker
+	ClientID   string             `json:"client_id"`
+	Username   string             `json:"username"`
+	Password   string             `json:"password"`
+	Routes     []models.MQTTRoute `json:"routes"`
+}
+
+// Enabled reports whether readings are taken from MQTT
+func (m MQTT) Enabled() bool {
+	return len(m.Routes) > 0
+}
+
+// Modbus configures polling of meters over Modbus TCP. Devices are only
+// given in the configuration file.
+type Modbus struct {
+	Devices []ModbusDevice `json:"devices"`
+}
+
+// ModbusDevice is a meter, or a unit behind a Modbus gateway, whose
+// registers are read every Interval and recorded as readings
+type ModbusDevice struct {
+	Name      string           `json:"name"`
+	Host      string           `json:"host"` // host:port; port 502 if omitted
+	UnitID    byte             `json:"unit_id"`
+	Serial    string           `json:"serial"` // registry device the polls count as seen, if any
+	Interval  Duration         `json:"interval"`
+	Timeout   Duration         `json:"timeout"` // bounds each request; 10 seconds when zero
+	Registers []ModbusRegister `json:"registers"`
+}
+
+// ModbusRegister is a register whose value is recorded as a reading of a
+// metric, such as {"table": "input", "address": 0, "type": "float32", "metric_id": "..."}
+type ModbusRegister struct {
+	modbus.Register
+	MetricID uuid.UUID `json:"metric_id"`
+}
+
+// Events configures the event bus side effects subscribe to
+type Events struct {
+	// Outbox saves the events waiting for durable subscribers with the
+	// state, so that they are delivered after a restart or crash
+	Outbox    bool `json:"outbox"`
+	QueueSize int  `json:"queue_size"` // events that may wait for each asynchronous subscriber
+}
+
+// Default returns the configuration used for settings given nowhere else
+func Default() *Config {
+	return &Config{
+		Addr: ":8080",
+		Storage: Storage{
+			DSN:           "memory",
+			FlushInterval: Duration{time.Minute},
+		},
+		JWT: JWT{
+			TTL: Duration{24 * time.Hour},
+		},
+		CORS: CORS{
+			MaxAge: Duration{10 * time.Minute},
+		},
+		Limits: Limits{
+			MaxBodySize:       1 << 20,
+			MaxHeaderBytes:    1 << 20,
+			ReadHeaderTimeout: Duration{10 * time.Second},
+			ReadTimeout:       Duration{30 * time.Second},
+			WriteTimeout:      Duration{60 * time.Second},
+			IdleTimeout:       Duration{120 * time.Second},
+			ShutdownTimeout:   Duration{30 * time.Second},
+		},
+		Backup: Backup{
+			Dir:    "backups",
+			Retain: 7,
+		},
+		MQTT: MQTT{
+			ClientID: "hm-ingest",
+		},
+		Events: Events{
+			QueueSize: 1024,
+		},
+		TrashRetention:     Duration{30 * 24 * time.Hour},
+		CompactionInterval: Duration{time.Hour},
+	}
+}
+
+// setting is a configuration value that can be given as an environment
+// variable and, unless it is a secret, as a flag
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"LISTEN_ADDR", "addr", "address of the API listener", setString(func(c *Config) *string { return &c.Addr })},
+	{"TLS_CERT_FILE", "tls-cert", "TLS certificate file; enables HTTPS", setString(func(c *Config) *string { return &c.TLS.CertFile })},
+	{"TLS_KEY_FILE", "tls-key", "TLS private key file", setString(func(c *Config) *string { return &c.TLS.KeyFile })},
+	{"TLS_CLIENT_CA_FILE", "tls-client-ca", "CA certificates gateway client certificates are verified against", setString(func(c *Config) *string { return &c.TLS.ClientCAFile })},
+	{"STORAGE_DSN", "storage", `where the state is kept: "memory" or "file:PATH"`, setString(func(c *Config) *string { return &c.Storage.DSN })},
+	{"STORAGE_FLUSH_INTERVAL", "flush-interval", "how often the state file is written", setDuration(func(c *Config) *Duration { return &c.Storage.FlushInterval })},
+	{"JWT_SECRET", "", "", setString(func(c *Config) *string { return &c.JWT.Secret })},
+	{"JWT_TTL", "jwt-ttl", "how long login tokens stay valid", setDuration(func(c *Config) *Duration { return &c.JWT.TTL })},
+	{"ADMIN_USERNAME", "admin-username", "administrator added at start unless a user of that name exists", setString(func(c *Config) *string { return &c.Admin.Username })},
+	{"ADMIN_PASSWORD", "", "", setString(func(c *Config) *string { return &c.Admin.Password })},
+	{"ADMIN_EMAIL", "admin-email", "email of the administrator added at start", setString(func(c *Config) *string { return &c.Admin.Email })},
+	{"CORS_ALLOWED_ORIGINS", "cors-origins", "comma separated browser origins allowed to call the API, * for any", setList(func(c *Config) *[]string { return &c.CORS.AllowedOrigins })},
+	{"CORS_MAX_AGE", "cors-max-age", "how long browsers may cache preflight responses", setDuration(func(c *Config) *Duration { return &c.CORS.MaxAge })},
+	{"MAX_BODY_SIZE", "max-body-size", "largest JSON request body in bytes", setInt64(func(c *Config) *int64 { return &c.Limits.MaxBodySize })},
+	{"MAX_HEADER_BYTES", "max-header-bytes", "largest request header in bytes", setInt(func(c *Config) *int { return &c.Limits.MaxHeaderBytes })},
+	{"READ_HEADER_TIMEOUT", "read-header-timeout", "how long reading request headers may take", setDuration(func(c *Config) *Duration { return &c.Limits.ReadHeaderTimeout })},
+	{"READ_TIMEOUT", "read-timeout", "how long reading a request may take", setDuration(func(c *Config) *Duration { return &c.Limits.ReadTimeout })},
+	{"WRITE_TIMEOUT", "write-timeout", "how long writing a response may take", setDuration(func(c *Config) *Duration { return &c.Limits.WriteTimeout })},
+	{"IDLE_TIMEOUT", "idle-timeout", "how long idle keep-alive connections are kept", setDuration(func(c *Config) *Duration { return &c.Limits.IdleTimeout })},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long in-flight requests and jobs may finish on shutdown", setDuration(func(c *Config) *Duration { return &c.Limits.ShutdownTimeout })},
+	{"BACKUP_DIR", "backup-dir", "directory backups are written to", setString(func(c *Config) *string { return &c.Backup.Dir })},
+	{"BACKUP_KEY", "", "", setString(func(c *Config) *string { return &c.Backup.Key })},
+	{"BACKUP_INTERVAL", "backup-interval", "how often a backup is taken; 0 disables scheduled backups", setDuration(func(c *Config) *Duration { return &c.Backup.Interval })},
+	{"BACKUP_RETAIN", "backup-retain", "number of scheduled backups kept", setInt(func(c *Config) *int { return &c.Backup.Retain })},
+	{"METRICS_ADDR", "metrics-addr", "address of a listener serving only the metric endpoints", setString(func(c *Config) *string { return &c.Metrics.Addr })},
+	{"METRICS_TOKEN", "", "", setString(func(c *Config) *string { return &c.Metrics.Token })},
+	{"OBSERVABILITY_ADDR", "observability-addr", "address of the Prometheus listener", setString(func(c *Config) *string { return &c.Observability.Addr })},
+	{"OBSERVABILITY_TOKEN", "", "", setString(func(c *Config) *string { return &c.Observability.Token })},
+	{"REMOTE_WRITE_ENABLED", "remote-write", "receive readings by Prometheus remote write", setBool(func(c *Config) *bool { return &c.Observability.RemoteWrite.Enabled })},
+	{"MQTT_BROKER", "mqtt-broker", "URL of the MQTT broker meters publish to", setString(func(c *Config) *string { return &c.MQTT.Broker })},
+	{"MQTT_LISTEN_ADDR", "mqtt-listen", "address of an embedded MQTT broker", setString(func(c *Config) *string { return &c.MQTT.ListenAddr })},
+	{"MQTT_CLIENT_ID", "mqtt-client-id", "client ID of the MQTT subscription", setString(func(c *Config) *string { return &c.MQTT.ClientID })},
+	{"MQTT_USERNAME", "mqtt-username", "user name on the MQTT broker", setString(func(c *Config) *string { return &c.MQTT.Username })},
+	{"MQTT_PASSWORD", "", "", setString(func(c *Config) *string { return &c.MQTT.Password })},
+	{"EVENTS_OUTBOX", "events-outbox", "save events waiting for durable subscribers with the state", setBool(func(c *Config) *bool { return &c.Events.Outbox })},
+	{"EVENTS_QUEUE_SIZE", "events-queue-size", "events that may wait for each asynchronous subscriber", setInt(func(c *Config) *int { return &c.Events.QueueSize })},
+	{"AUDIT_LOG", "audit-log", "file the audit log is appended to", setString(func(c *Config) *string { return &c.AuditLog })},
+	{"TRASH_RETENTION", "trash-retention", "how long deleted rooms and metrics can be restored", setDuration(func(c *Config) *Duration { return &c.TrashRetention })},
+	{"COMPACTION_INTERVAL", "compaction-interval", "how often readings are compacted by the retention policies", setDuration(func(c *Config) *Duration { return &c.CompactionInterval })},
+}
+
+// Load reads the configuration from the file named by -config or
+// CONFIG_FILE, the environment and the command-line arguments args, which
+// exclude the program name. The usage is written to output on -h.
+func Load(args []string, output io.Writer) (*Config, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(output)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON configuration file")
+
+	// Flags are applied after the file and the environment whatever their
+	// position on the command line
+	var fromFlags []func(c *Config) error
+	for _, st := range settings {
+		if st.flag == "" {
+			continue
+		}
+		fs.Func(st.flag, st.usage+" (env "+st.env+")", func(value string) error {
+			fromFlags = append(fromFlags, func(c *Config) error { return st.set(c, value) })
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	c := Default()
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(c); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", *file, err)
+		}
+	}
+
+	for _, st := range settings {
+		if value, ok := os.LookupEnv(st.env); ok && value != "" {
+			if err := st.set(c, value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", st.env, err)
+			}
+		}
+	}
+	for _, set := range fromFlags {
+		if err := set(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks that the settings are usable together
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Addr == "" {
+		errs = append(errs, errors.New("addr must be set"))
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls cert_file and key_file must be set together"))
+	}
+	if c.TLS.ClientCAFile != "" && !c.TLS.Enabled() {
+		errs = append(errs, errors.New("tls client_ca_file requires cert_file and key_file"))
+	}
+	if len(c.TLS.Gateways) > 0 && c.TLS.ClientCAFile == "" {
+		errs = append(errs, errors.New("tls gateways require client_ca_file"))
+	}
+	for i, g := range c.TLS.Gateways {
+		if g.Subject == "" {
+			errs = append(errs, fmt.Errorf("tls gateways[%d] subject must be set", i))
+		}
+	}
+	if c.Storage.DSN != "memory" && c.Storage.File() == "" {
+		errs = append(errs, fmt.Errorf(`storage dsn %q must be "memory" or "file:PATH"`, c.Storage.DSN))
+	}
+	if c.JWT.Secret != "" && len(c.JWT.Secret) < 32 {
+		errs = append(errs, errors.New("jwt secret must be at least 32 bytes"))
+	}
+	if c.JWT.TTL.Duration <= 0 {
+		errs = append(errs, errors.New("jwt ttl must be positive"))
+	}
+	if (c.Admin.Username == "") != (c.Admin.Password == "") {
+		errs = append(errs, errors.New("admin username and password must be set together"))
+	}
+	if c.Limits.MaxBodySize <= 0 {
+		errs = append(errs, errors.New("limits max_body_size must be positive"))
+	}
+	if c.Limits.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("limits shutdown_timeout must be positive"))
+	}
+	if c.Backup.Retain <= 0 {
+		errs = append(errs, errors.New("backup retain must be positive"))
+	}
+	if c.Observability.RemoteWrite.Enabled && c.Observability.Addr == "" {
+		errs = append(errs, errors.New("observability remote_write requires addr"))
+	}
+	if c.Observability.RemoteWrite.Enabled && c.Observability.Token == "" {
+		errs = append(errs, errors.New("observability remote_write requires token"))
+	}
+	for i, route := range c.Observability.RemoteWrite.Routes {
+		if len(route.Match) == 0 {
+			errs = append(errs, fmt.Errorf("observability remote_write routes[%d] match must have labels", i))
+		}
+		if route.MetricID == uuid.Nil {
+			errs = append(errs, fmt.Errorf("observability remote_write routes[%d] metric_id must be set", i))
+		}
+	}
+	if c.MQTT.Enabled() && c.MQTT.Broker == "" && c.MQTT.ListenAddr == "" {
+		errs = append(errs, errors.New("mqtt routes require broker or listen_addr"))
+	}
+	if c.MQTT.Broker != "" && c.MQTT.ClientID == "" {
+		errs = append(errs, errors.New("mqtt client_id must be set"))
+	}
+	for i, route := range c.MQTT.Routes {
+		if !mqtt.ValidFilter(route.Topic) {
+			errs = append(errs, fmt.Errorf("mqtt routes[%d] topic %q is not a valid topic filter", i, route.Topic))
+		}
+	}
+	names := make(map[string]bool)
+	for i, d := range c.Modbus.Devices {
+		if d.Name == "" || names[d.Name] {
+			errs = append(errs, fmt.Errorf("modbus devices[%d] name must be set and unique", i))
+		}
+		names[d.Name] = true
+		if d.Host == "" {
+			errs = append(errs, fmt.Errorf("modbus devices[%d] host must be set", i))
+		}
+		if d.Interval.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("modbus devices[%d] interval must be positive", i))
+		}
+		if len(d.Registers) == 0 {
+			errs = append(errs, fmt.Errorf("modbus devices[%d] must have registers", i))
+		}
+		for j, reg := range d.Registers {
+			if err := reg.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("modbus devices[%d] registers[%d]: %w", i, j, err))
+			}
+			if reg.MetricID == uuid.Nil {
+				errs = append(errs, fmt.Errorf("modbus devices[%d] registers[%d] metric_id must be set", i, j))
+			}
+		}
+	}
+	if c.Events.Outbox && c.Storage.File() == "" {
+		errs = append(errs, errors.New(`events outbox requires a "file:PATH" storage dsn`))
+	}
+	if c.Events.QueueSize <= 0 {
+		errs = append(errs, errors.New("events queue_size must be positive"))
+	}
+	if c.TrashRetention.Duration <= 0 {
+		errs = append(errs, errors.New("trash_retention must be positive"))
+	}
+	if c.CompactionInterval.Duration <= 0 {
+		errs = append(errs, errors.New("compaction_interval must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// Duration is a time.Duration written as a string such as "30s" in JSON
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func setString(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func setList(field func(c *Config) *[]string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}
+}
+
+func setDuration(field func(c *Config) *Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field(c).Duration = d
+		return nil
+	}
+}
+
+func setBool(field func(c *Config) *bool) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func setInt(field func(c *Config) *int) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func setInt64(field func(c *Config) *int64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
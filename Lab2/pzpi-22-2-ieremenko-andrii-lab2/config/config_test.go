@@ -0,0 +1,220 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/modbus"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// writeFile writes a configuration file and returns its path
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clearEnv unsets every variable Load reads for the duration of the test
+func clearEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	for _, st := range settings {
+		t.Setenv(st.env, "")
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, `{"addr": ":1000", "jwt": {"ttl": "1h"}, "backup": {"retain": 3}}`)
+	other := writeFile(t, `{"addr": ":4000"}`)
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		args       []string
+		wantAddr   string
+		wantTTL    time.Duration
+		wantRetain int
+	}{
+		{"defaults", nil, nil, ":8080", 24 * time.Hour, 7},
+		{"file", nil, []string{"-config", file}, ":1000", time.Hour, 3},
+		{"file from the environment", map[string]string{"CONFIG_FILE": file}, nil, ":1000", time.Hour, 3},
+		{"-config over CONFIG_FILE", map[string]string{"CONFIG_FILE": file}, []string{"-config", other}, ":4000", 24 * time.Hour, 7},
+		{"environment over file", map[string]string{"LISTEN_ADDR": ":2000", "JWT_TTL": "2h"}, []string{"-config", file}, ":2000", 2 * time.Hour, 3},
+		{"empty variables are ignored", map[string]string{"LISTEN_ADDR": ""}, []string{"-config", file}, ":1000", time.Hour, 3},
+		{"flags over environment and file", map[string]string{"LISTEN_ADDR": ":2000", "BACKUP_RETAIN": "5"}, []string{"-config", file, "-addr", ":3000"}, ":3000", time.Hour, 5},
+		{"flags before -config", map[string]string{"JWT_TTL": "2h"}, []string{"-jwt-ttl", "3h", "-config", file}, ":1000", 3 * time.Hour, 3},
+		{"last flag wins", nil, []string{"-addr", ":3000", "-addr", ":3001"}, ":3001", 24 * time.Hour, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			c, err := Load(tt.args, io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Addr != tt.wantAddr || c.JWT.TTL.Duration != tt.wantTTL || c.Backup.Retain != tt.wantRetain {
+				t.Errorf("got addr %s, ttl %s and retain %d; want %s, %s and %d",
+					c.Addr, c.JWT.TTL, c.Backup.Retain, tt.wantAddr, tt.wantTTL, tt.wantRetain)
+			}
+		})
+	}
+
+	// Lists are split on commas
+	clearEnv(t)
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example, ,https://b.example")
+	c, err := Load(nil, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(c.CORS.AllowedOrigins, " "); got != "https://a.example https://b.example" {
+		t.Errorf("got origins %q", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"missing file", "", nil, []string{"-config", "/nonexistent/config.json"}, "failed to read config file"},
+		{"unknown field", `{"adr": ":1000"}`, nil, nil, `unknown field "adr"`},
+		{"invalid duration in file", `{"jwt": {"ttl": 60}}`, nil, nil, "duration must be a string"},
+		{"invalid variable", "", map[string]string{"JWT_TTL": "soon"}, nil, "invalid JWT_TTL"},
+		{"invalid number", "", map[string]string{"BACKUP_RETAIN": "many"}, nil, "invalid BACKUP_RETAIN"},
+		{"invalid bool", "", map[string]string{"EVENTS_OUTBOX": "maybe"}, nil, "invalid EVENTS_OUTBOX"},
+		{"invalid flag", "", nil, []string{"-max-body-size", "big"}, "invalid syntax"},
+		{"secrets have no flag", "", nil, []string{"-jwt-secret", "x"}, "flag provided but not defined"},
+		{"argument", "", nil, []string{"serve"}, `unexpected argument "serve"`},
+		{"invalid result", "", map[string]string{"LISTEN_ADDR": ":1"}, []string{"-jwt-ttl", "0s"}, "jwt ttl must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			if tt.file != "" {
+				t.Setenv("CONFIG_FILE", writeFile(t, tt.file))
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			_, err := Load(tt.args, io.Discard)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	register := ModbusRegister{Register: modbus.Register{Type: modbus.Float32}, MetricID: uuid.New()}
+	device := ModbusDevice{Name: "meter", Host: "localhost", Interval: Duration{time.Minute}, Registers: []ModbusRegister{register}}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   string // in the error; empty if valid
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"addr", func(c *Config) { c.Addr = "" }, "addr must be set"},
+		{"cert without key", func(c *Config) { c.TLS.CertFile = "cert.pem" }, "cert_file and key_file must be set together"},
+		{"key without cert", func(c *Config) { c.TLS.KeyFile = "key.pem" }, "cert_file and key_file must be set together"},
+		{"client CA without TLS", func(c *Config) { c.TLS.ClientCAFile = "ca.pem" }, "client_ca_file requires cert_file and key_file"},
+		{"gateways without client CA", func(c *Config) {
+			c.TLS = TLS{CertFile: "cert.pem", KeyFile: "key.pem", Gateways: []Gateway{{Subject: "gw"}}}
+		}, "gateways require client_ca_file"},
+		{"gateway without subject", func(c *Config) {
+			c.TLS = TLS{CertFile: "cert.pem", KeyFile: "key.pem", ClientCAFile: "ca.pem", Gateways: []Gateway{{}}}
+		}, "gateways[0] subject must be set"},
+		{"file storage", func(c *Config) { c.Storage.DSN = "file:state.json" }, ""},
+		{"storage dsn", func(c *Config) { c.Storage.DSN = "postgres://db" }, `storage dsn "postgres://db"`},
+		{"empty storage file", func(c *Config) { c.Storage.DSN = "file:" }, "storage dsn"},
+		{"short jwt secret", func(c *Config) { c.JWT.Secret = "secret" }, "jwt secret must be at least 32 bytes"},
+		{"jwt ttl", func(c *Config) { c.JWT.TTL = Duration{} }, "jwt ttl must be positive"},
+		{"admin without password", func(c *Config) { c.Admin.Username = "admin" }, "admin username and password must be set together"},
+		{"admin password without username", func(c *Config) { c.Admin.Password = "secret" }, "admin username and password must be set together"},
+		{"max body size", func(c *Config) { c.Limits.MaxBodySize = 0 }, "max_body_size must be positive"},
+		{"shutdown timeout", func(c *Config) { c.Limits.ShutdownTimeout = Duration{} }, "shutdown_timeout must be positive"},
+		{"backup retain", func(c *Config) { c.Backup.Retain = 0 }, "backup retain must be positive"},
+		{"remote write without addr", func(c *Config) {
+			c.Observability = Observability{Token: "token", RemoteWrite: RemoteWrite{Enabled: true}}
+		}, "remote_write requires addr"},
+		{"remote write without token", func(c *Config) {
+			c.Observability = Observability{Addr: ":9100", RemoteWrite: RemoteWrite{Enabled: true}}
+		}, "remote_write requires token"},
+		{"remote write route without labels", func(c *Config) {
+			c.Observability.RemoteWrite.Routes = []models.RemoteWriteRoute{{MetricID: uuid.New()}}
+		}, "routes[0] match must have labels"},
+		{"remote write route without metric", func(c *Config) {
+			c.Observability.RemoteWrite.Routes = []models.RemoteWriteRoute{{Match: map[string]string{"job": "meter"}}}
+		}, "routes[0] metric_id must be set"},
+		{"mqtt without broker", func(c *Config) { c.MQTT.Routes = []models.MQTTRoute{{Topic: "meters/+"}} }, "mqtt routes require broker or listen_addr"},
+		{"mqtt embedded broker", func(c *Config) {
+			c.MQTT.ListenAddr = ":1883"
+			c.MQTT.Routes = []models.MQTTRoute{{Topic: "meters/#"}}
+		}, ""},
+		{"mqtt client ID", func(c *Config) { c.MQTT.Broker = "tcp://broker:1883"; c.MQTT.ClientID = "" }, "mqtt client_id must be set"},
+		{"mqtt topic filter", func(c *Config) {
+			c.MQTT.Broker = "tcp://broker:1883"
+			c.MQTT.Routes = []models.MQTTRoute{{Topic: "meters/#/power"}}
+		}, `routes[0] topic "meters/#/power" is not a valid topic filter`},
+		{"modbus device", func(c *Config) { c.Modbus.Devices = []ModbusDevice{device} }, ""},
+		{"modbus name", func(c *Config) { d := device; d.Name = ""; c.Modbus.Devices = []ModbusDevice{d} }, "devices[0] name must be set and unique"},
+		{"modbus duplicate name", func(c *Config) { c.Modbus.Devices = []ModbusDevice{device, device} }, "devices[1] name must be set and unique"},
+		{"modbus host", func(c *Config) { d := device; d.Host = ""; c.Modbus.Devices = []ModbusDevice{d} }, "devices[0] host must be set"},
+		{"modbus interval", func(c *Config) { d := device; d.Interval = Duration{}; c.Modbus.Devices = []ModbusDevice{d} }, "devices[0] interval must be positive"},
+		{"modbus registers", func(c *Config) { d := device; d.Registers = nil; c.Modbus.Devices = []ModbusDevice{d} }, "devices[0] must have registers"},
+		{"modbus register type", func(c *Config) {
+			d := device
+			d.Registers = []ModbusRegister{{Register: modbus.Register{Type: "float16"}, MetricID: uuid.New()}}
+			c.Modbus.Devices = []ModbusDevice{d}
+		}, `devices[0] registers[0]: modbus: unknown data type "float16"`},
+		{"modbus register metric", func(c *Config) {
+			d := device
+			d.Registers = []ModbusRegister{{Register: register.Register}}
+			c.Modbus.Devices = []ModbusDevice{d}
+		}, "devices[0] registers[0] metric_id must be set"},
+		{"outbox in memory", func(c *Config) { c.Events.Outbox = true }, `events outbox requires a "file:PATH" storage dsn`},
+		{"outbox with a file", func(c *Config) { c.Events.Outbox = true; c.Storage.DSN = "file:state.json" }, ""},
+		{"events queue size", func(c *Config) { c.Events.QueueSize = 0 }, "events queue_size must be positive"},
+		{"trash retention", func(c *Config) { c.TrashRetention = Duration{} }, "trash_retention must be positive"},
+		{"compaction interval", func(c *Config) { c.CompactionInterval = Duration{} }, "compaction_interval must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			tt.change(c)
+			err := c.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+
+	// Every problem is reported at once
+	c := Default()
+	c.Addr = ""
+	c.Backup.Retain = 0
+	if err := c.Validate(); err == nil || len(strings.Split(err.Error(), "\n")) != 2 {
+		t.Errorf("got %v, want both problems", err)
+	}
+}
@@ -0,0 +1,468 @@
+// Package events is an in-process publish/subscribe bus. Handlers publish
+// what happened, such as a reading being recorded or a room deleted, and
+// side effects subscribe to it, so that the handlers need not know about
+// them.
+//
+// Subscribers are synchronous, running within Publish; asynchronous,
+// running on a goroutine of their own fed by a bounded queue; or durable,
+// like asynchronous ones but fed by an unbounded outbox that can be saved
+// with the state, so that events published before a crash are delivered
+// after it.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// Event is something that happened. Events are structs with value
+// receivers, encoded as JSON in the outbox.
+type Event interface {
+	// EventType names the event, e.g. "reading.created"
+	EventType() string
+}
+
+// Meta describes the delivery of an event
+type Meta struct {
+	// ID is the same for every subscriber of the event. A durable
+	// subscriber may get an event again after a crash and can tell by it.
+	ID          uuid.UUID
+	PublishedAt time.Time
+	Attempt     int // 1 unless delivery to a durable subscriber is tried again
+}
+
+// Handler handles events of type T
+type Handler[T Event] func(ctx context.Context, meta Meta, event T) error
+
+// Defaults of new buses
+const (
+	DefaultQueueSize   = 1024
+	DefaultMaxAttempts = 10
+)
+
+// maxRetryDelay bounds the backoff between deliveries to a durable
+// subscriber
+const maxRetryDelay = 5 * time.Minute
+
+// Bus delivers published events to the subscribers of their type
+type Bus struct {
+	// QueueSize bounds the events waiting for each asynchronous
+	// subscriber; it applies to those subscribing afterwards. The outbox of
+	// durable subscribers is not bounded.
+	QueueSize int
+	// MaxAttempts is how often delivery to a durable subscriber is tried
+	// before the event is given up on
+	MaxAttempts int
+
+	mu          sync.RWMutex
+	subscribers map[string][]*subscriber // by event type
+	all         []*subscriber            // in order of subscription
+	closed      bool
+
+	// outboxMu guards outbox, the events waiting for durable subscribers in
+	// order of publication, and how many wait for each of them
+	outboxMu sync.Mutex
+	outbox   []models.OutboxEvent
+	pending  map[string]int
+
+	ctx     context.Context // canceled once the bus is closed
+	cancel  context.CancelFunc
+	async   sync.WaitGroup
+	durable sync.WaitGroup
+}
+
+type subscriber struct {
+	name      string
+	eventType string
+	mode      string
+	handle    func(ctx context.Context, meta Meta, event Event) error
+	decode    func(data []byte) (Event, error)
+
+	queue chan delivery // of asynchronous subscribers
+	wake  chan struct{} // of durable subscribers, when events are added to the outbox
+
+	delivered, failed, dropped atomic.Int64
+	// overflowing is set while events are dropped, so that only the first
+	// is logged
+	overflowing atomic.Bool
+}
+
+type delivery struct {
+	meta  Meta
+	event Event
+}
+
+// NewBus creates a bus without subscribers
+func NewBus() *Bus {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Bus{
+		QueueSize:   DefaultQueueSize,
+		MaxAttempts: DefaultMaxAttempts,
+		subscribers: make(map[string][]*subscriber),
+		pending:     make(map[string]int),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Subscribe calls h with each event of type T as it is published, before
+// Publish returns, which returns its errors. h must be quick; it runs with
+// the locks the publisher holds.
+func Subscribe[T Event](b *Bus, name string, h Handler[T]) {
+	b.add(newSubscriber(name, models.EventSync, h))
+}
+
+// SubscribeAsync calls h with each event of type T on a goroutine of its
+// own. Up to QueueSize events wait for it; further ones are dropped until it
+// catches up. Failed deliveries are logged.
+func SubscribeAsync[T Event](b *Bus, name string, h Handler[T]) {
+	b.add(newSubscriber(name, models.EventAsync, h))
+}
+
+// SubscribeDurable is SubscribeAsync for events that must not be lost:
+// they wait in the outbox until h succeeds, however many there are, and
+// failed deliveries are tried again with backoff, up to MaxAttempts times.
+// name identifies the subscriber in the outbox, so it must stay the same
+// across restarts.
+func SubscribeDurable[T Event](b *Bus, name string, h Handler[T]) {
+	b.add(newSubscriber(name, models.EventDurable, h))
+}
+
+func newSubscriber[T Event](name, mode string, h Handler[T]) *subscriber {
+	var zero T
+	return &subscriber{
+		name:      name,
+		eventType: zero.EventType(),
+		mode:      mode,
+		handle: func(ctx context.Context, meta Meta, event Event) error {
+			return h(ctx, meta, event.(T))
+		},
+		decode: func(data []byte) (Event, error) {
+			var event T
+			err := json.Unmarshal(data, &event)
+			return event, err
+		},
+	}
+}
+
+func (b *Bus) add(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if sub.mode == models.EventDurable {
+		for _, other := range b.all {
+			if other.mode == models.EventDurable && other.name == sub.name {
+				panic("events: durable subscriber " + sub.name + " subscribed twice")
+			}
+		}
+	}
+	b.subscribers[sub.eventType] = append(b.subscribers[sub.eventType], sub)
+	b.all = append(b.all, sub)
+
+	switch sub.mode {
+	case models.EventAsync:
+		sub.queue = make(chan delivery, b.QueueSize)
+		b.async.Add(1)
+		go b.runAsync(sub)
+	case models.EventDurable:
+		sub.wake = make(chan struct{}, 1)
+		b.durable.Add(1)
+		go b.runDurable(sub)
+	}
+}
+
+// Publish delivers an event to the subscribers of its type: synchronous ones
+// right away and the others through their queues. It returns the errors of
+// synchronous subscribers. Events published once the bus is closed are
+// dropped.
+func (b *Bus) Publish(event Event) error {
+	meta := Meta{ID: uuid.New(), PublishedAt: time.Now(), Attempt: 1}
+
+	var errs []error
+	var synchronous []*subscriber
+	var data []byte
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		return nil
+	}
+	for _, sub := range b.subscribers[event.EventType()] {
+		switch sub.mode {
+		case models.EventSync:
+			synchronous = append(synchronous, sub)
+		case models.EventAsync:
+			select {
+			case sub.queue <- delivery{meta: meta, event: event}:
+				sub.overflowing.Store(false)
+			default:
+				sub.drop(event)
+			}
+		case models.EventDurable:
+			if data == nil {
+				var err error
+				if data, err = json.Marshal(event); err != nil {
+					errs = append(errs, fmt.Errorf("failed to encode %s: %w", event.EventType(), err))
+					break
+				}
+			}
+			b.enqueue(sub, models.OutboxEvent{
+				ID:          meta.ID,
+				Type:        event.EventType(),
+				Subscriber:  sub.name,
+				PublishedAt: meta.PublishedAt,
+				Data:        data,
+			})
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range synchronous {
+		if err := sub.handle(context.Background(), meta, event); err != nil {
+			sub.failed.Add(1)
+			errs = append(errs, fmt.Errorf("%s: %w", sub.name, err))
+			continue
+		}
+		sub.delivered.Add(1)
+	}
+	return errors.Join(errs...)
+}
+
+// drop counts an event the subscriber missed as its queue was full
+func (sub *subscriber) drop(event Event) {
+	sub.dropped.Add(1)
+	if !sub.overflowing.Swap(true) {
+		log.Printf("Dropping %s events for %s: its queue is full", event.EventType(), sub.name)
+	}
+}
+
+// enqueue adds an event to the outbox for a durable subscriber
+func (b *Bus) enqueue(sub *subscriber, record models.OutboxEvent) {
+	b.outboxMu.Lock()
+	b.outbox = append(b.outbox, record)
+	b.pending[sub.name]++
+	b.outboxMu.Unlock()
+
+	sub.notify()
+}
+
+func (sub *subscriber) notify() {
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Bus) runAsync(sub *subscriber) {
+	defer b.async.Done()
+	for d := range sub.queue {
+		if err := sub.handle(b.ctx, d.meta, d.event); err != nil {
+			sub.failed.Add(1)
+			log.Printf("Handling event %s by %s failed: %v", d.meta.ID, sub.name, err)
+			continue
+		}
+		sub.delivered.Add(1)
+	}
+}
+
+func (b *Bus) runDurable(sub *subscriber) {
+	defer b.durable.Done()
+	for {
+		record, wait, ok := b.next(sub.name)
+		if !ok {
+			var retry <-chan time.Time
+			var timer *time.Timer
+			if wait > 0 {
+				timer = time.NewTimer(wait)
+				retry = timer.C
+			}
+			select {
+			case <-sub.wake:
+			case <-retry:
+			case <-b.ctx.Done():
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			if b.ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		event, err := sub.decode(record.Data)
+		if err == nil {
+			err = sub.handle(b.ctx, Meta{ID: record.ID, PublishedAt: record.PublishedAt, Attempt: record.Attempts + 1}, event)
+		}
+		if err != nil && b.ctx.Err() != nil {
+			// Cut short by Close; the event stays in the outbox
+			return
+		}
+		b.settle(sub, record, err)
+	}
+}
+
+// next returns the oldest event in the outbox that is due for the
+// subscriber. Without one, it returns how long until a failed delivery is
+// due again, or 0 if none is waiting.
+func (b *Bus) next(name string) (models.OutboxEvent, time.Duration, bool) {
+	b.outboxMu.Lock()
+	defer b.outboxMu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, record := range b.outbox {
+		if record.Subscriber != name {
+			continue
+		}
+		if record.NextAttempt == nil || !record.NextAttempt.After(now) {
+			return record, 0, true
+		}
+		if d := record.NextAttempt.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	return models.OutboxEvent{}, wait, false
+}
+
+// settle removes a delivered event from the outbox, or schedules it to be
+// tried again
+func (b *Bus) settle(sub *subscriber, record models.OutboxEvent, err error) {
+	b.outboxMu.Lock()
+	defer b.outboxMu.Unlock()
+
+	i := slices.IndexFunc(b.outbox, func(r models.OutboxEvent) bool {
+		return r.ID == record.ID && r.Subscriber == sub.name
+	})
+	if i < 0 {
+		// The outbox was replaced by LoadOutbox meanwhile
+		return
+	}
+	if err == nil {
+		sub.delivered.Add(1)
+		b.remove(i)
+		return
+	}
+
+	sub.failed.Add(1)
+	r := &b.outbox[i]
+	r.Attempts++
+	r.LastError = err.Error()
+	if r.Attempts >= b.MaxAttempts {
+		log.Printf("Giving up on event %s for %s after %d attempts: %v", r.ID, sub.name, r.Attempts, err)
+		sub.dropped.Add(1)
+		b.remove(i)
+		return
+	}
+	delay := min(time.Second<<(r.Attempts-1), maxRetryDelay)
+	nextAttempt := time.Now().Add(delay)
+	r.NextAttempt = &nextAttempt
+	log.Printf("Handling event %s by %s failed, trying again in %s: %v", r.ID, sub.name, delay, err)
+}
+
+// remove deletes the ith event of the outbox; the caller must hold outboxMu
+func (b *Bus) remove(i int) {
+	b.pending[b.outbox[i].Subscriber]--
+	b.outbox = slices.Delete(b.outbox, i, i+1)
+}
+
+// Outbox returns the events waiting for durable subscribers, oldest first,
+// e.g. to save them with the state
+func (b *Bus) Outbox() []models.OutboxEvent {
+	b.outboxMu.Lock()
+	defer b.outboxMu.Unlock()
+	return slices.Clone(b.outbox)
+}
+
+// LoadOutbox replaces the outbox with events saved before, e.g. when the
+// process stopped, and delivers them. Events of subscribers that have not
+// subscribed are kept until they do.
+func (b *Bus) LoadOutbox(records []models.OutboxEvent) {
+	b.outboxMu.Lock()
+	b.outbox = slices.Clone(records)
+	b.pending = make(map[string]int)
+	for _, record := range b.outbox {
+		b.pending[record.Subscriber]++
+	}
+	b.outboxMu.Unlock()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.all {
+		if sub.mode == models.EventDurable {
+			sub.notify()
+		}
+	}
+}
+
+// Stats returns the subscribers and how their deliveries go
+func (b *Bus) Stats() []models.EventSubscriber {
+	b.mu.RLock()
+	subs := slices.Clone(b.all)
+	b.mu.RUnlock()
+
+	b.outboxMu.Lock()
+	defer b.outboxMu.Unlock()
+	stats := make([]models.EventSubscriber, 0, len(subs))
+	for _, sub := range subs {
+		queued := 0
+		switch sub.mode {
+		case models.EventAsync:
+			queued = len(sub.queue)
+		case models.EventDurable:
+			queued = b.pending[sub.name]
+		}
+		stats = append(stats, models.EventSubscriber{
+			Name:      sub.name,
+			EventType: sub.eventType,
+			Mode:      sub.mode,
+			Queued:    queued,
+			Delivered: sub.delivered.Load(),
+			Failed:    sub.failed.Load(),
+			Dropped:   sub.dropped.Load(),
+		})
+	}
+	return stats
+}
+
+// Close stops accepting events and lets asynchronous subscribers handle
+// those queued, or until ctx is done. Durable subscribers stop right away;
+// what they have not handled stays in the outbox.
+func (b *Bus) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	for _, sub := range b.all {
+		if sub.mode == models.EventAsync {
+			close(sub.queue)
+		}
+	}
+	b.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		b.async.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = fmt.Errorf("events still queued: %w", ctx.Err())
+	}
+	b.cancel()
+	b.durable.Wait()
+	return err
+}
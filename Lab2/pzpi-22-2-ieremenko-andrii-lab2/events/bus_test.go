@@ -0,0 +1,242 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// testEvent is published by the tests
+type testEvent struct {
+	N int `json:"n"`
+}
+
+func (testEvent) EventType() string { return "test.event" }
+
+// closeBus closes b once the test is done
+func closeBus(t *testing.T, b *Bus) {
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		b.Close(ctx)
+	})
+}
+
+// receive returns the next value sent on ch, failing the test if none comes
+// within a few seconds
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		panic("unreachable")
+	}
+}
+
+// subscriberStats returns the stats of the named subscriber
+func subscriberStats(t *testing.T, b *Bus, name string) models.EventSubscriber {
+	t.Helper()
+	for _, stats := range b.Stats() {
+		if stats.Name == name {
+			return stats
+		}
+	}
+	t.Fatalf("no subscriber %s", name)
+	return models.EventSubscriber{}
+}
+
+// durableName is the name of the durable subscribers of the tests
+const durableName = "durable"
+
+func TestDurableOutboxUnbounded(t *testing.T) {
+	b := NewBus()
+	b.QueueSize = 2
+	closeBus(t, b)
+
+	release := make(chan struct{})
+	handled := make(chan int)
+	SubscribeDurable(b, "slow", func(ctx context.Context, meta Meta, e testEvent) error {
+		<-release
+		handled <- e.N
+		return nil
+	})
+
+	const published = 10
+	for i := range published {
+		if err := b.Publish(testEvent{N: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := subscriberStats(t, b, "slow"); stats.Queued != published || stats.Dropped != 0 {
+		t.Fatalf("got %+v, want all %d events queued", stats, published)
+	}
+
+	close(release)
+	for i := range published {
+		if n := receive(t, handled); n != i {
+			t.Fatalf("got event %d, want %d", n, i)
+		}
+	}
+}
+
+func TestSettle(t *testing.T) {
+	b := NewBus()
+	b.MaxAttempts = 3
+	sub := newSubscriber(durableName, models.EventDurable, func(context.Context, Meta, testEvent) error { return nil })
+	record := models.OutboxEvent{ID: uuid.New(), Type: "test.event", Subscriber: sub.name}
+	b.outbox = []models.OutboxEvent{record}
+	b.pending[sub.name] = 1
+
+	// Failures are tried again after 1s, then 2s, until MaxAttempts
+	for attempt, delay := range []time.Duration{time.Second, 2 * time.Second} {
+		before := time.Now()
+		b.settle(sub, record, errors.New("unavailable"))
+		r := b.outbox[0]
+		if r.Attempts != attempt+1 || r.LastError != "unavailable" || r.NextAttempt == nil {
+			t.Fatalf("attempt %d: got %+v", attempt+1, r)
+		}
+		if wait := r.NextAttempt.Sub(before); wait < delay || wait > delay+time.Second {
+			t.Errorf("attempt %d: got retry after %s, want %s", attempt+1, wait, delay)
+		}
+		if _, wait, ok := b.next(sub.name); ok || wait <= 0 || wait > delay {
+			t.Errorf("attempt %d: got the event due, or a wait of %s", attempt+1, wait)
+		}
+	}
+	b.settle(sub, record, errors.New("unavailable"))
+	if len(b.outbox) != 0 || b.pending[sub.name] != 0 || sub.dropped.Load() != 1 || sub.failed.Load() != 3 {
+		t.Fatalf("got outbox %v, %d dropped and %d failed; want it given up on", b.outbox, sub.dropped.Load(), sub.failed.Load())
+	}
+
+	// Delivered events are removed; settling one no longer there is a no-op
+	b.outbox = []models.OutboxEvent{record}
+	b.pending[sub.name] = 1
+	b.settle(sub, record, nil)
+	b.settle(sub, record, nil)
+	if len(b.outbox) != 0 || b.pending[sub.name] != 0 || sub.delivered.Load() != 1 {
+		t.Errorf("got outbox %v and %d delivered, want the event delivered once", b.outbox, sub.delivered.Load())
+	}
+}
+
+func TestDurableRetry(t *testing.T) {
+	b := NewBus()
+	closeBus(t, b)
+
+	attempts := make(chan Meta, 2)
+	SubscribeDurable(b, durableName, func(ctx context.Context, meta Meta, e testEvent) error {
+		attempts <- meta
+		if meta.Attempt == 1 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	b.Publish(testEvent{N: 1})
+
+	first := receive(t, attempts)
+	second := receive(t, attempts)
+	if second.ID != first.ID || first.Attempt != 1 || second.Attempt != 2 {
+		t.Errorf("got attempts %+v and %+v, want the same event tried twice", first, second)
+	}
+	if delay := time.Since(first.PublishedAt); delay < time.Second {
+		t.Errorf("tried again after %s, want a second of backoff", delay)
+	}
+}
+
+func TestLoadOutbox(t *testing.T) {
+	b := NewBus()
+	closeBus(t, b)
+
+	handled := make(chan Meta)
+	SubscribeDurable(b, durableName, func(ctx context.Context, meta Meta, e testEvent) error {
+		if e.N != 7 {
+			t.Errorf("got event %+v, want N 7", e)
+		}
+		handled <- meta
+		return nil
+	})
+
+	data, _ := json.Marshal(testEvent{N: 7})
+	saved := models.OutboxEvent{ID: uuid.New(), Type: "test.event", Subscriber: durableName, Data: data, Attempts: 2}
+	other := models.OutboxEvent{ID: uuid.New(), Type: "test.event", Subscriber: "unsubscribed", Data: data}
+	b.LoadOutbox([]models.OutboxEvent{saved, other})
+
+	meta := receive(t, handled)
+	if meta.ID != saved.ID || meta.Attempt != 3 {
+		t.Errorf("got %+v, want the saved event on its third attempt", meta)
+	}
+
+	// Events of subscribers that have not subscribed wait for them
+	deadline := time.Now().Add(time.Second)
+	for len(b.Outbox()) != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if outbox := b.Outbox(); len(outbox) != 1 || outbox[0].ID != other.ID {
+		t.Errorf("got outbox %+v, want the event of the other subscriber", outbox)
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := NewBus()
+
+	var handled []int
+	SubscribeAsync(b, "async", func(ctx context.Context, meta Meta, e testEvent) error {
+		time.Sleep(10 * time.Millisecond)
+		handled = append(handled, e.N)
+		return nil
+	})
+	started := make(chan struct{})
+	SubscribeDurable(b, durableName, func(ctx context.Context, meta Meta, e testEvent) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	for i := range 3 {
+		b.Publish(testEvent{N: i})
+	}
+	receive(t, started)
+
+	// Queued asynchronous events are handled before Close returns, while
+	// the durable event cut short stays in the outbox
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 3 {
+		t.Errorf("got %v handled, want all 3", handled)
+	}
+	if outbox := b.Outbox(); len(outbox) != 3 || outbox[0].Attempts != 0 {
+		t.Errorf("got outbox %+v, want the 3 events untried", outbox)
+	}
+
+	// Events published afterwards are dropped
+	b.Publish(testEvent{N: 3})
+	if len(b.Outbox()) != 3 {
+		t.Error("an event published after Close was queued")
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Errorf("closing again: %v", err)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	b := NewBus()
+	release := make(chan struct{})
+	defer close(release)
+	SubscribeAsync(b, "stuck", func(ctx context.Context, meta Meta, e testEvent) error {
+		<-release
+		return nil
+	})
+	b.Publish(testEvent{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := b.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline exceeded", err)
+	}
+}
@@ -0,0 +1,52 @@
+package events
+
+import (
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// ReadingCreated is published when a reading is recorded, from any source
+type ReadingCreated struct {
+	Reading models.MetricReading `json:"reading"`
+	Source  string               `json:"source"` // api, import, mqtt, modbus, telegram or remote_write
+}
+
+func (ReadingCreated) EventType() string { return "reading.created" }
+
+// ReadingCorrected is published when a reading is corrected; the previous
+// value is the last of its revisions
+type ReadingCorrected struct {
+	Reading models.MetricReading `json:"reading"`
+}
+
+func (ReadingCorrected) EventType() string { return "reading.corrected" }
+
+// MetricCreated is published when a metric is created
+type MetricCreated struct {
+	Metric models.Metric `json:"metric"`
+}
+
+func (MetricCreated) EventType() string { return "metric.created" }
+
+// MetricDeleted is published when a metric is moved to the trash
+type MetricDeleted struct {
+	Metric models.Metric `json:"metric"`
+}
+
+func (MetricDeleted) EventType() string { return "metric.deleted" }
+
+// RoomCreated is published when a room is created
+type RoomCreated struct {
+	Room models.Room `json:"room"`
+}
+
+func (RoomCreated) EventType() string { return "room.created" }
+
+// RoomDeleted is published when a room is moved to the trash, with the
+// metrics moved along with it
+type RoomDeleted struct {
+	Room      models.Room `json:"room"`
+	MetricIDs []uuid.UUID `json:"metric_ids"`
+}
+
+func (RoomDeleted) EventType() string { return "room.deleted" }
@@ -1,25 +1,319 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/audit"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/auth"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/backup"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/certs"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/config"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/mqtt"
 	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/server"
+	"github.com/google/uuid"
 )
 
 func main() {
-	// Create and start the server
+	cfg, err := config.Load(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Printf("Invalid configuration: %v", err)
+		os.Exit(2)
+	}
+
+	if err := run(cfg); err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
+// run serves the API until SIGINT or SIGTERM, then lets in-flight requests
+// and background work finish and saves the state
+func run(cfg *config.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Without a configured secret tokens are signed with a random key, so
+	// they are not accepted after a restart
+	secret := []byte(cfg.JWT.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return fmt.Errorf("failed to generate JWT secret: %w", err)
+		}
+		log.Print("JWT_SECRET is not set; tokens will not be accepted after a restart")
+	}
+	auth.SetSecret(secret)
+	auth.TokenTTL = cfg.JWT.TTL.Duration
+
 	s := server.NewServer()
 
-	// Start the server in a goroutine
-	go func() {
-		fmt.Println("Server is starting on http://localhost:8080")
-		fmt.Println("Swagger UI is available at http://localhost:8080/swagger/index.html")
-		if err := s.Start(":8080"); err != nil {
-			log.Fatalf("Failed to start server: %v", err)
+	// Backups, and the state file, are encrypted when a backup key is set
+	backupKey, err := backup.ParseKey(cfg.Backup.Key)
+	if err != nil {
+		return fmt.Errorf("failed to configure backups: %w", err)
+	}
+	s.SetBackupStore(backup.NewStore(cfg.Backup.Dir, backupKey))
+
+	if cfg.AuditLog != "" {
+		auditLog, err := audit.Open(cfg.AuditLog)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		defer auditLog.Close()
+		s.SetAuditLog(auditLog)
+	}
+
+	s.SetTrashRetention(cfg.TrashRetention.Duration)
+	s.SetMaxBodySize(cfg.Limits.MaxBodySize)
+	s.SetCORS(cfg.CORS.AllowedOrigins, cfg.CORS.MaxAge.Duration)
+	s.SetEventOutbox(cfg.Events.Outbox)
+	s.Events().QueueSize = cfg.Events.QueueSize
+
+	if len(cfg.TLS.Gateways) > 0 {
+		gateways := make(map[string][]uuid.UUID, len(cfg.TLS.Gateways))
+		for _, g := range cfg.TLS.Gateways {
+			gateways[g.Subject] = g.Metrics
+		}
+		s.SetGateways(gateways)
+	}
+
+	if path := cfg.Storage.File(); path != "" {
+		if err := s.OpenState(path, backupKey); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Admin.Username != "" {
+		if err := s.EnsureAdmin(cfg.Admin.Username, cfg.Admin.Password, cfg.Admin.Email); err != nil {
+			return fmt.Errorf("failed to add administrator: %w", err)
+		}
+	}
+
+	// Background workers stop when a signal arrives
+	var workers sync.WaitGroup
+	worker := func(f func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			f(ctx)
+		}()
+	}
+	if cfg.Backup.Interval.Duration > 0 {
+		worker(func(ctx context.Context) { s.ScheduleBackups(ctx, cfg.Backup.Interval.Duration, cfg.Backup.Retain) })
+	}
+	worker(func(ctx context.Context) { s.SchedulePurge(ctx, time.Hour) })
+	worker(func(ctx context.Context) { s.ScheduleCompaction(ctx, cfg.CompactionInterval.Duration) })
+	if cfg.Storage.File() != "" && cfg.Storage.FlushInterval.Duration > 0 {
+		worker(func(ctx context.Context) { s.ScheduleFlush(ctx, cfg.Storage.FlushInterval.Duration) })
+	}
+
+	servers := []*http.Server{newHTTPServer(cfg.Addr, s.Handler(), cfg.Limits)}
+
+	// The metric endpoints can additionally be served on their own, e.g. for
+	// meters on a separate network, authenticated by a shared token
+	if cfg.Metrics.Addr != "" {
+		var metricsAuth server.Authenticator
+		if cfg.Metrics.Token != "" {
+			metricsAuth = server.ServiceToken(cfg.Metrics.Token)
+		}
+		servers = append(servers, newHTTPServer(cfg.Metrics.Addr, server.NewMetricServer(s, metricsAuth).Handler(), cfg.Limits))
+	}
+
+	// Prometheus scrapes, and pushes readings to, a listener of its own
+	if cfg.Observability.Addr != "" {
+		observability := server.NewObservabilityServer(s, cfg.Observability.Token)
+		if cfg.Observability.RemoteWrite.Enabled {
+			observability.EnableRemoteWrite(cfg.Observability.RemoteWrite.Routes)
+		}
+		servers = append(servers, newHTTPServer(cfg.Observability.Addr, observability.Handler(), cfg.Limits))
+	}
+
+	// Streams do not end on their own, so shutdown ends them
+	for _, srv := range servers {
+		srv.RegisterOnShutdown(s.CloseStreams)
+	}
+
+	// Certificates are loaded again when their files change, or right away
+	// on SIGHUP
+	var reloader *certs.Reloader
+	if cfg.TLS.Enabled() {
+		reloader, err = certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		for _, srv := range servers {
+			srv.TLSConfig = reloader.TLSConfig()
+		}
+		worker(func(ctx context.Context) { reloadOnHangup(ctx, reloader) })
+	}
+
+	// Listeners are opened up front so that a taken port fails the start
+	var brokerListener net.Listener
+	if cfg.MQTT.ListenAddr != "" {
+		brokerListener, err = net.Listen("tcp", cfg.MQTT.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", cfg.MQTT.ListenAddr, err)
+		}
+		defer brokerListener.Close()
+	}
+	listeners := make([]net.Listener, 0, len(servers))
+	for _, srv := range servers {
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", srv.Addr, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	scheme := "http"
+	if cfg.TLS.Enabled() {
+		scheme = "https"
+	}
+	serveErrs := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func() {
+			var err error
+			if cfg.TLS.Enabled() {
+				err = srv.ServeTLS(listeners[i], "", "")
+			} else {
+				err = srv.Serve(listeners[i])
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				serveErrs <- fmt.Errorf("failed to serve on %s: %w", srv.Addr, err)
+			}
+		}()
+	}
+	log.Printf("Server is listening on %s://%s", scheme, listeners[0].Addr())
+	log.Printf("Swagger UI is available at %s://%s/swagger/index.html", scheme, listeners[0].Addr())
+	next := 1
+	if cfg.Metrics.Addr != "" {
+		log.Printf("Metric endpoints are available on %s://%s", scheme, listeners[next].Addr())
+		next++
+	}
+	if cfg.Observability.Addr != "" {
+		log.Printf("Prometheus metrics are available at %s://%s/metrics", scheme, listeners[next].Addr())
+	}
+
+	// Meters may publish readings over MQTT, to the embedded broker or to
+	// another one the bridge subscribes on
+	opts := mqtt.Options{ClientID: cfg.MQTT.ClientID, Username: cfg.MQTT.Username, Password: cfg.MQTT.Password}
+	if brokerListener != nil {
+		// Clients of the embedded broker log in with the configured
+		// credentials or as a device; a bridge connecting to it without
+		// configured credentials is given ones of its own
+		if cfg.MQTT.Broker == "" && opts.Username == "" {
+			opts.Username, opts.Password = "bridge", uuid.NewString()
+		}
+		broker := mqtt.NewBroker()
+		s.GuardMQTTBroker(broker, opts, cfg.MQTT.Routes)
+		go broker.Serve(brokerListener)
+		defer broker.Close()
+		log.Printf("MQTT broker is listening on %s", brokerListener.Addr())
+	}
+	if cfg.MQTT.Enabled() {
+		brokerURL := cfg.MQTT.Broker
+		if brokerURL == "" {
+			brokerURL = "tcp://" + brokerListener.Addr().String()
+		}
+		worker(func(ctx context.Context) { s.RunMQTTBridge(ctx, brokerURL, opts, cfg.MQTT.Routes) })
+	}
+	if len(cfg.Modbus.Devices) > 0 {
+		devices := make([]server.ModbusDevice, len(cfg.Modbus.Devices))
+		for i, d := range cfg.Modbus.Devices {
+			devices[i] = server.ModbusDevice{
+				Name:     d.Name,
+				Host:     d.Host,
+				UnitID:   d.UnitID,
+				Serial:   d.Serial,
+				Interval: d.Interval.Duration,
+				Timeout:  d.Timeout.Duration,
+			}
+			for _, reg := range d.Registers {
+				devices[i].Registers = append(devices[i].Registers, server.ModbusRegister{Register: reg.Register, MetricID: reg.MetricID})
+			}
 		}
-	}()
+		worker(func(ctx context.Context) { s.RunModbusPoller(ctx, devices) })
+	}
+
+	var errs []error
+	select {
+	case <-ctx.Done():
+		log.Print("Shutting down")
+	case err := <-serveErrs:
+		errs = append(errs, err)
+	}
+	// A second signal kills the process right away
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Limits.ShutdownTimeout.Duration)
+	defer cancel()
+
+	// In-flight requests are drained before the state is saved, so that
+	// readings they record are kept
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to drain requests on %s: %w", srv.Addr, err))
+		}
+	}
+	workers.Wait()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		log.Print("Server stopped")
+	}
+	return errors.Join(errs...)
+}
+
+// reloadOnHangup reloads the certificates whenever SIGHUP arrives, until
+// ctx is done
+func reloadOnHangup(ctx context.Context, reloader *certs.Reloader) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			if err := reloader.Reload(); err != nil {
+				log.Printf("Reloading certificates failed: %v", err)
+				continue
+			}
+			log.Print("Certificates reloaded")
+		}
+	}
+}
 
-	// Wait for interrupt signal to gracefully shutdown the server
-	select {}
+// newHTTPServer creates an HTTP server with the configured limits
+func newHTTPServer(addr string, handler http.Handler, limits config.Limits) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		MaxHeaderBytes:    limits.MaxHeaderBytes,
+		ReadHeaderTimeout: limits.ReadHeaderTimeout.Duration,
+		ReadTimeout:       limits.ReadTimeout.Duration,
+		WriteTimeout:      limits.WriteTimeout.Duration,
+		IdleTimeout:       limits.IdleTimeout.Duration,
+	}
 }
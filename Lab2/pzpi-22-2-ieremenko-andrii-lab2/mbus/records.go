@@ -0,0 +1,237 @@
+package mbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Function tells what a record's value is
+type Function string
+
+const (
+	Instantaneous    Function = "instantaneous"
+	Maximum          Function = "maximum"
+	Minimum          Function = "minimum"
+	ValueDuringError Function = "error"
+)
+
+var functions = [4]Function{Instantaneous, Maximum, Minimum, ValueDuringError}
+
+// Record is a data record of a telegram: one value with what it measures
+type Record struct {
+	// Quantity is what the value measures, such as energy, volume or
+	// flow_temperature
+	Quantity string
+	// Unit is the SI unit Value is in, such as Wh, m3 or °C, or the unit
+	// sent as text by the meter
+	Unit  string
+	Value float64
+	// Time is set instead of Value for date and time records
+	Time time.Time
+
+	Function Function
+	// Storage 0 is the current value; others are historic values, such as
+	// the reading at the last billing date
+	Storage int
+	Tariff  int
+	Subunit int
+	// Qualified is set when the record has VIF extensions changing its
+	// meaning, such as accumulation of backward flow only
+	Qualified bool
+}
+
+// parseRecords reads the data records of an application layer payload.
+// Manufacturer specific data at the end is ignored.
+func parseRecords(b []byte) ([]Record, error) {
+	var records []Record
+	for len(b) > 0 {
+		dif := b[0]
+		b = b[1:]
+		switch dif {
+		case 0x2F: // idle filler
+			continue
+		case 0x0F, 0x1F: // manufacturer specific data follows
+			return records, nil
+		}
+
+		r := Record{
+			Function: functions[(dif>>4)&0x03],
+			Storage:  int(dif>>6) & 0x01,
+		}
+		coding := dif & 0x0F
+
+		// DIFEs extend the storage number, tariff and subunit
+		ext := dif&0x80 != 0
+		for i := 0; ext; i++ {
+			if len(b) == 0 || i == 10 {
+				return nil, errTruncated
+			}
+			dife := b[0]
+			b = b[1:]
+			r.Storage |= int(dife&0x0F) << (1 + 4*i)
+			r.Tariff |= int((dife>>4)&0x03) << (2 * i)
+			r.Subunit |= int((dife>>6)&0x01) << i
+			ext = dife&0x80 != 0
+		}
+
+		v, rest, err := parseVIF(b)
+		if err != nil {
+			return nil, err
+		}
+		b = rest
+
+		size, err := dataSize(coding, b)
+		if err != nil {
+			return nil, err
+		}
+		if coding == 0x0D {
+			b = b[1:] // LVAR
+		}
+		if len(b) < size {
+			return nil, errTruncated
+		}
+		data := b[:size]
+		b = b[size:]
+
+		if v.skip || size == 0 || coding == 0x0D {
+			continue
+		}
+		r.Quantity, r.Unit, r.Qualified = v.quantity, v.unit, v.qualified
+
+		switch v.quantity {
+		case "date":
+			if size != 2 {
+				continue
+			}
+			r.Time = decodeDate(data)
+		case "date_time":
+			if size != 4 {
+				continue
+			}
+			t, ok := decodeDateTime(data)
+			if !ok {
+				continue
+			}
+			r.Time = t
+		default:
+			raw, ok := decodeValue(coding, data)
+			if !ok {
+				continue
+			}
+			r.Value = raw * math.Pow10(v.exponent) * v.factor
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// dataSize returns the size of the data of a record with the given DIF data
+// field coding; b is what follows the VIF
+func dataSize(coding byte, b []byte) (int, error) {
+	switch coding {
+	case 0x00, 0x08:
+		return 0, nil
+	case 0x01, 0x09:
+		return 1, nil
+	case 0x02, 0x0A:
+		return 2, nil
+	case 0x03, 0x0B:
+		return 3, nil
+	case 0x04, 0x05, 0x0C:
+		return 4, nil
+	case 0x06, 0x0E:
+		return 6, nil
+	case 0x07:
+		return 8, nil
+	case 0x0D:
+		if len(b) == 0 {
+			return 0, errTruncated
+		}
+		lvar := int(b[0])
+		switch {
+		case lvar <= 0xBF: // text
+			return lvar, nil
+		case lvar <= 0xCF: // positive BCD
+			return lvar - 0xC0, nil
+		case lvar <= 0xDF: // negative BCD
+			return lvar - 0xD0, nil
+		case lvar <= 0xEF: // binary
+			return lvar - 0xE0, nil
+		default: // floating point
+			return lvar - 0xF0, nil
+		}
+	default:
+		return 0, fmt.Errorf("mbus: unsupported data field coding %#x", coding)
+	}
+}
+
+// decodeValue decodes integer, real and BCD data
+func decodeValue(coding byte, data []byte) (float64, bool) {
+	switch coding {
+	case 0x01, 0x02, 0x03, 0x04, 0x06, 0x07:
+		// Signed little endian integers
+		var u uint64
+		for i := len(data) - 1; i >= 0; i-- {
+			u = u<<8 | uint64(data[i])
+		}
+		bits := uint(8 * len(data))
+		if bits < 64 && u&(1<<(bits-1)) != 0 {
+			u |= ^uint64(0) << bits
+		}
+		return float64(int64(u)), true
+	case 0x05:
+		f := math.Float32frombits(binary.LittleEndian.Uint32(data))
+		if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+			return 0, false
+		}
+		return float64(f), true
+	case 0x09, 0x0A, 0x0B, 0x0C, 0x0E:
+		return decodeBCD(data)
+	}
+	return 0, false
+}
+
+// decodeBCD decodes little endian BCD; a high nibble of F marks a negative
+// value
+func decodeBCD(data []byte) (float64, bool) {
+	var v float64
+	negative := false
+	for i := len(data) - 1; i >= 0; i-- {
+		hi, lo := data[i]>>4, data[i]&0x0F
+		if i == len(data)-1 && hi == 0x0F {
+			negative, hi = true, 0
+		}
+		if hi > 9 || lo > 9 {
+			return 0, false
+		}
+		v = v*100 + float64(hi)*10 + float64(lo)
+	}
+	if negative {
+		v = -v
+	}
+	return v, true
+}
+
+// decodeDate decodes a date of type G
+func decodeDate(b []byte) time.Time {
+	day := int(b[0] & 0x1F)
+	month := time.Month(b[1] & 0x0F)
+	year := 2000 + int((b[0]&0xE0)>>5|(b[1]&0xF0)>>1)
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+// decodeDateTime decodes a date and time of type F, reporting false if the
+// meter marks it invalid
+func decodeDateTime(b []byte) (time.Time, bool) {
+	if b[0]&0x80 != 0 {
+		return time.Time{}, false
+	}
+	minute := int(b[0] & 0x3F)
+	hour := int(b[1] & 0x1F)
+	day := int(b[2] & 0x1F)
+	month := time.Month(b[3] & 0x0F)
+	year := 2000 + int((b[2]&0xE0)>>5|(b[3]&0xF0)>>1)
+	return time.Date(year, month, day, hour, minute, 0, 0, time.Local), true
+}
@@ -0,0 +1,271 @@
+// Package mbus decodes telegrams of M-Bus meters (EN 13757), as received by
+// wireless M-Bus and OMS gateways or read from a wired M-Bus: the meter's
+// identity and its data records, decrypting OMS payloads (security mode 5)
+// with the meter's key.
+package mbus
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNoKey is returned for encrypted telegrams of meters without a key
+	ErrNoKey = errors.New("mbus: telegram is encrypted and no key is known for the meter")
+	// ErrDecryption is returned when a payload does not decrypt with the
+	// meter's key
+	ErrDecryption = errors.New("mbus: payload does not decrypt with the meter's key")
+
+	errTruncated = errors.New("mbus: telegram is truncated")
+)
+
+// Telegram is a decoded telegram
+type Telegram struct {
+	Manufacturer string // three letter code, such as KAM
+	ID           string // eight digit identification number, usually the serial number
+	Version      byte
+	DeviceType   DeviceType
+	AccessNumber byte
+	Status       byte
+	Encrypted    bool
+	Records      []Record
+}
+
+// KeyFunc returns the AES-128 key of a meter, or nil if none is known
+type KeyFunc func(manufacturer, id string) []byte
+
+// address is the manufacturer and address fields identifying a meter, as
+// sent
+type address struct {
+	manufacturer [2]byte
+	id           [4]byte
+	version      byte
+	deviceType   byte
+}
+
+// DecodeHex decodes a telegram written in hex; spaces are ignored
+func DecodeHex(s string, key KeyFunc) (*Telegram, error) {
+	frame, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return nil, fmt.Errorf("mbus: invalid hex: %w", err)
+	}
+	return Decode(frame, key)
+}
+
+// Decode decodes a wireless M-Bus frame, with or without its CRCs, or a
+// wired M-Bus long frame. key is asked for the key of encrypted telegrams.
+func Decode(frame []byte, key KeyFunc) (*Telegram, error) {
+	if len(frame) > 0 && frame[0] == 0x68 {
+		return decodeWired(frame, key)
+	}
+	return decodeWireless(frame, key)
+}
+
+// decodeWireless decodes a wireless M-Bus frame: L C M A CI ...
+func decodeWireless(frame []byte, key KeyFunc) (*Telegram, error) {
+	if len(frame) < 11 {
+		return nil, errTruncated
+	}
+	if int(frame[0]) != len(frame)-1 {
+		stripped, err := stripCRCs(frame)
+		if err != nil {
+			return nil, err
+		}
+		frame = stripped
+	}
+	// The L-field may claim a shorter frame than was sent
+	if len(frame) < 10 {
+		return nil, errTruncated
+	}
+
+	var link address
+	copy(link.manufacturer[:], frame[2:4])
+	copy(link.id[:], frame[4:8])
+	link.version, link.deviceType = frame[8], frame[9]
+	return decodeTransport(frame[10:], link, key)
+}
+
+// decodeWired decodes a wired M-Bus long frame: 68 L L 68 C A CI ... CS 16
+func decodeWired(frame []byte, key KeyFunc) (*Telegram, error) {
+	if len(frame) < 9 {
+		return nil, errTruncated
+	}
+	length := int(frame[1])
+	if frame[2] != frame[1] || frame[3] != 0x68 || len(frame) != length+6 || frame[len(frame)-1] != 0x16 {
+		return nil, errors.New("mbus: invalid long frame")
+	}
+	var sum byte
+	for _, c := range frame[4 : 4+length] {
+		sum += c
+	}
+	if sum != frame[len(frame)-2] {
+		return nil, errors.New("mbus: checksum mismatch")
+	}
+
+	// The wired link layer has no meter identity; it is in the data header
+	ci := frame[6]
+	if ci != 0x72 && ci != 0x76 {
+		return nil, fmt.Errorf("mbus: unsupported CI field %#x", ci)
+	}
+	return decodeTransport(frame[6:4+length], address{}, key)
+}
+
+// decodeTransport decodes what follows the link layer, starting with the
+// CI field
+func decodeTransport(b []byte, link address, key KeyFunc) (*Telegram, error) {
+	// An extended link layer carries no data this decoder needs
+	for len(b) > 0 && (b[0] == 0x8C || b[0] == 0x8D) {
+		size := 3
+		if b[0] == 0x8D {
+			size = 9
+		}
+		if len(b) < size {
+			return nil, errTruncated
+		}
+		b = b[size:]
+	}
+	if len(b) == 0 {
+		return nil, errTruncated
+	}
+
+	ci := b[0]
+	b = b[1:]
+	meter := link
+	var accessNumber, status byte
+	var config uint16
+
+	switch ci {
+	case 0x78: // no header
+	case 0x7A: // short header
+		if len(b) < 4 {
+			return nil, errTruncated
+		}
+		accessNumber, status, config = b[0], b[1], binary.LittleEndian.Uint16(b[2:])
+		b = b[4:]
+	case 0x72, 0x76: // long header, with the identity of the meter
+		if len(b) < 12 {
+			return nil, errTruncated
+		}
+		copy(meter.id[:], b[0:4])
+		copy(meter.manufacturer[:], b[4:6])
+		meter.version, meter.deviceType = b[6], b[7]
+		accessNumber, status, config = b[8], b[9], binary.LittleEndian.Uint16(b[10:])
+		b = b[12:]
+	default:
+		return nil, fmt.Errorf("mbus: unsupported CI field %#x", ci)
+	}
+
+	t := &Telegram{
+		Manufacturer: manufacturerCode(meter.manufacturer),
+		ID:           fmt.Sprintf("%02x%02x%02x%02x", meter.id[3], meter.id[2], meter.id[1], meter.id[0]),
+		Version:      meter.version,
+		DeviceType:   DeviceType(meter.deviceType),
+		AccessNumber: accessNumber,
+		Status:       status,
+	}
+
+	switch mode := (config >> 8) & 0x1F; mode {
+	case 0:
+	case 5:
+		t.Encrypted = true
+		blocks := int(config>>4) & 0x0F
+		k := key(t.Manufacturer, t.ID)
+		if k == nil {
+			return nil, ErrNoKey
+		}
+		plain, err := decryptMode5(b, blocks, k, meter, accessNumber)
+		if err != nil {
+			return nil, err
+		}
+		b = plain
+	default:
+		return nil, fmt.Errorf("mbus: unsupported security mode %d", mode)
+	}
+
+	records, err := parseRecords(b)
+	if err != nil {
+		return nil, err
+	}
+	t.Records = records
+	return t, nil
+}
+
+// decryptMode5 decrypts the first blocks AES blocks of a payload encrypted
+// with AES-128-CBC, whose IV is the meter address followed by the access
+// number eight times. A decrypted payload starts with 2F 2F.
+func decryptMode5(b []byte, blocks int, key []byte, meter address, accessNumber byte) ([]byte, error) {
+	size := blocks * aes.BlockSize
+	if blocks == 0 || len(b) < size {
+		return nil, errTruncated
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("mbus: invalid key: %w", err)
+	}
+
+	iv := make([]byte, 0, aes.BlockSize)
+	iv = append(iv, meter.manufacturer[:]...)
+	iv = append(iv, meter.id[:]...)
+	iv = append(iv, meter.version, meter.deviceType)
+	for range 8 {
+		iv = append(iv, accessNumber)
+	}
+
+	plain := make([]byte, len(b))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain[:size], b[:size])
+	copy(plain[size:], b[size:])
+	if plain[0] != 0x2F || plain[1] != 0x2F {
+		return nil, ErrDecryption
+	}
+	return plain, nil
+}
+
+// manufacturerCode returns the three letters the manufacturer field encodes
+func manufacturerCode(m [2]byte) string {
+	v := binary.LittleEndian.Uint16(m[:])
+	return string([]byte{byte(v>>10&0x1F) + 64, byte(v>>5&0x1F) + 64, byte(v&0x1F) + 64})
+}
+
+// stripCRCs removes the CRCs of a wireless M-Bus frame of format A, where
+// the first block of 10 bytes and every further block of up to 16 bytes is
+// followed by a CRC
+func stripCRCs(frame []byte) ([]byte, error) {
+	want := int(frame[0]) + 1
+	out := make([]byte, 0, want)
+	for b, size := frame, 10; len(b) > 0; size = 16 {
+		size = min(size, want-len(out))
+		if size <= 0 || len(b) < size+2 {
+			return nil, errors.New("mbus: length field does not match the frame")
+		}
+		if crc(b[:size]) != binary.BigEndian.Uint16(b[size:]) {
+			return nil, errors.New("mbus: CRC mismatch")
+		}
+		out = append(out, b[:size]...)
+		b = b[size+2:]
+	}
+	if len(out) != want {
+		return nil, errors.New("mbus: length field does not match the frame")
+	}
+	return out, nil
+}
+
+// crc computes the CRC of EN 13757-4
+func crc(b []byte) uint16 {
+	var c uint16
+	for _, x := range b {
+		c ^= uint16(x) << 8
+		for range 8 {
+			if c&0x8000 != 0 {
+				c = c<<1 ^ 0x3D65
+			} else {
+				c <<= 1
+			}
+		}
+	}
+	return ^c
+}
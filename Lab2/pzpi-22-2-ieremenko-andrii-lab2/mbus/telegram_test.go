@@ -0,0 +1,105 @@
+package mbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// plainTelegram is an unencrypted wireless telegram of a water meter
+// 12345678 with no data header and a volume of 12345.678 m3
+var plainTelegram = []byte{
+	0x10, 0x44, 0x2D, 0x2C, 0x78, 0x56, 0x34, 0x12, 0x33, 0x07,
+	0x78, 0x0C, 0x13, 0x78, 0x56, 0x34, 0x12,
+}
+
+// withCRCs adds the CRCs of format A to a frame
+func withCRCs(frame []byte) []byte {
+	var out []byte
+	for b, size := frame, 10; len(b) > 0; size = 16 {
+		size = min(size, len(b))
+		out = append(out, b[:size]...)
+		out = binary.BigEndian.AppendUint16(out, crc(b[:size]))
+		b = b[size:]
+	}
+	return out
+}
+
+func TestDecodeWireless(t *testing.T) {
+	for name, frame := range map[string][]byte{
+		"without CRCs": plainTelegram,
+		"with CRCs":    withCRCs(plainTelegram),
+	} {
+		t.Run(name, func(t *testing.T) {
+			telegram, err := Decode(frame, nil)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if telegram.ID != "12345678" || telegram.DeviceType != DeviceType(0x07) {
+				t.Errorf("got meter %s of type %#x", telegram.ID, telegram.DeviceType)
+			}
+			if len(telegram.Records) != 1 {
+				t.Fatalf("got %d records, want 1", len(telegram.Records))
+			}
+			if r := telegram.Records[0]; r.Unit != "m3" || r.Value != 12345.678 {
+				t.Errorf("got %v %s, want 12345.678 m3", r.Value, r.Unit)
+			}
+		})
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	// The L-field claims 9 bytes of an 11 byte frame whose first block CRC
+	// is valid, so only 9 bytes are left once the CRCs are stripped
+	short := append([]byte{0x08}, plainTelegram[1:9]...)
+	short = binary.BigEndian.AppendUint16(short, crc(short))
+
+	tests := map[string][]byte{
+		"empty":                 {},
+		"shorter than a header": plainTelegram[:9],
+		"short L-field":         short,
+		"header only":           append([]byte{0x09}, plainTelegram[1:10]...),
+		"long header cut short": {0x0D, 0x44, 0x2D, 0x2C, 0x78, 0x56, 0x34, 0x12, 0x33, 0x07, 0x72, 0x78, 0x56, 0x34},
+		"wired frame cut short": {0x68, 0x03, 0x03, 0x68, 0x08},
+	}
+	for name, frame := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Decode(frame, nil); err == nil {
+				t.Fatal("Decode succeeded")
+			}
+		})
+	}
+
+	if _, err := Decode(short, nil); !errors.Is(err, errTruncated) {
+		t.Errorf("Decode of a short L-field: got %v, want %v", err, errTruncated)
+	}
+}
+
+func TestDecodeOddLength(t *testing.T) {
+	framed := withCRCs(plainTelegram)
+	for _, frame := range [][]byte{
+		framed[:len(framed)-1],            // last CRC cut in half
+		append(framed, 0x00),              // a byte too many
+		append(plainTelegram, 0x00, 0x00), // L-field short of the frame
+	} {
+		if _, err := Decode(frame, nil); err == nil {
+			t.Errorf("Decode of % x succeeded", frame)
+		}
+	}
+}
+
+func TestDecodeGarbage(t *testing.T) {
+	// Frames from untrusted gateways must fail, not crash the decoder
+	rng := rand.New(rand.NewSource(1))
+	key := func(string, string) []byte { return make([]byte, 16) }
+	for range 20000 {
+		frame := make([]byte, rng.Intn(64))
+		rng.Read(frame)
+		if len(frame) > 0 && rng.Intn(2) == 0 {
+			frame[0] = byte(len(frame) - 1)
+		}
+		Decode(frame, key)
+		Decode(withCRCs(frame), key)
+	}
+}
@@ -0,0 +1,99 @@
+package mbus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeviceType is the kind of meter, the medium field of the address
+type DeviceType byte
+
+var deviceTypes = map[DeviceType]string{
+	0x00: "other",
+	0x02: "electricity",
+	0x03: "gas",
+	0x04: "heat",
+	0x06: "warm_water",
+	0x07: "water",
+	0x08: "heat_cost_allocator",
+	0x0A: "cooling",
+	0x0B: "cooling",
+	0x0C: "heat",
+	0x0D: "heat_cooling",
+	0x15: "hot_water",
+	0x16: "cold_water",
+	0x1A: "smoke_detector",
+	0x1B: "room_sensor",
+	0x1C: "gas_detector",
+}
+
+func (t DeviceType) String() string {
+	if name, ok := deviceTypes[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("type_%#02x", byte(t))
+}
+
+// unit is a unit values can be converted to and from: what it measures and
+// its size in the SI unit of that
+type unit struct {
+	dimension string
+	scale     float64
+}
+
+// units maps the names records and metrics use for units, lower case, to
+// what they measure
+var units = map[string]unit{
+	"j":     {"energy", 1},
+	"kj":    {"energy", 1e3},
+	"mj":    {"energy", 1e6},
+	"gj":    {"energy", 1e9},
+	"wh":    {"energy", 3600},
+	"kwh":   {"energy", 3600e3},
+	"mwh":   {"energy", 3600e6},
+	"m3":    {"volume", 1},
+	"m³":    {"volume", 1},
+	"l":     {"volume", 1e-3},
+	"kg":    {"mass", 1},
+	"t":     {"mass", 1e3},
+	"w":     {"power", 1},
+	"kw":    {"power", 1e3},
+	"mw":    {"power", 1e6},
+	"j/h":   {"power", 1.0 / 3600},
+	"m3/h":  {"volume_flow", 1},
+	"m³/h":  {"volume_flow", 1},
+	"l/h":   {"volume_flow", 1e-3},
+	"l/min": {"volume_flow", 60e-3},
+	"kg/h":  {"mass_flow", 1},
+	"°c":    {"temperature", 1},
+	"c":     {"temperature", 1},
+	"degc":  {"temperature", 1},
+	"k":     {"temperature_difference", 1},
+	"bar":   {"pressure", 1},
+	"kpa":   {"pressure", 1e-2},
+	"v":     {"voltage", 1},
+	"a":     {"current", 1},
+	"s":     {"duration", 1},
+	"h":     {"duration", 3600},
+	"d":     {"duration", 86400},
+}
+
+// Convert converts the value of a record to the unit to, such as kWh or
+// l, reporting false if the units do not measure the same thing
+func (r Record) Convert(to string) (float64, bool) {
+	if !r.Time.IsZero() {
+		return 0, false
+	}
+	if r.Unit == to {
+		return r.Value, true
+	}
+	from, ok := units[strings.ToLower(r.Unit)]
+	if !ok {
+		return 0, false
+	}
+	target, ok := units[strings.ToLower(strings.TrimSpace(to))]
+	if !ok || target.dimension != from.dimension {
+		return 0, false
+	}
+	return r.Value * from.scale / target.scale, true
+}
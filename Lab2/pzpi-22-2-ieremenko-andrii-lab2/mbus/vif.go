@@ -0,0 +1,176 @@
+package mbus
+
+// vif is what a value information field says about a record
+type vif struct {
+	quantity string
+	unit     string
+	// the value is raw * 10^exponent * factor
+	exponent int
+	factor   float64
+	// qualified is set by extensions changing the meaning of the value
+	qualified bool
+	// skip is set for records that are not measurements, such as the
+	// manufacturer specific ones
+	skip bool
+}
+
+// timeUnits are the seconds in the time units of durations
+var timeUnits = [4]float64{1, 60, 3600, 86400}
+
+// parseVIF reads the VIF of a record with its extensions and returns what
+// follows
+func parseVIF(b []byte) (vif, []byte, error) {
+	if len(b) == 0 {
+		return vif{}, nil, errTruncated
+	}
+	code := b[0]
+	b = b[1:]
+	v := vif{factor: 1}
+
+	switch code {
+	case 0x7C, 0xFC:
+		v.quantity = "custom"
+	case 0xFD, 0xFB:
+		if len(b) == 0 {
+			return vif{}, nil, errTruncated
+		}
+		ext := b[0]
+		b = b[1:]
+		if code == 0xFD {
+			v = extendedFD(ext & 0x7F)
+		} else {
+			v = extendedFB(ext & 0x7F)
+		}
+		code = ext // whether VIFEs follow is told by the extension
+	default:
+		v = primary(code & 0x7F)
+	}
+
+	// VIFEs correct the value or qualify it
+	for ext := code&0x80 != 0; ext; {
+		if len(b) == 0 {
+			return vif{}, nil, errTruncated
+		}
+		vife := b[0]
+		b = b[1:]
+		ext = vife&0x80 != 0
+
+		switch e := vife & 0x7F; {
+		case e >= 0x70 && e <= 0x77:
+			v.exponent += int(e&0x07) - 6
+		case e == 0x7D:
+			v.exponent += 3
+		case e == 0x7F, e == 0x7E:
+			// Manufacturer specific extensions; their meaning is unknown
+			v.skip = true
+		default:
+			v.qualified = true
+		}
+	}
+
+	// A unit sent as text follows the VIFEs, last character first
+	if v.quantity == "custom" {
+		if len(b) == 0 || len(b) < 1+int(b[0]) {
+			return vif{}, nil, errTruncated
+		}
+		n := int(b[0])
+		text := make([]byte, n)
+		for i := range text {
+			text[i] = b[n-i]
+		}
+		v.unit = string(text)
+		b = b[1+n:]
+	}
+	return v, b, nil
+}
+
+// primary interprets a VIF of the primary table
+func primary(code byte) vif {
+	n := int(code & 0x07)
+	switch {
+	case code <= 0x07:
+		return vif{quantity: "energy", unit: "Wh", exponent: n - 3, factor: 1}
+	case code <= 0x0F:
+		return vif{quantity: "energy", unit: "J", exponent: n, factor: 1}
+	case code <= 0x17:
+		return vif{quantity: "volume", unit: "m3", exponent: n - 6, factor: 1}
+	case code <= 0x1F:
+		return vif{quantity: "mass", unit: "kg", exponent: n - 3, factor: 1}
+	case code <= 0x23:
+		return vif{quantity: "on_time", unit: "s", factor: timeUnits[code&0x03]}
+	case code <= 0x27:
+		return vif{quantity: "operating_time", unit: "s", factor: timeUnits[code&0x03]}
+	case code <= 0x2F:
+		return vif{quantity: "power", unit: "W", exponent: n - 3, factor: 1}
+	case code <= 0x37:
+		return vif{quantity: "power", unit: "J/h", exponent: n, factor: 1}
+	case code <= 0x3F:
+		return vif{quantity: "volume_flow", unit: "m3/h", exponent: n - 6, factor: 1}
+	case code <= 0x47:
+		return vif{quantity: "volume_flow", unit: "m3/h", exponent: n - 7, factor: 60}
+	case code <= 0x4F:
+		return vif{quantity: "volume_flow", unit: "m3/h", exponent: n - 9, factor: 3600}
+	case code <= 0x57:
+		return vif{quantity: "mass_flow", unit: "kg/h", exponent: n - 3, factor: 1}
+	}
+
+	n = int(code & 0x03)
+	switch {
+	case code <= 0x5B:
+		return vif{quantity: "flow_temperature", unit: "°C", exponent: n - 3, factor: 1}
+	case code <= 0x5F:
+		return vif{quantity: "return_temperature", unit: "°C", exponent: n - 3, factor: 1}
+	case code <= 0x63:
+		return vif{quantity: "temperature_difference", unit: "K", exponent: n - 3, factor: 1}
+	case code <= 0x67:
+		return vif{quantity: "external_temperature", unit: "°C", exponent: n - 3, factor: 1}
+	case code <= 0x6B:
+		return vif{quantity: "pressure", unit: "bar", exponent: n - 3, factor: 1}
+	case code == 0x6C:
+		return vif{quantity: "date", factor: 1}
+	case code == 0x6D:
+		return vif{quantity: "date_time", factor: 1}
+	case code == 0x6E:
+		return vif{quantity: "heat_cost_allocation", factor: 1}
+	case code >= 0x70 && code <= 0x73:
+		return vif{quantity: "averaging_duration", unit: "s", factor: timeUnits[n]}
+	case code >= 0x74 && code <= 0x77:
+		return vif{quantity: "actuality_duration", unit: "s", factor: timeUnits[n]}
+	case code == 0x78:
+		return vif{quantity: "fabrication_number", factor: 1}
+	}
+	return vif{skip: true, factor: 1}
+}
+
+// extendedFD interprets a VIF of the first extension table
+func extendedFD(code byte) vif {
+	switch {
+	case code == 0x08:
+		return vif{quantity: "access_number", factor: 1}
+	case code == 0x17:
+		return vif{quantity: "error_flags", factor: 1}
+	case code >= 0x40 && code <= 0x4F:
+		return vif{quantity: "voltage", unit: "V", exponent: int(code&0x0F) - 9, factor: 1}
+	case code >= 0x50 && code <= 0x5F:
+		return vif{quantity: "current", unit: "A", exponent: int(code&0x0F) - 12, factor: 1}
+	case code == 0x74:
+		return vif{quantity: "battery_remaining", unit: "s", factor: timeUnits[3]}
+	}
+	return vif{skip: true, factor: 1}
+}
+
+// extendedFB interprets a VIF of the second extension table
+func extendedFB(code byte) vif {
+	n := int(code & 0x01)
+	switch code &^ 0x01 {
+	case 0x00:
+		return vif{quantity: "energy", unit: "Wh", exponent: n + 5, factor: 1} // 0.1 MWh
+	case 0x08:
+		return vif{quantity: "energy", unit: "J", exponent: n + 8, factor: 1} // 0.1 GJ
+	case 0x10:
+		return vif{quantity: "volume", unit: "m3", exponent: n + 2, factor: 1}
+	case 0x18:
+		return vif{quantity: "mass", unit: "kg", exponent: n + 5, factor: 1} // 100 t
+	}
+	return vif{skip: true, factor: 1}
+}
@@ -0,0 +1,188 @@
+// Package modbus reads registers of meters over Modbus TCP, and simulates
+// such meters for development and tests. Only the read functions meters
+// need are supported.
+package modbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Function codes
+const (
+	funcReadHoldingRegisters = 0x03
+	funcReadInputRegisters   = 0x04
+)
+
+// Exception codes
+const (
+	exceptionIllegalFunction    = 0x01
+	exceptionIllegalDataAddress = 0x02
+	exceptionIllegalDataValue   = 0x03
+)
+
+// maxRegisters is the most registers one request may read
+const maxRegisters = 125
+
+// ExceptionError is an error response from a device
+type ExceptionError struct {
+	Function byte
+	Code     byte
+}
+
+func (e *ExceptionError) Error() string {
+	var reason string
+	switch e.Code {
+	case exceptionIllegalFunction:
+		reason = "illegal function"
+	case exceptionIllegalDataAddress:
+		reason = "illegal data address"
+	case exceptionIllegalDataValue:
+		reason = "illegal data value"
+	default:
+		reason = fmt.Sprintf("exception %d", e.Code)
+	}
+	return fmt.Sprintf("modbus: function %d: %s", e.Function, reason)
+}
+
+// Client is a Modbus TCP connection. Requests are sent one at a time.
+type Client struct {
+	conn    net.Conn
+	timeout time.Duration
+
+	mu            sync.Mutex
+	transactionID uint16
+}
+
+// Dial connects to a device or gateway at addr, host:port with port 502 if
+// omitted. timeout bounds each request; 10 seconds when zero.
+func Dial(ctx context.Context, addr string, timeout time.Duration) (*Client, error) {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "502")
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, timeout: timeout}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// ReadRegisters reads count registers of a table starting at address from
+// the unit behind the connection
+func (c *Client) ReadRegisters(ctx context.Context, unitID byte, table Table, address uint16, count int) ([]uint16, error) {
+	function, err := table.function()
+	if err != nil {
+		return nil, err
+	}
+	if count < 1 || count > maxRegisters {
+		return nil, fmt.Errorf("modbus: cannot read %d registers at once", count)
+	}
+
+	pdu := []byte{function}
+	pdu = binary.BigEndian.AppendUint16(pdu, address)
+	pdu = binary.BigEndian.AppendUint16(pdu, uint16(count))
+
+	resp, err := c.roundTrip(ctx, unitID, pdu)
+	if err != nil {
+		return nil, err
+	}
+	if resp[0] == function|0x80 {
+		if len(resp) < 2 {
+			return nil, errMalformed
+		}
+		return nil, &ExceptionError{Function: function, Code: resp[1]}
+	}
+	if resp[0] != function || len(resp) < 2 || int(resp[1]) != 2*count || len(resp) != 2+2*count {
+		return nil, errMalformed
+	}
+
+	regs := make([]uint16, count)
+	for i := range regs {
+		regs[i] = binary.BigEndian.Uint16(resp[2+2*i:])
+	}
+	return regs, nil
+}
+
+// Read reads the value of a register description
+func (c *Client) Read(ctx context.Context, unitID byte, r Register) (float64, error) {
+	regs, err := c.ReadRegisters(ctx, unitID, r.Table, r.Address, r.Type.Registers())
+	if err != nil {
+		return 0, err
+	}
+	return r.Decode(regs)
+}
+
+var errMalformed = errors.New("modbus: malformed response")
+
+// roundTrip sends a request PDU to a unit and returns the response PDU
+func (c *Client) roundTrip(ctx context.Context, unitID byte, pdu []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+
+	c.transactionID++
+	id := c.transactionID
+	if _, err := c.conn.Write(frame(id, unitID, pdu)); err != nil {
+		return nil, err
+	}
+
+	// Responses to earlier requests that timed out are skipped
+	for {
+		respID, respUnit, resp, err := readFrame(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		if respID == id && respUnit == unitID && len(resp) > 0 {
+			return resp, nil
+		}
+	}
+}
+
+// frame wraps a PDU in the MBAP header of Modbus TCP
+func frame(transactionID uint16, unitID byte, pdu []byte) []byte {
+	b := make([]byte, 0, 7+len(pdu))
+	b = binary.BigEndian.AppendUint16(b, transactionID)
+	b = binary.BigEndian.AppendUint16(b, 0) // protocol identifier
+	b = binary.BigEndian.AppendUint16(b, uint16(len(pdu)+1))
+	b = append(b, unitID)
+	return append(b, pdu...)
+}
+
+// readFrame reads one Modbus TCP frame and returns its transaction
+// identifier, unit and PDU
+func readFrame(r io.Reader) (uint16, byte, []byte, error) {
+	var header [7]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if binary.BigEndian.Uint16(header[2:]) != 0 || length < 2 || length > 254 {
+		return 0, 0, nil, errMalformed
+	}
+
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(r, pdu); err != nil {
+		return 0, 0, nil, err
+	}
+	return binary.BigEndian.Uint16(header[:]), header[6], pdu, nil
+}
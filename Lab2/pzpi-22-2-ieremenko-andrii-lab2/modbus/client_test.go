@@ -0,0 +1,87 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// startSimulator serves a simulator on a local port and returns it with
+// its address
+func startSimulator(t *testing.T) (*Simulator, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := NewSimulator()
+	go sim.Serve(ln)
+	t.Cleanup(func() { sim.Close() })
+	return sim, ln.Addr().String()
+}
+
+func TestReadValues(t *testing.T) {
+	sim, addr := startSimulator(t)
+	ctx := context.Background()
+	client, err := Dial(ctx, addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		reg   Register
+		value float64
+	}{
+		{Register{Address: 0, Type: Uint16}, 65535},
+		{Register{Address: 1, Type: Int16}, -2},
+		{Register{Address: 2, Type: Uint32, Scale: 0.01}, 123456.78},
+		{Register{Address: 4, Type: Int32, LowWordFirst: true}, -70000},
+		{Register{Address: 6, Type: Float32}, 1.5},
+		{Register{Table: InputRegisters, Address: 0, Type: Int64, Offset: 10}, -1 << 40},
+		{Register{Table: InputRegisters, Address: 4, Type: Float64, LowWordFirst: true}, 0.1},
+	}
+	for _, tt := range tests {
+		if err := sim.SetValue(1, tt.reg, tt.value); err != nil {
+			t.Fatal(err)
+		}
+		got, err := client.Read(ctx, 1, tt.reg)
+		if err != nil || got != tt.value {
+			t.Errorf("%s %s at %d: got %v, %v; want %v", tt.reg.Table, tt.reg.Type, tt.reg.Address, got, err, tt.value)
+		}
+	}
+
+	// Registers of other units and unset registers fail with an exception,
+	// leaving the connection usable
+	for _, unitID := range []byte{1, 2} {
+		_, err := client.Read(ctx, unitID, Register{Address: 100, Type: Uint16})
+		var exception *ExceptionError
+		if !errors.As(err, &exception) || exception.Code != exceptionIllegalDataAddress {
+			t.Errorf("unit %d: got %v, want an illegal data address exception", unitID, err)
+		}
+	}
+	if got, err := client.Read(ctx, 1, tests[0].reg); err != nil || got != tests[0].value {
+		t.Errorf("after an exception: got %v, %v", got, err)
+	}
+}
+
+func TestReadAfterClose(t *testing.T) {
+	sim, addr := startSimulator(t)
+	sim.Set(1, HoldingRegisters, 0, 7)
+	ctx := context.Background()
+	client, err := Dial(ctx, addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	sim.Close()
+	if _, err := client.Read(ctx, 1, Register{Type: Uint16}); err == nil {
+		t.Error("read from a closed simulator succeeded")
+	}
+	if _, err := Dial(ctx, addr, time.Second); err == nil {
+		t.Error("dialed a closed simulator")
+	}
+}
@@ -0,0 +1,180 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Table is a register table of a Modbus device
+type Table string
+
+const (
+	HoldingRegisters Table = "holding"
+	InputRegisters   Table = "input"
+)
+
+// function returns the function code reading the table
+func (t Table) function() (byte, error) {
+	switch t {
+	case HoldingRegisters, "":
+		return funcReadHoldingRegisters, nil
+	case InputRegisters:
+		return funcReadInputRegisters, nil
+	default:
+		return 0, fmt.Errorf("modbus: unknown register table %q", t)
+	}
+}
+
+// DataType is how a value is laid out in consecutive registers
+type DataType string
+
+const (
+	Uint16  DataType = "uint16"
+	Int16   DataType = "int16"
+	Uint32  DataType = "uint32"
+	Int32   DataType = "int32"
+	Float32 DataType = "float32"
+	Uint64  DataType = "uint64"
+	Int64   DataType = "int64"
+	Float64 DataType = "float64"
+)
+
+// Registers returns how many registers a value of the type takes, or 0 for
+// an unknown type
+func (t DataType) Registers() int {
+	switch t {
+	case Uint16, Int16:
+		return 1
+	case Uint32, Int32, Float32:
+		return 2
+	case Uint64, Int64, Float64:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// Register describes a value a device exposes: where it is, how it is
+// encoded and how it is scaled to the unit of its metric
+type Register struct {
+	Table   Table    `json:"table"` // holding (default) or input
+	Address uint16   `json:"address"`
+	Type    DataType `json:"type"`
+	// LowWordFirst is set for devices that send the least significant
+	// register of multi-register values first
+	LowWordFirst bool    `json:"low_word_first"`
+	Scale        float64 `json:"scale"` // the value is raw*Scale+Offset; a zero Scale means 1
+	Offset       float64 `json:"offset"`
+}
+
+// Validate checks that the register can be read
+func (r Register) Validate() error {
+	if _, err := r.Table.function(); err != nil {
+		return err
+	}
+	if r.Type.Registers() == 0 {
+		return fmt.Errorf("modbus: unknown data type %q", r.Type)
+	}
+	if int(r.Address)+r.Type.Registers() > 1<<16 {
+		return fmt.Errorf("modbus: register %d of type %s runs past the last address", r.Address, r.Type)
+	}
+	return nil
+}
+
+// Decode converts the raw registers of the value to its scaled value
+func (r Register) Decode(regs []uint16) (float64, error) {
+	n := r.Type.Registers()
+	if n == 0 {
+		return 0, fmt.Errorf("modbus: unknown data type %q", r.Type)
+	}
+	if len(regs) != n {
+		return 0, fmt.Errorf("modbus: %s takes %d registers, got %d", r.Type, n, len(regs))
+	}
+
+	b := wordsToBytes(regs, r.LowWordFirst)
+	var raw float64
+	switch r.Type {
+	case Uint16:
+		raw = float64(binary.BigEndian.Uint16(b))
+	case Int16:
+		raw = float64(int16(binary.BigEndian.Uint16(b)))
+	case Uint32:
+		raw = float64(binary.BigEndian.Uint32(b))
+	case Int32:
+		raw = float64(int32(binary.BigEndian.Uint32(b)))
+	case Float32:
+		raw = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case Uint64:
+		raw = float64(binary.BigEndian.Uint64(b))
+	case Int64:
+		raw = float64(int64(binary.BigEndian.Uint64(b)))
+	case Float64:
+		raw = math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	if math.IsNaN(raw) || math.IsInf(raw, 0) {
+		return 0, fmt.Errorf("modbus: register %d holds no number", r.Address)
+	}
+
+	scale := r.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return raw*scale + r.Offset, nil
+}
+
+// Encode converts a scaled value to the registers holding it, the reverse
+// of Decode. Integer types are rounded.
+func (r Register) Encode(value float64) ([]uint16, error) {
+	scale := r.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	raw := (value - r.Offset) / scale
+
+	var b []byte
+	switch r.Type {
+	case Uint16, Int16:
+		b = binary.BigEndian.AppendUint16(nil, uint16(int64(math.Round(raw))))
+	case Uint32, Int32:
+		b = binary.BigEndian.AppendUint32(nil, uint32(int64(math.Round(raw))))
+	case Float32:
+		b = binary.BigEndian.AppendUint32(nil, math.Float32bits(float32(raw)))
+	case Uint64, Int64:
+		b = binary.BigEndian.AppendUint64(nil, uint64(int64(math.Round(raw))))
+	case Float64:
+		b = binary.BigEndian.AppendUint64(nil, math.Float64bits(raw))
+	default:
+		return nil, fmt.Errorf("modbus: unknown data type %q", r.Type)
+	}
+	return bytesToWords(b, r.LowWordFirst), nil
+}
+
+// wordsToBytes returns the big endian bytes of registers given in the
+// device's word order
+func wordsToBytes(regs []uint16, lowWordFirst bool) []byte {
+	b := make([]byte, 0, 2*len(regs))
+	for i := range regs {
+		w := regs[i]
+		if lowWordFirst {
+			w = regs[len(regs)-1-i]
+		}
+		b = binary.BigEndian.AppendUint16(b, w)
+	}
+	return b
+}
+
+// bytesToWords returns the registers, in the device's word order, holding
+// big endian bytes
+func bytesToWords(b []byte, lowWordFirst bool) []uint16 {
+	regs := make([]uint16, len(b)/2)
+	for i := range regs {
+		w := binary.BigEndian.Uint16(b[2*i:])
+		if lowWordFirst {
+			regs[len(regs)-1-i] = w
+		} else {
+			regs[i] = w
+		}
+	}
+	return regs
+}
@@ -0,0 +1,169 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// Simulator is a Modbus TCP server holding registers set in code, standing
+// in for meters during development and tests. Reading a register that was
+// never set fails with an illegal data address exception.
+type Simulator struct {
+	mu        sync.Mutex
+	registers map[registerKey]uint16
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// registerKey identifies a register of a unit
+type registerKey struct {
+	unitID  byte
+	table   Table
+	address uint16
+}
+
+// NewSimulator creates a simulator without registers; call Serve to accept
+// connections
+func NewSimulator() *Simulator {
+	return &Simulator{
+		registers: make(map[registerKey]uint16),
+		listeners: make(map[net.Listener]bool),
+		conns:     make(map[net.Conn]bool),
+	}
+}
+
+// Set sets consecutive registers of a unit starting at address
+func (s *Simulator) Set(unitID byte, table Table, address uint16, values ...uint16) {
+	if table == "" {
+		table = HoldingRegisters
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range values {
+		s.registers[registerKey{unitID, table, address + uint16(i)}] = v
+	}
+}
+
+// SetValue sets the registers of a unit so that reading r gives value
+func (s *Simulator) SetValue(unitID byte, r Register, value float64) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	regs, err := r.Encode(value)
+	if err != nil {
+		return err
+	}
+	s.Set(unitID, r.Table, r.Address, regs...)
+	return nil
+}
+
+// Serve accepts connections on ln until Close is called
+func (s *Simulator) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.listeners[ln] = true
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections and closes the open ones
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// serveConn answers the requests on one connection until it ends
+func (s *Simulator) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		id, unitID, pdu, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(frame(id, unitID, s.handle(unitID, pdu))); err != nil {
+			return
+		}
+	}
+}
+
+// handle returns the response PDU to a request PDU
+func (s *Simulator) handle(unitID byte, pdu []byte) []byte {
+	function := pdu[0]
+	exception := func(code byte) []byte {
+		return []byte{function | 0x80, code}
+	}
+
+	var table Table
+	switch function {
+	case funcReadHoldingRegisters:
+		table = HoldingRegisters
+	case funcReadInputRegisters:
+		table = InputRegisters
+	default:
+		return exception(exceptionIllegalFunction)
+	}
+	if len(pdu) != 5 {
+		return exception(exceptionIllegalDataValue)
+	}
+	address := binary.BigEndian.Uint16(pdu[1:])
+	count := int(binary.BigEndian.Uint16(pdu[3:]))
+	if count < 1 || count > maxRegisters || int(address)+count > 1<<16 {
+		return exception(exceptionIllegalDataValue)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := []byte{function, byte(2 * count)}
+	for i := 0; i < count; i++ {
+		v, ok := s.registers[registerKey{unitID, table, address + uint16(i)}]
+		if !ok {
+			return exception(exceptionIllegalDataAddress)
+		}
+		resp = binary.BigEndian.AppendUint16(resp, v)
+	}
+	return resp
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditChange describes a single field changed by an audited action
+type AuditChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After  json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+}
+
+// AuditEntry represents a record of an administrative or data-changing action.
+// Entries are hash chained: Hash covers the entry including PrevHash, the
+// hash of the entry before it.
+type AuditEntry struct {
+	Sequence   int64           `json:"sequence"`
+	Timestamp  time.Time       `json:"timestamp"`
+	ActorID    uuid.UUID       `json:"actor_id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"` // e.g. room.delete, reading.create
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Before     json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After      json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	Changes    []AuditChange   `json:"changes,omitempty"`
+	SourceIP   string          `json:"source_ip"`
+	RequestID  string          `json:"request_id"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// AuditLogResponse represents the response for querying the audit log
+type AuditLogResponse struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+}
+
+// AuditVerifyResponse represents the result of verifying the audit hash chain
+type AuditVerifyResponse struct {
+	Valid    bool   `json:"valid"`
+	Entries  int    `json:"entries"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Message  string `json:"message"`
+}
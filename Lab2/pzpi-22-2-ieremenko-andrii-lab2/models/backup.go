@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Backup describes a stored snapshot of the database
+type Backup struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum"` // hex encoded SHA-256 of the backup file
+	Encrypted bool      `json:"encrypted"`
+	Scheduled bool      `json:"scheduled"`
+}
+
+// BackupListResponse represents the response for listing backups
+type BackupListResponse struct {
+	Backups []Backup `json:"backups"`
+	Total   int      `json:"total"`
+}
+
+// RestoreResponse summarizes the state loaded from a backup
+type RestoreResponse struct {
+	Message  string    `json:"message"`
+	TakenAt  time.Time `json:"taken_at"`
+	Users    int       `json:"users"`
+	Roles    int       `json:"roles"`
+	Rooms    int       `json:"rooms"`
+	Metrics  int       `json:"metrics"`
+	Readings int       `json:"readings"`
+	Devices  int       `json:"devices"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BenchmarkResponse represents an anonymized comparison of an apartment's
+// consumption against comparable apartments
+type BenchmarkResponse struct {
+	RoomID         uuid.UUID          `json:"room_id"`
+	Kind           string             `json:"kind"`
+	Scope          string             `json:"scope"`     // building or complex
+	Normalize      string             `json:"normalize"` // area or occupant
+	StartTime      time.Time          `json:"startTime"`
+	EndTime        time.Time          `json:"endTime"`
+	PeerCount      int                `json:"peer_count"`      // other rooms compared with
+	Value          float64            `json:"value"`           // normalized consumption of the room
+	PercentileRank float64            `json:"percentile_rank"` // share of peers consuming less, 0-100
+	Percentiles    map[string]float64 `json:"percentiles"`     // p10 to p90; those that would reveal a peer are left out
+}
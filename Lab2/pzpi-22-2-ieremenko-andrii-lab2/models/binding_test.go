@@ -0,0 +1,87 @@
+package models
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/validation"
+)
+
+// payloads lists every type of the package with binding tags
+var payloads = []interface{}{
+	CreateDeviceKeyRequest{},
+	CreateDeviceRequest{},
+	UpdateDeviceRequest{},
+	AddReadingRequest{},
+	CorrectReadingRequest{},
+	CorrelationRequest{},
+	CreateMetricRequest{},
+	UpdateMetricRequest{},
+	CreateRetentionPolicyRequest{},
+	CreateRoleRequest{},
+	CreateRoomRequest{},
+	UpdateRoomRequest{},
+	ChangePasswordRequest{},
+	DeleteAccountRequest{},
+	StreamRequest{},
+	TelegramRequest{},
+	LoginRequest{},
+	RegisterRequest{},
+	UpdateUserRequest{},
+}
+
+func TestBindingTags(t *testing.T) {
+	listed := make(map[string]bool)
+	for _, v := range payloads {
+		name := reflect.TypeOf(v).Name()
+		listed[name] = true
+		if err := validation.CheckTags(v); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// Types with binding tags added later must be listed too
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range files {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			spec, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			if st, ok := spec.Type.(*ast.StructType); ok && hasBinding(st) && !listed[spec.Name.Name] {
+				t.Errorf("%s has binding tags but is not in payloads", spec.Name.Name)
+			}
+			return true
+		})
+	}
+}
+
+// hasBinding reports whether a field of a struct has a binding tag
+func hasBinding(st *ast.StructType) bool {
+	for _, field := range st.Fields.List {
+		if field.Tag == nil {
+			continue
+		}
+		tag, _ := strconv.Unquote(field.Tag.Value)
+		if _, ok := reflect.StructTag(tag).Lookup("binding"); ok {
+			return true
+		}
+	}
+	return false
+}
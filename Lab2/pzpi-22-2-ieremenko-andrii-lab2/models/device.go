@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Device health states reported by GET /devices
+const (
+	DeviceHealthy            = "ok"
+	DeviceNeverSeen          = "never_seen"
+	DeviceStale              = "stale"
+	DeviceCalibrationOverdue = "calibration_overdue"
+)
+
+// Device represents a physical meter that records readings of its metrics
+// with an API key instead of a user login
+type Device struct {
+	ID             uuid.UUID   `json:"id"`
+	Serial         string      `json:"serial"`
+	Model          string      `json:"model"`
+	InstalledAt    *time.Time  `json:"installed_at,omitempty"`
+	CalibrationDue *time.Time  `json:"calibration_due,omitempty"`
+	MetricIDs      []uuid.UUID `json:"metric_ids"` // metrics the device may record readings of
+	Keys           []DeviceKey `json:"keys"`
+	OMSKey         string      `json:"-"`                      // hex AES key decrypting the meter's wireless M-Bus telegrams
+	HasOMSKey      bool        `json:"has_oms_key"`            // whether OMSKey is set; the key itself is never returned
+	LastSeenAt     *time.Time  `json:"last_seen_at,omitempty"` // when the device last recorded a reading
+	Health         string      `json:"health,omitempty"`       // set when listing: ok, never_seen, stale or calibration_overdue
+	Version        int64       `json:"version"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+}
+
+// DeviceKey is an API key of a device. Only a hash of the key is kept; the
+// key itself is returned once, when it is created.
+type DeviceKey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // first characters of the key, to tell keys apart
+	Hash       string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateDeviceRequest represents the request to register a device
+type CreateDeviceRequest struct {
+	Serial         string      `json:"serial" binding:"required,max=64"`
+	Model          string      `json:"model" binding:"max=100"`
+	InstalledAt    *time.Time  `json:"installed_at"`
+	CalibrationDue *time.Time  `json:"calibration_due"`
+	MetricIDs      []uuid.UUID `json:"metric_ids" binding:"required"`
+	OMSKey         string      `json:"oms_key"` // 32 hex digits; for meters sending encrypted telegrams
+}
+
+// UpdateDeviceRequest represents the request to update a device; omitted
+// fields are left unchanged
+type UpdateDeviceRequest struct {
+	Serial         *string      `json:"serial" binding:"min=1,max=64"`
+	Model          *string      `json:"model" binding:"max=100"`
+	InstalledAt    *time.Time   `json:"installed_at"`
+	CalibrationDue *time.Time   `json:"calibration_due"`
+	MetricIDs      *[]uuid.UUID `json:"metric_ids" binding:"min=1"`
+	OMSKey         *string      `json:"oms_key"` // an empty string removes the key
+}
+
+// DeviceListResponse represents the response for listing devices
+type DeviceListResponse struct {
+	Devices []Device       `json:"devices"`
+	Total   int            `json:"total"`
+	Health  map[string]int `json:"health"` // number of devices in each health state
+}
+
+// CreateDeviceKeyRequest represents the request to issue an API key
+type CreateDeviceKeyRequest struct {
+	Name string `json:"name" binding:"max=100"`
+}
+
+// DeviceKeyResponse represents a newly issued API key. Key is not shown
+// again.
+type DeviceKeyResponse struct {
+	DeviceKey
+	Key string `json:"key"`
+}
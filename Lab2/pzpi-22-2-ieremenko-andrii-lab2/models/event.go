@@ -0,0 +1,46 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event subscriber modes
+const (
+	EventSync    = "sync"    // runs as the event is published
+	EventAsync   = "async"   // runs on its own goroutine, fed by a bounded queue
+	EventDurable = "durable" // like async, fed by the outbox
+)
+
+// OutboxEvent is an event waiting in the outbox for a durable subscriber
+type OutboxEvent struct {
+	ID          uuid.UUID       `json:"id"` // the same for every subscriber of the event
+	Type        string          `json:"type"`
+	Subscriber  string          `json:"subscriber"`
+	PublishedAt time.Time       `json:"published_at"`
+	Data        json.RawMessage `json:"data" swaggertype:"object"`
+	Attempts    int             `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	NextAttempt *time.Time      `json:"next_attempt,omitempty"` // when a failed delivery is tried again
+}
+
+// EventSubscriber represents a subscriber of the event bus and how its
+// deliveries go
+type EventSubscriber struct {
+	Name      string `json:"name"`
+	EventType string `json:"event_type"`
+	Mode      string `json:"mode"`   // sync, async or durable
+	Queued    int    `json:"queued"` // events waiting for it
+	Delivered int64  `json:"delivered"`
+	Failed    int64  `json:"failed"`  // deliveries its handler failed
+	Dropped   int64  `json:"dropped"` // events it missed as its queue was full, or durable ones given up on
+}
+
+// EventBusStatus represents the response for the event bus status
+type EventBusStatus struct {
+	Subscribers []EventSubscriber `json:"subscribers"`
+	Outbox      []OutboxEvent     `json:"outbox"`           // the oldest pending events
+	Persisted   bool              `json:"outbox_persisted"` // whether the outbox is saved with the state
+}
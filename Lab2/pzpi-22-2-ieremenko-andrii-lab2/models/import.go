@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Import job statuses
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// ImportMapping describes how the columns of an import file map to readings.
+// For CSV files fields name header columns, for NDJSON files object keys.
+type ImportMapping struct {
+	MetricIDField   string    `json:"metric_id_field"`  // column holding the metric ID
+	SerialField     string    `json:"serial_field"`     // column holding the meter serial number
+	MetricID        uuid.UUID `json:"metric_id"`        // metric for files with a single meter
+	TimestampField  string    `json:"timestamp_field"`  // defaults to "timestamp"
+	TimestampFormat string    `json:"timestamp_format"` // Go layout, "unix" or "unix_ms"; RFC3339 by default
+	ValueField      string    `json:"value_field"`      // defaults to "value"
+	UnitField       string    `json:"unit_field"`       // optional, must match the metric unit
+}
+
+// ImportRowError describes why a row of an import file was rejected
+type ImportRowError struct {
+	Row     int    `json:"row"` // line of the file the row starts on
+	Message string `json:"message"`
+}
+
+// ImportJob represents a background import of historical readings
+type ImportJob struct {
+	ID            uuid.UUID        `json:"id"`
+	Format        string           `json:"format"`
+	DryRun        bool             `json:"dry_run"`
+	Status        string           `json:"status"`
+	TotalRows     int              `json:"total_rows"`
+	ProcessedRows int              `json:"processed_rows"`
+	ImportedRows  int              `json:"imported_rows"`
+	FailedRows    int              `json:"failed_rows"`
+	Errors        []ImportRowError `json:"errors"`
+	Error         string           `json:"error,omitempty"` // why the file could not be read, if the job failed
+	CreatedAt     time.Time        `json:"created_at"`
+	CompletedAt   *time.Time       `json:"completed_at,omitempty"`
+}
+
+// ImportListResponse represents the response for listing import jobs
+type ImportListResponse struct {
+	Imports []ImportJob `json:"imports"`
+	Total   int         `json:"total"`
+}
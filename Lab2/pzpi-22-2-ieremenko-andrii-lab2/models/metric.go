@@ -8,35 +8,70 @@ import (
 
 // Metric represents a household metric
 type Metric struct {
-	ID          uuid.UUID `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Unit        string    `json:"unit"` // единица измерения (кВт, м³, и т.д.)
-	RoomID      uuid.UUID `json:"room_id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID              uuid.UUID  `json:"id"`
+	Name            string     `json:"name"`
+	Description     string     `json:"description"`
+	Unit            string     `json:"unit"` // единица измерения (кВт, м³, и т.д.)
+	Kind            string     `json:"kind"` // electricity, water, gas, heat
+	MeterSerial     string     `json:"meter_serial"`
+	RoomID          uuid.UUID  `json:"room_id"`
+	Version         int64      `json:"version"`          // incremented on every change to the metric itself
+	ReadingsVersion int64      `json:"readings_version"` // incremented on every change to its readings
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
 }
 
 // MetricReading represents a single reading of a metric
 type MetricReading struct {
-	ID        uuid.UUID `json:"id"`
-	MetricID  uuid.UUID `json:"metric_id"`
+	ID        uuid.UUID         `json:"id"`
+	MetricID  uuid.UUID         `json:"metric_id"`
+	Value     float64           `json:"value"`
+	Timestamp time.Time         `json:"timestamp"`
+	CreatedAt time.Time         `json:"created_at"`
+	Revisions []ReadingRevision `json:"revisions,omitempty"` // earlier values, oldest first
+}
+
+// ReadingRevision preserves a reading as it was before a correction
+type ReadingRevision struct {
 	Value     float64   `json:"value"`
 	Timestamp time.Time `json:"timestamp"`
-	CreatedAt time.Time `json:"created_at"`
+	ChangedAt time.Time `json:"changed_at"`
+	ChangedBy string    `json:"changed_by"`
+	Reason    string    `json:"reason"`
 }
 
 // CreateMetricRequest represents the request to create a new metric
 type CreateMetricRequest struct {
-	Name        string    `json:"name" binding:"required"`
+	Name        string    `json:"name" binding:"required,max=100"`
 	Description string    `json:"description"`
-	Unit        string    `json:"unit" binding:"required"`
-	RoomID      uuid.UUID `json:"room_id"`
+	Unit        string    `json:"unit" binding:"required,max=20"`
+	Kind        string    `json:"kind" binding:"oneof=electricity water gas heat"`
+	MeterSerial string    `json:"meter_serial" binding:"max=64"`
+	RoomID      uuid.UUID `json:"room_id" binding:"required"`
+}
+
+// UpdateMetricRequest represents the request to update a metric; omitted
+// fields are left unchanged. Setting room_id moves the metric to another room.
+type UpdateMetricRequest struct {
+	Name        *string    `json:"name" binding:"min=1,max=100"`
+	Description *string    `json:"description"`
+	Unit        *string    `json:"unit" binding:"min=1,max=20"`
+	Kind        *string    `json:"kind" binding:"oneof=electricity water gas heat"`
+	MeterSerial *string    `json:"meter_serial" binding:"max=64"`
+	RoomID      *uuid.UUID `json:"room_id"`
+}
+
+// CorrectReadingRequest represents the request to correct a reading
+type CorrectReadingRequest struct {
+	Value     *float64   `json:"value"`
+	Timestamp *time.Time `json:"timestamp"`
+	Reason    string     `json:"reason" binding:"max=500"`
 }
 
 // AddReadingRequest represents the request to add a new reading
 type AddReadingRequest struct {
-	Value     float64   `json:"value" binding:"required"`
+	Value     *float64  `json:"value" binding:"required"`
 	Timestamp time.Time `json:"timestamp"`
 }
 
@@ -52,18 +87,20 @@ type MetricListResponse struct {
 	Total   int      `json:"total"`
 }
 
-// ReadingListResponse represents the response for listing readings
+// ReadingListResponse represents the response for listing readings. Readings
+// compacted by a retention policy come as rollups by hour or day.
 type ReadingListResponse struct {
 	Readings []MetricReading `json:"readings"`
-	Total    int             `json:"total"`
+	Total    int             `json:"total"`             // raw readings
+	Rollups  []SeriesPoint   `json:"rollups,omitempty"` // oldest first
 }
 
 // CorrelationRequest represents a request to calculate correlation between metrics
 type CorrelationRequest struct {
-	Metric1ID uuid.UUID `json:"metric1Id"`
-	Metric2ID uuid.UUID `json:"metric2Id"`
-	StartTime time.Time `json:"startTime"`
-	EndTime   time.Time `json:"endTime"`
+	Metric1ID uuid.UUID `json:"metric1Id" binding:"required"`
+	Metric2ID uuid.UUID `json:"metric2Id" binding:"required"`
+	StartTime time.Time `json:"startTime" binding:"required"`
+	EndTime   time.Time `json:"endTime" binding:"required"`
 }
 
 // CorrelationResponse represents the correlation calculation result
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Modbus poll states
+const (
+	ModbusPending = "pending" // not polled yet
+	ModbusOK      = "ok"
+	ModbusFailing = "failing" // the last poll failed; polls are backing off
+)
+
+// ModbusRegisterStatus is the last value read from a polled register
+type ModbusRegisterStatus struct {
+	MetricID uuid.UUID  `json:"metric_id"`
+	Address  uint16     `json:"address"`
+	Value    *float64   `json:"value,omitempty"`
+	ReadAt   *time.Time `json:"read_at,omitempty"`
+}
+
+// ModbusDeviceStatus represents how polling a Modbus device goes
+type ModbusDeviceStatus struct {
+	Name                string                 `json:"name"`
+	Host                string                 `json:"host"`
+	UnitID              byte                   `json:"unit_id"`
+	Serial              string                 `json:"serial,omitempty"` // registry device the polls count as seen
+	State               string                 `json:"state"`            // pending, ok or failing
+	Interval            string                 `json:"interval"`
+	LastPollAt          *time.Time             `json:"last_poll_at,omitempty"`
+	LastSuccessAt       *time.Time             `json:"last_success_at,omitempty"`
+	LastError           string                 `json:"last_error,omitempty"`
+	ConsecutiveFailures int                    `json:"consecutive_failures"`
+	NextPollAt          *time.Time             `json:"next_poll_at,omitempty"`
+	Registers           []ModbusRegisterStatus `json:"registers"`
+}
+
+// ModbusStatusResponse represents the response for the Modbus poller status
+type ModbusStatusResponse struct {
+	Devices []ModbusDeviceStatus `json:"devices"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "github.com/google/uuid"
+
+// MQTTRoute maps the messages published on a topic to readings of a metric.
+// A payload is either a plain number, read now, or a JSON object such as
+// {"value": 12.5, "timestamp": "2024-01-01T10:00:00Z"}.
+//
+// The metric is taken from the payload field MetricField if present, else
+// from Metrics by the topic level matched by the first + of Topic, else it
+// is MetricID. A metric in the payload must be MetricID or one of Metrics.
+type MQTTRoute struct {
+	Topic          string               `json:"topic"` // topic filter; + matches one level, # any number at the end
+	MetricID       uuid.UUID            `json:"metric_id"`
+	Metrics        map[string]uuid.UUID `json:"metrics,omitempty"`
+	MetricField    string               `json:"metric_field,omitempty"`    // "metric_id" by default
+	ValueField     string               `json:"value_field,omitempty"`     // "value" by default
+	TimestampField string               `json:"timestamp_field,omitempty"` // "timestamp" by default; RFC 3339 or Unix seconds
+}
@@ -0,0 +1,16 @@
+package models
+
+// Problem represents an error response in the RFC 7807 problem+json format.
+// Code is a stable machine-readable identifier of the error; Detail is the
+// human-readable message.
+// @Description Error response (application/problem+json)
+type Problem struct {
+	Type      string                 `json:"type" example:"about:blank"`
+	Title     string                 `json:"title" example:"Not Found"`
+	Status    int                    `json:"status" example:"404"`
+	Code      string                 `json:"code" example:"metric_not_found"`
+	Detail    string                 `json:"detail" example:"Metric not found"`
+	Instance  string                 `json:"instance,omitempty" example:"/metrics/123e4567-e89b-12d3-a456-426614174000"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
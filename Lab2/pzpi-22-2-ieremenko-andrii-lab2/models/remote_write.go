@@ -0,0 +1,14 @@
+package models
+
+import "github.com/google/uuid"
+
+// RemoteWriteRoute maps the Prometheus series pushed by remote write to
+// readings of a metric. A series matches when it has every label of Match
+// with the same value, such as {"__name__": "meter_energy_kwh", "serial": "SN-1"}.
+//
+// Series carrying a metric_id label are recorded to that metric, as long as
+// some route maps it.
+type RemoteWriteRoute struct {
+	Match    map[string]string `json:"match"`
+	MetricID uuid.UUID         `json:"metric_id"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Report job statuses
+const (
+	ReportJobPending   = "pending"
+	ReportJobRunning   = "running"
+	ReportJobCompleted = "completed"
+	ReportJobFailed    = "failed"
+)
+
+// ReportJob represents an asynchronous report generation job
+type ReportJob struct {
+	ID          uuid.UUID  `json:"id"`
+	Type        string     `json:"type"`
+	Format      string     `json:"format"`
+	StartTime   time.Time  `json:"startTime"`
+	EndTime     time.Time  `json:"endTime"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Resolutions of readings and their rollups
+const (
+	ResolutionRaw  = "raw"  // readings as recorded
+	ResolutionHour = "hour" // rolled up by UTC hour
+	ResolutionDay  = "day"  // rolled up by UTC day
+)
+
+// RetentionPolicy says how long the readings of a metric are kept at each
+// resolution. It applies to one metric, to the metrics of a kind, or to every
+// metric when it names neither; the most specific one wins. Zero days keeps
+// a tier forever.
+type RetentionPolicy struct {
+	ID         uuid.UUID  `json:"id"`
+	MetricID   *uuid.UUID `json:"metric_id,omitempty"`
+	Kind       string     `json:"kind,omitempty"`
+	RawDays    int        `json:"raw_days"`    // then rolled up by hour
+	HourlyDays int        `json:"hourly_days"` // then rolled up by day
+	DailyDays  int        `json:"daily_days"`  // then deleted
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateRetentionPolicyRequest represents the request to create a retention
+// policy
+type CreateRetentionPolicyRequest struct {
+	MetricID   *uuid.UUID `json:"metric_id"`
+	Kind       string     `json:"kind" binding:"oneof=electricity water gas heat"`
+	RawDays    int        `json:"raw_days" binding:"min=0"`
+	HourlyDays int        `json:"hourly_days" binding:"min=0"`
+	DailyDays  int        `json:"daily_days" binding:"min=0"`
+}
+
+// RetentionPolicyListResponse represents the response for listing retention
+// policies
+type RetentionPolicyListResponse struct {
+	Policies []RetentionPolicy `json:"policies"`
+	Total    int               `json:"total"`
+}
+
+// Rollup aggregates the readings of a metric taken within an hour or a day
+type Rollup struct {
+	MetricID   uuid.UUID `json:"metric_id"`
+	Resolution string    `json:"resolution"` // hour or day
+	Start      time.Time `json:"start"`
+	Count      int       `json:"count"`
+	Sum        float64   `json:"sum"`
+	Min        float64   `json:"min"`
+	Max        float64   `json:"max"`
+}
+
+// CompactionResult represents what a compaction of the readings did
+type CompactionResult struct {
+	Metrics        int       `json:"metrics"`          // metrics a policy applied to
+	RawRolledUp    int       `json:"raw_rolled_up"`    // readings rolled up by hour and deleted
+	HourlyRolledUp int       `json:"hourly_rolled_up"` // hourly rollups rolled up by day and deleted
+	DailyDeleted   int       `json:"daily_deleted"`    // daily rollups deleted
+	CompactedAt    time.Time `json:"compacted_at"`
+}
+
+// SeriesPoint represents the readings of a metric within a bucket: a single
+// reading at raw resolution, or a rollup
+type SeriesPoint struct {
+	Start      time.Time `json:"start"`
+	Resolution string    `json:"resolution"` // raw, hour or day
+	Count      int       `json:"count"`
+	Sum        float64   `json:"sum"`
+	Min        float64   `json:"min"`
+	Max        float64   `json:"max"`
+	Avg        float64   `json:"avg"`
+}
+
+// SeriesResponse represents the readings of a metric over a range, at the
+// resolution asked for or chosen for the range. Points older than what is
+// kept at that resolution come at the resolution they are kept at.
+type SeriesResponse struct {
+	MetricID   uuid.UUID     `json:"metric_id"`
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Resolution string        `json:"resolution"`
+	Points     []SeriesPoint `json:"points"`
+}
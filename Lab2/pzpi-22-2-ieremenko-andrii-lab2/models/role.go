@@ -8,11 +8,12 @@ type Role struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Permissions []string  `json:"permissions"`
+	Version     int64     `json:"version"`
 }
 
 // CreateRoleRequest represents the request to create a new role
 type CreateRoleRequest struct {
-	Name        string   `json:"name" binding:"required"`
+	Name        string   `json:"name" binding:"required,max=64"`
 	Description string   `json:"description"`
 	Permissions []string `json:"permissions"`
 }
@@ -8,17 +8,41 @@ import (
 
 // Room represents a room in the system
 type Room struct {
-	ID          uuid.UUID `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Building    string     `json:"building"`
+	Complex     string     `json:"complex"`
+	Area        float64    `json:"area"` // floor area in m²
+	Occupants   int        `json:"occupants"`
+	OwnerID     uuid.UUID  `json:"owner_id"`
+	Version     int64      `json:"version"` // incremented on every change, served as the ETag
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 }
 
 // CreateRoomRequest represents a request to create a new room
 type CreateRoomRequest struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Name        string    `json:"name" binding:"required,max=100"`
+	Description string    `json:"description"`
+	Building    string    `json:"building"`
+	Complex     string    `json:"complex"`
+	Area        float64   `json:"area" binding:"min=0"`
+	Occupants   int       `json:"occupants" binding:"min=0"`
+	OwnerID     uuid.UUID `json:"owner_id"`
+}
+
+// UpdateRoomRequest represents a request to update a room; omitted fields
+// are left unchanged
+type UpdateRoomRequest struct {
+	Name        *string    `json:"name" binding:"min=1,max=100"`
+	Description *string    `json:"description"`
+	Building    *string    `json:"building"`
+	Complex     *string    `json:"complex"`
+	Area        *float64   `json:"area" binding:"min=0"`
+	Occupants   *int       `json:"occupants" binding:"min=0"`
+	OwnerID     *uuid.UUID `json:"owner_id"`
 }
 
 // RoomListResponse represents a response for listing rooms
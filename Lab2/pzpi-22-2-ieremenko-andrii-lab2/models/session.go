@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session represents a login of a user; every token belongs to one
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserID     uuid.UUID `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"` // the session of the request listing it
+}
+
+// SessionListResponse represents the response for listing sessions
+type SessionListResponse struct {
+	Sessions []Session `json:"sessions"`
+	Total    int       `json:"total"`
+}
+
+// ChangePasswordRequest represents the request to change one's password.
+// Other sessions of the user are ended.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// DeleteAccountRequest represents the request to delete one's account
+type DeleteAccountRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+}
+
+// AccountExport represents all data held about a user, as returned before
+// the account is deleted
+type AccountExport struct {
+	ExportedAt time.Time            `json:"exported_at"`
+	User       User                 `json:"user"`
+	Rooms      []Room               `json:"rooms"`
+	Metrics    []MetricWithReadings `json:"metrics"`
+	Sessions   []Session            `json:"sessions"`
+}
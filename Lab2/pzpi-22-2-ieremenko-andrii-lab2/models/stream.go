@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stream event types. Events about metrics carry an ID that a client
+// resumes after; the others tell about the stream itself.
+const (
+	StreamReading          = "reading"           // a reading was recorded
+	StreamReadingCorrected = "reading.corrected" // a reading was corrected
+	StreamSubscribed       = "subscribed"        // the subscriptions of a WebSocket changed
+	StreamGap              = "gap"               // events were missed; fetch the readings to catch up
+	StreamError            = "error"             // a request failed, or the stream ends
+)
+
+// StreamEvent is a message of a reading stream, sent as the data of a
+// Server-Sent Event or as a WebSocket text message
+type StreamEvent struct {
+	ID       uint64         `json:"id,omitempty"`
+	Type     string         `json:"type"`
+	MetricID *uuid.UUID     `json:"metric_id,omitempty"`
+	RoomID   *uuid.UUID     `json:"room_id,omitempty"`
+	Reading  *MetricReading `json:"reading,omitempty"`
+	Time     *time.Time     `json:"time,omitempty"` // when the event happened
+
+	MetricIDs []uuid.UUID `json:"metric_ids,omitempty"` // subscribed metrics
+	RoomIDs   []uuid.UUID `json:"room_ids,omitempty"`   // subscribed rooms
+	Code      string      `json:"code,omitempty"`       // of errors, e.g. slow_consumer
+	Message   string      `json:"message,omitempty"`
+}
+
+// StreamRequest is a message a WebSocket client sends to change what it
+// receives, such as {"action": "subscribe", "room_ids": ["..."], "last_event_id": 42}
+type StreamRequest struct {
+	Action      string      `json:"action" binding:"required,oneof=subscribe unsubscribe"`
+	MetricIDs   []uuid.UUID `json:"metric_ids"`
+	RoomIDs     []uuid.UUID `json:"room_ids"`
+	LastEventID uint64      `json:"last_event_id"` // replay the events after this one that are still kept
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TelegramRequest represents a wireless or wired M-Bus telegram forwarded
+// by a gateway
+type TelegramRequest struct {
+	Telegram   string    `json:"telegram" binding:"required"` // the frame in hex; spaces are ignored
+	ReceivedAt time.Time `json:"received_at"`                 // when the gateway received it; now if omitted
+}
+
+// TelegramRecord is a data record of a telegram
+type TelegramRecord struct {
+	Quantity  string      `json:"quantity"` // e.g. energy, volume, flow_temperature or date_time
+	Unit      string      `json:"unit,omitempty"`
+	Value     *float64    `json:"value,omitempty"`
+	Time      *time.Time  `json:"time,omitempty"` // set instead of value for date and time records
+	Function  string      `json:"function"`       // instantaneous, maximum, minimum or error
+	Storage   int         `json:"storage"`        // 0 for the current value
+	Tariff    int         `json:"tariff"`
+	Subunit   int         `json:"subunit"`
+	MetricIDs []uuid.UUID `json:"metric_ids,omitempty"` // metrics the value was recorded to
+}
+
+// TelegramResponse represents a decoded telegram and the readings recorded
+// from it
+type TelegramResponse struct {
+	Manufacturer string           `json:"manufacturer"`
+	Serial       string           `json:"serial"` // identification number of the meter
+	Version      int              `json:"version"`
+	DeviceType   string           `json:"device_type"`
+	AccessNumber int              `json:"access_number"`
+	Status       int              `json:"status"`
+	Encrypted    bool             `json:"encrypted"`
+	Duplicate    bool             `json:"duplicate"` // received before, e.g. by another gateway; nothing was recorded
+	Records      []TelegramRecord `json:"records"`
+	Readings     []MetricReading  `json:"readings"`
+	Unmatched    []uuid.UUID      `json:"unmatched"` // metrics of the meter no record could be recorded to
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// TrashedRoom represents a deleted room awaiting purge
+type TrashedRoom struct {
+	Room
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// TrashedMetric represents a deleted metric awaiting purge
+type TrashedMetric struct {
+	Metric
+	Readings int       `json:"readings"`
+	PurgeAt  time.Time `json:"purge_at"`
+}
+
+// TrashResponse represents the response for listing the trash
+type TrashResponse struct {
+	Rooms   []TrashedRoom   `json:"rooms"`
+	Metrics []TrashedMetric `json:"metrics"`
+}
@@ -9,13 +9,14 @@ import (
 // User represents a user in the system
 // @Description User information
 type User struct {
-	ID        uuid.UUID `json:"id" example:"123e4567-e89b-12d3-a456-426614174000"`
-	Username  string    `json:"username" example:"johndoe"`
-	Password  string    `json:"-"` // Password is not exposed in JSON
-	Email     string    `json:"email" example:"john@example.com"`
-	Roles     []Role    `json:"roles"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           uuid.UUID `json:"id" example:"123e4567-e89b-12d3-a456-426614174000"`
+	Username     string    `json:"username" example:"johndoe"`
+	PasswordHash string    `json:"-"` // bcrypt hash of the password, never exposed
+	Email        string    `json:"email" example:"john@example.com"`
+	Roles        []Role    `json:"roles"`
+	Version      int64     `json:"version"` // incremented on every change, served as the ETag
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 // LoginRequest represents the login request payload
@@ -25,13 +26,23 @@ type LoginRequest struct {
 	Password string `json:"password" example:"secretpassword" binding:"required"`
 }
 
-// RegisterRequest represents the registration request payload
+// RegisterRequest represents the registration request payload. Registered
+// users get the user role; administrators grant others.
 // @Description Registration request payload
 type RegisterRequest struct {
-	Username string   `json:"username" example:"johndoe" binding:"required"`
-	Password string   `json:"password" example:"secretpassword" binding:"required"`
-	Email    string   `json:"email" example:"john@example.com" binding:"required,email"`
-	Roles    []string `json:"roles" example:"['user', 'admin']"`
+	Username string `json:"username" example:"johndoe" binding:"required,max=64"`
+	Password string `json:"password" example:"secretpassword" binding:"required"`
+	Email    string `json:"email" example:"john@example.com" binding:"required,email"`
+}
+
+// UpdateUserRequest represents the request to update a user; omitted fields
+// are left unchanged. Changing one's own password requires the current one.
+// @Description User update request payload
+type UpdateUserRequest struct {
+	Email           *string   `json:"email" example:"john@example.com" binding:"email"`
+	Password        *string   `json:"password" example:"newsecretpassword" binding:"min=1"`
+	CurrentPassword string    `json:"current_password" example:"secretpassword"`
+	Roles           *[]string `json:"roles"` // administrators only
 }
 
 // AuthResponse represents the authentication response
@@ -0,0 +1,436 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxQueued bounds the QoS 1 messages kept for a client that is away; the
+// oldest are dropped first
+const maxQueued = 10000
+
+// Broker is a small MQTT broker for meters to publish to when there is no
+// other broker, and for tests. Sessions are kept in memory only.
+type Broker struct {
+	// Authenticate, if set, decides whether a client may connect
+	Authenticate func(clientID, username, password string) bool
+	// AuthorizePublish, if set, decides whether the client that logged in
+	// as username may publish a message. MQTT 3.1.1 cannot refuse a
+	// message, so others are acknowledged and dropped.
+	AuthorizePublish func(username, topic string, payload []byte) bool
+	// AuthorizeSubscribe, if set, decides whether the client that logged in
+	// as username may subscribe to a topic filter
+	AuthorizeSubscribe func(username, filter string) bool
+
+	mu        sync.Mutex
+	sessions  map[string]*session
+	listeners map[net.Listener]bool
+	closed    bool
+	conns     sync.WaitGroup
+}
+
+// session is the state the broker keeps for a client ID
+type session struct {
+	id       string
+	clean    bool
+	subs     map[string]byte // topic filter to granted QoS
+	conn     *brokerConn     // nil while the client is away
+	nextID   uint16
+	inflight map[uint16]queued // sent with QoS 1, not yet acknowledged
+	order    []uint16          // identifiers in inflight, oldest first
+	queue    []queued          // QoS 1 messages waiting for the client to return
+}
+
+// queued is a message on its way to a subscriber
+type queued struct {
+	topic   string
+	payload []byte
+	qos     byte
+}
+
+// brokerConn is a client connection to the broker
+type brokerConn struct {
+	conn     net.Conn
+	username string // the client logged in as
+	writeMu  sync.Mutex
+}
+
+// NewBroker creates a broker; call Serve to accept connections
+func NewBroker() *Broker {
+	return &Broker{
+		sessions:  make(map[string]*session),
+		listeners: make(map[net.Listener]bool),
+	}
+}
+
+// Serve accepts connections on ln until Close is called
+func (b *Broker) Serve(ln net.Listener) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return net.ErrClosed
+	}
+	b.listeners[ln] = true
+	b.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			b.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		b.conns.Add(1)
+		go func() {
+			defer b.conns.Done()
+			b.serveConn(conn)
+		}()
+	}
+}
+
+// Close stops accepting connections and disconnects every client
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	for ln := range b.listeners {
+		ln.Close()
+	}
+	for _, s := range b.sessions {
+		if s.conn != nil {
+			s.conn.conn.Close()
+		}
+	}
+	b.mu.Unlock()
+
+	b.conns.Wait()
+	return nil
+}
+
+// Publish delivers a message to the subscribers, as if a client had
+// published it
+func (b *Broker) Publish(topic string, payload []byte, qos byte) error {
+	if !ValidTopic(topic) {
+		return errors.New("mqtt: invalid topic")
+	}
+	b.route(topic, payload, qos)
+	return nil
+}
+
+// serveConn handles one client connection until it ends
+func (b *Broker) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	c := &brokerConn{conn: conn}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	p, err := readPacket(r)
+	if err != nil || p.typ != typeConnect {
+		return
+	}
+	s, keepAlive, ok := b.connect(c, p)
+	if !ok {
+		return
+	}
+	defer b.disconnect(s, c)
+
+	for {
+		if keepAlive > 0 {
+			conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+		p, err := readPacket(r)
+		if err != nil {
+			return
+		}
+
+		switch p.typ {
+		case typePublish:
+			m, err := parsePublish(p)
+			if err != nil {
+				return
+			}
+			if b.AuthorizePublish == nil || b.AuthorizePublish(c.username, m.Topic, m.Payload) {
+				b.route(m.Topic, m.Payload, m.QoS)
+			} else {
+				log.Printf("MQTT broker: dropping a message %s may not publish on %s", clientLabel(s.id, c.username), m.Topic)
+			}
+			if m.QoS == 1 {
+				c.write(idPacket(typePuback, m.PacketID))
+			}
+
+		case typePuback:
+			d := decoder{b: p.body}
+			id := d.uint16()
+			if d.err != nil {
+				return
+			}
+			b.acknowledge(s, id)
+
+		case typeSubscribe:
+			if !b.subscribe(s, c, p) {
+				return
+			}
+
+		case typeUnsubscribe:
+			d := decoder{b: p.body}
+			id := d.uint16()
+			b.mu.Lock()
+			for len(d.b) > 0 && d.err == nil {
+				delete(s.subs, d.string())
+			}
+			b.mu.Unlock()
+			if d.err != nil {
+				return
+			}
+			c.write(idPacket(typeUnsuback, id))
+
+		case typePingreq:
+			c.write(packet{typ: typePingresp})
+
+		case typeDisconnect:
+			return
+
+		default:
+			return
+		}
+	}
+}
+
+// connect handles CONNECT, taking over or starting the session of the
+// client, and sends what was kept for it
+func (b *Broker) connect(c *brokerConn, p packet) (*session, time.Duration, bool) {
+	d := decoder{b: p.body}
+	protocol := d.string()
+	level := d.byte()
+	flags := d.byte()
+	keepAlive := time.Duration(d.uint16()) * time.Second
+	clientID := d.string()
+	if flags&connectWill != 0 {
+		d.string()
+		d.bytes()
+	}
+	var username, password string
+	if flags&connectUsername != 0 {
+		username = d.string()
+	}
+	if flags&connectPassword != 0 {
+		password = string(d.bytes())
+	}
+	if d.err != nil {
+		return nil, 0, false
+	}
+
+	refuse := func(code byte) (*session, time.Duration, bool) {
+		c.write(packet{typ: typeConnack, body: []byte{0, code}})
+		return nil, 0, false
+	}
+	if protocol != "MQTT" || level != 4 {
+		return refuse(1)
+	}
+	clean := flags&connectCleanSession != 0
+	if clientID == "" {
+		if !clean {
+			return refuse(2)
+		}
+		clientID = randomID()
+	}
+	if b.Authenticate != nil && !b.Authenticate(clientID, username, password) {
+		return refuse(5)
+	}
+	c.username = username
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, present := b.sessions[clientID]
+	if present && s.conn != nil {
+		// A client connecting again with the same ID replaces the old
+		// connection
+		s.conn.conn.Close()
+		s.conn = nil
+	}
+	if !present || clean {
+		s = &session{
+			id:       clientID,
+			subs:     make(map[string]byte),
+			inflight: make(map[uint16]queued),
+		}
+		b.sessions[clientID] = s
+		present = false
+	}
+	s.clean = clean
+	s.conn = c
+
+	sessionPresent := byte(0)
+	if present {
+		sessionPresent = 1
+	}
+	c.write(packet{typ: typeConnack, body: []byte{sessionPresent, 0}})
+
+	// Messages that were not acknowledged are sent again, then the ones
+	// that arrived while the client was away
+	for _, id := range s.order {
+		m := s.inflight[id]
+		c.write(publishPacket(m.topic, m.payload, m.qos, id, true))
+	}
+	queue := s.queue
+	s.queue = nil
+	for _, m := range queue {
+		b.deliver(s, m)
+	}
+	return s, keepAlive, true
+}
+
+// disconnect ends the connection of a session; clean sessions are dropped
+func (b *Broker) disconnect(s *session, c *brokerConn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s.conn != c {
+		return
+	}
+	s.conn = nil
+	if s.clean {
+		delete(b.sessions, s.id)
+	}
+}
+
+// subscribe handles SUBSCRIBE, granting QoS 1 at most
+func (b *Broker) subscribe(s *session, c *brokerConn, p packet) bool {
+	d := decoder{b: p.body}
+	id := d.uint16()
+	var codes []byte
+	granted := make(map[string]byte)
+
+	for len(d.b) > 0 && d.err == nil {
+		filter := d.string()
+		qos := min(d.byte(), 1)
+		if d.err != nil {
+			break
+		}
+		if !ValidFilter(filter) || b.AuthorizeSubscribe != nil && !b.AuthorizeSubscribe(c.username, filter) {
+			codes = append(codes, subackFailure)
+			continue
+		}
+		granted[filter] = qos
+		codes = append(codes, qos)
+	}
+	if d.err != nil || len(codes) == 0 {
+		return false
+	}
+
+	b.mu.Lock()
+	for filter, qos := range granted {
+		s.subs[filter] = qos
+	}
+	b.mu.Unlock()
+
+	c.write(packet{typ: typeSuback, body: append(appendUint16(nil, id), codes...)})
+	return true
+}
+
+// route delivers a message to every session subscribed to its topic
+func (b *Broker) route(topic string, payload []byte, qos byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, s := range b.sessions {
+		granted, subscribed := byte(0), false
+		for filter, q := range s.subs {
+			if Match(filter, topic) {
+				granted, subscribed = max(granted, q), true
+			}
+		}
+		if subscribed {
+			b.deliver(s, queued{topic: topic, payload: payload, qos: min(qos, granted)})
+		}
+	}
+}
+
+// deliver sends a message to a session, or keeps it until the client
+// returns; the caller must hold mu
+func (b *Broker) deliver(s *session, m queued) {
+	if s.conn == nil {
+		if m.qos == 0 || s.clean {
+			return
+		}
+		if len(s.queue) >= maxQueued {
+			log.Printf("MQTT broker: dropping a message queued for %s", s.id)
+			s.queue = s.queue[1:]
+		}
+		s.queue = append(s.queue, m)
+		return
+	}
+
+	var id uint16
+	if m.qos == 1 {
+		for {
+			s.nextID++
+			if s.nextID == 0 {
+				s.nextID = 1
+			}
+			if _, taken := s.inflight[s.nextID]; !taken {
+				break
+			}
+		}
+		id = s.nextID
+		s.inflight[id] = m
+		s.order = append(s.order, id)
+	}
+	s.conn.write(publishPacket(m.topic, m.payload, m.qos, id, false))
+}
+
+// acknowledge handles PUBACK from a subscriber
+func (b *Broker) acknowledge(s *session, id uint16) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := s.inflight[id]; !ok {
+		return
+	}
+	delete(s.inflight, id)
+	for i, queuedID := range s.order {
+		if queuedID == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// write sends a packet to the client, closing the connection if that fails
+func (c *brokerConn) write(p packet) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(p.encode()); err != nil {
+		c.conn.Close()
+	}
+}
+
+// clientLabel names a client in log messages
+func clientLabel(clientID, username string) string {
+	if username == "" {
+		return clientID
+	}
+	return clientID + " (" + username + ")"
+}
+
+// randomID returns a client ID for clients that connect without one
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "auto-" + hex.EncodeToString(b)
+}
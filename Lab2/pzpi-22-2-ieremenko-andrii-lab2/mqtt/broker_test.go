@@ -0,0 +1,79 @@
+package mqtt
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// startBroker serves b on a local port and returns its URL
+func startBroker(t *testing.T, b *Broker) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go b.Serve(ln)
+	t.Cleanup(func() { b.Close() })
+	return "tcp://" + ln.Addr().String()
+}
+
+// dial connects to the broker, failing the test if it cannot
+func dial(t *testing.T, url string, opts Options) *Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, url, opts)
+	if err != nil {
+		t.Fatalf("%s: %v", opts.ClientID, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestBrokerAuthorization(t *testing.T) {
+	b := NewBroker()
+	b.Authenticate = func(clientID, username, password string) bool {
+		return password == "secret"
+	}
+	b.AuthorizePublish = func(username, topic string, payload []byte) bool {
+		return username == "reader" || topic == "meters/"+username
+	}
+	b.AuthorizeSubscribe = func(username, filter string) bool {
+		return username == "reader"
+	}
+	url := startBroker(t, b)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if c, err := Dial(ctx, url, Options{ClientID: "guest", Username: "guest", Password: "guess"}); err == nil {
+		c.Close()
+		t.Error("wrong password: connected, want refused")
+	}
+
+	reader := dial(t, url, Options{ClientID: "reader", Username: "reader", Password: "secret"})
+	if err := reader.Subscribe(ctx, Subscription{Filter: "meters/#", QoS: 1}); err != nil {
+		t.Fatal(err)
+	}
+	meter := dial(t, url, Options{ClientID: "meter", Username: "a", Password: "secret"})
+	if err := meter.Subscribe(ctx, Subscription{Filter: "#"}); err == nil {
+		t.Error("subscribe: got no error, want refused")
+	}
+
+	// The refused message is acknowledged, and never delivered
+	if err := meter.Publish(ctx, "meters/b", []byte("1"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := meter.Publish(ctx, "meters/a", []byte("2"), 1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-reader.Messages():
+		if m.Topic != "meters/a" || string(m.Payload) != "2" {
+			t.Errorf("got %s %q, want meters/a 2", m.Topic, m.Payload)
+		}
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for the message")
+	}
+}
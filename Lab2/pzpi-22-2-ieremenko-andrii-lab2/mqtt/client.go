@@ -0,0 +1,401 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned for calls on a client whose connection is gone
+var ErrClosed = errors.New("mqtt: connection closed")
+
+// Options configures a client connection
+type Options struct {
+	ClientID string
+	Username string
+	Password string
+
+	// CleanSession discards any earlier session of the client ID. Without
+	// it the broker keeps the subscriptions, and the QoS 1 messages that
+	// were not acknowledged, while the client is away.
+	CleanSession bool
+
+	// KeepAlive is how often the connection is checked; 30 seconds when zero
+	KeepAlive time.Duration
+
+	// TLSConfig is used for tls:// and mqtts:// brokers
+	TLSConfig *tls.Config
+}
+
+// Subscription is a topic filter and the highest QoS to receive it with
+type Subscription struct {
+	Filter string
+	QoS    byte
+}
+
+// Client is a connection to a broker
+type Client struct {
+	conn      net.Conn
+	keepAlive time.Duration
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan packet // replies awaited by packet identifier
+
+	messages chan Message
+	lastRead atomic.Int64 // unix nanoseconds
+	done     chan struct{}
+	err      error // why done was closed
+	once     sync.Once
+}
+
+// Dial connects to the broker at a URL such as tcp://localhost:1883 or
+// tls://broker.example.com:8883
+func Dial(ctx context.Context, broker string, opts Options) (*Client, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: invalid broker URL: %w", err)
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", hostPort(u, "1883"))
+	case "tls", "ssl", "mqtts":
+		d := tls.Dialer{Config: opts.TLSConfig}
+		conn, err = d.DialContext(ctx, "tcp", hostPort(u, "8883"))
+	default:
+		return nil, fmt.Errorf("mqtt: unsupported broker scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := connect(ctx, conn, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// hostPort returns the address of the broker, adding the default port
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// connect sends CONNECT over conn and starts the client once the broker
+// accepts it
+func connect(ctx context.Context, conn net.Conn, opts Options) (*Client, error) {
+	keepAlive := opts.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = 30 * time.Second
+	}
+
+	flags := byte(0)
+	if opts.CleanSession {
+		flags |= connectCleanSession
+	}
+	if opts.Username != "" {
+		flags |= connectUsername
+	}
+	if opts.Password != "" {
+		flags |= connectPassword
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags)
+	body = appendUint16(body, uint16(keepAlive/time.Second))
+	body = appendString(body, opts.ClientID)
+	if opts.Username != "" {
+		body = appendString(body, opts.Username)
+	}
+	if opts.Password != "" {
+		body = appendString(body, opts.Password)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(packet{typ: typeConnect, body: body}.encode()); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	ack, err := readPacket(r)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: no CONNACK: %w", err)
+	}
+	if ack.typ != typeConnack || len(ack.body) != 2 {
+		return nil, errMalformed
+	}
+	if code := ack.body[1]; code != 0 {
+		return nil, fmt.Errorf("mqtt: connection refused: %s", connackReason(code))
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &Client{
+		conn:      conn,
+		keepAlive: keepAlive,
+		pending:   make(map[uint16]chan packet),
+		messages:  make(chan Message),
+		done:      make(chan struct{}),
+	}
+	c.lastRead.Store(time.Now().UnixNano())
+	go c.readLoop(r)
+	go c.pingLoop()
+	return c, nil
+}
+
+// connackReason describes a CONNACK return code
+func connackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	default:
+		return fmt.Sprintf("return code %d", code)
+	}
+}
+
+// Messages returns the messages received on the subscriptions. It is
+// closed when the connection ends. QoS 1 messages must be acknowledged with
+// Ack once they are handled.
+func (c *Client) Messages() <-chan Message {
+	return c.messages
+}
+
+// Done is closed when the connection ends
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection ended
+func (c *Client) Err() error {
+	<-c.done
+	return c.err
+}
+
+// Subscribe subscribes to the topic filters and waits for the broker to
+// grant them
+func (c *Client) Subscribe(ctx context.Context, subs ...Subscription) error {
+	id, reply := c.await()
+	body := appendUint16(nil, id)
+	for _, sub := range subs {
+		if !ValidFilter(sub.Filter) {
+			c.cancel(id)
+			return fmt.Errorf("mqtt: invalid topic filter %q", sub.Filter)
+		}
+		body = appendString(body, sub.Filter)
+		body = append(body, sub.QoS)
+	}
+
+	ack, err := c.roundTrip(ctx, packet{typ: typeSubscribe, flags: 0x02, body: body}, id, reply)
+	if err != nil {
+		return err
+	}
+	codes := ack.body[2:]
+	if len(codes) != len(subs) {
+		return errMalformed
+	}
+	for i, code := range codes {
+		if code == subackFailure {
+			return fmt.Errorf("mqtt: subscription to %q refused", subs[i].Filter)
+		}
+	}
+	return nil
+}
+
+// Publish sends a message. With QoS 1 it waits for the broker to
+// acknowledge it.
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte, qos byte) error {
+	if !ValidTopic(topic) {
+		return fmt.Errorf("mqtt: invalid topic %q", topic)
+	}
+	if qos > 1 {
+		return fmt.Errorf("mqtt: QoS %d is not supported", qos)
+	}
+	if qos == 0 {
+		return c.write(publishPacket(topic, payload, 0, 0, false))
+	}
+
+	id, reply := c.await()
+	_, err := c.roundTrip(ctx, publishPacket(topic, payload, 1, id, false), id, reply)
+	return err
+}
+
+// Ack acknowledges a QoS 1 message. A message that is not acknowledged is
+// delivered again when the client reconnects without a clean session.
+func (c *Client) Ack(m Message) error {
+	if m.QoS == 0 {
+		return nil
+	}
+	return c.write(idPacket(typePuback, m.PacketID))
+}
+
+// Close disconnects from the broker
+func (c *Client) Close() error {
+	c.write(packet{typ: typeDisconnect})
+	c.shutdown(ErrClosed)
+	return nil
+}
+
+// await reserves a packet identifier for a request and returns it with the
+// channel its reply is delivered on
+func (c *Client) await() (uint16, chan packet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		if _, taken := c.pending[c.nextID]; !taken {
+			break
+		}
+	}
+	reply := make(chan packet, 1)
+	c.pending[c.nextID] = reply
+	return c.nextID, reply
+}
+
+// cancel releases a packet identifier reserved by await
+func (c *Client) cancel(id uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+// roundTrip sends p and waits for the reply with the same identifier
+func (c *Client) roundTrip(ctx context.Context, p packet, id uint16, reply chan packet) (packet, error) {
+	defer c.cancel(id)
+
+	if err := c.write(p); err != nil {
+		return packet{}, err
+	}
+	select {
+	case ack := <-reply:
+		return ack, nil
+	case <-ctx.Done():
+		return packet{}, ctx.Err()
+	case <-c.done:
+		return packet{}, c.err
+	}
+}
+
+// write sends a packet; writes from several goroutines do not interleave
+func (c *Client) write(p packet) error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.keepAlive))
+	if _, err := c.conn.Write(p.encode()); err != nil {
+		c.shutdown(err)
+		return err
+	}
+	return nil
+}
+
+// shutdown closes the connection, recording why
+func (c *Client) shutdown(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// readLoop dispatches the packets the broker sends until the connection ends
+func (c *Client) readLoop(r *bufio.Reader) {
+	defer close(c.messages)
+
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+		c.lastRead.Store(time.Now().UnixNano())
+
+		switch p.typ {
+		case typePublish:
+			m, err := parsePublish(p)
+			if err != nil {
+				c.shutdown(err)
+				return
+			}
+			select {
+			case c.messages <- m:
+			case <-c.done:
+				return
+			}
+
+		case typePuback, typeSuback, typeUnsuback:
+			if len(p.body) < 2 {
+				c.shutdown(errMalformed)
+				return
+			}
+			id := uint16(p.body[0])<<8 | uint16(p.body[1])
+			c.mu.Lock()
+			reply, ok := c.pending[id]
+			c.mu.Unlock()
+			if ok {
+				reply <- p
+			}
+
+		case typePingresp:
+
+		default:
+			c.shutdown(fmt.Errorf("mqtt: unexpected packet type %d", p.typ))
+			return
+		}
+	}
+}
+
+// pingLoop keeps the connection alive, and ends it when the broker stops
+// answering
+func (c *Client) pingLoop() {
+	ticker := time.NewTicker(c.keepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		if time.Since(time.Unix(0, c.lastRead.Load())) > c.keepAlive*3/2 {
+			c.shutdown(errors.New("mqtt: broker stopped responding"))
+			return
+		}
+		c.write(packet{typ: typePingreq})
+	}
+}
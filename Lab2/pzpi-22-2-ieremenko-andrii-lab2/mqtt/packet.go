@@ -0,0 +1,282 @@
+// Package mqtt speaks the parts of MQTT 3.1.1 that meter ingestion needs:
+// a client that subscribes and publishes with QoS 0 and 1, and a small
+// in-process broker for installations without one and for tests. QoS 2,
+// retained messages and wills are not supported.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Packet types
+const (
+	typeConnect     = 1
+	typeConnack     = 2
+	typePublish     = 3
+	typePuback      = 4
+	typeSubscribe   = 8
+	typeSuback      = 9
+	typeUnsubscribe = 10
+	typeUnsuback    = 11
+	typePingreq     = 12
+	typePingresp    = 13
+	typeDisconnect  = 14
+)
+
+// PUBLISH flags
+const (
+	flagDuplicate = 0x08
+	flagRetain    = 0x01
+)
+
+// CONNECT flags
+const (
+	connectUsername     = 0x80
+	connectPassword     = 0x40
+	connectWill         = 0x04
+	connectCleanSession = 0x02
+)
+
+// maxPacketSize bounds the packets read, well above any meter message
+const maxPacketSize = 1 << 20
+
+// subackFailure is the SUBACK return code of a refused subscription
+const subackFailure = 0x80
+
+var errMalformed = errors.New("mqtt: malformed packet")
+
+// packet is a control packet: its type, the flags of the fixed header and
+// everything after the remaining length
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads one control packet
+func readPacket(r *bufio.Reader) (packet, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, errMalformed
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	if length > maxPacketSize {
+		return packet{}, fmt.Errorf("mqtt: packet of %d bytes is too large", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{typ: first >> 4, flags: first & 0x0f, body: body}, nil
+}
+
+// encode returns the packet as written on the wire
+func (p packet) encode() []byte {
+	b := make([]byte, 0, len(p.body)+5)
+	b = append(b, p.typ<<4|p.flags)
+	length := len(p.body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if length == 0 {
+			break
+		}
+	}
+	return append(b, p.body...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return binary.BigEndian.AppendUint16(b, v)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// decoder reads the fields of a packet body, remembering the first error
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.b) < 1 {
+		d.err = errMalformed
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uint16() uint16 {
+	if d.err != nil || len(d.b) < 2 {
+		d.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := int(d.uint16())
+	if d.err != nil || len(d.b) < n {
+		d.err = errMalformed
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// rest returns the unread part of the body
+func (d *decoder) rest() []byte {
+	v := d.b
+	d.b = nil
+	return v
+}
+
+// Message is an application message received on a subscription
+type Message struct {
+	Topic     string
+	Payload   []byte
+	QoS       byte
+	Duplicate bool // the broker may have delivered the message before
+	PacketID  uint16
+}
+
+// publishPacket builds a PUBLISH packet
+func publishPacket(topic string, payload []byte, qos byte, id uint16, duplicate bool) packet {
+	body := appendString(nil, topic)
+	if qos > 0 {
+		body = appendUint16(body, id)
+	}
+	body = append(body, payload...)
+
+	flags := qos << 1
+	if duplicate {
+		flags |= flagDuplicate
+	}
+	return packet{typ: typePublish, flags: flags, body: body}
+}
+
+// parsePublish decodes a PUBLISH packet
+func parsePublish(p packet) (Message, error) {
+	d := decoder{b: p.body}
+	m := Message{
+		Topic:     d.string(),
+		QoS:       (p.flags >> 1) & 0x03,
+		Duplicate: p.flags&flagDuplicate != 0,
+	}
+	if m.QoS > 0 {
+		m.PacketID = d.uint16()
+	}
+	m.Payload = d.rest()
+	if d.err != nil {
+		return Message{}, d.err
+	}
+	if m.QoS > 1 {
+		return Message{}, fmt.Errorf("mqtt: QoS %d is not supported", m.QoS)
+	}
+	if !ValidTopic(m.Topic) {
+		return Message{}, fmt.Errorf("mqtt: invalid topic %q", m.Topic)
+	}
+	return m, nil
+}
+
+// idPacket builds a packet carrying only a packet identifier, such as PUBACK
+func idPacket(typ byte, id uint16) packet {
+	return packet{typ: typ, body: appendUint16(nil, id)}
+}
+
+// ValidTopic reports whether topic may be published to: it is not empty and
+// has no wildcards
+func ValidTopic(topic string) bool {
+	return topic != "" && !strings.ContainsAny(topic, "+#\x00")
+}
+
+// ValidFilter reports whether filter may be subscribed to. + stands for a
+// single level and # for any number of levels at the end.
+func ValidFilter(filter string) bool {
+	if filter == "" || strings.ContainsRune(filter, 0) {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether topic matches filter. Wildcards at the first level
+// do not match topics starting with $, which brokers reserve.
+func Match(filter, topic string) bool {
+	_, ok := Wildcards(filter, topic)
+	return ok
+}
+
+// Wildcards matches topic against filter and returns the topic levels
+// matched by each + in the filter
+func Wildcards(filter, topic string) ([]string, bool) {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return nil, false
+	}
+
+	var matched []string
+	f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return matched, true
+		}
+		if i >= len(t) {
+			return nil, false
+		}
+		switch level {
+		case "+":
+			matched = append(matched, t[i])
+		case t[i]:
+		default:
+			return nil, false
+		}
+	}
+	if len(f) != len(t) {
+		return nil, false
+	}
+	return matched, true
+}
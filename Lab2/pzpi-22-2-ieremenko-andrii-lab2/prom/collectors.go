@@ -0,0 +1,146 @@
+package prom
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets for request durations in seconds
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Counter is a counter with a series per combination of label values. It is
+// safe for concurrent use.
+type Counter struct {
+	name, help string
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+// NewCounter creates a counter whose series have the labels labelNames
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{name: name, help: help, labelNames: labelNames, series: make(map[string]*counterSeries)}
+}
+
+// Add adds v to the series with the label values, given in the order of
+// the label names
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := seriesKey(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{labels: append([]string(nil), labelValues...)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+// Write writes the counter's family
+func (c *Counter) Write(w *Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.Family(c.name, "counter", c.help)
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		w.Sample(c.name, labels(c.labelNames, s.labels), s.value)
+	}
+}
+
+// Histogram is a histogram with a series per combination of label values.
+// It is safe for concurrent use.
+type Histogram struct {
+	name, help string
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with the upper bounds buckets, in
+// increasing order, whose series have the labels labelNames
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return &Histogram{name: name, help: help, labelNames: labelNames, buckets: buckets, series: make(map[string]*histogramSeries)}
+}
+
+// Observe adds an observation to the series with the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := seriesKey(labelValues)
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// Write writes the histogram's family
+func (h *Histogram) Write(w *Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w.Family(h.name, "histogram", h.help)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		base := labels(h.labelNames, s.labels)
+
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			w.Sample(h.name+"_bucket", append(base[:len(base):len(base)], Label{"le", formatValue(le)}), float64(cumulative))
+		}
+		w.Sample(h.name+"_sum", base, s.sum)
+		w.Sample(h.name+"_count", base, float64(s.count))
+	}
+}
+
+// seriesKey joins label values into a map key
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func labels(names, values []string) []Label {
+	ls := make([]Label, len(names))
+	for i, name := range names {
+		if i < len(values) {
+			ls[i] = Label{name, values[i]}
+		} else {
+			ls[i] = Label{Name: name}
+		}
+	}
+	return ls
+}
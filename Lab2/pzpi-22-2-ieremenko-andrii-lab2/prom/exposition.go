@@ -0,0 +1,87 @@
+// Package prom speaks the parts of the Prometheus protocols the server
+// needs: writing metrics in the text exposition format, with counters and
+// histograms to collect them, and decoding the snappy compressed protobuf
+// requests of remote write.
+package prom
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Label is a label of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Writer writes metrics in the text exposition format. Each family is
+// started with Family before its samples are written.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter creates a writer writing to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Family starts a metric family; typ is counter, gauge or histogram
+func (w *Writer) Family(name, typ, help string) {
+	w.w.WriteString("# HELP " + name + " " + escapeHelp(help) + "\n")
+	w.w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample writes a sample of the current family
+func (w *Writer) Sample(name string, labels []Label, value float64) {
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.w.WriteByte(',')
+			}
+			w.w.WriteString(l.Name + `="` + escapeLabel(l.Value) + `"`)
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteByte(' ')
+	w.w.WriteString(formatValue(value))
+	w.w.WriteByte('\n')
+}
+
+// Flush writes out what is buffered
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,312 @@
+package prom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	errCorrupt        = errors.New("prom: corrupt snappy data")
+	errTruncatedProto = errors.New("prom: truncated protobuf message")
+)
+
+// TimeSeries is a series of a remote write request with its samples
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Label returns the value of the label name, or "" if the series has none
+func (t TimeSeries) Label(name string) string {
+	for _, l := range t.Labels {
+		if l.Name == name {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// Sample is a value of a series
+type Sample struct {
+	Value     float64
+	Timestamp int64 // milliseconds since the Unix epoch
+}
+
+// IsStale reports whether v is the marker Prometheus sends when a series
+// disappears, rather than a value
+func IsStale(v float64) bool {
+	return math.Float64bits(v) == 0x7FF0000000000002
+}
+
+// DecodeWriteRequest decodes the body of a remote write request (protocol
+// version 1): a snappy compressed WriteRequest message. Exemplars,
+// histograms and metadata are skipped. maxSize bounds the size of the
+// decompressed message.
+func DecodeWriteRequest(body []byte, maxSize int) ([]TimeSeries, error) {
+	message, err := snappyDecode(body, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var series []TimeSeries
+	p := protoReader{message}
+	for len(p.b) > 0 {
+		field, wire, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if field != 1 || wire != 2 {
+			if err := p.skip(wire); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		b, err := p.bytes()
+		if err != nil {
+			return nil, err
+		}
+		ts, err := decodeTimeSeries(b)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, ts)
+	}
+	return series, nil
+}
+
+// decodeTimeSeries decodes a TimeSeries message: labels are field 1 and
+// samples field 2
+func decodeTimeSeries(b []byte) (TimeSeries, error) {
+	var ts TimeSeries
+	p := protoReader{b}
+	for len(p.b) > 0 {
+		field, wire, err := p.key()
+		if err != nil {
+			return ts, err
+		}
+		if (field != 1 && field != 2) || wire != 2 {
+			if err := p.skip(wire); err != nil {
+				return ts, err
+			}
+			continue
+		}
+		b, err := p.bytes()
+		if err != nil {
+			return ts, err
+		}
+		if field == 1 {
+			l, err := decodeLabel(b)
+			if err != nil {
+				return ts, err
+			}
+			ts.Labels = append(ts.Labels, l)
+		} else {
+			s, err := decodeSample(b)
+			if err != nil {
+				return ts, err
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+	}
+	return ts, nil
+}
+
+// decodeLabel decodes a Label message: the name is field 1 and the value
+// field 2
+func decodeLabel(b []byte) (Label, error) {
+	var l Label
+	p := protoReader{b}
+	for len(p.b) > 0 {
+		field, wire, err := p.key()
+		if err != nil {
+			return l, err
+		}
+		if (field != 1 && field != 2) || wire != 2 {
+			if err := p.skip(wire); err != nil {
+				return l, err
+			}
+			continue
+		}
+		s, err := p.bytes()
+		if err != nil {
+			return l, err
+		}
+		if field == 1 {
+			l.Name = string(s)
+		} else {
+			l.Value = string(s)
+		}
+	}
+	return l, nil
+}
+
+// decodeSample decodes a Sample message: the value is field 1, a double,
+// and the timestamp field 2
+func decodeSample(b []byte) (Sample, error) {
+	var s Sample
+	p := protoReader{b}
+	for len(p.b) > 0 {
+		field, wire, err := p.key()
+		if err != nil {
+			return s, err
+		}
+		switch {
+		case field == 1 && wire == 1:
+			if len(p.b) < 8 {
+				return s, errTruncatedProto
+			}
+			s.Value = math.Float64frombits(binary.LittleEndian.Uint64(p.b))
+			p.b = p.b[8:]
+		case field == 2 && wire == 0:
+			v, err := p.varint()
+			if err != nil {
+				return s, err
+			}
+			s.Timestamp = int64(v)
+		default:
+			if err := p.skip(wire); err != nil {
+				return s, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// protoReader reads the fields of a protobuf message
+type protoReader struct {
+	b []byte
+}
+
+// key reads the key of the next field
+func (p *protoReader) key() (field, wire int, err error) {
+	v, err := p.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(v >> 3), int(v & 0x07), nil
+}
+
+func (p *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(p.b)
+	if n <= 0 {
+		return 0, errTruncatedProto
+	}
+	p.b = p.b[n:]
+	return v, nil
+}
+
+// bytes reads a length delimited field
+func (p *protoReader) bytes() ([]byte, error) {
+	n, err := p.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(p.b)) {
+		return nil, errTruncatedProto
+	}
+	b := p.b[:n]
+	p.b = p.b[n:]
+	return b, nil
+}
+
+// skip skips the value of a field of an unneeded kind
+func (p *protoReader) skip(wire int) error {
+	var size int
+	switch wire {
+	case 0:
+		_, err := p.varint()
+		return err
+	case 1:
+		size = 8
+	case 2:
+		_, err := p.bytes()
+		return err
+	case 5:
+		size = 4
+	default:
+		return fmt.Errorf("prom: unsupported protobuf wire type %d", wire)
+	}
+	if len(p.b) < size {
+		return errTruncatedProto
+	}
+	p.b = p.b[size:]
+	return nil
+}
+
+// snappyDecode decompresses data in the snappy block format: the
+// decompressed length as a varint, then literals and copies of earlier
+// output
+func snappyDecode(src []byte, maxSize int) ([]byte, error) {
+	n, k := binary.Uvarint(src)
+	if k <= 0 {
+		return nil, errCorrupt
+	}
+	if n > uint64(maxSize) {
+		return nil, fmt.Errorf("prom: request of %d bytes decompressed exceeds %d", n, maxSize)
+	}
+	src = src[k:]
+	size := int(n)
+	dst := make([]byte, 0, size)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 0x03 {
+		case 0x00: // literal; lengths over 60 follow in 1 to 4 bytes
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errCorrupt
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			length++
+			if length <= 0 || len(src) < length || len(dst)+length > size {
+				return nil, errCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 0x01: // copy with a 1 byte offset
+			if len(src) < 2 {
+				return nil, errCorrupt
+			}
+			length = 4 + int(tag>>2&0x07)
+			offset = int(tag&0xE0)<<3 | int(src[1])
+			src = src[2:]
+		case 0x02: // copy with a 2 byte offset
+			if len(src) < 3 {
+				return nil, errCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 0x03: // copy with a 4 byte offset
+			if len(src) < 5 {
+				return nil, errCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || len(dst)+length > size {
+			return nil, errCorrupt
+		}
+		// Copies may overlap what they produce, repeating it
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != size {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
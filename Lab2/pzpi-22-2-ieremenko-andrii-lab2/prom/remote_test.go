@@ -0,0 +1,166 @@
+package prom
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// protoField appends a length delimited field
+func protoField(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// encodeWriteRequest encodes series as an uncompressed WriteRequest
+func encodeWriteRequest(series []TimeSeries) []byte {
+	var message []byte
+	for _, ts := range series {
+		var tsb []byte
+		for _, l := range ts.Labels {
+			var lb []byte
+			lb = protoField(lb, 1, []byte(l.Name))
+			lb = protoField(lb, 2, []byte(l.Value))
+			tsb = protoField(tsb, 1, lb)
+		}
+		for _, s := range ts.Samples {
+			sb := []byte{1<<3 | 1}
+			sb = binary.LittleEndian.AppendUint64(sb, math.Float64bits(s.Value))
+			sb = append(sb, 2<<3)
+			sb = binary.AppendUvarint(sb, uint64(s.Timestamp))
+			tsb = protoField(tsb, 2, sb)
+		}
+		message = protoField(message, 1, tsb)
+	}
+	return message
+}
+
+// snappyLiterals compresses data as snappy literals only
+func snappyLiterals(data []byte) []byte {
+	b := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := min(len(data), 1<<16)
+		b = append(b, 61<<2)
+		b = binary.LittleEndian.AppendUint16(b, uint16(n-1))
+		b = append(b, data[:n]...)
+		data = data[n:]
+	}
+	return b
+}
+
+func TestDecodeWriteRequest(t *testing.T) {
+	want := []TimeSeries{
+		{
+			Labels:  []Label{{"__name__", "meter_energy_kwh"}, {"serial", "SN-1"}},
+			Samples: []Sample{{12.5, 1704067200000}, {13, 1704067260000}},
+		},
+		{
+			Labels:  []Label{{"metric_id", "8a0e4c1e"}},
+			Samples: []Sample{{math.Float64frombits(0x7FF0000000000002), 1704067200000}},
+		},
+	}
+	message := encodeWriteRequest(want)
+	// Fields the receiver does not need, such as metadata, are skipped
+	message = protoField(message, 3, []byte{1<<3 | 0, 7})
+	message = append(message, 4<<3|5, 1, 2, 3, 4)
+
+	got, err := DecodeWriteRequest(snappyLiterals(message), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !reflect.DeepEqual(got[0], want[0]) || got[1].Label("metric_id") != "8a0e4c1e" || got[1].Label("job") != "" {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if !IsStale(got[1].Samples[0].Value) || IsStale(got[0].Samples[0].Value) {
+		t.Error("staleness markers are not told apart from values")
+	}
+
+	if _, err := DecodeWriteRequest(snappyLiterals(message), len(message)-1); err == nil {
+		t.Error("a request larger than the limit was decoded")
+	}
+	if _, err := DecodeWriteRequest(snappyLiterals(message[:len(message)-1]), 1<<20); err == nil {
+		t.Error("a truncated request was decoded")
+	}
+}
+
+func TestSnappyDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     []byte
+		want    string
+		wantErr bool
+	}{
+		{"literal", []byte{5, 4 << 2, 'h', 'e', 'l', 'l', 'o'}, "hello", false},
+		{"empty", []byte{0}, "", false},
+		// "abcd", then 8 bytes copied from 4 back, overlapping the output
+		{"1 byte offset copy", []byte{12, 3 << 2, 'a', 'b', 'c', 'd', 0x01 | 4<<2, 4}, "abcdabcdabcd", false},
+		{"2 byte offset copy", []byte{6, 2 << 2, 'x', 'y', 'z', 0x02 | 2<<2, 3, 0}, "xyzxyz", false},
+		{"4 byte offset copy", []byte{4, 1 << 2, 'a', 'b', 0x03 | 1<<2, 2, 0, 0, 0}, "abab", false},
+		{"long literal", append([]byte{61, 60 << 2, 60}, make([]byte, 61)...), string(make([]byte, 61)), false},
+
+		{"no length", nil, "", true},
+		{"short output", []byte{6, 4 << 2, 'h', 'e', 'l', 'l', 'o'}, "", true},
+		{"long output", []byte{4, 4 << 2, 'h', 'e', 'l', 'l', 'o'}, "", true},
+		{"truncated literal", []byte{5, 4 << 2, 'h', 'e'}, "", true},
+		{"truncated literal length", []byte{100, 61 << 2, 1}, "", true},
+		{"copy before output", []byte{4, 0x01, 1}, "", true},
+		{"zero offset", []byte{8, 3 << 2, 'a', 'b', 'c', 'd', 0x01, 0}, "", true},
+		{"offset past output", []byte{8, 3 << 2, 'a', 'b', 'c', 'd', 0x01, 5}, "", true},
+		{"truncated copy", []byte{8, 3 << 2, 'a', 'b', 'c', 'd', 0x02, 4}, "", true},
+		{"huge literal", []byte{8, 63 << 2, 0xff, 0xff, 0xff, 0xff}, "", true},
+		{"huge offset", []byte{8, 3 << 2, 'a', 'b', 'c', 'd', 0x03, 0xff, 0xff, 0xff, 0xff}, "", true},
+		{"over the limit", []byte{0x80, 0x80, 0x80, 0x80, 0x10}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := snappyDecode(tt.src, 1<<20)
+			if (err != nil) != tt.wantErr || string(got) != tt.want {
+				t.Errorf("got %q, %v; want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeProto(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"truncated key", []byte{0x80}},
+		{"truncated length", []byte{1<<3 | 2, 0x80}},
+		{"length past the end", []byte{1<<3 | 2, 5, 1}},
+		{"truncated fixed64", []byte{7<<3 | 1, 1, 2}},
+		{"truncated fixed32", []byte{7<<3 | 5, 1}},
+		{"group wire type", []byte{7<<3 | 3}},
+		{"truncated series", protoField(nil, 1, []byte{1<<3 | 2, 9})},
+		{"truncated label", protoField(nil, 1, protoField(nil, 1, []byte{2<<3 | 2, 3, 'a'}))},
+		{"truncated sample value", protoField(nil, 1, protoField(nil, 2, []byte{1<<3 | 1, 0, 0}))},
+		{"truncated sample timestamp", protoField(nil, 1, protoField(nil, 2, []byte{2 << 3, 0x80}))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeWriteRequest(snappyLiterals(tt.b), 1<<20); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestDecodeWriteRequestGarbage(t *testing.T) {
+	// Bodies come from the network, so no input may panic
+	rng := rand.New(rand.NewSource(1))
+	message := encodeWriteRequest([]TimeSeries{{Labels: []Label{{"a", "b"}}, Samples: []Sample{{1, 2}}}})
+	for range 10000 {
+		b := make([]byte, rng.Intn(64))
+		rng.Read(b)
+		DecodeWriteRequest(b, 1<<10)
+
+		// Corrupt a valid request in one place
+		corrupt := snappyLiterals(message)
+		corrupt[rng.Intn(len(corrupt))] = byte(rng.Intn(256))
+		DecodeWriteRequest(corrupt, 1<<10)
+	}
+}
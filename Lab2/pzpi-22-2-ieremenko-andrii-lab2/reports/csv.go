@@ -0,0 +1,30 @@
+package reports
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// renderCSV writes the header and rows of the report as comma separated values
+func renderCSV(w io.Writer, r *Report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(r.Columns); err != nil {
+		return err
+	}
+
+	record := make([]string, len(r.Columns))
+	for _, row := range r.Rows {
+		for i := range record {
+			record[i] = ""
+			if i < len(row) {
+				record[i] = formatCell(row[i])
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
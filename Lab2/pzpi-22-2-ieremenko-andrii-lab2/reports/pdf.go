@@ -0,0 +1,192 @@
+package reports
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Page geometry in PDF points (A4 portrait)
+const (
+	pdfPageWidth   = 595.0
+	pdfPageHeight  = 842.0
+	pdfMargin      = 40.0
+	pdfFontSize    = 9.0
+	pdfLineHeight  = 13.0
+	pdfChartHeight = 200.0
+	pdfMaxBars     = 24
+)
+
+// renderPDF writes the report as a PDF document. The chart, if any, is drawn
+// with vector operators on the first page and the table follows, continuing
+// on as many pages as needed.
+//
+// Text is set in the standard Helvetica fonts, which every reader has, so
+// that no font needs to be embedded. They only cover Latin-1: other
+// characters, Cyrillic included, come out as '?', and reports with such
+// text are better rendered as CSV or XLSX.
+func renderPDF(w io.Writer, r *Report) error {
+	var pages []*bytes.Buffer
+	page := &bytes.Buffer{}
+	pages = append(pages, page)
+
+	y := pdfPageHeight - pdfMargin
+	pdfText(page, "F2", 14, pdfMargin, y-14, r.Title)
+	y -= 22
+	if r.Subtitle != "" {
+		pdfText(page, "F1", 10, pdfMargin, y-10, r.Subtitle)
+		y -= 18
+	}
+
+	if r.Chart != nil && len(r.Chart.Values) > 0 {
+		y -= 10
+		pdfChart(page, r.Chart, y)
+		y -= pdfChartHeight + 30
+	}
+
+	colWidth := (pdfPageWidth - 2*pdfMargin) / float64(max(len(r.Columns), 1))
+	header := make([]interface{}, len(r.Columns))
+	for i, c := range r.Columns {
+		header[i] = c
+	}
+
+	y -= pdfLineHeight
+	pdfRow(page, "F2", y, colWidth, header)
+	for _, row := range r.Rows {
+		y -= pdfLineHeight
+		if y < pdfMargin {
+			page = &bytes.Buffer{}
+			pages = append(pages, page)
+			y = pdfPageHeight - pdfMargin - pdfLineHeight
+			pdfRow(page, "F2", y, colWidth, header)
+			y -= pdfLineHeight
+		}
+		pdfRow(page, "F1", y, colWidth, row)
+	}
+
+	return writePDF(w, pages)
+}
+
+// pdfRow writes one table row, truncating cells to the column width
+func pdfRow(b *bytes.Buffer, font string, y, colWidth float64, cells []interface{}) {
+	maxChars := int(colWidth / (pdfFontSize * 0.55))
+	for i, cell := range cells {
+		text := formatCell(cell)
+		if len(text) > maxChars {
+			text = text[:max(maxChars-1, 0)] + "~"
+		}
+		pdfText(b, font, pdfFontSize, pdfMargin+float64(i)*colWidth, y, text)
+	}
+}
+
+// pdfChart draws a bar chart whose top edge is at y
+func pdfChart(b *bytes.Buffer, c *Chart, top float64) {
+	pdfText(b, "F2", 11, pdfMargin, top-11, c.Title)
+
+	values := c.Values
+	if len(values) > pdfMaxBars {
+		values = values[:pdfMaxBars]
+	}
+
+	maxValue := 0.0
+	for _, v := range values {
+		maxValue = max(maxValue, v)
+	}
+	if maxValue <= 0 {
+		maxValue = 1
+	}
+
+	width := pdfPageWidth - 2*pdfMargin
+	bottom := top - pdfChartHeight
+	plotHeight := pdfChartHeight - 40
+	slot := width / float64(len(values))
+
+	// Axes
+	fmt.Fprintf(b, "0 0 0 RG 0.5 w %.2f %.2f m %.2f %.2f l S\n", pdfMargin, bottom, pdfMargin+width, bottom)
+	fmt.Fprintf(b, "%.2f %.2f m %.2f %.2f l S\n", pdfMargin, bottom, pdfMargin, bottom+plotHeight)
+
+	for i, v := range values {
+		h := plotHeight * max(v, 0) / maxValue
+		x := pdfMargin + float64(i)*slot + slot*0.15
+		fmt.Fprintf(b, "0.25 0.45 0.75 rg %.2f %.2f %.2f %.2f re f\n", x, bottom, slot*0.7, h)
+		fmt.Fprint(b, "0 0 0 rg\n")
+		pdfText(b, "F1", 7, x, bottom+h+3, strconv.FormatFloat(v, 'f', 2, 64))
+
+		label := ""
+		if i < len(c.Labels) {
+			label = c.Labels[i]
+		}
+		if maxChars := int(slot / 3.6); len(label) > maxChars {
+			label = label[:max(maxChars, 0)]
+		}
+		pdfText(b, "F1", 7, x, bottom-10, label)
+	}
+}
+
+// pdfText appends a text drawing operation
+func pdfText(b *bytes.Buffer, font string, size, x, y float64, text string) {
+	fmt.Fprintf(b, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(text))
+}
+
+// pdfEscape escapes a string for use in a PDF literal string. The standard
+// fonts use a Latin-1 compatible encoding, so other characters are replaced
+// with '?'.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 255:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
+
+// writePDF assembles the document objects and the cross-reference table
+func writePDF(w io.Writer, pages []*bytes.Buffer) error {
+	// Objects 1-4 are the catalog, page tree and fonts; every page then
+	// takes two objects, the page itself and its content stream
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+
+	kids := make([]string, 0, len(pages))
+	for i, content := range pages {
+		pageID := 5 + 2*i
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageID))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, pageID+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
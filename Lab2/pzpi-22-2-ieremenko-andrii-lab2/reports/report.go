@@ -0,0 +1,85 @@
+// Package reports renders tabular consumption reports as CSV, XLSX and PDF
+// using only the standard library
+package reports
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Supported output formats
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+	FormatPDF  = "pdf"
+)
+
+// ErrUnknownFormat is returned when a report is rendered in an unsupported format
+var ErrUnknownFormat = errors.New("unknown report format")
+
+// Report is a titled table with an optional chart
+type Report struct {
+	Title    string
+	Subtitle string
+	Columns  []string
+	// Rows hold string, float64, int or time.Time cells
+	Rows  [][]interface{}
+	Chart *Chart
+}
+
+// Chart is a bar chart embedded in formats that support graphics
+type Chart struct {
+	Title  string
+	Labels []string
+	Values []float64
+}
+
+// ContentType returns the MIME type of the format
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case FormatPDF:
+		return "application/pdf"
+	}
+	return "application/octet-stream"
+}
+
+// ValidFormat reports whether the format can be rendered
+func ValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatXLSX || format == FormatPDF
+}
+
+// Render writes the report to w in the requested format
+func Render(w io.Writer, format string, r *Report) error {
+	switch format {
+	case FormatCSV:
+		return renderCSV(w, r)
+	case FormatXLSX:
+		return renderXLSX(w, r)
+	case FormatPDF:
+		return renderPDF(w, r)
+	}
+	return ErrUnknownFormat
+}
+
+// formatCell converts a cell value to its textual representation
+func formatCell(v interface{}) string {
+	switch c := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return c
+	case float64:
+		return strconv.FormatFloat(c, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(c)
+	case time.Time:
+		return c.Format(time.RFC3339)
+	}
+	return ""
+}
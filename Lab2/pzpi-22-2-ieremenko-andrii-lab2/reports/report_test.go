@@ -0,0 +1,51 @@
+package reports
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	report := &Report{
+		Title:   "Statements",
+		Columns: []string{"Apartment", "Readings", "Total", "Since"},
+		Rows: [][]interface{}{
+			{"Flat (1)", 2, 3.5, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{"Квартира 2", 1, 0.25, time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		},
+		Chart: &Chart{Labels: []string{"Flat (1)", "Квартира 2"}, Values: []float64{3.5, 0.25}},
+	}
+
+	for _, format := range []string{FormatCSV, FormatXLSX, FormatPDF} {
+		var buf bytes.Buffer
+		if err := Render(&buf, format, report); err != nil || buf.Len() == 0 {
+			t.Errorf("%s: got %d bytes, %v", format, buf.Len(), err)
+		}
+	}
+
+	var buf bytes.Buffer
+	Render(&buf, FormatCSV, report)
+	want := "Apartment,Readings,Total,Since\nFlat (1),2,3.5,2024-01-01T00:00:00Z\nКвартира 2,1,0.25,2024-01-02T00:00:00Z\n"
+	if buf.String() != want {
+		t.Errorf("got CSV\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	if err := Render(&buf, "doc", report); err != ErrUnknownFormat {
+		t.Errorf("unknown format: got %v", err)
+	}
+}
+
+func TestPDFEscape(t *testing.T) {
+	// The standard fonts cover Latin-1 only
+	for in, want := range map[string]string{
+		`Flat (1) \ A`: `Flat \(1\) \\ A`,
+		"Größe m²":     "Gr\xf6\xdfe m\xb2",
+		"Квартира 2":   "???????? 2",
+		"tab\there":    "tab?here",
+	} {
+		if got := pdfEscape(in); got != want {
+			t.Errorf("pdfEscape(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/audit"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+// SetAuditLog replaces the log audited actions are recorded in
+func (s *Server) SetAuditLog(l *audit.Log) {
+	s.audit = l
+}
+
+// newAuditEntry starts an audit entry for an action taken by actor in the
+// request
+func newAuditEntry(r *http.Request, actor *models.User, action, targetType, targetID string) models.AuditEntry {
+	entry := models.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		SourceIP:   sourceIP(r),
+		RequestID:  requestID(r),
+	}
+	if actor != nil {
+		entry.ActorID = actor.ID
+		entry.Actor = actor.Username
+	}
+	return entry
+}
+
+// appendAudit records the entry with the state of the target before and
+// after the action; either may be nil
+func (s *Server) appendAudit(entry models.AuditEntry, before, after interface{}) {
+	if before != nil {
+		entry.Before, _ = json.Marshal(before)
+	}
+	if after != nil {
+		entry.After, _ = json.Marshal(after)
+	}
+
+	if _, err := s.audit.Append(entry); err != nil {
+		log.Printf("Failed to record audit entry %s: %v", entry.Action, err)
+	}
+}
+
+// recordAudit records an action taken by actor in the request
+func (s *Server) recordAudit(r *http.Request, actor *models.User, action, targetType, targetID string, before, after interface{}) {
+	s.appendAudit(newAuditEntry(r, actor, action, targetType, targetID), before, after)
+}
+
+// ListAuditLog godoc
+// @Summary Query the audit log
+// @Description Get audit entries, newest first, filtered by actor, action, target and time
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param actor query string false "Actor username or ID"
+// @Param action query string false "Action, e.g. room.delete"
+// @Param target_type query string false "Target type, e.g. room"
+// @Param target_id query string false "Target ID"
+// @Param from query string false "Earliest timestamp (RFC3339)"
+// @Param to query string false "Latest timestamp (RFC3339)"
+// @Param limit query int false "Maximum number of entries, 100 by default"
+// @Param offset query int false "Number of entries to skip"
+// @Success 200 {object} models.AuditLogResponse
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Router /admin/audit [get]
+func (s *Server) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		TargetID:   query.Get("target_id"),
+		Limit:      100,
+	}
+
+	var err error
+	if v := query.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_time", "Invalid from time")
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_time", "Invalid to time")
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 {
+			writeError(w, r, http.StatusBadRequest, "invalid_query", "Invalid limit")
+			return
+		}
+	}
+	if v := query.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_query", "Invalid offset")
+			return
+		}
+	}
+
+	entries, total := s.audit.Query(filter)
+
+	writeJSON(w, models.AuditLogResponse{
+		Entries: entries,
+		Total:   total,
+	})
+}
+
+// VerifyAuditLog godoc
+// @Summary Verify the audit log
+// @Description Check the hash chain of the audit log for tampering
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.AuditVerifyResponse
+// @Failure 403 {object} models.Problem
+// @Router /admin/audit/verify [get]
+func (s *Server) VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	response := models.AuditVerifyResponse{
+		Valid:   true,
+		Entries: s.audit.Len(),
+		Message: "Audit log is intact",
+	}
+	if seq, err := s.audit.Verify(); err != nil {
+		response.Valid = false
+		response.BrokenAt = seq
+		response.Message = err.Error()
+	}
+
+	writeJSON(w, response)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/auth"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// Authenticator identifies the user a request is made by
+type Authenticator func(r *http.Request) (*models.User, error)
+
+// SetAuthenticator replaces how requests to the server are authenticated.
+// By default the JWT issued by /login is required.
+func (s *Server) SetAuthenticator(a Authenticator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authenticate = a
+}
+
+// withAuthenticator makes requests passing through it authenticate with a
+// instead of the authenticator of the server
+func withAuthenticator(a Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authenticatorKey, a)))
+	})
+}
+
+// tokenUser authenticates a request by the JWT issued at login. The session
+// of the token must not have been ended.
+func (s *Server) tokenUser(r *http.Request) (*models.User, error) {
+	claims, err := auth.ValidateToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return nil, fmt.Errorf("token has no session")
+	}
+
+	s.sessionMu.Lock()
+	session, exists := s.sessions[sessionID]
+	if exists {
+		session.LastSeenAt = time.Now()
+	}
+	s.sessionMu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("session has ended")
+	}
+
+	user, exists := s.users[session.UserID]
+	if !exists {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	return user, nil
+}
+
+// issueToken starts a session for user, recording it in the audit log, and
+// returns a token for it
+func (s *Server) issueToken(r *http.Request, user *models.User) (string, error) {
+	now := time.Now()
+	session := &models.Session{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(auth.TokenTTL),
+		LastSeenAt: now,
+		IP:         sourceIP(r),
+		UserAgent:  r.UserAgent(),
+	}
+
+	token, err := auth.GenerateToken(user, session.ID.String())
+	if err != nil {
+		return "", err
+	}
+
+	s.sessionMu.Lock()
+	// Expired sessions are dropped as new ones start
+	for id, old := range s.sessions {
+		if now.After(old.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[session.ID] = session
+	s.sessionMu.Unlock()
+
+	s.recordAudit(r, user, "session.create", "session", session.ID.String(), nil, session)
+	return token, nil
+}
+
+// sessionID returns the ID of the session the request is made in, if any
+func sessionID(r *http.Request) uuid.UUID {
+	claims, err := auth.ValidateToken(r)
+	if err != nil {
+		return uuid.Nil
+	}
+	id, _ := uuid.Parse(claims.ID)
+	return id
+}
+
+// userSessions returns the active sessions of a user, newest first
+func (s *Server) userSessions(r *http.Request, userID uuid.UUID) []models.Session {
+	current := sessionID(r)
+	now := time.Now()
+
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+
+	sessions := make([]models.Session, 0)
+	for _, session := range s.sessions {
+		if session.UserID == userID && now.Before(session.ExpiresAt) {
+			listed := *session
+			listed.Current = session.ID == current
+			sessions = append(sessions, listed)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions
+}
+
+// endSessions ends every session of a user except keep, revoking their tokens
+func (s *Server) endSessions(userID, keep uuid.UUID) int {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+
+	ended := 0
+	for id, session := range s.sessions {
+		if session.UserID == userID && id != keep {
+			delete(s.sessions, id)
+			ended++
+		}
+	}
+	return ended
+}
+
+// ServiceToken returns an Authenticator accepting a single shared bearer
+// token, for nodes that serve machines rather than people. Requests are made
+// as a service user holding the given roles.
+func ServiceToken(token string, roles ...string) Authenticator {
+	user := &models.User{Username: "service", Roles: make([]models.Role, 0, len(roles))}
+	for _, name := range roles {
+		user.Roles = append(user.Roles, models.Role{Name: name})
+	}
+
+	return func(r *http.Request) (*models.User, error) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			return nil, fmt.Errorf("invalid service token")
+		}
+		return user, nil
+	}
+}
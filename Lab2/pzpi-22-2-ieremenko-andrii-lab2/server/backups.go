@@ -0,0 +1,343 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/auth"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/backup"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// maxRestoreSize limits the size of an uploaded backup file
+const maxRestoreSize = 512 << 20
+
+// SetBackupStore replaces the store backups are written to
+func (s *Server) SetBackupStore(store *backup.Store) {
+	s.backups = store
+}
+
+// CreateBackup godoc
+// @Summary Create a backup
+// @Description Take a consistent point-in-time snapshot of users, roles, rooms, metrics and readings
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.Backup
+// @Failure 403 {object} models.Problem
+// @Router /admin/backups [post]
+func (s *Server) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
+		s.mu.RUnlock()
+		return
+	}
+	snap := s.snapshot()
+	s.mu.RUnlock()
+
+	meta, err := s.backups.Save(snap, false)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to write backup")
+		return
+	}
+	s.recordAudit(r, user, "backup.create", "backup", meta.ID.String(), nil, meta)
+
+	writeJSON(w, meta)
+}
+
+// ListBackups godoc
+// @Summary List backups
+// @Description Get all stored backups, newest first
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.BackupListResponse
+// @Failure 403 {object} models.Problem
+// @Router /admin/backups [get]
+func (s *Server) ListBackups(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	_, ok := s.requireAdmin(w, r)
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	backups, err := s.backups.List()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to list backups")
+		return
+	}
+
+	writeJSON(w, models.BackupListResponse{
+		Backups: backups,
+		Total:   len(backups),
+	})
+}
+
+// DownloadBackup godoc
+// @Summary Download a backup
+// @Description Download a stored backup file
+// @Tags admin
+// @Produce application/octet-stream
+// @Param id path string true "Backup ID"
+// @Success 200 {file} file
+// @Failure 404 {object} models.Problem
+// @Router /admin/backups/{id} [get]
+func (s *Server) DownloadBackup(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	_, ok := s.requireAdmin(w, r)
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid backup ID")
+		return
+	}
+
+	meta, data, err := s.backups.Read(id)
+	if errors.Is(err, backup.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "backup_not_found", "Backup not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read backup: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", meta.ID.String()+".hmbk"))
+	w.Header().Set("Digest", "sha-256="+meta.Checksum)
+	w.Write(data)
+}
+
+// RestoreBackup godoc
+// @Summary Restore from a backup
+// @Description Replace the whole state with a snapshot, either a stored backup given by backup_id or a backup file sent as the request body. The snapshot is validated before anything is replaced.
+// @Tags admin
+// @Accept application/octet-stream
+// @Produce json
+// @Param backup_id query string false "ID of a stored backup"
+// @Success 200 {object} models.RestoreResponse
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /admin/restore [post]
+func (s *Server) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	user, ok := s.requireAdmin(w, r)
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	var data []byte
+	if idStr := r.URL.Query().Get("backup_id"); idStr != "" {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid backup ID")
+			return
+		}
+		_, data, err = s.backups.Read(id)
+		if errors.Is(err, backup.ErrNotFound) {
+			writeError(w, r, http.StatusNotFound, "backup_not_found", "Backup not found")
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to read backup: "+err.Error())
+			return
+		}
+	} else {
+		var err error
+		data, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxRestoreSize))
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_body", "Failed to read backup file")
+			return
+		}
+	}
+
+	snap, err := s.backups.Decode(data)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_backup", "Invalid backup: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.restore(snap)
+	s.mu.Unlock()
+
+	response := models.RestoreResponse{
+		Message:  "Backup restored successfully",
+		TakenAt:  snap.CreatedAt,
+		Users:    len(snap.Users),
+		Roles:    len(snap.Roles),
+		Rooms:    len(snap.Rooms),
+		Metrics:  len(snap.Metrics),
+		Readings: len(snap.Readings),
+		Devices:  len(snap.Devices),
+	}
+	s.recordAudit(r, user, "backup.restore", "backup", r.URL.Query().Get("backup_id"), nil, response)
+
+	writeJSON(w, response)
+}
+
+// ScheduleBackups takes a backup every interval and keeps the newest retain
+// scheduled backups. It blocks until ctx is done, so it is meant to run in
+// its own goroutine.
+func (s *Server) ScheduleBackups(ctx context.Context, interval time.Duration, retain int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.RLock()
+		snap := s.snapshot()
+		s.mu.RUnlock()
+
+		if _, err := s.backups.Save(snap, true); err != nil {
+			log.Printf("Scheduled backup failed: %v", err)
+			continue
+		}
+		if err := s.backups.Prune(retain); err != nil {
+			log.Printf("Pruning backups failed: %v", err)
+		}
+	}
+}
+
+// snapshot copies the server state; the caller must hold mu
+func (s *Server) snapshot() *backup.Snapshot {
+	snap := backup.NewSnapshot(time.Now())
+
+	for _, user := range s.users {
+		u := *user
+		u.Roles = append([]models.Role{}, user.Roles...)
+		snap.Users = append(snap.Users, backup.User{User: u, PasswordHash: user.PasswordHash})
+	}
+	for _, role := range s.roles {
+		snap.Roles = append(snap.Roles, *role)
+	}
+	for _, room := range s.rooms {
+		snap.Rooms = append(snap.Rooms, *room)
+	}
+	for _, metric := range s.metrics {
+		snap.Metrics = append(snap.Metrics, *metric)
+		for _, reading := range s.readings[metric.ID] {
+			snap.Readings = append(snap.Readings, *reading)
+		}
+		for _, rollup := range s.rollups[metric.ID] {
+			snap.Rollups = append(snap.Rollups, *rollup)
+		}
+	}
+	for _, policy := range s.retention {
+		snap.Retention = append(snap.Retention, *policy)
+	}
+	for _, device := range s.devices {
+		d := backup.Device{Device: copyDevice(device), OMSKey: device.OMSKey}
+		for _, key := range device.Keys {
+			d.Keys = append(d.Keys, backup.DeviceKey{DeviceKey: key, Hash: key.Hash})
+		}
+		snap.Devices = append(snap.Devices, d)
+	}
+	if s.persistOutbox {
+		snap.Outbox = s.events.Outbox()
+	}
+
+	return snap
+}
+
+// restore replaces the server state with the snapshot and ends the sessions
+// of users it does not have; the caller must hold mu exclusively
+func (s *Server) restore(snap *backup.Snapshot) {
+	users := make(map[uuid.UUID]*models.User, len(snap.Users))
+	for _, u := range snap.Users {
+		user := u.User
+		user.PasswordHash = u.PasswordHash
+		if user.PasswordHash == "" && u.Password != "" {
+			hash, err := auth.HashPassword(u.Password)
+			if err != nil {
+				log.Printf("Failed to hash the password of %s, who cannot log in: %v", user.Username, err)
+			}
+			user.PasswordHash = hash
+		}
+		users[user.ID] = &user
+	}
+
+	roles := make(map[string]*models.Role, len(snap.Roles))
+	for i := range snap.Roles {
+		roles[snap.Roles[i].Name] = &snap.Roles[i]
+	}
+
+	rooms := make(map[uuid.UUID]*models.Room, len(snap.Rooms))
+	for i := range snap.Rooms {
+		rooms[snap.Rooms[i].ID] = &snap.Rooms[i]
+	}
+
+	metrics := make(map[uuid.UUID]*models.Metric, len(snap.Metrics))
+	readings := make(map[uuid.UUID][]*models.MetricReading, len(snap.Metrics))
+	for i := range snap.Metrics {
+		metrics[snap.Metrics[i].ID] = &snap.Metrics[i]
+		readings[snap.Metrics[i].ID] = make([]*models.MetricReading, 0)
+	}
+	for i := range snap.Readings {
+		reading := &snap.Readings[i]
+		readings[reading.MetricID] = append(readings[reading.MetricID], reading)
+	}
+
+	retention := make(map[uuid.UUID]*models.RetentionPolicy, len(snap.Retention))
+	for i := range snap.Retention {
+		retention[snap.Retention[i].ID] = &snap.Retention[i]
+	}
+
+	rollups := make(map[uuid.UUID]map[rollupKey]*models.Rollup)
+	for i := range snap.Rollups {
+		rollup := &snap.Rollups[i]
+		if rollups[rollup.MetricID] == nil {
+			rollups[rollup.MetricID] = make(map[rollupKey]*models.Rollup)
+		}
+		rollups[rollup.MetricID][rollupKey{rollup.Resolution, rollup.Start.Unix()}] = rollup
+	}
+
+	devices := make(map[uuid.UUID]*models.Device, len(snap.Devices))
+	for _, d := range snap.Devices {
+		device := d.Device
+		device.OMSKey = d.OMSKey
+		device.HasOMSKey = d.OMSKey != ""
+		device.Keys = make([]models.DeviceKey, 0, len(d.Keys))
+		for _, k := range d.Keys {
+			key := k.DeviceKey
+			key.Hash = k.Hash
+			device.Keys = append(device.Keys, key)
+		}
+		devices[device.ID] = &device
+	}
+
+	s.users = users
+	s.roles = roles
+	s.rooms = rooms
+	s.metrics = metrics
+	s.readings = readings
+	s.devices = devices
+	s.retention = retention
+	s.rollups = rollups
+
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	for id, session := range s.sessions {
+		if users[session.UserID] == nil {
+			delete(s.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/backup"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+// restoreSnapshot uploads snap to be restored and returns the response
+func (api *testAPI) restoreSnapshot(snap *backup.Snapshot) *http.Response {
+	api.t.Helper()
+	var data bytes.Buffer
+	if err := backup.Encode(&data, snap, nil); err != nil {
+		api.t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, api.url+"/admin/restore", &data)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+api.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestRestore(t *testing.T) {
+	api := newTestAPI(t)
+	api.s.mu.RLock()
+	snap := api.s.snapshot()
+	api.s.mu.RUnlock()
+	resident := api.as(api.register("resident"))
+
+	// Snapshots lacking a built-in role are refused
+	withoutRoles := *snap
+	withoutRoles.Roles = nil
+	for _, role := range snap.Roles {
+		if role.Name != "user" {
+			withoutRoles.Roles = append(withoutRoles.Roles, role)
+		}
+	}
+	withoutRoles.Users = append([]backup.User(nil), snap.Users...)
+	for i := range withoutRoles.Users {
+		withoutRoles.Users[i].Roles = nil
+	}
+	if resp := api.restoreSnapshot(&withoutRoles); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("restoring without the user role: got status %d, want 400", resp.StatusCode)
+	}
+
+	if resp := api.restoreSnapshot(snap); resp.StatusCode != http.StatusOK {
+		t.Fatalf("restore: got status %d, want 200", resp.StatusCode)
+	}
+
+	// The resident is not in the snapshot, so neither is its session
+	resident.expectProblem(http.MethodGet, "/users/me", nil, http.StatusUnauthorized, "unauthorized")
+	api.expect(http.MethodGet, "/users/me", nil, http.StatusOK, nil)
+	var auth models.AuthResponse
+	api.as("").expect(http.MethodPost, "/register", models.RegisterRequest{
+		Username: "resident",
+		Password: "secret123",
+		Email:    "resident@example.com",
+	}, http.StatusOK, &auth)
+}
+
+func TestBackupPasswords(t *testing.T) {
+	api := newTestAPI(t)
+	api.s.SetBackupStore(backup.NewStore(t.TempDir(), nil))
+
+	var meta models.Backup
+	api.expect(http.MethodPost, "/admin/backups", nil, http.StatusOK, &meta)
+	_, data, err := api.s.backups.Read(meta.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := api.s.backups.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encoded, []byte("secret123")) {
+		t.Error("the backup holds a plaintext password")
+	}
+	if len(snap.Users) != 1 || !strings.HasPrefix(snap.Users[0].PasswordHash, "$2") {
+		t.Fatalf("got users %+v, want the administrator with a bcrypt hash", snap.Users)
+	}
+
+	// Plaintext passwords of older snapshots are hashed when restored
+	snap.Users[0].PasswordHash = ""
+	snap.Users[0].Password = "legacy123"
+	if resp := api.restoreSnapshot(snap); resp.StatusCode != http.StatusOK {
+		t.Fatalf("restore: got status %d, want 200", resp.StatusCode)
+	}
+	api.s.mu.RLock()
+	hash := api.s.users[snap.Users[0].ID].PasswordHash
+	api.s.mu.RUnlock()
+	if !strings.HasPrefix(hash, "$2") {
+		t.Errorf("got password hash %q, want a bcrypt hash", hash)
+	}
+	api.as("").expect(http.MethodPost, "/login", models.LoginRequest{Username: "admin", Password: "legacy123"}, http.StatusOK, nil)
+	api.as("").expectProblem(http.MethodPost, "/login", models.LoginRequest{Username: "admin", Password: "secret123"}, http.StatusUnauthorized, "invalid_credentials")
+}
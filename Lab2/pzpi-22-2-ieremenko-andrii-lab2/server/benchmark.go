@@ -0,0 +1,237 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// minPeerGroupSize is the k-anonymity threshold: no statistics are
+	// published for a peer group with fewer apartments than this, not
+	// counting the one compared
+	minPeerGroupSize = 10
+	// minBenchmarkPeriod is the shortest period compared. Periods are
+	// widened to whole days, so that they cannot be narrowed down to
+	// single readings of a peer.
+	minBenchmarkPeriod = 7 * 24 * time.Hour
+)
+
+// benchmarkPercentiles are the percentiles published for a peer group
+var benchmarkPercentiles = []struct {
+	name string
+	p    float64
+}{
+	{"p10", 10}, {"p25", 25}, {"p50", 50}, {"p75", 75}, {"p90", 90},
+}
+
+// GetRoomBenchmark godoc
+// @Summary Compare room consumption with peers
+// @Description Compare normalized consumption of a room with comparable apartments in the same building or complex. Only anonymized percentiles are returned, and only when the peer group has at least 10 other apartments. Each percentile is the midpoint between two peers, and is left out when they consume the same. The period is widened to whole days (UTC) and must span at least 7 days.
+// @Tags rooms
+// @Accept json
+// @Produce json
+// @Param id path string true "Room ID"
+// @Param kind query string true "Metric kind (electricity, water, gas, heat)"
+// @Param scope query string false "Peer scope: building (default) or complex"
+// @Param normalize query string false "Normalization: area (default, per m²) or occupant"
+// @Param from query string false "Period start (RFC3339), defaults to 30 days ago; widened to the start of its day"
+// @Param to query string false "Period end (RFC3339), defaults to now; widened to the end of its day"
+// @Success 200 {object} models.BenchmarkResponse
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Router /rooms/{id}/benchmark [get]
+func (s *Server) GetRoomBenchmark(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid room ID")
+		return
+	}
+
+	room, exists := s.activeRoom(id)
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "room_not_found", "Room not found")
+		return
+	}
+
+	// Residents may only benchmark their own apartment
+	if room.OwnerID != user.ID && !hasRole(user, "admin") {
+		writeError(w, r, http.StatusForbidden, "forbidden", "Permission denied")
+		return
+	}
+
+	query := r.URL.Query()
+	kind := query.Get("kind")
+	if kind == "" {
+		writeError(w, r, http.StatusBadRequest, "kind_required", "Metric kind is required")
+		return
+	}
+
+	scope := query.Get("scope")
+	if scope == "" {
+		scope = "building"
+	}
+	if scope != "building" && scope != "complex" {
+		writeError(w, r, http.StatusBadRequest, "invalid_query", "Invalid scope")
+		return
+	}
+
+	normalize := query.Get("normalize")
+	if normalize == "" {
+		normalize = "area"
+	}
+	if normalize != "area" && normalize != "occupant" {
+		writeError(w, r, http.StatusBadRequest, "invalid_query", "Invalid normalization")
+		return
+	}
+
+	endTime := time.Now()
+	startTime := endTime.AddDate(0, 0, -30)
+	if v := query.Get("from"); v != "" {
+		if startTime, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_time", "Invalid from time")
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if endTime, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_time", "Invalid to time")
+			return
+		}
+	}
+
+	startTime, endTime = benchmarkPeriod(startTime, endTime)
+	if endTime.Sub(startTime) < minBenchmarkPeriod {
+		writeError(w, r, http.StatusBadRequest, "invalid_query", "Period must span at least 7 days")
+		return
+	}
+
+	value, ok := s.normalizedConsumption(room, kind, normalize, startTime, endTime)
+	if !ok {
+		writeError(w, r, http.StatusUnprocessableEntity, "not_comparable", "No comparable consumption for this room")
+		return
+	}
+
+	// The room is compared with the others only, so that its own
+	// consumption does not count towards the anonymity threshold
+	peers := make([]float64, 0)
+	for _, peer := range s.rooms {
+		if peer.ID == room.ID || peer.DeletedAt != nil || !sameGroup(room, peer, scope) {
+			continue
+		}
+		if v, ok := s.normalizedConsumption(peer, kind, normalize, startTime, endTime); ok {
+			peers = append(peers, v)
+		}
+	}
+
+	if len(peers) < minPeerGroupSize {
+		writeError(w, r, http.StatusUnprocessableEntity, "peer_group_too_small", "Peer group is too small to publish anonymized statistics")
+		return
+	}
+
+	sort.Float64s(peers)
+
+	below := 0
+	for _, v := range peers {
+		if v < value {
+			below++
+		}
+	}
+
+	percentiles := make(map[string]float64, len(benchmarkPercentiles))
+	for _, bp := range benchmarkPercentiles {
+		if v, ok := peerPercentile(peers, bp.p); ok {
+			percentiles[bp.name] = v
+		}
+	}
+
+	writeJSON(w, models.BenchmarkResponse{
+		RoomID:         room.ID,
+		Kind:           kind,
+		Scope:          scope,
+		Normalize:      normalize,
+		StartTime:      startTime,
+		EndTime:        endTime,
+		PeerCount:      len(peers),
+		Value:          value,
+		PercentileRank: 100 * float64(below) / float64(len(peers)),
+		Percentiles:    percentiles,
+	})
+}
+
+// benchmarkPeriod widens a period to whole UTC days
+func benchmarkPeriod(start, end time.Time) (time.Time, time.Time) {
+	const day = 24 * time.Hour
+	start = start.UTC().Truncate(day)
+	if widened := end.UTC().Truncate(day); widened.Before(end) {
+		end = widened.Add(day)
+	}
+	return start, end.UTC()
+}
+
+// sameGroup reports whether peer belongs to the same building or complex as room
+func sameGroup(room, peer *models.Room, scope string) bool {
+	if scope == "complex" {
+		return room.Complex != "" && peer.Complex == room.Complex
+	}
+	return room.Building != "" && peer.Building == room.Building &&
+		peer.Complex == room.Complex
+}
+
+// normalizedConsumption sums the readings of all metrics of the given kind in
+// the room and divides by floor area or number of occupants. Rooms without the
+// data needed for normalization or without readings are not comparable.
+func (s *Server) normalizedConsumption(room *models.Room, kind, normalize string, startTime, endTime time.Time) (float64, bool) {
+	var divisor float64
+	if normalize == "occupant" {
+		divisor = float64(room.Occupants)
+	} else {
+		divisor = room.Area
+	}
+	if divisor <= 0 {
+		return 0, false
+	}
+
+	total := 0.0
+	found := false
+	for _, metric := range s.metrics {
+		if metric.DeletedAt != nil || metric.RoomID != room.ID || metric.Kind != kind {
+			continue
+		}
+		if sum, count := s.consumptionInPeriod(metric.ID, startTime, endTime); count > 0 {
+			total += sum
+			found = true
+		}
+	}
+
+	if !found {
+		return 0, false
+	}
+	return total / divisor, true
+}
+
+// peerPercentile returns the p-th percentile of at least two sorted values
+// as the midpoint of the two values around its rank. It reports false when
+// those are equal, so that a published percentile never is the value of a
+// peer.
+func peerPercentile(sorted []float64, p float64) (float64, bool) {
+	i := min(int(math.Floor(p/100*float64(len(sorted)-1))), len(sorted)-2)
+	lower, upper := sorted[i], sorted[i+1]
+	if lower == upper {
+		return 0, false
+	}
+	return (lower + upper) / 2, true
+}
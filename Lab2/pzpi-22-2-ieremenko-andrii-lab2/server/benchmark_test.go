@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+func TestBenchmarkPeerGroup(t *testing.T) {
+	api := newTestAPI(t)
+
+	// addFlat adds a flat of the building consuming value
+	addFlat := func(i int, value float64) models.Metric {
+		metric := api.createMetric(fmt.Sprintf("Flat %d", i))
+		api.expect(http.MethodPost, "/metrics/"+metric.ID.String()+"/readings", models.AddReadingRequest{
+			Value:     &value,
+			Timestamp: time.Now().Add(-time.Hour),
+		}, http.StatusOK, nil)
+		return metric
+	}
+
+	flat := addFlat(0, 100)
+	path := "/rooms/" + flat.RoomID.String() + "/benchmark?kind=electricity"
+	for i := 1; i < minPeerGroupSize; i++ {
+		addFlat(i, float64(i*50))
+	}
+
+	// The flat itself does not count towards the threshold
+	api.expectProblem(http.MethodGet, path, nil, http.StatusUnprocessableEntity, "peer_group_too_small")
+
+	addFlat(minPeerGroupSize, float64(minPeerGroupSize*50))
+	var benchmark models.BenchmarkResponse
+	api.expect(http.MethodGet, path, nil, http.StatusOK, &benchmark)
+	if benchmark.PeerCount != minPeerGroupSize {
+		t.Errorf("got %d peers, want %d", benchmark.PeerCount, minPeerGroupSize)
+	}
+	// Peers consume 1 to 10 per m²; the flat 2
+	if benchmark.Value != 2 || benchmark.PercentileRank != 10 || benchmark.Percentiles["p50"] != 5.5 {
+		t.Errorf("got value %g, rank %g, median %g; want 2, 10, 5.5", benchmark.Value, benchmark.PercentileRank, benchmark.Percentiles["p50"])
+	}
+	if len(benchmark.Percentiles) != len(benchmarkPercentiles) {
+		t.Errorf("got percentiles %v, want all of them", benchmark.Percentiles)
+	}
+	for name, v := range benchmark.Percentiles {
+		if v == math.Trunc(v) {
+			t.Errorf("%s is %g, the value of a peer", name, v)
+		}
+	}
+
+	// A period too short to hide single readings is refused
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	short := fmt.Sprintf("%s&from=%s&to=%s", path, day.AddDate(0, 0, -6).Format(time.RFC3339), day.Add(-time.Second).Format(time.RFC3339))
+	api.expectProblem(http.MethodGet, short, nil, http.StatusBadRequest, "invalid_query")
+}
+
+func TestPeerPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted []float64
+		p      float64
+		want   float64
+		ok     bool
+	}{
+		{"lowest", []float64{1, 2, 3, 4}, 0, 1.5, true},
+		{"highest", []float64{1, 2, 3, 4}, 100, 3.5, true},
+		{"median", []float64{1, 2, 3, 4, 5}, 50, 3.5, true},
+		{"between equal peers", []float64{1, 2, 2, 3}, 50, 0, false},
+		{"all equal", []float64{4, 4}, 90, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := peerPercentile(tt.sorted, tt.p)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %g, %t; want %g, %t", got, ok, tt.want, tt.ok)
+			}
+			if ok && slices.Contains(tt.sorted, got) {
+				t.Errorf("%g is the value of a peer", got)
+			}
+		})
+	}
+}
+
+func TestBenchmarkPeriod(t *testing.T) {
+	start, end := benchmarkPeriod(
+		time.Date(2024, 3, 1, 13, 30, 0, 0, time.UTC),
+		time.Date(2024, 3, 8, 9, 0, 0, 0, time.UTC),
+	)
+	if !start.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %s to %s, want whole days from 1 to 9 March", start, end)
+	}
+
+	// A period already on day boundaries is kept
+	midnight := time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)
+	if _, end := benchmarkPeriod(midnight.AddDate(0, 0, -7), midnight); !end.Equal(midnight) {
+		t.Errorf("got end %s, want %s", end, midnight)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy describes which browser origins may call the API
+type corsPolicy struct {
+	origins map[string]bool
+	any     bool
+	maxAge  time.Duration
+}
+
+// corsHeaders are the request headers browsers may send across origins, and
+// corsExposed the response headers scripts may read
+const (
+	corsMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	corsHeaders = "Authorization, Content-Type, If-Match, If-None-Match, X-Request-ID"
+	corsExposed = "ETag, Location, Retry-After, X-Request-ID"
+)
+
+// SetCORS allows browser scripts from the given origins, or from any with
+// "*", to call the API. Preflight responses may be cached for maxAge. No
+// origin is allowed by default.
+func (s *Server) SetCORS(origins []string, maxAge time.Duration) {
+	policy := corsPolicy{origins: make(map[string]bool), maxAge: maxAge}
+	for _, origin := range origins {
+		if origin == "*" {
+			policy.any = true
+		}
+		policy.origins[strings.TrimRight(origin, "/")] = true
+	}
+	s.cors = policy
+}
+
+// withCORS adds CORS headers for allowed origins and answers preflight
+// requests
+func (s *Server) withCORS(next http.Handler) http.Handler {
+	policy := s.cors
+	if !policy.any && len(policy.origins) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !(policy.any || policy.origins[origin]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", corsExposed)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", corsMethods)
+			h.Set("Access-Control-Allow-Headers", corsHeaders)
+			if policy.maxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.maxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
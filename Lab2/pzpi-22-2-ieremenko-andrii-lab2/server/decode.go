@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/validation"
+)
+
+// defaultMaxBodySize is the largest JSON request body accepted unless
+// SetMaxBodySize says otherwise
+const defaultMaxBodySize = 1 << 20
+
+// SetMaxBodySize sets the largest JSON request body accepted, in bytes
+func (s *Server) SetMaxBodySize(n int64) {
+	s.maxBodySize = n
+}
+
+// withBodyLimit makes decodeJSON reject bodies over n bytes for requests
+// passing through it
+func withBodyLimit(n int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), maxBodySizeKey, n)))
+	})
+}
+
+// decodeJSON decodes the JSON body of r into v and validates it against its
+// binding tags. Unknown fields and bodies over the size limit are rejected.
+// It writes an error response and returns false if the body is not
+// acceptable.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	limit, ok := r.Context().Value(maxBodySizeKey).(int64)
+	if !ok {
+		limit = defaultMaxBodySize
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		var maxErr *http.MaxBytesError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &maxErr):
+			writeError(w, r, http.StatusRequestEntityTooLarge, "body_too_large",
+				fmt.Sprintf("Request body must not exceed %d bytes", maxErr.Limit))
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			// encoding/json has no error type for unknown fields
+			field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+			writeProblem(w, r, http.StatusBadRequest, "unknown_field", "Unknown field "+field,
+				map[string]interface{}{"field": field})
+		case errors.As(err, &typeErr):
+			writeProblem(w, r, http.StatusBadRequest, "invalid_body", "Field "+typeErr.Field+" must be "+jsonType(typeErr.Type),
+				map[string]interface{}{"field": typeErr.Field})
+		default:
+			writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid request body")
+		}
+		return false
+	}
+	if decoder.More() {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Request body must hold a single JSON value")
+		return false
+	}
+
+	if err := validation.Struct(v); err != nil {
+		var fieldErrs validation.Errors
+		errors.As(err, &fieldErrs)
+		writeProblem(w, r, http.StatusBadRequest, "validation_failed", "Request validation failed: "+err.Error(),
+			map[string]interface{}{"fields": fieldErrs})
+		return false
+	}
+
+	return true
+}
+
+// jsonType describes the JSON value expected for a Go type
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return "a string"
+}
@@ -0,0 +1,547 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// deviceKeyPrefix starts every device API key, telling them apart from
+	// login tokens
+	deviceKeyPrefix = "hmd_"
+
+	// deviceStaleAfter is how long a device may go without recording a
+	// reading before it is reported as stale
+	deviceStaleAfter = 24 * time.Hour
+)
+
+// deviceCredential identifies the device, and the key, a request is made with
+type deviceCredential struct {
+	deviceID uuid.UUID
+	keyID    uuid.UUID
+}
+
+// withDevices authenticates requests carrying a device API key as that
+// device. Device keys may only record readings.
+func (s *Server) withDevices(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !strings.HasPrefix(key, deviceKeyPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s.mu.RLock()
+		device, deviceKey := s.deviceByKey(key)
+		var user *models.User
+		if device != nil {
+			user = deviceUser(device)
+		}
+		s.mu.RUnlock()
+		if device == nil {
+			writeError(w, r, http.StatusUnauthorized, "invalid_device_key", "Device key is invalid or revoked")
+			return
+		}
+
+		if !recordsReadings(r) {
+			writeError(w, r, http.StatusForbidden, "forbidden", "Device keys may only record readings")
+			return
+		}
+
+		authenticate := Authenticator(func(*http.Request) (*models.User, error) {
+			return user, nil
+		})
+		ctx := context.WithValue(r.Context(), authenticatorKey, authenticate)
+		ctx = context.WithValue(ctx, deviceCredentialKey, deviceCredential{deviceID: device.ID, keyID: deviceKey.ID})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// recordsReadings reports whether the request records readings, i.e. is
+// POST /metrics/{id}/readings or POST /metrics/telegrams
+func recordsReadings(r *http.Request) bool {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method != http.MethodPost || len(parts) < 2 || parts[0] != "metrics" {
+		return false
+	}
+	return len(parts) == 3 && parts[2] == "readings" || len(parts) == 2 && parts[1] == "telegrams"
+}
+
+// deviceByKey returns the device holding the unrevoked API key, or nil; the
+// caller must hold mu
+func (s *Server) deviceByKey(key string) (*models.Device, *models.DeviceKey) {
+	hash := hashDeviceKey(key)
+	for _, device := range s.devices {
+		for i := range device.Keys {
+			k := &device.Keys[i]
+			if k.Hash == hash && k.RevokedAt == nil {
+				return device, k
+			}
+		}
+	}
+	return nil, nil
+}
+
+// deviceUser returns the user requests of a device are made as
+func deviceUser(device *models.Device) *models.User {
+	return &models.User{
+		ID:       device.ID,
+		Username: "device:" + device.Serial,
+		Roles:    []models.Role{{Name: "device"}},
+	}
+}
+
+// touchDevice records that the device making the request, if any, was just
+// seen; the caller must hold mu exclusively
+func (s *Server) touchDevice(r *http.Request) {
+	cred, ok := r.Context().Value(deviceCredentialKey).(deviceCredential)
+	if !ok {
+		return
+	}
+	device, exists := s.devices[cred.deviceID]
+	if !exists {
+		return
+	}
+
+	now := time.Now()
+	device.LastSeenAt = &now
+	for i := range device.Keys {
+		if device.Keys[i].ID == cred.keyID {
+			device.Keys[i].LastUsedAt = &now
+		}
+	}
+}
+
+// newDeviceKey generates an API key and returns it with its hash
+func newDeviceKey() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key := deviceKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, hashDeviceKey(key), nil
+}
+
+// hashDeviceKey returns the hash a device key is stored as. Keys are random,
+// so a plain SHA-256 is enough.
+func hashDeviceKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// deviceHealth tells whether the device reports readings and is calibrated
+func deviceHealth(device *models.Device, now time.Time) string {
+	switch {
+	case device.CalibrationDue != nil && now.After(*device.CalibrationDue):
+		return models.DeviceCalibrationOverdue
+	case device.LastSeenAt == nil:
+		return models.DeviceNeverSeen
+	case now.Sub(*device.LastSeenAt) > deviceStaleAfter:
+		return models.DeviceStale
+	default:
+		return models.DeviceHealthy
+	}
+}
+
+// copyDevice copies a device, including its keys, for the audit log and
+// for responses
+func copyDevice(device *models.Device) models.Device {
+	c := *device
+	c.MetricIDs = slices.Clone(device.MetricIDs)
+	c.Keys = slices.Clone(device.Keys)
+	return c
+}
+
+// checkDeviceMetrics writes an error response and returns false unless
+// every metric exists
+func (s *Server) checkDeviceMetrics(w http.ResponseWriter, r *http.Request, ids []uuid.UUID) bool {
+	for _, id := range ids {
+		if _, exists := s.activeMetric(id); !exists {
+			writeError(w, r, http.StatusBadRequest, "metric_not_found", fmt.Sprintf("Metric %s not found", id))
+			return false
+		}
+	}
+	return true
+}
+
+// checkOMSKey writes an error response and returns false unless key is
+// empty or an AES-128 key in hex
+func checkOMSKey(w http.ResponseWriter, r *http.Request, key string) bool {
+	if key == "" {
+		return true
+	}
+	if b, err := hex.DecodeString(key); err != nil || len(b) != 16 {
+		writeError(w, r, http.StatusBadRequest, "invalid_oms_key", "OMS key must be 32 hex digits")
+		return false
+	}
+	return true
+}
+
+// serialTaken reports whether another device than id has the serial number
+func (s *Server) serialTaken(serial string, id uuid.UUID) bool {
+	for _, device := range s.devices {
+		if device.Serial == serial && device.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceBySerial returns the device with the serial number, or nil; the
+// caller must hold mu
+func (s *Server) deviceBySerial(serial string) *models.Device {
+	for _, device := range s.devices {
+		if device.Serial == serial {
+			return device
+		}
+	}
+	return nil
+}
+
+// deviceFromPath returns the device named by the id path value, writing an
+// error response if there is none
+func (s *Server) deviceFromPath(w http.ResponseWriter, r *http.Request) (*models.Device, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid device ID")
+		return nil, false
+	}
+
+	device, exists := s.devices[id]
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "device_not_found", "Device not found")
+		return nil, false
+	}
+	return device, true
+}
+
+// CreateDevice godoc
+// @Summary Register a device
+// @Description Register a physical meter that records readings of the given metrics. Issue it an API key with POST /devices/{id}/keys.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param request body models.CreateDeviceRequest true "Device registration request"
+// @Success 200 {object} models.Device
+// @Header 200 {string} ETag "Version of the resource"
+// @Failure 400 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /devices [post]
+func (s *Server) CreateDevice(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.CreateDeviceRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if !s.checkDeviceMetrics(w, r, req.MetricIDs) || !checkOMSKey(w, r, req.OMSKey) {
+		return
+	}
+	if s.serialTaken(req.Serial, uuid.Nil) {
+		writeError(w, r, http.StatusConflict, "device_exists", "A device with this serial number is already registered")
+		return
+	}
+
+	now := time.Now()
+	device := &models.Device{
+		ID:             uuid.New(),
+		Serial:         req.Serial,
+		Model:          req.Model,
+		InstalledAt:    req.InstalledAt,
+		CalibrationDue: req.CalibrationDue,
+		MetricIDs:      req.MetricIDs,
+		Keys:           make([]models.DeviceKey, 0),
+		OMSKey:         req.OMSKey,
+		HasOMSKey:      req.OMSKey != "",
+		Version:        1,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	s.devices[device.ID] = device
+	s.recordAudit(r, user, "device.create", "device", device.ID.String(), nil, device)
+
+	setETag(w, device.Version)
+	writeJSON(w, device)
+}
+
+// ListDevices godoc
+// @Summary List devices
+// @Description List registered devices with their health: ok, never_seen, stale when no reading was recorded for a day, or calibration_overdue
+// @Tags devices
+// @Produce json
+// @Success 200 {object} models.DeviceListResponse
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Router /devices [get]
+func (s *Server) ListDevices(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	now := time.Now()
+	response := models.DeviceListResponse{
+		Devices: make([]models.Device, 0, len(s.devices)),
+		Health:  make(map[string]int),
+	}
+	for _, device := range s.devices {
+		d := copyDevice(device)
+		d.Health = deviceHealth(device, now)
+		response.Devices = append(response.Devices, d)
+		response.Health[d.Health]++
+	}
+	sort.Slice(response.Devices, func(i, j int) bool {
+		return response.Devices[i].Serial < response.Devices[j].Serial
+	})
+	response.Total = len(response.Devices)
+
+	writeJSON(w, response)
+}
+
+// GetDevice godoc
+// @Summary Get a device
+// @Description Get a device with its keys and health
+// @Tags devices
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param If-None-Match header string false "ETag of the cached version"
+// @Success 200 {object} models.Device
+// @Header 200 {string} ETag "Version of the resource"
+// @Success 304 "Not modified"
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /devices/{id} [get]
+func (s *Server) GetDevice(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	device, ok := s.deviceFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if notModified(w, r, device.Version) {
+		return
+	}
+
+	d := copyDevice(device)
+	d.Health = deviceHealth(device, time.Now())
+
+	setETag(w, device.Version)
+	writeJSON(w, d)
+}
+
+// UpdateDevice godoc
+// @Summary Update a device
+// @Description Update a device; omitted fields are left unchanged. metric_ids replaces the metrics the device may record readings of.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param request body models.UpdateDeviceRequest true "Device update request"
+// @Param If-Match header string false "ETag of the version being changed"
+// @Success 200 {object} models.Device
+// @Header 200 {string} ETag "Version of the resource"
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Router /devices/{id} [patch]
+func (s *Server) UpdateDevice(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	device, ok := s.deviceFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if preconditionFailed(w, r, device.Version) {
+		return
+	}
+
+	var req models.UpdateDeviceRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.MetricIDs != nil && !s.checkDeviceMetrics(w, r, *req.MetricIDs) {
+		return
+	}
+	if req.OMSKey != nil && !checkOMSKey(w, r, *req.OMSKey) {
+		return
+	}
+	if req.Serial != nil && s.serialTaken(*req.Serial, device.ID) {
+		writeError(w, r, http.StatusConflict, "device_exists", "A device with this serial number is already registered")
+		return
+	}
+
+	before := copyDevice(device)
+	if req.Serial != nil {
+		device.Serial = *req.Serial
+	}
+	if req.Model != nil {
+		device.Model = *req.Model
+	}
+	if req.InstalledAt != nil {
+		device.InstalledAt = req.InstalledAt
+	}
+	if req.CalibrationDue != nil {
+		device.CalibrationDue = req.CalibrationDue
+	}
+	if req.MetricIDs != nil {
+		device.MetricIDs = *req.MetricIDs
+	}
+	if req.OMSKey != nil {
+		device.OMSKey = *req.OMSKey
+		device.HasOMSKey = *req.OMSKey != ""
+	}
+	device.UpdatedAt = time.Now()
+	device.Version++
+
+	s.recordAudit(r, user, "device.update", "device", device.ID.String(), before, device)
+
+	setETag(w, device.Version)
+	writeJSON(w, device)
+}
+
+// DeleteDevice godoc
+// @Summary Delete a device
+// @Description Remove a device; its keys stop working. Readings it recorded are kept.
+// @Tags devices
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param If-Match header string false "ETag of the version being changed"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Router /devices/{id} [delete]
+func (s *Server) DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	device, ok := s.deviceFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if preconditionFailed(w, r, device.Version) {
+		return
+	}
+
+	delete(s.devices, device.ID)
+	s.recordAudit(r, user, "device.delete", "device", device.ID.String(), device, nil)
+
+	writeJSON(w, map[string]string{
+		"message": "Device deleted",
+	})
+}
+
+// CreateDeviceKey godoc
+// @Summary Issue a device API key
+// @Description Issue an API key the device sends as a bearer token to record readings of its metrics. The key is only shown in this response.
+// @Tags devices
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param request body models.CreateDeviceKeyRequest false "Key request"
+// @Success 200 {object} models.DeviceKeyResponse
+// @Failure 404 {object} models.Problem
+// @Router /devices/{id}/keys [post]
+func (s *Server) CreateDeviceKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	device, ok := s.deviceFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.CreateDeviceKeyRequest
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+
+	key, hash, err := newDeviceKey()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to generate key")
+		return
+	}
+
+	deviceKey := models.DeviceKey{
+		ID:        uuid.New(),
+		Name:      req.Name,
+		Prefix:    key[:len(deviceKeyPrefix)+8],
+		Hash:      hash,
+		CreatedAt: time.Now(),
+	}
+	device.Keys = append(device.Keys, deviceKey)
+	device.Version++
+	s.recordAudit(r, user, "device.key.create", "device", device.ID.String(), nil, deviceKey)
+
+	writeJSON(w, models.DeviceKeyResponse{DeviceKey: deviceKey, Key: key})
+}
+
+// RevokeDeviceKey godoc
+// @Summary Revoke a device API key
+// @Description Revoke an API key of a device; requests made with it are rejected from then on
+// @Tags devices
+// @Param id path string true "Device ID"
+// @Param keyId path string true "Key ID"
+// @Success 204 "Key revoked"
+// @Failure 404 {object} models.Problem
+// @Router /devices/{id}/keys/{keyId} [delete]
+func (s *Server) RevokeDeviceKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	device, ok := s.deviceFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := uuid.Parse(r.PathValue("keyId"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid key ID")
+		return
+	}
+
+	i := slices.IndexFunc(device.Keys, func(k models.DeviceKey) bool { return k.ID == keyID })
+	if i < 0 || device.Keys[i].RevokedAt != nil {
+		writeError(w, r, http.StatusNotFound, "key_not_found", "Key not found")
+		return
+	}
+
+	now := time.Now()
+	device.Keys[i].RevokedAt = &now
+	device.Version++
+	s.recordAudit(r, user, "device.key.revoke", "device", device.ID.String(), nil, device.Keys[i])
+
+	w.WriteHeader(http.StatusNoContent)
+}
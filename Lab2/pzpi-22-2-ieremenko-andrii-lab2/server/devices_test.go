@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+func TestDeviceKeys(t *testing.T) {
+	api := newTestAPI(t)
+	metric := api.createMetric("Meter")
+	other := api.createMetric("Other")
+	room := api.createRoom("Flat")
+	key := api.createDevice("SN-1", metric.ID)
+	api.s.mu.RLock()
+	device := api.s.deviceBySerial("SN-1")
+	api.s.mu.RUnlock()
+	readings := "/metrics/" + metric.ID.String() + "/readings"
+	value := 1.0
+
+	// The two ingestion endpoints take device keys
+	devices := api.as(key.Key)
+	devices.expect(http.MethodPost, readings, models.AddReadingRequest{Value: &value}, http.StatusOK, nil)
+	devices.expectProblem(http.MethodPost, "/metrics/telegrams", models.TelegramRequest{}, http.StatusBadRequest, "validation_failed")
+	devices.expectProblem(http.MethodPost, "/metrics/"+other.ID.String()+"/readings", models.AddReadingRequest{Value: &value}, http.StatusForbidden, "forbidden")
+
+	// Nothing else does
+	tests := []struct {
+		method, path string
+	}{
+		{http.MethodGet, readings},
+		{http.MethodGet, "/metrics"},
+		{http.MethodGet, "/metrics/" + metric.ID.String()},
+		{http.MethodDelete, "/metrics/" + metric.ID.String()},
+		{http.MethodGet, "/rooms"},
+		{http.MethodPatch, "/rooms/" + room.ID.String()},
+		{http.MethodGet, "/devices"},
+		{http.MethodGet, "/devices/" + device.ID.String()},
+		{http.MethodPost, "/devices/" + device.ID.String() + "/keys"},
+		{http.MethodGet, "/users/me"},
+		{http.MethodGet, "/admin/audit"},
+	}
+	for _, tt := range tests {
+		devices.expectProblem(tt.method, tt.path, nil, http.StatusForbidden, "forbidden")
+	}
+
+	// Residents may not see devices and their keys either
+	resident := api.as(api.register("resident"))
+	resident.expectProblem(http.MethodGet, "/devices", nil, http.StatusForbidden, "forbidden")
+	resident.expectProblem(http.MethodGet, "/devices/"+device.ID.String(), nil, http.StatusForbidden, "forbidden")
+	var list models.DeviceListResponse
+	api.expect(http.MethodGet, "/devices", nil, http.StatusOK, &list)
+	if list.Total != 1 || len(list.Devices[0].Keys) != 1 {
+		t.Errorf("got %+v, want the device and its key", list)
+	}
+
+	// Revoked keys are rejected everywhere
+	api.expect(http.MethodDelete, "/devices/"+device.ID.String()+"/keys/"+key.ID.String(), nil, http.StatusNoContent, nil)
+	devices.expectProblem(http.MethodPost, readings, models.AddReadingRequest{Value: &value}, http.StatusUnauthorized, "invalid_device_key")
+	devices.expectProblem(http.MethodGet, "/rooms", nil, http.StatusUnauthorized, "invalid_device_key")
+	api.as(key.Key+"x").expectProblem(http.MethodPost, readings, models.AddReadingRequest{Value: &value}, http.StatusUnauthorized, "invalid_device_key")
+}
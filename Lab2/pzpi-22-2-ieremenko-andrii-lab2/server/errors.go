@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an RFC 7807 problem+json error response. code is a
+// stable identifier clients can act on; message is meant for people.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeProblem(w, r, status, code, message, nil)
+}
+
+// writeProblem writes an error response like writeError, with details about
+// the error, such as the fields that failed validation
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Detail:    message,
+		Instance:  r.URL.Path,
+		Details:   details,
+		RequestID: requestID(r),
+	})
+}
+
+// statusCodes are the error codes of responses not written by the handlers,
+// such as those of the mux for unknown routes
+var statusCodes = map[int]string{
+	http.StatusNotFound:              "route_not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusRequestEntityTooLarge: "body_too_large",
+}
+
+// withProblemErrors turns plain text errors, written by http.Error outside
+// the handlers, into problem+json responses so that every error a client
+// sees has the same shape
+func withProblemErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&problemWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+// problemWriter replaces plain text error responses with problem+json
+type problemWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	replaced bool
+}
+
+func (pw *problemWriter) WriteHeader(status int) {
+	if status < 400 || !strings.HasPrefix(pw.Header().Get("Content-Type"), "text/plain") {
+		pw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	code, ok := statusCodes[status]
+	if !ok {
+		code = "error"
+	}
+	pw.replaced = true
+	writeError(pw.ResponseWriter, pw.r, status, code, http.StatusText(status))
+}
+
+func (pw *problemWriter) Write(b []byte) (int, error) {
+	if pw.replaced {
+		return len(b), nil
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (pw *problemWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+// etag returns the entity tag of a resource at the given version
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// metricETag returns the entity tag of a metric with its readings: the
+// version of the metric and, after a dot, the version of its readings
+func metricETag(metric *models.Metric) string {
+	return `"` + strconv.FormatInt(metric.Version, 10) + "." + strconv.FormatInt(metric.ReadingsVersion, 10) + `"`
+}
+
+// setETag sets the ETag header for a resource at the given version
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// notModified answers a conditional GET with 304 Not Modified when the
+// client's copy, named in If-None-Match, is still current. It reports
+// whether the response has been written.
+func notModified(w http.ResponseWriter, r *http.Request, version int64) bool {
+	return notModifiedTag(w, r, etag(version))
+}
+
+// notModifiedTag is notModified for a resource whose current entity tag is
+// given
+func notModifiedTag(w http.ResponseWriter, r *http.Request, current string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	// If-None-Match uses the weak comparison
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			w.Header().Set("ETag", current)
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed rejects a change with 412 Precondition Failed when
+// If-Match does not name the current version of the resource, so that a
+// client cannot overwrite changes it has not seen. It reports whether the
+// response has been written.
+//
+// The tag of a metric with its readings matches by the version of the
+// metric alone, so that readings recorded meanwhile do not fail changes to
+// the metric itself.
+func preconditionFailed(w http.ResponseWriter, r *http.Request, version int64) bool {
+	current := etag(version)
+	return failedPrecondition(w, r, current, func(tag string) bool {
+		if resource, _, ok := strings.Cut(tag, "."); ok {
+			tag = resource + `"`
+		}
+		return tag == current
+	})
+}
+
+// readingsPreconditionFailed is preconditionFailed for a change to the
+// readings of a metric, which If-Match must name by the tag of the metric
+// with its readings
+func readingsPreconditionFailed(w http.ResponseWriter, r *http.Request, metric *models.Metric) bool {
+	current := metricETag(metric)
+	return failedPrecondition(w, r, current, func(tag string) bool { return tag == current })
+}
+
+// failedPrecondition rejects a change unless a tag in If-Match matches the
+// current one
+func failedPrecondition(w http.ResponseWriter, r *http.Request, current string, matches func(tag string) bool) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return false
+	}
+
+	// If-Match uses the strong comparison, so weak tags never match
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || matches(tag) {
+			return false
+		}
+	}
+
+	w.Header().Set("ETag", current)
+	writeError(w, r, http.StatusPreconditionFailed, "precondition_failed", "Resource has been modified; fetch it again and retry")
+	return true
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/events"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/prom"
+)
+
+// outboxListed is how many pending events the event bus status lists
+const outboxListed = 100
+
+// Events returns the bus the server publishes what happens on, such as
+// readings being recorded and rooms being deleted. Handlers publish with mu
+// held, so synchronous subscribers must not take it.
+func (s *Server) Events() *events.Bus {
+	return s.events
+}
+
+// SetEventOutbox makes the events waiting for durable subscribers be saved
+// with the state, so that they are delivered after a restart or crash
+func (s *Server) SetEventOutbox(persist bool) {
+	s.persistOutbox = persist
+}
+
+// subscribeEvents wires the side effects of the server's own handlers to
+// the event bus
+func (s *Server) subscribeEvents() {
+	events.Subscribe(s.events, "stream", func(_ context.Context, _ events.Meta, e events.ReadingCreated) error {
+		s.stream.publish(models.StreamReading, s.metrics[e.Reading.MetricID], e.Reading)
+		return nil
+	})
+	events.Subscribe(s.events, "stream", func(_ context.Context, _ events.Meta, e events.ReadingCorrected) error {
+		s.stream.publish(models.StreamReadingCorrected, s.metrics[e.Reading.MetricID], e.Reading)
+		return nil
+	})
+	events.Subscribe(s.events, "telemetry", func(_ context.Context, _ events.Meta, e events.ReadingCreated) error {
+		s.telemetry.ingested.Add(1, e.Source)
+		return nil
+	})
+}
+
+// publish publishes an event on the bus, logging what synchronous
+// subscribers failed at; the change it tells about has been made already
+func (s *Server) publish(e events.Event) {
+	if err := s.events.Publish(e); err != nil {
+		log.Printf("Handling event %s failed: %v", e.EventType(), err)
+	}
+}
+
+// GetEventStatus godoc
+// @Summary Get the event bus status
+// @Description Get the subscribers of the event bus with how their deliveries go, and the oldest events waiting in the outbox for durable subscribers
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.EventBusStatus
+// @Failure 403 {object} models.Problem
+// @Router /admin/events [get]
+func (s *Server) GetEventStatus(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	outbox := s.events.Outbox()
+	if outbox == nil {
+		outbox = make([]models.OutboxEvent, 0)
+	}
+	writeJSON(w, models.EventBusStatus{
+		Subscribers: s.events.Stats(),
+		Outbox:      outbox[:min(len(outbox), outboxListed)],
+		Persisted:   s.persistOutbox,
+	})
+}
+
+// writeEventStats writes how deliveries to each subscriber of the event bus
+// go
+func (s *Server) writeEventStats(pw *prom.Writer) {
+	stats := s.events.Stats()
+	labels := func(sub models.EventSubscriber) []prom.Label {
+		return []prom.Label{{Name: "subscriber", Value: sub.Name}, {Name: "event", Value: sub.EventType}, {Name: "mode", Value: sub.Mode}}
+	}
+	for _, family := range []struct {
+		name, typ, help string
+		value           func(sub models.EventSubscriber) float64
+	}{
+		{"hm_events_delivered_total", "counter", "Events handled by a subscriber.", func(sub models.EventSubscriber) float64 { return float64(sub.Delivered) }},
+		{"hm_events_failed_total", "counter", "Deliveries of events a subscriber failed at.", func(sub models.EventSubscriber) float64 { return float64(sub.Failed) }},
+		{"hm_events_dropped_total", "counter", "Events a subscriber missed as its queue was full, or that were given up on.", func(sub models.EventSubscriber) float64 { return float64(sub.Dropped) }},
+		{"hm_events_queued", "gauge", "Events waiting for a subscriber.", func(sub models.EventSubscriber) float64 { return float64(sub.Queued) }},
+	} {
+		pw.Family(family.name, family.typ, family.help)
+		for _, sub := range stats {
+			pw.Sample(family.name, labels(sub), family.value(sub))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// gatewayNamespace derives stable user IDs for gateways from their subjects
+var gatewayNamespace = uuid.MustParse("6f0c1d8e-5a7b-4c1e-9d2f-3b8a4e6c7d10")
+
+// gateway is a meter gateway authenticated by its client certificate
+type gateway struct {
+	user    *models.User
+	metrics map[uuid.UUID]bool
+}
+
+// SetGateways lets meter gateways authenticate with TLS client certificates
+// instead of user logins. gateways maps a certificate subject, either its
+// common name or its full distinguished name, to the metrics the gateway may
+// read and record readings of. Client certificates are only accepted on the
+// metric endpoints, and only when the listener verifies them.
+func (s *Server) SetGateways(gateways map[string][]uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gateways = make(map[string]*gateway, len(gateways))
+	for subject, metrics := range gateways {
+		g := &gateway{
+			user: &models.User{
+				ID:       uuid.NewSHA1(gatewayNamespace, []byte(subject)),
+				Username: "gateway:" + subject,
+				Roles:    []models.Role{{Name: "gateway"}},
+			},
+			metrics: make(map[uuid.UUID]bool, len(metrics)),
+		}
+		for _, id := range metrics {
+			g.metrics[id] = true
+		}
+		s.gateways[subject] = g
+	}
+}
+
+// withGateways authenticates requests to the metric endpoints that carry a
+// verified client certificate of a known gateway as that gateway. Gateways
+// are looked up on every request, so that they may be set after the handler
+// is built.
+func (s *Server) withGateways(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || !isMetricPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cert := r.TLS.VerifiedChains[0][0]
+		s.mu.RLock()
+		configured := len(s.gateways) > 0
+		g, ok := s.gateways[cert.Subject.String()]
+		if !ok {
+			g, ok = s.gateways[cert.Subject.CommonName]
+		}
+		s.mu.RUnlock()
+		if !configured {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !ok {
+			writeError(w, r, http.StatusForbidden, "unknown_gateway", "Client certificate is not registered for a gateway")
+			return
+		}
+
+		authenticate := Authenticator(func(*http.Request) (*models.User, error) {
+			return g.user, nil
+		})
+		ctx := context.WithValue(r.Context(), authenticatorKey, authenticate)
+		ctx = context.WithValue(ctx, gatewayKey, g)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// isMetricPath reports whether a path is that of a metric endpoint
+func isMetricPath(path string) bool {
+	return path == "/metrics" || strings.HasPrefix(path, "/metrics/")
+}
+
+// metricAllowed reports whether the request may access the metric. Gateways
+// and devices are limited to the metrics they are registered for; the
+// caller must hold mu.
+func (s *Server) metricAllowed(r *http.Request, id uuid.UUID) bool {
+	if g, ok := r.Context().Value(gatewayKey).(*gateway); ok && !g.metrics[id] {
+		return false
+	}
+	if cred, ok := r.Context().Value(deviceCredentialKey).(deviceCredential); ok {
+		device, exists := s.devices[cred.deviceID]
+		return exists && slices.Contains(device.MetricIDs, id)
+	}
+	return true
+}
+
+// requireMetricAccess writes an error response and returns false unless the
+// request may access the metric
+func (s *Server) requireMetricAccess(w http.ResponseWriter, r *http.Request, id uuid.UUID) bool {
+	if !s.metricAllowed(r, id) {
+		writeError(w, r, http.StatusForbidden, "forbidden", fmt.Sprintf("Not allowed to access metric %s", id))
+		return false
+	}
+	return true
+}
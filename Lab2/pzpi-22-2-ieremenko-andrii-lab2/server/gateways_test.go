@@ -0,0 +1,57 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// gatewayRequest sends a request to handler as if over TLS with a verified
+// client certificate of the given common name
+func gatewayRequest(handler http.Handler, method, path, commonName string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGateways(t *testing.T) {
+	api := newTestAPI(t)
+	allowed := api.createMetric("Allowed")
+	other := api.createMetric("Other")
+
+	// Gateways set after the handler is built still apply
+	handler := api.s.Handler()
+	api.s.SetGateways(map[string][]uuid.UUID{"gw-1": {allowed.ID}})
+
+	tests := []struct {
+		path, commonName string
+		status           int
+		code             string
+	}{
+		{"/metrics/" + allowed.ID.String(), "gw-1", http.StatusOK, ""},
+		{"/metrics/" + other.ID.String(), "gw-1", http.StatusForbidden, "forbidden"},
+		{"/metrics", "gw-9", http.StatusForbidden, "unknown_gateway"},
+		{"/metrics/" + allowed.ID.String(), "gw-9", http.StatusForbidden, "unknown_gateway"},
+		// Only the metric endpoints accept gateway certificates
+		{"/metricsfoo", "gw-9", http.StatusNotFound, "route_not_found"},
+		{"/rooms", "gw-9", http.StatusUnauthorized, "unauthorized"},
+	}
+	for _, tt := range tests {
+		rec := gatewayRequest(handler, http.MethodGet, tt.path, tt.commonName)
+		var problem models.Problem
+		json.Unmarshal(rec.Body.Bytes(), &problem)
+		if rec.Code != tt.status || problem.Code != tt.code {
+			t.Errorf("%s as %s: got %d %q, want %d %q", tt.path, tt.commonName, rec.Code, problem.Code, tt.status, tt.code)
+		}
+	}
+}
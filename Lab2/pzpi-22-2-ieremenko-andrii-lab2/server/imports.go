@@ -0,0 +1,512 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxImportSize limits the size of an uploaded import file
+	maxImportSize = 64 << 20
+	// maxImportErrors caps the number of row errors kept in a job report
+	maxImportErrors = 1000
+	// importBatchSize is the number of rows written per lock acquisition so
+	// that an import does not block the API for its whole duration
+	importBatchSize = 500
+	// importJobTTL is how long a finished import job is kept for its report
+	// to be read
+	importJobTTL = 24 * time.Hour
+)
+
+// importRow is a single parsed row of an import file, or the error it could
+// not be parsed with
+type importRow struct {
+	line   int // line of the file the row starts on
+	fields map[string]string
+	err    error
+}
+
+// importJob holds the state of a background import
+type importJob struct {
+	models.ImportJob
+	mapping models.ImportMapping
+	data    []byte // the uploaded file, until it is parsed
+	audit   models.AuditEntry
+}
+
+// snapshot copies the job status; the caller must hold importMu
+func (j *importJob) snapshot() models.ImportJob {
+	status := j.ImportJob
+	status.Errors = append([]models.ImportRowError{}, j.Errors...)
+	return status
+}
+
+// CreateImport godoc
+// @Summary Import historical readings
+// @Description Upload a CSV or NDJSON file of historical readings. The file is parsed and processed in the background; with dry_run set rows are only validated and nothing is stored. Rows that are malformed or rejected are reported by the line of the file they start on, and the others are imported. A job fails only when the file cannot be read at all. Finished jobs are kept for a day.
+// @Tags imports
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV or NDJSON file"
+// @Param format formData string false "File format: csv or ndjson, inferred from the file name by default"
+// @Param mapping formData string true "Column mapping as JSON (models.ImportMapping)"
+// @Param dry_run formData bool false "Only validate the file"
+// @Success 202 {object} models.ImportJob
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Router /imports [post]
+func (s *Server) CreateImport(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	user, ok := s.requireAdmin(w, r)
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Invalid multipart form")
+		return
+	}
+
+	var mapping models.ImportMapping
+	if err := json.Unmarshal([]byte(r.FormValue("mapping")), &mapping); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_mapping", "Invalid column mapping")
+		return
+	}
+	if mapping.MetricIDField == "" && mapping.SerialField == "" && mapping.MetricID == uuid.Nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_mapping", "Mapping must identify the metric by ID column, serial column or fixed metric ID")
+		return
+	}
+	if mapping.TimestampField == "" {
+		mapping.TimestampField = "timestamp"
+	}
+	if mapping.ValueField == "" {
+		mapping.ValueField = "value"
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "file_required", "Import file is required")
+		return
+	}
+	defer file.Close()
+
+	format := r.FormValue("format")
+	if format == "" {
+		switch strings.ToLower(path.Ext(header.Filename)) {
+		case ".ndjson", ".jsonl":
+			format = "ndjson"
+		default:
+			format = "csv"
+		}
+	}
+	if format != "csv" && format != "ndjson" {
+		writeError(w, r, http.StatusBadRequest, "unsupported_format", "Unsupported import format")
+		return
+	}
+
+	// The form is removed once the request ends, so the job keeps the file
+	// it parses
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_file", "Failed to read import file")
+		return
+	}
+
+	job := &importJob{
+		ImportJob: models.ImportJob{
+			ID:        uuid.New(),
+			Format:    format,
+			DryRun:    r.FormValue("dry_run") == "true",
+			Status:    models.ImportJobPending,
+			Errors:    []models.ImportRowError{},
+			CreatedAt: time.Now(),
+		},
+		mapping: mapping,
+		data:    data,
+	}
+	job.audit = newAuditEntry(r, user, "import.complete", "import", job.ID.String())
+
+	s.importMu.Lock()
+	// Expired jobs are dropped as new ones start
+	for id, old := range s.importJobs {
+		if old.CompletedAt != nil && time.Since(*old.CompletedAt) > importJobTTL {
+			delete(s.importJobs, id)
+		}
+	}
+	s.importJobs[job.ID] = job
+	status := job.snapshot()
+	s.importMu.Unlock()
+	s.recordAudit(r, user, "import.create", "import", job.ID.String(), nil, status)
+
+	s.jobs.Add(1)
+	go s.runImportJob(job)
+
+	w.Header().Set("Location", "/imports/"+job.ID.String())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, status)
+}
+
+// ListImports godoc
+// @Summary List import jobs
+// @Description Get all import jobs with their progress
+// @Tags imports
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.ImportListResponse
+// @Failure 403 {object} models.Problem
+// @Router /imports [get]
+func (s *Server) ListImports(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	_, ok := s.requireAdmin(w, r)
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	s.importMu.Lock()
+	jobs := make([]models.ImportJob, 0, len(s.importJobs))
+	for _, job := range s.importJobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	s.importMu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+
+	writeJSON(w, models.ImportListResponse{
+		Imports: jobs,
+		Total:   len(jobs),
+	})
+}
+
+// GetImport godoc
+// @Summary Get import job
+// @Description Get progress and the validation report of an import job
+// @Tags imports
+// @Accept json
+// @Produce json
+// @Param id path string true "Import job ID"
+// @Success 200 {object} models.ImportJob
+// @Failure 404 {object} models.Problem
+// @Router /imports/{id} [get]
+func (s *Server) GetImport(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	_, ok := s.requireAdmin(w, r)
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid import ID")
+		return
+	}
+
+	s.importMu.Lock()
+	job, exists := s.importJobs[id]
+	var status models.ImportJob
+	if exists {
+		status = job.snapshot()
+	}
+	s.importMu.Unlock()
+
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "import_job_not_found", "Import job not found")
+		return
+	}
+
+	writeJSON(w, status)
+}
+
+// runImportJob parses the file of an import, then validates and stores its
+// rows in batches. The job fails if the file cannot be read; rows that
+// cannot be parsed are reported like those that are rejected.
+func (s *Server) runImportJob(job *importJob) {
+	defer s.jobs.Done()
+
+	s.importMu.Lock()
+	job.Status = models.ImportJobRunning
+	data := job.data
+	job.data = nil
+	s.importMu.Unlock()
+
+	parse := parseCSVImport
+	if job.Format == "ndjson" {
+		parse = parseNDJSONImport
+	}
+	rows, err := parse(bytes.NewReader(data))
+	if err != nil {
+		s.finishImportJob(job, err)
+		return
+	}
+	s.importMu.Lock()
+	job.TotalRows = len(rows)
+	s.importMu.Unlock()
+
+	for start := 0; start < len(rows); start += importBatchSize {
+		batch := rows[start:min(start+importBatchSize, len(rows))]
+
+		var rowErrors []models.ImportRowError
+		imported := 0
+		if job.DryRun {
+			s.mu.RLock()
+		} else {
+			s.mu.Lock()
+		}
+		for _, row := range batch {
+			if row.err != nil {
+				rowErrors = append(rowErrors, models.ImportRowError{Row: row.line, Message: row.err.Error()})
+				continue
+			}
+			reading, err := s.importReading(job.mapping, row)
+			if err != nil {
+				rowErrors = append(rowErrors, models.ImportRowError{Row: row.line, Message: err.Error()})
+				continue
+			}
+			if !job.DryRun {
+				s.storeReading(reading, sourceImport)
+			}
+			imported++
+		}
+		if job.DryRun {
+			s.mu.RUnlock()
+		} else {
+			s.mu.Unlock()
+		}
+
+		s.importMu.Lock()
+		job.ProcessedRows += len(batch)
+		job.ImportedRows += imported
+		job.FailedRows += len(rowErrors)
+		for _, e := range rowErrors {
+			if len(job.Errors) >= maxImportErrors {
+				break
+			}
+			job.Errors = append(job.Errors, e)
+		}
+		s.importMu.Unlock()
+	}
+
+	s.finishImportJob(job, nil)
+}
+
+// finishImportJob marks an import completed, or failed with err, and audits
+// it unless it was a dry run
+func (s *Server) finishImportJob(job *importJob, err error) {
+	s.importMu.Lock()
+	now := time.Now()
+	job.Status = models.ImportJobCompleted
+	if err != nil {
+		job.Status = models.ImportJobFailed
+		job.Error = err.Error()
+	}
+	job.CompletedAt = &now
+	status := job.snapshot()
+	s.importMu.Unlock()
+
+	if !job.DryRun {
+		status.Errors = nil
+		s.appendAudit(job.audit, nil, status)
+	}
+}
+
+// importReading converts a row to a reading using the mapping; the caller
+// must hold mu
+func (s *Server) importReading(mapping models.ImportMapping, row importRow) (*models.MetricReading, error) {
+	metricID := mapping.MetricID
+	switch {
+	case mapping.MetricIDField != "":
+		id, err := uuid.Parse(row.fields[mapping.MetricIDField])
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric ID %q", row.fields[mapping.MetricIDField])
+		}
+		metricID = id
+	case mapping.SerialField != "":
+		serial := row.fields[mapping.SerialField]
+		metric := s.metricBySerial(serial)
+		if metric == nil {
+			return nil, fmt.Errorf("no metric for meter serial %q", serial)
+		}
+		metricID = metric.ID
+	}
+
+	metric, exists := s.activeMetric(metricID)
+	if !exists {
+		return nil, fmt.Errorf("metric not found")
+	}
+
+	if mapping.UnitField != "" {
+		if unit := row.fields[mapping.UnitField]; !strings.EqualFold(unit, metric.Unit) {
+			return nil, fmt.Errorf("unit %q does not match metric unit %q", unit, metric.Unit)
+		}
+	}
+
+	rawValue, ok := row.fields[mapping.ValueField]
+	if !ok || rawValue == "" {
+		return nil, fmt.Errorf("missing value")
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(rawValue), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", rawValue)
+	}
+
+	rawTimestamp := row.fields[mapping.TimestampField]
+	if rawTimestamp == "" {
+		return nil, fmt.Errorf("missing timestamp")
+	}
+	timestamp, err := parseImportTimestamp(rawTimestamp, mapping.TimestampFormat)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp %q", rawTimestamp)
+	}
+
+	return s.newReading(metricID, models.AddReadingRequest{
+		Value:     &value,
+		Timestamp: timestamp,
+	})
+}
+
+// metricBySerial finds the metric measured by the meter with the given serial
+// number; the caller must hold mu
+func (s *Server) metricBySerial(serial string) *models.Metric {
+	if serial == "" {
+		return nil
+	}
+	for _, metric := range s.metrics {
+		if metric.DeletedAt == nil && metric.MeterSerial == serial {
+			return metric
+		}
+	}
+	return nil
+}
+
+// parseImportTimestamp parses a timestamp in the given layout, as Unix
+// seconds or milliseconds, or as RFC3339 when no layout is given
+func parseImportTimestamp(value, layout string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	switch layout {
+	case "":
+		return time.Parse(time.RFC3339, value)
+	case "unix", "unix_ms":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if layout == "unix_ms" {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(layout, value)
+}
+
+// parseCSVImport reads a CSV file whose first row names the columns.
+// Malformed records are returned with their error.
+func parseCSVImport(r io.Reader) ([]importRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("missing header row")
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, importRow{line: parseErr.StartLine, err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Quoted fields may span lines
+		line, _ := cr.FieldPos(0)
+
+		fields := make(map[string]string, len(header))
+		for i, name := range header {
+			if i < len(record) {
+				fields[name] = record[i]
+			}
+		}
+		rows = append(rows, importRow{line: line, fields: fields})
+	}
+
+	return rows, nil
+}
+
+// parseNDJSONImport reads a file with one JSON object per line; blank lines
+// are skipped and malformed ones returned with their error
+func parseNDJSONImport(r io.Reader) ([]importRow, error) {
+	br := bufio.NewReader(r)
+
+	var rows []importRow
+	for line := 1; ; line++ {
+		data, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			fields, err := parseNDJSONLine(data)
+			rows = append(rows, importRow{line: line, fields: fields, err: err})
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rows, nil
+}
+
+// parseNDJSONLine reads the fields of the JSON object on a line
+func parseNDJSONLine(data []byte) (map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var object map[string]interface{}
+	if err := dec.Decode(&object); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("more than one JSON value")
+	}
+
+	fields := make(map[string]string, len(object))
+	for key, value := range object {
+		switch v := value.(type) {
+		case string:
+			fields[key] = v
+		case json.Number:
+			fields[key] = v.String()
+		case nil:
+		default:
+			fields[key] = fmt.Sprint(v)
+		}
+	}
+	return fields, nil
+}
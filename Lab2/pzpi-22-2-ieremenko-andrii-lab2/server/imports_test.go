@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+func TestParseImportLines(t *testing.T) {
+	ndjson := "{\"value\": 1}\n\n  \n{\"value\": 2}\r\n{\"value\": \n{\"value\": 1} {\"value\": 2}\n{\"value\": 3}"
+	rows, err := parseNDJSONImport(strings.NewReader(ndjson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLines := []int{1, 4, 5, 6, 7}
+	if len(rows) != len(wantLines) {
+		t.Fatalf("got rows %+v, want lines %v", rows, wantLines)
+	}
+	for i, row := range rows {
+		if row.line != wantLines[i] {
+			t.Errorf("row %d: got line %d, want %d", i, row.line, wantLines[i])
+		}
+		// Malformed lines are returned with their error
+		if malformed := row.line == 5 || row.line == 6; (row.err != nil) != malformed {
+			t.Errorf("line %d: got error %v", row.line, row.err)
+		}
+	}
+	if rows[4].fields["value"] != "3" {
+		t.Errorf("got fields %v, want value 3", rows[4].fields)
+	}
+
+	csv := "timestamp,value,note\n2024-01-01T00:00:00Z,1,\"two\nlines\"\n2024-01-02T00:00:00Z,2,x\"y\n2024-01-03T00:00:00Z,3,\n"
+	if rows, err = parseCSVImport(strings.NewReader(csv)); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0].line != 2 || rows[1].line != 4 || rows[1].err == nil || rows[2].line != 5 || rows[2].err != nil {
+		t.Errorf("got rows %+v, want lines 2, 4 and 5 with 4 malformed", rows)
+	}
+
+	if _, err := parseCSVImport(strings.NewReader("")); err == nil {
+		t.Error("a file without a header was parsed")
+	}
+}
+
+// upload posts an import file with its mapping and returns the job started
+func (api *testAPI) upload(name, content string, mapping models.ImportMapping) models.ImportJob {
+	api.t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", name)
+	part.Write([]byte(content))
+	data, _ := json.Marshal(mapping)
+	form.WriteField("mapping", string(data))
+	form.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, api.url+"/imports", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+api.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var job models.ImportJob
+	if resp.StatusCode != http.StatusAccepted || json.NewDecoder(resp.Body).Decode(&job) != nil {
+		api.t.Fatalf("upload: got status %d", resp.StatusCode)
+	}
+	return job
+}
+
+// waitImport waits for an import job to finish and returns it
+func (api *testAPI) waitImport(job models.ImportJob) models.ImportJob {
+	api.t.Helper()
+	path := "/imports/" + job.ID.String()
+	waitFor(api.t, "the import job", func() bool {
+		api.expect(http.MethodGet, path, nil, http.StatusOK, &job)
+		return job.Status == models.ImportJobCompleted || job.Status == models.ImportJobFailed
+	})
+	return job
+}
+
+func TestImportJobs(t *testing.T) {
+	api := newTestAPI(t)
+	metric := api.createMetric("Meter")
+
+	// A job finished long ago, to be dropped when the next one starts
+	expired := uuid.New()
+	finished := time.Now().Add(-importJobTTL - time.Minute)
+	api.s.importJobs[expired] = &importJob{ImportJob: models.ImportJob{ID: expired, Status: models.ImportJobCompleted, CompletedAt: &finished}}
+
+	file := "{\"timestamp\": \"2024-01-01T00:00:00Z\", \"value\": 1}\n" +
+		"\n" +
+		"{\"timestamp\": \"2024-01-01T01:00:00Z\", \"value\": \"x\"}\n" +
+		"{\"timestamp\": \"2024-01-01T02:00:00Z\", \n" +
+		"{\"timestamp\": \"2024-01-01T03:00:00Z\", \"value\": 4}\n"
+	job := api.waitImport(api.upload("readings.ndjson", file, models.ImportMapping{MetricID: metric.ID}))
+	if job.Status != models.ImportJobCompleted || job.TotalRows != 4 || job.ImportedRows != 2 || job.FailedRows != 2 ||
+		len(job.Errors) != 2 || job.Errors[0].Row != 3 || job.Errors[1].Row != 4 {
+		t.Errorf("got job %+v, want the rows on lines 3 and 4 rejected", job)
+	}
+
+	// Files that cannot be read at all fail the job
+	job = api.waitImport(api.upload("readings.csv", "", models.ImportMapping{MetricID: metric.ID}))
+	if job.Status != models.ImportJobFailed || job.Error == "" {
+		t.Errorf("got job %+v, want failed", job)
+	}
+
+	api.expectProblem(http.MethodGet, "/imports/"+expired.String(), nil, http.StatusNotFound, "import_job_not_found")
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+func TestMetricVersions(t *testing.T) {
+	api := newTestAPI(t)
+	metric := api.createMetric("Meter")
+	path := "/metrics/" + metric.ID.String()
+
+	resp := api.expect(http.MethodGet, path, nil, http.StatusOK, nil)
+	cached := resp.Header.Get("ETag")
+	if cached != `"1.0"` {
+		t.Fatalf("got ETag %s, want \"1.0\"", cached)
+	}
+
+	// A reading changes the cached representation but not the metric
+	value := 5.0
+	var reading models.MetricReading
+	api.expect(http.MethodPost, path+"/readings", models.AddReadingRequest{Value: &value}, http.StatusOK, &reading)
+	api.expect(http.MethodGet, path, nil, http.StatusOK, nil, "If-None-Match", cached)
+	resp = api.expect(http.MethodGet, path+"/readings", nil, http.StatusOK, nil)
+	readings := resp.Header.Get("ETag")
+	if readings != `"1.1"` {
+		t.Fatalf("got readings ETag %s, want \"1.1\"", readings)
+	}
+	api.expect(http.MethodGet, path+"/readings", nil, http.StatusNotModified, nil, "If-None-Match", readings)
+
+	name := "Main meter"
+	var updated models.Metric
+	api.expect(http.MethodPatch, path, models.UpdateMetricRequest{Name: &name}, http.StatusOK, &updated, "If-Match", cached)
+	if updated.Version != 2 || updated.ReadingsVersion != 1 {
+		t.Errorf("got versions %d.%d, want 2.1", updated.Version, updated.ReadingsVersion)
+	}
+	api.expectProblem(http.MethodPatch, path, models.UpdateMetricRequest{Name: &name}, http.StatusPreconditionFailed, "precondition_failed", "If-Match", `"1"`)
+
+	// Corrections must name the readings they were made against
+	correction := models.CorrectReadingRequest{Value: &value, Reason: "misread"}
+	readingPath := path + "/readings/" + reading.ID.String()
+	api.expectProblem(http.MethodPatch, readingPath, correction, http.StatusPreconditionFailed, "precondition_failed", "If-Match", cached)
+	api.expect(http.MethodPatch, readingPath, correction, http.StatusOK, nil, "If-Match", `"2.1"`)
+	api.expectProblem(http.MethodPatch, readingPath, correction, http.StatusPreconditionFailed, "precondition_failed", "If-Match", `"2.1"`)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// contextKey is the type of keys for values the server stores in a request context
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	authenticatorKey
+	maxBodySizeKey
+	gatewayKey
+	deviceCredentialKey
+)
+
+// withRequestID tags every request with an ID, taken from the X-Request-ID
+// header when the client sends one, and echoes it in the response
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// requestID returns the ID assigned to the request by withRequestID
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// sourceIP returns the address of the client that sent the request
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/modbus"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// modbusMaxBackoff is the longest wait between polls of a failing device,
+// unless its interval is longer
+const modbusMaxBackoff = 5 * time.Minute
+
+// ModbusDevice is a meter polled over Modbus TCP
+type ModbusDevice struct {
+	Name      string
+	Host      string // host:port; port 502 if omitted
+	UnitID    byte
+	Serial    string // serial of the registry device the polls count as seen, if any
+	Interval  time.Duration
+	Timeout   time.Duration // bounds each request; 10 seconds when zero
+	Registers []ModbusRegister
+}
+
+// ModbusRegister is a register whose value is recorded as a reading of a
+// metric
+type ModbusRegister struct {
+	modbus.Register
+	MetricID uuid.UUID
+}
+
+// RunModbusPoller polls the registers of devices and records their values
+// as readings until ctx is done. Each device is polled on its own
+// connection, which is kept open between polls. After a failed poll the
+// connection is dropped and the wait before the next one doubles with each
+// failure, up to five minutes or the interval if longer.
+func (s *Server) RunModbusPoller(ctx context.Context, devices []ModbusDevice) {
+	statuses := make([]*models.ModbusDeviceStatus, 0, len(devices))
+	for _, d := range devices {
+		status := &models.ModbusDeviceStatus{
+			Name:      d.Name,
+			Host:      d.Host,
+			UnitID:    d.UnitID,
+			Serial:    d.Serial,
+			State:     models.ModbusPending,
+			Interval:  d.Interval.String(),
+			Registers: make([]models.ModbusRegisterStatus, len(d.Registers)),
+		}
+		for i, reg := range d.Registers {
+			status.Registers[i] = models.ModbusRegisterStatus{MetricID: reg.MetricID, Address: reg.Address}
+		}
+		statuses = append(statuses, status)
+	}
+
+	s.modbusMu.Lock()
+	s.modbusStatus = statuses
+	s.modbusMu.Unlock()
+
+	var wg sync.WaitGroup
+	for i, d := range devices {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.pollModbusDevice(ctx, d, statuses[i])
+		}()
+	}
+	wg.Wait()
+}
+
+// pollModbusDevice polls one device until ctx is done
+func (s *Server) pollModbusDevice(ctx context.Context, d ModbusDevice, status *models.ModbusDeviceStatus) {
+	var client *modbus.Client
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	failures := 0
+	wait := d.Interval
+	for {
+		values, err := s.pollModbus(ctx, &client, d)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			failures++
+			wait = modbusBackoff(d.Interval, wait)
+			log.Printf("Modbus device %s: %v; polling again in %s", d.Name, err, wait)
+			// The connection may be out of step after a failure
+			if client != nil {
+				client.Close()
+				client = nil
+			}
+		} else {
+			if failures > 0 {
+				log.Printf("Modbus device %s is responding again", d.Name)
+			}
+			failures = 0
+			wait = d.Interval
+		}
+		s.setModbusStatus(status, values, err, failures, wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// modbusBackoff returns the wait after another failed poll of a device,
+// double the previous one up to five minutes or the interval if longer
+func modbusBackoff(interval, previous time.Duration) time.Duration {
+	limit := max(interval, modbusMaxBackoff)
+	if previous >= limit/2 {
+		return limit
+	}
+	return previous * 2
+}
+
+// pollModbus reads the registers of a device, connecting first if needed,
+// and records their values. It returns the values read, by register index.
+func (s *Server) pollModbus(ctx context.Context, client **modbus.Client, d ModbusDevice) (map[int]float64, error) {
+	if *client == nil {
+		c, err := modbus.Dial(ctx, d.Host, d.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		*client = c
+	}
+
+	values := make(map[int]float64, len(d.Registers))
+	var errs []error
+	for i, reg := range d.Registers {
+		value, err := (*client).Read(ctx, d.UnitID, reg.Register)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("register %d: %w", reg.Address, err))
+			// Only an exception leaves the connection usable
+			var exception *modbus.ExceptionError
+			if !errors.As(err, &exception) {
+				break
+			}
+			continue
+		}
+		values[i] = value
+	}
+
+	if len(values) > 0 {
+		if err := s.recordModbusReadings(d, values); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return values, errors.Join(errs...)
+}
+
+// recordModbusReadings stores the values read from a device as readings
+// and marks the device as seen in the registry
+func (s *Server) recordModbusReadings(d ModbusDevice, values map[int]float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var errs []error
+	for i, value := range values {
+		metricID := d.Registers[i].MetricID
+		// Readings go through the checks of AddReading
+		reading, err := s.newReading(metricID, models.AddReadingRequest{Value: &value, Timestamp: now})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("metric %s: %w", metricID, err))
+			continue
+		}
+		s.storeReading(reading, sourceModbus)
+	}
+
+	if d.Serial != "" {
+		for _, device := range s.devices {
+			if device.Serial == d.Serial {
+				device.LastSeenAt = &now
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setModbusStatus records the outcome of a poll
+func (s *Server) setModbusStatus(status *models.ModbusDeviceStatus, values map[int]float64, err error, failures int, wait time.Duration) {
+	s.modbusMu.Lock()
+	defer s.modbusMu.Unlock()
+
+	now := time.Now()
+	next := now.Add(wait)
+	status.LastPollAt = &now
+	status.NextPollAt = &next
+	status.ConsecutiveFailures = failures
+	if err != nil {
+		status.State = models.ModbusFailing
+		status.LastError = err.Error()
+	} else {
+		status.State = models.ModbusOK
+		status.LastSuccessAt = &now
+		status.LastError = ""
+	}
+	for i, value := range values {
+		status.Registers[i].Value = &value
+		status.Registers[i].ReadAt = &now
+	}
+}
+
+// GetModbusStatus godoc
+// @Summary Get the Modbus poller status
+// @Description Get how polling each configured Modbus device goes, with the last values read
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.ModbusStatusResponse
+// @Failure 403 {object} models.Problem
+// @Router /admin/modbus [get]
+func (s *Server) GetModbusStatus(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	s.modbusMu.Lock()
+	defer s.modbusMu.Unlock()
+
+	response := models.ModbusStatusResponse{Devices: make([]models.ModbusDeviceStatus, 0)}
+	for _, status := range s.modbusStatus {
+		c := *status
+		c.Registers = slices.Clone(status.Registers)
+		response.Devices = append(response.Devices, c)
+	}
+	writeJSON(w, response)
+}
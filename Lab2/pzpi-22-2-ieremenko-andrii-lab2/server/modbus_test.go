@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/modbus"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+func TestModbusBackoff(t *testing.T) {
+	tests := []struct {
+		interval, previous, want time.Duration
+	}{
+		{time.Second, time.Second, 2 * time.Second},
+		{time.Second, 4 * time.Minute, modbusMaxBackoff},
+		{time.Second, modbusMaxBackoff, modbusMaxBackoff},
+		{10 * time.Minute, 10 * time.Minute, 10 * time.Minute},
+		// Intervals long enough to overflow when doubled
+		{math.MaxInt64, math.MaxInt64, math.MaxInt64},
+		{time.Duration(1) << 62, time.Duration(1) << 62, time.Duration(1) << 62},
+	}
+	for _, tt := range tests {
+		if got := modbusBackoff(tt.interval, tt.previous); got != tt.want {
+			t.Errorf("modbusBackoff(%s, %s) = %s, want %s", tt.interval, tt.previous, got, tt.want)
+		}
+	}
+
+	// Waits never shrink or go negative however long a device fails
+	wait := time.Duration(1) << 40
+	for range 100 {
+		next := modbusBackoff(wait, wait)
+		if next < wait {
+			t.Fatalf("wait shrank from %s to %s", wait, next)
+		}
+		wait = next
+	}
+}
+
+// modbusStatus returns a copy of the status of the first polled device
+func modbusStatus(s *Server) models.ModbusDeviceStatus {
+	s.modbusMu.Lock()
+	defer s.modbusMu.Unlock()
+	if len(s.modbusStatus) == 0 {
+		return models.ModbusDeviceStatus{}
+	}
+	return *s.modbusStatus[0]
+}
+
+func TestModbusPoller(t *testing.T) {
+	api := newTestAPI(t)
+	metric := api.createMetric("Meter")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := modbus.NewSimulator()
+	go sim.Serve(ln)
+	defer sim.Close()
+	energy := modbus.Register{Address: 10, Type: modbus.Float32, Scale: 0.5}
+	if err := sim.SetValue(3, energy, 42.5); err != nil {
+		t.Fatal(err)
+	}
+
+	const interval = 10 * time.Millisecond
+	device := ModbusDevice{
+		Name:      "meter",
+		Host:      ln.Addr().String(),
+		UnitID:    3,
+		Interval:  interval,
+		Timeout:   time.Second,
+		Registers: []ModbusRegister{{Register: energy, MetricID: metric.ID}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		api.s.RunModbusPoller(ctx, []ModbusDevice{device})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor(t, "a reading", func() bool { return hasReading(api.s, metric.ID, 42.5) })
+	if status := modbusStatus(api.s); status.State != models.ModbusOK {
+		t.Fatalf("got state %s, want ok", status.State)
+	}
+
+	// Once the meter is gone, polls back off
+	sim.Close()
+	waitFor(t, "failed polls", func() bool { return modbusStatus(api.s).ConsecutiveFailures >= 3 })
+	status := modbusStatus(api.s)
+	if status.State != models.ModbusFailing || status.LastError == "" {
+		t.Errorf("got state %s, error %q; want failing", status.State, status.LastError)
+	}
+	if wait := status.NextPollAt.Sub(*status.LastPollAt); wait != interval<<status.ConsecutiveFailures {
+		t.Errorf("after %d failures got wait %s, want %s", status.ConsecutiveFailures, wait, interval<<status.ConsecutiveFailures)
+	}
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/mqtt"
+	"github.com/google/uuid"
+)
+
+// mqttRecentSize is how many stored messages the bridge remembers to
+// recognize ones the broker delivers again
+const mqttRecentSize = 4096
+
+// mqttBridge records readings from the messages of an MQTT broker
+type mqttBridge struct {
+	s      *Server
+	routes []models.MQTTRoute
+
+	// recent holds the most recently stored messages, oldest first in order
+	recent map[string]bool
+	order  []string
+}
+
+// RunMQTTBridge subscribes to the topics of routes on broker and records the
+// readings published there, reconnecting until ctx is done.
+//
+// Delivery is at least once: the bridge keeps a persistent session, so the
+// broker holds messages while it is away, and acknowledges each message only
+// once its reading is stored or found invalid. Messages delivered again are
+// recognized, when they carry a timestamp by the reading already stored and
+// otherwise by the recently stored messages, and recorded once.
+func (s *Server) RunMQTTBridge(ctx context.Context, broker string, opts mqtt.Options, routes []models.MQTTRoute) {
+	b := &mqttBridge{s: s, routes: routes, recent: make(map[string]bool)}
+	opts.CleanSession = false
+
+	backoff := time.Second
+	for {
+		connected, err := b.run(ctx, broker, opts)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+		log.Printf("MQTT bridge: %v; reconnecting in %s", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// GuardMQTTBroker sets who may connect to the embedded broker and what they
+// may do there. The bridge logs in with the options it uses and may do
+// anything; only it may use its client ID. Devices log in with their serial
+// number as user name and an API key as password. They may only publish
+// messages that routes record to the device's own metrics, and may not
+// subscribe. Without a bridge user name only devices log in.
+func (s *Server) GuardMQTTBroker(broker *mqtt.Broker, bridge mqtt.Options, routes []models.MQTTRoute) {
+	isBridge := func(user, pass string) bool {
+		return bridge.Username != "" &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(bridge.Username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pass), []byte(bridge.Password)) == 1
+	}
+
+	broker.Authenticate = func(clientID, user, pass string) bool {
+		if isBridge(user, pass) {
+			return true
+		}
+		// The bridge's user name and client ID are the bridge's alone
+		if user == bridge.Username || clientID == bridge.ClientID || !strings.HasPrefix(pass, deviceKeyPrefix) {
+			return false
+		}
+
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		device, _ := s.deviceByKey(pass)
+		return device != nil && device.Serial == user
+	}
+
+	broker.AuthorizePublish = func(user, topic string, payload []byte) bool {
+		if bridge.Username != "" && user == bridge.Username {
+			return true
+		}
+		route, levels, ok := mqttRoute(routes, topic)
+		if !ok {
+			return false
+		}
+		metricID, _, err := parseMQTTPayload(route, levels, payload)
+		if err != nil {
+			return false
+		}
+
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		device := s.deviceBySerial(user)
+		return device != nil && slices.Contains(device.MetricIDs, metricID)
+	}
+
+	broker.AuthorizeSubscribe = func(user, _ string) bool {
+		return bridge.Username != "" && user == bridge.Username
+	}
+}
+
+// run handles the messages of one connection to the broker until it ends.
+// It reports whether the bridge got to subscribe.
+func (b *mqttBridge) run(ctx context.Context, broker string, opts mqtt.Options) (bool, error) {
+	client, err := mqtt.Dial(ctx, broker, opts)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	subs := make([]mqtt.Subscription, len(b.routes))
+	for i, route := range b.routes {
+		subs[i] = mqtt.Subscription{Filter: route.Topic, QoS: 1}
+	}
+	if err := client.Subscribe(ctx, subs...); err != nil {
+		return false, err
+	}
+	log.Printf("MQTT bridge subscribed to %d topics on %s", len(subs), broker)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case m, ok := <-client.Messages():
+			if !ok {
+				return true, client.Err()
+			}
+			// Messages that cannot be recorded are dropped; delivering them
+			// again would not help
+			if err := b.handle(m); err != nil {
+				log.Printf("MQTT bridge: dropping message on %s: %v", m.Topic, err)
+			}
+			if err := client.Ack(m); err != nil {
+				return true, err
+			}
+		}
+	}
+}
+
+// handle records the reading a message carries
+func (b *mqttBridge) handle(m mqtt.Message) error {
+	route, levels, ok := b.route(m.Topic)
+	if !ok {
+		return fmt.Errorf("no route matches the topic")
+	}
+	metricID, req, err := parseMQTTPayload(route, levels, m.Payload)
+	if err != nil {
+		return err
+	}
+
+	s := b.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b.duplicate(m, metricID, req) {
+		return nil
+	}
+
+	// Readings go through the checks of AddReading
+	reading, err := s.newReading(metricID, req)
+	if err != nil {
+		return err
+	}
+	s.storeReading(reading, sourceMQTT)
+	b.remember(m)
+	return nil
+}
+
+// route returns the first route whose topic filter matches topic, with the
+// levels matched by its + wildcards
+func (b *mqttBridge) route(topic string) (models.MQTTRoute, []string, bool) {
+	return mqttRoute(b.routes, topic)
+}
+
+// mqttRoute returns the first of routes whose topic filter matches topic,
+// with the levels matched by its + wildcards
+func mqttRoute(routes []models.MQTTRoute, topic string) (models.MQTTRoute, []string, bool) {
+	for _, route := range routes {
+		if levels, ok := mqtt.Wildcards(route.Topic, topic); ok {
+			return route, levels, true
+		}
+	}
+	return models.MQTTRoute{}, nil, false
+}
+
+// duplicate reports whether the message was recorded before; the caller
+// must hold mu
+func (b *mqttBridge) duplicate(m mqtt.Message, metricID uuid.UUID, req models.AddReadingRequest) bool {
+	if !req.Timestamp.IsZero() {
+		return b.s.hasReading(metricID, req.Timestamp, *req.Value)
+	}
+	return m.Duplicate && b.recent[mqttMessageKey(m)]
+}
+
+// remember adds a stored message to the recent ones
+func (b *mqttBridge) remember(m mqtt.Message) {
+	key := mqttMessageKey(m)
+	if b.recent[key] {
+		return
+	}
+	if len(b.order) >= mqttRecentSize {
+		delete(b.recent, b.order[0])
+		b.order = b.order[1:]
+	}
+	b.recent[key] = true
+	b.order = append(b.order, key)
+}
+
+// mqttMessageKey identifies a delivery of a message; the broker keeps the
+// packet identifier when it delivers a message again
+func mqttMessageKey(m mqtt.Message) string {
+	return strconv.Itoa(int(m.PacketID)) + "\x00" + m.Topic + "\x00" + string(m.Payload)
+}
+
+// parseMQTTPayload reads the metric and reading of a message
+func parseMQTTPayload(route models.MQTTRoute, levels []string, payload []byte) (uuid.UUID, models.AddReadingRequest, error) {
+	var req models.AddReadingRequest
+
+	metricID := route.MetricID
+	if len(levels) > 0 && route.Metrics != nil {
+		id, ok := route.Metrics[levels[0]]
+		if !ok {
+			return uuid.Nil, req, fmt.Errorf("no metric is mapped to %q", levels[0])
+		}
+		metricID = id
+	}
+
+	payload = bytes.TrimSpace(payload)
+	if value, err := strconv.ParseFloat(string(payload), 64); err == nil {
+		req.Value = &value
+		return checkMQTTMetric(metricID, req)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return uuid.Nil, req, fmt.Errorf("payload is neither a number nor a JSON object")
+	}
+
+	if raw, ok := fields[fieldOr(route.MetricField, "metric_id")]; ok {
+		s, _ := raw.(string)
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return uuid.Nil, req, fmt.Errorf("invalid metric ID %v", raw)
+		}
+		if !mapsMetric(route, id) {
+			return uuid.Nil, req, fmt.Errorf("metric %s is not mapped by the route", id)
+		}
+		metricID = id
+	}
+
+	valueField := fieldOr(route.ValueField, "value")
+	value, err := jsonFloat(fields[valueField])
+	if err != nil {
+		return uuid.Nil, req, fmt.Errorf("%s: %w", valueField, err)
+	}
+	req.Value = &value
+
+	timestampField := fieldOr(route.TimestampField, "timestamp")
+	if raw, ok := fields[timestampField]; ok {
+		if req.Timestamp, err = jsonTime(raw); err != nil {
+			return uuid.Nil, req, fmt.Errorf("%s: %w", timestampField, err)
+		}
+	}
+
+	return checkMQTTMetric(metricID, req)
+}
+
+// checkMQTTMetric fails when no metric is known for a message
+func checkMQTTMetric(metricID uuid.UUID, req models.AddReadingRequest) (uuid.UUID, models.AddReadingRequest, error) {
+	if metricID == uuid.Nil {
+		return uuid.Nil, req, fmt.Errorf("no metric is mapped to the message")
+	}
+	return metricID, req, nil
+}
+
+// mapsMetric reports whether a route records to a metric, so that publishers
+// may only pick among the metrics the route maps
+func mapsMetric(route models.MQTTRoute, metricID uuid.UUID) bool {
+	if metricID == route.MetricID {
+		return true
+	}
+	for _, id := range route.Metrics {
+		if id == metricID {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldOr(field, fallback string) string {
+	if field == "" {
+		return fallback
+	}
+	return field
+}
+
+// jsonFloat reads a number, or a string holding one
+func jsonFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case nil:
+		return 0, fmt.Errorf("missing")
+	default:
+		return 0, fmt.Errorf("not a number")
+	}
+}
+
+// jsonTime reads an RFC 3339 timestamp, or Unix seconds; values too large
+// to be seconds are taken as milliseconds
+func jsonTime(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case string:
+		return time.Parse(time.RFC3339, v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return time.Time{}, fmt.Errorf("invalid time %s", v)
+		}
+		if f > 1e12 {
+			return time.UnixMilli(int64(f)), nil
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid time %v", v)
+	}
+}
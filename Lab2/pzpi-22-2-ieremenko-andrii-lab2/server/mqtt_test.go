@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/mqtt"
+	"github.com/google/uuid"
+)
+
+// bridgeOptions are the options the bridge of startBroker logs in with
+var bridgeOptions = mqtt.Options{ClientID: "bridge", Username: "bridge", Password: "bridge-secret"}
+
+// startBroker starts an embedded broker guarded by s and a bridge recording
+// the messages of routes, and returns the broker URL
+func startBroker(t *testing.T, s *Server, routes []models.MQTTRoute) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := mqtt.NewBroker()
+	s.GuardMQTTBroker(broker, bridgeOptions, routes)
+	go broker.Serve(ln)
+	t.Cleanup(func() { broker.Close() })
+
+	url := "tcp://" + ln.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.RunMQTTBridge(ctx, url, bridgeOptions, routes)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return url
+}
+
+// createMetric creates a metric in a new room as api's user
+func (api *testAPI) createMetric(name string) models.Metric {
+	api.t.Helper()
+	room := api.createRoom(name)
+	var metric models.Metric
+	api.expect(http.MethodPost, "/metrics", models.CreateMetricRequest{Name: name, Unit: "kWh", Kind: "electricity", RoomID: room.ID}, http.StatusOK, &metric)
+	return metric
+}
+
+// currentReadings returns copies of the readings a metric has now
+func currentReadings(s *Server, metricID uuid.UUID) []models.MetricReading {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var readings []models.MetricReading
+	for _, reading := range s.readings[metricID] {
+		readings = append(readings, *reading)
+	}
+	return readings
+}
+
+// waitFor waits until ok holds
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// hasReading reports whether a metric has a reading of value
+func hasReading(s *Server, metricID uuid.UUID, value float64) bool {
+	for _, reading := range currentReadings(s, metricID) {
+		if reading.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMQTTAuthentication(t *testing.T) {
+	api := newTestAPI(t)
+	metric := api.createMetric("Meter")
+	var device models.Device
+	api.expect(http.MethodPost, "/devices", models.CreateDeviceRequest{Serial: "SN-1", MetricIDs: []uuid.UUID{metric.ID}}, http.StatusOK, &device)
+	var key models.DeviceKeyResponse
+	api.expect(http.MethodPost, "/devices/"+device.ID.String()+"/keys", models.CreateDeviceKeyRequest{Name: "mqtt"}, http.StatusOK, &key)
+
+	url := startBroker(t, api.s, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	refused := []mqtt.Options{
+		{ClientID: "anonymous"},
+		{ClientID: "guesser", Username: "bridge", Password: "wrong"},
+		{ClientID: "other-serial", Username: "SN-2", Password: key.Key},
+		{ClientID: "unknown-key", Username: "SN-1", Password: deviceKeyPrefix + "unknown"},
+		// Only the bridge may take over its session
+		{ClientID: bridgeOptions.ClientID, Username: "SN-1", Password: key.Key},
+	}
+	for _, opts := range refused {
+		if client, err := mqtt.Dial(ctx, url, opts); err == nil {
+			client.Close()
+			t.Errorf("%s: connected, want refused", opts.ClientID)
+		}
+	}
+
+	client, err := mqtt.Dial(ctx, url, mqtt.Options{ClientID: "meter", Username: "SN-1", Password: key.Key})
+	if err != nil {
+		t.Fatalf("device: %v", err)
+	}
+	client.Close()
+
+	// Revoked keys no longer log in
+	api.expect(http.MethodDelete, "/devices/"+device.ID.String()+"/keys/"+key.ID.String(), nil, http.StatusNoContent, nil)
+	if client, err := mqtt.Dial(ctx, url, mqtt.Options{ClientID: "meter", Username: "SN-1", Password: key.Key}); err == nil {
+		client.Close()
+		t.Error("revoked key: connected, want refused")
+	}
+}
+
+// createDevice registers a device recording to metrics and returns an API
+// key of it
+func (api *testAPI) createDevice(serial string, metrics ...uuid.UUID) models.DeviceKeyResponse {
+	api.t.Helper()
+	var device models.Device
+	api.expect(http.MethodPost, "/devices", models.CreateDeviceRequest{Serial: serial, MetricIDs: metrics}, http.StatusOK, &device)
+	var key models.DeviceKeyResponse
+	api.expect(http.MethodPost, "/devices/"+device.ID.String()+"/keys", models.CreateDeviceKeyRequest{}, http.StatusOK, &key)
+	return key
+}
+
+func TestMQTTAuthorization(t *testing.T) {
+	api := newTestAPI(t)
+	mine := api.createMetric("Mine")
+	theirs := api.createMetric("Theirs")
+	key := api.createDevice("SN-1", mine.ID)
+	api.createDevice("SN-2", theirs.ID)
+
+	url := startBroker(t, api.s, []models.MQTTRoute{
+		{Topic: "meters/+/energy", Metrics: map[string]uuid.UUID{"SN-1": mine.ID, "SN-2": theirs.ID}},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := mqtt.Dial(ctx, url, mqtt.Options{ClientID: "meter", Username: "SN-1", Password: key.Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Devices may not read what others publish
+	for _, filter := range []string{"#", "meters/SN-2/energy"} {
+		if err := client.Subscribe(ctx, mqtt.Subscription{Filter: filter}); err == nil {
+			t.Errorf("subscribing to %s: got no error, want refused", filter)
+		}
+	}
+
+	publish := func(topic, payload string) {
+		t.Helper()
+		if err := client.Publish(ctx, topic, []byte(payload), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the bridge to subscribe", func() bool {
+		publish("meters/SN-1/energy", "1")
+		return hasReading(api.s, mine.ID, 1)
+	})
+	// Dropped by the broker: another meter's topic, and another meter's
+	// metric named in the payload
+	publish("meters/SN-2/energy", "5")
+	publish("meters/SN-1/energy", `{"metric_id": "`+theirs.ID.String()+`", "value": 6}`)
+	publish("meters/SN-1/energy", "2")
+
+	waitFor(t, "the last reading", func() bool { return hasReading(api.s, mine.ID, 2) })
+	if readings := currentReadings(api.s, theirs.ID); len(readings) != 0 {
+		t.Errorf("a device recorded %+v to another meter's metric", readings)
+	}
+}
+
+func TestMQTTBridge(t *testing.T) {
+	api := newTestAPI(t)
+	energy := api.createMetric("Energy")
+	water := api.createMetric("Water")
+	other := api.createMetric("Other")
+
+	url := startBroker(t, api.s, []models.MQTTRoute{
+		{Topic: "meters/+/energy", Metrics: map[string]uuid.UUID{"SN-1": energy.ID}},
+		{Topic: "water/main", MetricID: water.ID, ValueField: "v", TimestampField: "ts"},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// A client with the bridge's credentials may publish anything, so the
+	// bridge itself decides what is recorded
+	publisher := bridgeOptions
+	publisher.ClientID = "publisher"
+	client, err := mqtt.Dial(ctx, url, publisher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	publish := func(topic, payload string) {
+		t.Helper()
+		if err := client.Publish(ctx, topic, []byte(payload), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The bridge subscribes once connected; until then messages are lost
+	waitFor(t, "the bridge to subscribe", func() bool {
+		publish("meters/SN-1/energy", "1")
+		return hasReading(api.s, energy.ID, 1)
+	})
+	// Dropped: an unmapped topic level, and metrics the route does not map
+	publish("meters/SN-9/energy", "5")
+	publish("water/main", `{"metric_id": "`+other.ID.String()+`", "v": 7}`)
+	publish("meters/SN-1/energy", `{"metric_id": "`+water.ID.String()+`", "value": 8}`)
+	// Recorded
+	publish("water/main", `{"metric_id": "`+water.ID.String()+`", "v": 3.5, "ts": "2024-01-01T10:00:00Z"}`)
+	publish("meters/SN-1/energy", `{"value": 2}`)
+
+	// The bridge handles messages in order, so the dropped ones are done
+	waitFor(t, "the last reading", func() bool { return hasReading(api.s, energy.ID, 2) })
+	readings := currentReadings(api.s, water.ID)
+	if len(readings) != 1 || readings[0].Value != 3.5 || !readings[0].Timestamp.Equal(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("got water readings %+v, want 3.5 at 2024-01-01T10:00:00Z", readings)
+	}
+	if hasReading(api.s, energy.ID, 8) || len(currentReadings(api.s, other.ID)) != 0 {
+		t.Error("a payload was recorded to a metric its route does not map")
+	}
+}
+
+func TestParseMQTTPayload(t *testing.T) {
+	mapped, unmapped := uuid.New(), uuid.New()
+	route := models.MQTTRoute{Topic: "meters/+", MetricID: mapped, Metrics: map[string]uuid.UUID{"a": mapped}}
+
+	tests := []struct {
+		name    string
+		levels  []string
+		payload string
+		want    uuid.UUID
+		wantErr bool
+	}{
+		{"number", []string{"a"}, " 12.5 ", mapped, false},
+		{"mapped metric", []string{"a"}, `{"metric_id": "` + mapped.String() + `", "value": 1}`, mapped, false},
+		{"unmapped metric", []string{"a"}, `{"metric_id": "` + unmapped.String() + `", "value": 1}`, uuid.Nil, true},
+		{"unmapped level", []string{"b"}, "1", uuid.Nil, true},
+		{"invalid metric", []string{"a"}, `{"metric_id": "x", "value": 1}`, uuid.Nil, true},
+		{"no value", []string{"a"}, `{}`, uuid.Nil, true},
+		{"garbage", []string{"a"}, `on`, uuid.Nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := parseMQTTPayload(route, tt.levels, []byte(tt.payload))
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("got %s, %v; want %s, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
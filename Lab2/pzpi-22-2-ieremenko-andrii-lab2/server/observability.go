@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/prom"
+	"github.com/google/uuid"
+)
+
+// maxRemoteWriteSize bounds the decompressed size of a remote write request
+const maxRemoteWriteSize = 32 << 20
+
+// ObservabilityServer serves what operators monitor a Server with, on a
+// listener of its own since /metrics of the API is the household metric
+// API: operational metrics and the latest value of every household metric
+// in the Prometheus format and, when enabled, a Prometheus remote write
+// receiver recording the pushed samples as readings.
+type ObservabilityServer struct {
+	server *Server
+	auth   Authenticator
+
+	remoteWrite bool
+	routes      []models.RemoteWriteRoute
+}
+
+// NewObservabilityServer creates an observability server for s. With a
+// token, requests must send it as a bearer token.
+func NewObservabilityServer(s *Server, token string) *ObservabilityServer {
+	o := &ObservabilityServer{server: s}
+	if token != "" {
+		o.auth = ServiceToken(token)
+	}
+	return o
+}
+
+// EnableRemoteWrite accepts remote writes. Series are recorded to the metric
+// of their metric_id label if a route maps it, else of the first route
+// matching them.
+func (o *ObservabilityServer) EnableRemoteWrite(routes []models.RemoteWriteRoute) {
+	o.remoteWrite = true
+	o.routes = routes
+}
+
+// Handler returns the HTTP handler serving the observability endpoints
+func (o *ObservabilityServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", o.Metrics)
+	if o.remoteWrite {
+		mux.HandleFunc("POST /api/v1/write", o.RemoteWrite)
+	}
+	return withRequestID(o.withToken(withProblemErrors(mux)))
+}
+
+// withToken rejects requests without the token, if one is required
+func (o *ObservabilityServer) withToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if o.auth != nil {
+			if _, err := o.auth(r); err != nil {
+				writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Metrics writes the metrics in the Prometheus text exposition format
+func (o *ObservabilityServer) Metrics(w http.ResponseWriter, r *http.Request) {
+	s := o.server
+
+	// The response is built first so that a slow scraper does not hold mu
+	var buf bytes.Buffer
+	pw := prom.NewWriter(&buf)
+	s.telemetry.requests.Write(pw)
+	s.telemetry.ingested.Write(pw)
+	if o.remoteWrite {
+		s.telemetry.remoteWrite.Write(pw)
+	}
+
+	s.writeEventStats(pw)
+
+	s.mu.RLock()
+	s.writeStoreSize(pw)
+	s.writeMetricValues(pw)
+	s.mu.RUnlock()
+
+	if s.stateFile != "" {
+		if info, err := os.Stat(s.stateFile); err == nil {
+			pw.Family("hm_state_file_bytes", "gauge", "Size of the state file.")
+			pw.Sample("hm_state_file_bytes", nil, float64(info.Size()))
+		}
+	}
+	pw.Flush()
+
+	w.Header().Set("Content-Type", prom.ContentType)
+	w.Write(buf.Bytes())
+}
+
+// writeStoreSize writes how many objects of each type are stored, including
+// those in the trash; the caller must hold mu
+func (s *Server) writeStoreSize(pw *prom.Writer) {
+	readings := 0
+	for _, rs := range s.readings {
+		readings += len(rs)
+	}
+	pw.Family("hm_store_objects", "gauge", "Objects stored, including those in the trash.")
+	for _, c := range []struct {
+		kind  string
+		count int
+	}{
+		{"users", len(s.users)},
+		{"rooms", len(s.rooms)},
+		{"metrics", len(s.metrics)},
+		{"readings", readings},
+		{"devices", len(s.devices)},
+	} {
+		pw.Sample("hm_store_objects", []prom.Label{{Name: "type", Value: c.kind}}, float64(c.count))
+	}
+}
+
+// writeMetricValues writes the latest reading of every household metric,
+// labelled with its room and unit; the caller must hold mu
+func (s *Server) writeMetricValues(pw *prom.Writer) {
+	type latest struct {
+		labels  []prom.Label
+		reading *models.MetricReading
+	}
+	var values []latest
+	for id, metric := range s.metrics {
+		if metric.DeletedAt != nil {
+			continue
+		}
+		var last *models.MetricReading
+		for _, reading := range s.readings[id] {
+			if last == nil || reading.Timestamp.After(last.Timestamp) {
+				last = reading
+			}
+		}
+		if last == nil {
+			continue
+		}
+
+		room := ""
+		if r, ok := s.rooms[metric.RoomID]; ok {
+			room = r.Name
+		}
+		values = append(values, latest{
+			labels: []prom.Label{
+				{Name: "metric_id", Value: id.String()},
+				{Name: "metric", Value: metric.Name},
+				{Name: "kind", Value: metric.Kind},
+				{Name: "unit", Value: metric.Unit},
+				{Name: "room_id", Value: metric.RoomID.String()},
+				{Name: "room", Value: room},
+			},
+			reading: last,
+		})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].labels[0].Value < values[j].labels[0].Value
+	})
+
+	pw.Family("hm_metric_value", "gauge", "Latest reading of a household metric, in the unit of the metric.")
+	for _, v := range values {
+		pw.Sample("hm_metric_value", v.labels, v.reading.Value)
+	}
+	pw.Family("hm_metric_last_reading_timestamp_seconds", "gauge", "Time of the latest reading of a household metric.")
+	for _, v := range values {
+		pw.Sample("hm_metric_last_reading_timestamp_seconds", v.labels, float64(v.reading.Timestamp.UnixMilli())/1000)
+	}
+}
+
+// RemoteWrite records the samples of a Prometheus remote write request as
+// readings. Samples of series no metric is found for, or that are already
+// stored, are skipped; the request still succeeds, since sending it again
+// would not change that.
+func (o *ObservabilityServer) RemoteWrite(w http.ResponseWriter, r *http.Request) {
+	s := o.server
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBodySize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "body_too_large",
+				fmt.Sprintf("Request body must not exceed %d bytes", maxErr.Limit))
+			return
+		}
+		writeError(w, r, http.StatusBadRequest, "invalid_body", "Failed to read request body")
+		return
+	}
+	series, err := prom.DecodeWriteRequest(body, maxRemoteWriteSize)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_write_request", err.Error())
+		return
+	}
+
+	results := make(map[string]int)
+	s.mu.Lock()
+	for _, ts := range series {
+		metricID, ok := o.route(ts)
+		if !ok {
+			results["unmatched"] += len(ts.Samples)
+			continue
+		}
+		for _, sample := range ts.Samples {
+			if prom.IsStale(sample.Value) {
+				results["stale"]++
+				continue
+			}
+			req := models.AddReadingRequest{Value: &sample.Value, Timestamp: time.UnixMilli(sample.Timestamp)}
+			if s.hasReading(metricID, req.Timestamp, sample.Value) {
+				results["duplicate"]++
+				continue
+			}
+
+			// Readings go through the checks of AddReading
+			reading, err := s.newReading(metricID, req)
+			if err != nil {
+				results["rejected"]++
+				continue
+			}
+			s.storeReading(reading, sourceRemoteWrite)
+			results["stored"]++
+		}
+	}
+	s.mu.Unlock()
+
+	for result, n := range results {
+		s.telemetry.remoteWrite.Add(float64(n), result)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// route returns the metric the samples of a series are recorded to. A
+// metric_id label may only name a metric that a route maps, so that writers
+// cannot record to any metric.
+func (o *ObservabilityServer) route(ts prom.TimeSeries) (uuid.UUID, bool) {
+	if label := ts.Label("metric_id"); label != "" {
+		id, err := uuid.Parse(label)
+		if err != nil {
+			return uuid.Nil, false
+		}
+		for _, route := range o.routes {
+			if route.MetricID == id {
+				return id, true
+			}
+		}
+		return uuid.Nil, false
+	}
+	for _, route := range o.routes {
+		matches := true
+		for name, value := range route.Match {
+			if ts.Label(name) != value {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return route.MetricID, true
+		}
+	}
+	return uuid.Nil, false
+}
+
+// hasReading reports whether the metric has a reading with the timestamp
+// and value, i.e. one delivered before; the caller must hold mu
+func (s *Server) hasReading(metricID uuid.UUID, timestamp time.Time, value float64) bool {
+	readings := s.readings[metricID]
+	for i := len(readings) - 1; i >= 0; i-- {
+		if readings[i].Timestamp.Equal(timestamp) && readings[i].Value == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/prom"
+	"github.com/google/uuid"
+)
+
+// writeRequest encodes series as the snappy compressed body of a remote
+// write request, using literals only
+func writeRequest(series []prom.TimeSeries) []byte {
+	field := func(b []byte, n int, data []byte) []byte {
+		b = binary.AppendUvarint(b, uint64(n<<3|2))
+		b = binary.AppendUvarint(b, uint64(len(data)))
+		return append(b, data...)
+	}
+	var message []byte
+	for _, ts := range series {
+		var tsb []byte
+		for _, l := range ts.Labels {
+			tsb = field(tsb, 1, field(field(nil, 1, []byte(l.Name)), 2, []byte(l.Value)))
+		}
+		for _, s := range ts.Samples {
+			sb := binary.LittleEndian.AppendUint64([]byte{1<<3 | 1}, math.Float64bits(s.Value))
+			sb = binary.AppendUvarint(append(sb, 2<<3), uint64(s.Timestamp))
+			tsb = field(tsb, 2, sb)
+		}
+		message = field(message, 1, tsb)
+	}
+
+	body := binary.AppendUvarint(nil, uint64(len(message)))
+	for len(message) > 0 {
+		n := min(len(message), 1<<16)
+		body = binary.LittleEndian.AppendUint16(append(body, 61<<2), uint16(n-1))
+		body = append(body, message[:n]...)
+		message = message[n:]
+	}
+	return body
+}
+
+func TestRemoteWrite(t *testing.T) {
+	api := newTestAPI(t)
+	routed := api.createMetric("Routed")
+	labelled := api.createMetric("Labelled")
+	unrouted := api.createMetric("Unrouted")
+
+	o := NewObservabilityServer(api.s, "secret")
+	o.EnableRemoteWrite([]models.RemoteWriteRoute{
+		{Match: map[string]string{"__name__": "meter_energy_kwh", "serial": "SN-1"}, MetricID: routed.ID},
+		{Match: map[string]string{"serial": "SN-2"}, MetricID: labelled.ID},
+	})
+	handler := o.Handler()
+	write := func(token string, body []byte) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	at := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	sample := func(value float64, offset time.Duration) prom.Sample {
+		return prom.Sample{Value: value, Timestamp: at.Add(offset).UnixMilli()}
+	}
+	body := writeRequest([]prom.TimeSeries{
+		{Labels: []prom.Label{{Name: "__name__", Value: "meter_energy_kwh"}, {Name: "serial", Value: "SN-1"}}, Samples: []prom.Sample{sample(1, 0), sample(2, time.Minute)}},
+		// A metric_id label may name a metric some route maps
+		{Labels: []prom.Label{{Name: "metric_id", Value: labelled.ID.String()}}, Samples: []prom.Sample{sample(3, 0)}},
+		// Dropped: metrics no route maps, invalid IDs, unmatched labels and
+		// staleness markers
+		{Labels: []prom.Label{{Name: "metric_id", Value: unrouted.ID.String()}, {Name: "serial", Value: "SN-2"}}, Samples: []prom.Sample{sample(4, 0)}},
+		{Labels: []prom.Label{{Name: "metric_id", Value: "x"}}, Samples: []prom.Sample{sample(5, 0)}},
+		{Labels: []prom.Label{{Name: "__name__", Value: "meter_energy_kwh"}, {Name: "serial", Value: "SN-9"}}, Samples: []prom.Sample{sample(6, 0)}},
+		{Labels: []prom.Label{{Name: "serial", Value: "SN-2"}}, Samples: []prom.Sample{sample(math.Float64frombits(0x7FF0000000000002), 0)}},
+	})
+
+	if code := write("", body); code != http.StatusUnauthorized {
+		t.Fatalf("without the token: got status %d, want 401", code)
+	}
+	if code := write("wrong", body); code != http.StatusUnauthorized {
+		t.Fatalf("with a wrong token: got status %d, want 401", code)
+	}
+	if code := write("secret", []byte{5, 1}); code != http.StatusBadRequest {
+		t.Errorf("corrupt body: got status %d, want 400", code)
+	}
+	// Retried batches are stored once
+	for range 2 {
+		if code := write("secret", body); code != http.StatusNoContent {
+			t.Fatalf("got status %d, want 204", code)
+		}
+	}
+
+	for _, tt := range []struct {
+		metric uuid.UUID
+		values []float64
+	}{
+		{routed.ID, []float64{1, 2}},
+		{labelled.ID, []float64{3}},
+		{unrouted.ID, nil},
+	} {
+		readings := currentReadings(api.s, tt.metric)
+		if len(readings) != len(tt.values) {
+			t.Errorf("metric %s: got readings %+v, want values %v", tt.metric, readings, tt.values)
+			continue
+		}
+		for i, v := range tt.values {
+			if readings[i].Value != v {
+				t.Errorf("metric %s: got readings %+v, want values %v", tt.metric, readings, tt.values)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	pw := prom.NewWriter(&buf)
+	api.s.telemetry.remoteWrite.Write(pw)
+	pw.Flush()
+	for _, want := range []string{`result="stored"} 3`, `result="duplicate"} 3`, `result="unmatched"} 6`, `result="stale"} 2`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("counters lack %s:\n%s", want, buf.String())
+		}
+	}
+}
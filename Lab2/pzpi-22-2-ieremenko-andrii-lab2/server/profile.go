@@ -0,0 +1,330 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/auth"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// GetCurrentUser godoc
+// @Summary Get the current user
+// @Description Get the profile of the authenticated user
+// @Tags profile
+// @Produce json
+// @Param If-None-Match header string false "ETag of the cached version"
+// @Success 200 {object} models.User
+// @Header 200 {string} ETag "Version of the resource"
+// @Success 304 "Not modified"
+// @Failure 401 {object} models.Problem
+// @Router /users/me [get]
+func (s *Server) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAccount(w, r)
+	if !ok {
+		return
+	}
+
+	if notModified(w, r, user.Version) {
+		return
+	}
+
+	setETag(w, user.Version)
+	writeJSON(w, user)
+}
+
+// ChangePassword godoc
+// @Summary Change the password
+// @Description Change the password of the authenticated user. Every other session of the user is ended.
+// @Tags profile
+// @Accept json
+// @Produce json
+// @Param request body models.ChangePasswordRequest true "Password change request"
+// @Success 204 "Password changed"
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Router /users/me/password [post]
+func (s *Server) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAccount(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	update := models.UpdateUserRequest{
+		Password:        &req.NewPassword,
+		CurrentPassword: req.CurrentPassword,
+	}
+	if !s.updateUser(w, r, user, user, update, true) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExportAccount godoc
+// @Summary Export the current user's data
+// @Description Get everything held about the authenticated user: the profile, owned rooms with their metrics and readings, and active sessions
+// @Tags profile
+// @Produce json
+// @Success 200 {object} models.AccountExport
+// @Failure 401 {object} models.Problem
+// @Router /users/me/export [get]
+func (s *Server) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAccount(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, s.exportAccount(r, user))
+}
+
+// DeleteAccount godoc
+// @Summary Delete the current user
+// @Description Delete the account of the authenticated user and end all of its sessions. The data held about the user is exported first and returned. Owned rooms are kept without an owner. The last administrator cannot be deleted.
+// @Tags profile
+// @Accept json
+// @Produce json
+// @Param request body models.DeleteAccountRequest true "Account deletion request"
+// @Success 200 {object} models.AccountExport
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /users/me [delete]
+func (s *Server) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAccount(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.DeleteAccountRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if !auth.CheckPassword(user.PasswordHash, req.CurrentPassword) {
+		writeError(w, r, http.StatusForbidden, "wrong_password", "Current password is incorrect")
+		return
+	}
+
+	if hasRole(user, "admin") && s.countAdmins() == 1 {
+		writeError(w, r, http.StatusConflict, "last_admin", "The last administrator cannot be deleted")
+		return
+	}
+
+	export := s.exportAccount(r, user)
+
+	now := time.Now()
+	for _, room := range s.rooms {
+		if room.OwnerID != user.ID {
+			continue
+		}
+		before := *room
+		room.OwnerID = uuid.Nil
+		room.UpdatedAt = now
+		room.Version++
+		s.recordAudit(r, user, "room.update", "room", room.ID.String(), before, room)
+	}
+
+	delete(s.users, user.ID)
+	s.endSessions(user.ID, uuid.Nil)
+	s.recordAudit(r, user, "user.delete", "user", user.ID.String(), user, nil)
+
+	writeJSON(w, export)
+}
+
+// ListMyRooms godoc
+// @Summary List the current user's rooms
+// @Description Get the rooms owned by the authenticated user
+// @Tags profile
+// @Produce json
+// @Success 200 {object} models.RoomListResponse
+// @Failure 401 {object} models.Problem
+// @Router /users/me/rooms [get]
+func (s *Server) ListMyRooms(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAccount(w, r)
+	if !ok {
+		return
+	}
+
+	rooms := s.ownedRooms(user.ID)
+	writeJSON(w, models.RoomListResponse{
+		Rooms: rooms,
+		Total: len(rooms),
+	})
+}
+
+// ListMyMetrics godoc
+// @Summary List the current user's metrics
+// @Description Get the metrics in the rooms owned by the authenticated user
+// @Tags profile
+// @Produce json
+// @Success 200 {object} models.MetricListResponse
+// @Failure 401 {object} models.Problem
+// @Router /users/me/metrics [get]
+func (s *Server) ListMyMetrics(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAccount(w, r)
+	if !ok {
+		return
+	}
+
+	metrics := s.ownedMetrics(user.ID)
+	writeJSON(w, models.MetricListResponse{
+		Metrics: metrics,
+		Total:   len(metrics),
+	})
+}
+
+// ListSessions godoc
+// @Summary List the current user's sessions
+// @Description Get the active sessions of the authenticated user, newest first. The session of the request is marked as current.
+// @Tags profile
+// @Produce json
+// @Success 200 {object} models.SessionListResponse
+// @Failure 401 {object} models.Problem
+// @Router /users/me/sessions [get]
+func (s *Server) ListSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAccount(w, r)
+	if !ok {
+		return
+	}
+
+	sessions := s.userSessions(r, user.ID)
+	writeJSON(w, models.SessionListResponse{
+		Sessions: sessions,
+		Total:    len(sessions),
+	})
+}
+
+// RevokeSession godoc
+// @Summary End a session
+// @Description End a session of the authenticated user; its token stops being accepted
+// @Tags profile
+// @Param id path string true "Session ID"
+// @Success 204 "Session ended"
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /users/me/sessions/{id} [delete]
+func (s *Server) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAccount(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid session ID")
+		return
+	}
+
+	s.sessionMu.Lock()
+	session, exists := s.sessions[id]
+	if exists && session.UserID == user.ID {
+		delete(s.sessions, id)
+	}
+	s.sessionMu.Unlock()
+
+	// Sessions of other users are reported as missing rather than forbidden
+	if !exists || session.UserID != user.ID {
+		writeError(w, r, http.StatusNotFound, "session_not_found", "Session not found")
+		return
+	}
+
+	s.recordAudit(r, user, "session.revoke", "session", id.String(), session, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireAccount writes an error response and returns false unless the
+// request is made by a registered user, who is returned otherwise. Service
+// users have no profile.
+func (s *Server) requireAccount(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return nil, false
+	}
+
+	if _, exists := s.users[user.ID]; !exists {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// countAdmins returns the number of users holding the admin role
+func (s *Server) countAdmins() int {
+	count := 0
+	for _, u := range s.users {
+		if hasRole(u, "admin") {
+			count++
+		}
+	}
+	return count
+}
+
+// ownedRooms returns the rooms owned by a user that are not in the trash,
+// oldest first
+func (s *Server) ownedRooms(userID uuid.UUID) []models.Room {
+	rooms := make([]models.Room, 0)
+	for _, room := range s.rooms {
+		if room.OwnerID == userID && room.DeletedAt == nil {
+			rooms = append(rooms, *room)
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].CreatedAt.Before(rooms[j].CreatedAt)
+	})
+	return rooms
+}
+
+// ownedMetrics returns the metrics in the rooms owned by a user that are not
+// in the trash, oldest first
+func (s *Server) ownedMetrics(userID uuid.UUID) []models.Metric {
+	metrics := make([]models.Metric, 0)
+	for _, metric := range s.metrics {
+		if metric.DeletedAt != nil {
+			continue
+		}
+		if room, exists := s.activeRoom(metric.RoomID); exists && room.OwnerID == userID {
+			metrics = append(metrics, *metric)
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].CreatedAt.Before(metrics[j].CreatedAt)
+	})
+	return metrics
+}
+
+// exportAccount collects everything held about a user
+func (s *Server) exportAccount(r *http.Request, user *models.User) models.AccountExport {
+	export := models.AccountExport{
+		ExportedAt: time.Now(),
+		User:       *user,
+		Rooms:      s.ownedRooms(user.ID),
+		Metrics:    make([]models.MetricWithReadings, 0),
+		Sessions:   s.userSessions(r, user.ID),
+	}
+
+	for _, metric := range s.ownedMetrics(user.ID) {
+		readings := make([]models.MetricReading, 0, len(s.readings[metric.ID]))
+		for _, reading := range s.readings[metric.ID] {
+			readings = append(readings, *reading)
+		}
+		export.Metrics = append(export.Metrics, models.MetricWithReadings{
+			Metric:   metric,
+			Readings: readings,
+		})
+	}
+
+	return export
+}
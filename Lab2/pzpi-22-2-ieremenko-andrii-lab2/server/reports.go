@@ -0,0 +1,398 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/reports"
+	"github.com/google/uuid"
+)
+
+// asyncReportRange is the longest period rendered within the request;
+// reports over longer periods are generated in the background
+const asyncReportRange = 31 * 24 * time.Hour
+
+// reportJobTTL is how long a finished report job is kept for its output to
+// be downloaded
+const reportJobTTL = 24 * time.Hour
+
+// reportJob holds the state of a background report and its rendered output
+type reportJob struct {
+	models.ReportJob
+	content []byte
+}
+
+// GenerateReport godoc
+// @Summary Generate a report
+// @Description Generate a consumption, readings or statements report as CSV, XLSX or PDF. Reports over periods longer than 31 days are generated asynchronously and a job is returned instead; finished jobs are kept for a day. PDF reports use the standard Latin-1 fonts, so other characters, such as Cyrillic, are printed as '?'; use CSV or XLSX for those.
+// @Tags reports
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Produce application/pdf
+// @Produce json
+// @Param type path string true "Report type (consumption, readings, statements)"
+// @Param from query string false "Period start (RFC3339), defaults to 30 days ago"
+// @Param to query string false "Period end (RFC3339), defaults to now"
+// @Param format query string false "Output format: csv (default), xlsx or pdf"
+// @Success 200 {file} file
+// @Success 202 {object} models.ReportJob
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Router /reports/{type} [get]
+func (s *Server) GenerateReport(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	_, ok := s.requireAdmin(w, r)
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	reportType := r.PathValue("type")
+	collect, exists := map[string]func(startTime, endTime time.Time) *reports.Report{
+		"consumption": s.consumptionReport,
+		"readings":    s.readingsReport,
+		"statements":  s.statementsReport,
+	}[reportType]
+	if !exists {
+		writeError(w, r, http.StatusBadRequest, "unknown_report_type", "Unknown report type")
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = reports.FormatCSV
+	}
+	if !reports.ValidFormat(format) {
+		writeError(w, r, http.StatusBadRequest, "unsupported_format", "Unsupported report format")
+		return
+	}
+
+	var err error
+	endTime := time.Now()
+	startTime := endTime.AddDate(0, 0, -30)
+	if v := query.Get("from"); v != "" {
+		if startTime, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_time", "Invalid from time")
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if endTime, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_time", "Invalid to time")
+			return
+		}
+	}
+	if !endTime.After(startTime) {
+		writeError(w, r, http.StatusBadRequest, "invalid_period", "Period end must be after its start")
+		return
+	}
+
+	// The data is collected under the lock and rendered after it is released
+	if endTime.Sub(startTime) <= asyncReportRange {
+		s.mu.RLock()
+		report := collect(startTime, endTime)
+		s.mu.RUnlock()
+
+		var buf bytes.Buffer
+		if err := reports.Render(&buf, format, report); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to render report")
+			return
+		}
+		w.Header().Set("Content-Type", reports.ContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", reportType+"."+format))
+		w.Write(buf.Bytes())
+		return
+	}
+
+	job := &reportJob{ReportJob: models.ReportJob{
+		ID:        uuid.New(),
+		Type:      reportType,
+		Format:    format,
+		StartTime: startTime,
+		EndTime:   endTime,
+		Status:    models.ReportJobPending,
+		CreatedAt: time.Now(),
+	}}
+
+	s.reportMu.Lock()
+	// Expired jobs are dropped as new ones start
+	for id, old := range s.reportJobs {
+		if old.CompletedAt != nil && time.Since(*old.CompletedAt) > reportJobTTL {
+			delete(s.reportJobs, id)
+		}
+	}
+	s.reportJobs[job.ID] = job
+	status := job.ReportJob
+	s.reportMu.Unlock()
+
+	s.jobs.Add(1)
+	go s.runReportJob(job, collect)
+
+	w.Header().Set("Location", "/reports/jobs/"+job.ID.String())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, status)
+}
+
+// runReportJob collects and renders a report in the background and records
+// the outcome
+func (s *Server) runReportJob(job *reportJob, collect func(startTime, endTime time.Time) *reports.Report) {
+	defer s.jobs.Done()
+
+	s.reportMu.Lock()
+	job.Status = models.ReportJobRunning
+	s.reportMu.Unlock()
+
+	s.mu.RLock()
+	report := collect(job.StartTime, job.EndTime)
+	s.mu.RUnlock()
+
+	var buf bytes.Buffer
+	err := reports.Render(&buf, job.Format, report)
+
+	s.reportMu.Lock()
+	defer s.reportMu.Unlock()
+
+	now := time.Now()
+	job.CompletedAt = &now
+	if err != nil {
+		job.Status = models.ReportJobFailed
+		job.Error = err.Error()
+		return
+	}
+	job.Status = models.ReportJobCompleted
+	job.content = buf.Bytes()
+}
+
+// GetReportJob godoc
+// @Summary Get report job status
+// @Description Get the status of an asynchronous report job
+// @Tags reports
+// @Accept json
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} models.ReportJob
+// @Failure 404 {object} models.Problem
+// @Router /reports/jobs/{id} [get]
+func (s *Server) GetReportJob(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid job ID")
+		return
+	}
+
+	s.reportMu.Lock()
+	job, exists := s.reportJobs[id]
+	var status models.ReportJob
+	if exists {
+		status = job.ReportJob
+	}
+	s.reportMu.Unlock()
+
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "report_job_not_found", "Report job not found")
+		return
+	}
+
+	writeJSON(w, status)
+}
+
+// DownloadReport godoc
+// @Summary Download a generated report
+// @Description Download the output of a completed report job
+// @Tags reports
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Produce application/pdf
+// @Param id path string true "Job ID"
+// @Success 200 {file} file
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /reports/jobs/{id}/download [get]
+func (s *Server) DownloadReport(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid job ID")
+		return
+	}
+
+	s.reportMu.Lock()
+	job, exists := s.reportJobs[id]
+	var status models.ReportJob
+	var content []byte
+	if exists {
+		status = job.ReportJob
+		content = job.content
+	}
+	s.reportMu.Unlock()
+
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "report_job_not_found", "Report job not found")
+		return
+	}
+	if status.Status != models.ReportJobCompleted {
+		writeError(w, r, http.StatusConflict, "report_not_ready", "Report is not ready")
+		return
+	}
+
+	w.Header().Set("Content-Type", reports.ContentType(status.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", status.Type+"."+status.Format))
+	w.Write(content)
+}
+
+// consumptionReport totals readings per apartment and service over the period
+func (s *Server) consumptionReport(startTime, endTime time.Time) *reports.Report {
+	report := &reports.Report{
+		Title:    "Consumption by apartment and service",
+		Subtitle: periodSubtitle(startTime, endTime),
+		Columns:  []string{"Building", "Apartment", "Service", "Unit", "Readings", "Total"},
+		Chart:    &reports.Chart{Title: "Total consumption"},
+	}
+
+	type key struct {
+		roomID uuid.UUID
+		kind   string
+		unit   string
+	}
+	totals := make(map[key]float64)
+	counts := make(map[key]int)
+	for _, metric := range s.metrics {
+		if metric.DeletedAt != nil {
+			continue
+		}
+		k := key{metric.RoomID, metric.Kind, metric.Unit}
+		if total, count := s.consumptionInPeriod(metric.ID, startTime, endTime); count > 0 {
+			totals[k] += total
+			counts[k] += count
+		}
+	}
+
+	keys := make([]key, 0, len(totals))
+	for k := range totals {
+		keys = append(keys, k)
+	}
+	roomName := func(id uuid.UUID) (string, string) {
+		if room, exists := s.rooms[id]; exists {
+			return room.Building, room.Name
+		}
+		return "", id.String()
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		bi, ni := roomName(keys[i].roomID)
+		bj, nj := roomName(keys[j].roomID)
+		if bi != bj {
+			return bi < bj
+		}
+		if ni != nj {
+			return ni < nj
+		}
+		return keys[i].kind < keys[j].kind
+	})
+
+	for _, k := range keys {
+		building, name := roomName(k.roomID)
+		report.Rows = append(report.Rows, []interface{}{building, name, k.kind, k.unit, counts[k], totals[k]})
+		report.Chart.Labels = append(report.Chart.Labels, name+" "+k.kind)
+		report.Chart.Values = append(report.Chart.Values, totals[k])
+	}
+
+	return report
+}
+
+// readingsReport lists every reading recorded over the period. Readings
+// compacted by a retention policy are listed by the hour or day they were
+// rolled up into, with their count and sum.
+func (s *Server) readingsReport(startTime, endTime time.Time) *reports.Report {
+	report := &reports.Report{
+		Title:    "Meter readings",
+		Subtitle: periodSubtitle(startTime, endTime),
+		Columns:  []string{"Apartment", "Metric", "Service", "Unit", "Timestamp", "Resolution", "Readings", "Value"},
+	}
+
+	var rows [][]interface{}
+	for _, metric := range s.metrics {
+		if metric.DeletedAt != nil {
+			continue
+		}
+		name := metric.RoomID.String()
+		if room, exists := s.rooms[metric.RoomID]; exists {
+			name = room.Name
+		}
+		for _, reading := range s.getReadingsInPeriod(metric.ID, startTime, endTime) {
+			rows = append(rows, []interface{}{name, metric.Name, metric.Kind, metric.Unit, reading.Timestamp, models.ResolutionRaw, 1, reading.Value})
+		}
+		for _, rollup := range s.rollupsInPeriod(metric.ID, startTime, endTime) {
+			rows = append(rows, []interface{}{name, metric.Name, metric.Kind, metric.Unit, rollup.Start, rollup.Resolution, rollup.Count, rollup.Sum})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i][4].(time.Time).Before(rows[j][4].(time.Time))
+	})
+	report.Rows = rows
+
+	return report
+}
+
+// statementsReport lists, apartment by apartment, the consumption of each
+// meter over the period, for statements to be sent to the owners
+func (s *Server) statementsReport(startTime, endTime time.Time) *reports.Report {
+	report := &reports.Report{
+		Title:    "Apartment statements",
+		Subtitle: periodSubtitle(startTime, endTime),
+		Columns:  []string{"Building", "Apartment", "Owner", "Meter", "Service", "Unit", "Readings", "Consumption"},
+	}
+
+	var rows [][]interface{}
+	for _, metric := range s.metrics {
+		if metric.DeletedAt != nil {
+			continue
+		}
+		room, exists := s.rooms[metric.RoomID]
+		if !exists || room.DeletedAt != nil {
+			continue
+		}
+		owner := ""
+		if user, exists := s.users[room.OwnerID]; exists {
+			owner = user.Username
+		}
+		meter := metric.Name
+		if metric.MeterSerial != "" {
+			meter += " (" + metric.MeterSerial + ")"
+		}
+		total, count := s.consumptionInPeriod(metric.ID, startTime, endTime)
+		rows = append(rows, []interface{}{room.Building, room.Name, owner, meter, metric.Kind, metric.Unit, count, total})
+	}
+
+	// Apartments in order, each with its meters by service
+	sort.Slice(rows, func(i, j int) bool {
+		for _, col := range []int{0, 1, 4, 3} {
+			if a, b := rows[i][col].(string), rows[j][col].(string); a != b {
+				return a < b
+			}
+		}
+		return false
+	})
+	report.Rows = rows
+
+	return report
+}
+
+// periodSubtitle describes a report period
+func periodSubtitle(startTime, endTime time.Time) string {
+	return fmt.Sprintf("Period: %s - %s", startTime.Format("2006-01-02 15:04"), endTime.Format("2006-01-02 15:04"))
+}
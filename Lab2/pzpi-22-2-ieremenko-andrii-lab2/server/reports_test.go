@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// readCSV sends a request and returns the records of the CSV it answers
+func (api *testAPI) readCSV(path string) [][]string {
+	api.t.Helper()
+	resp := api.do(http.MethodGet, path, nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		api.t.Fatalf("GET %s: got status %d: %s", path, resp.StatusCode, data)
+	}
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	return records
+}
+
+func TestStatementsReport(t *testing.T) {
+	api := newTestAPI(t)
+	var owner models.User
+	api.as(api.register("resident")).expect(http.MethodGet, "/users/me", nil, http.StatusOK, &owner)
+
+	var room models.Room
+	api.expect(http.MethodPost, "/rooms", models.CreateRoomRequest{Name: "Flat 1", Building: "A", OwnerID: owner.ID}, http.StatusOK, &room)
+	var metric models.Metric
+	api.expect(http.MethodPost, "/metrics", models.CreateMetricRequest{Name: "Water", Unit: "m3", Kind: "water", MeterSerial: "W-1", RoomID: room.ID}, http.StatusOK, &metric)
+	for _, value := range []float64{1.5, 2} {
+		api.expect(http.MethodPost, "/metrics/"+metric.ID.String()+"/readings", models.AddReadingRequest{Value: &value, Timestamp: time.Now().Add(-time.Hour)}, http.StatusOK, nil)
+	}
+
+	records := api.readCSV("/reports/statements?format=csv")
+	want := []string{"A", "Flat 1", "resident", "Water (W-1)", "water", "m3", "2", "3.5"}
+	if len(records) != 2 || len(records[1]) != len(want) {
+		t.Fatalf("got %v, want a header and %v", records, want)
+	}
+	for i := range want {
+		if records[1][i] != want[i] {
+			t.Errorf("column %s: got %q, want %q", records[0][i], records[1][i], want[i])
+		}
+	}
+
+	api.expectProblem(http.MethodGet, "/reports/invoices", nil, http.StatusBadRequest, "unknown_report_type")
+}
+
+func TestReportJobs(t *testing.T) {
+	api := newTestAPI(t)
+
+	// A job finished long ago, to be dropped when the next one starts
+	expired := uuid.New()
+	finished := time.Now().Add(-reportJobTTL - time.Minute)
+	api.s.reportJobs[expired] = &reportJob{ReportJob: models.ReportJob{ID: expired, Status: models.ReportJobCompleted, CompletedAt: &finished}}
+
+	from := time.Now().AddDate(0, -3, 0).UTC().Format(time.RFC3339)
+	var job models.ReportJob
+	api.expect(http.MethodGet, "/reports/statements?format=pdf&from="+from, nil, http.StatusAccepted, &job)
+
+	path := "/reports/jobs/" + job.ID.String()
+	waitFor(t, "the report job", func() bool {
+		api.expect(http.MethodGet, path, nil, http.StatusOK, &job)
+		return job.Status != models.ReportJobPending && job.Status != models.ReportJobRunning
+	})
+	if job.Status != models.ReportJobCompleted {
+		t.Fatalf("got job %+v, want completed", job)
+	}
+	resp := api.expect(http.MethodGet, path+"/download", nil, http.StatusOK, nil)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/pdf" {
+		t.Errorf("got Content-Type %s, want application/pdf", ct)
+	}
+
+	api.expectProblem(http.MethodGet, "/reports/jobs/"+expired.String(), nil, http.StatusNotFound, "report_job_not_found")
+}
@@ -0,0 +1,558 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// Ranges longer than these are served at least by hour or by day when no
+// resolution is asked for, to keep the number of points reasonable
+const (
+	hourlySeriesSpan = 7 * 24 * time.Hour
+	dailySeriesSpan  = 90 * 24 * time.Hour
+)
+
+// rollupKey identifies the rollup of a metric for a bucket
+type rollupKey struct {
+	resolution string
+	start      int64 // Unix seconds
+}
+
+// resolutionRank orders resolutions from the finest
+var resolutionRank = map[string]int{
+	models.ResolutionRaw:  0,
+	models.ResolutionHour: 1,
+	models.ResolutionDay:  2,
+}
+
+// bucketStart returns the start of the UTC hour or day t falls in
+func bucketStart(t time.Time, resolution string) time.Time {
+	switch resolution {
+	case models.ResolutionHour:
+		return t.UTC().Truncate(time.Hour)
+	case models.ResolutionDay:
+		return t.UTC().Truncate(24 * time.Hour)
+	}
+	return t
+}
+
+// mergeRollup adds the readings aggregated by src to dst
+func mergeRollup(dst *models.Rollup, src models.Rollup) {
+	dst.Count += src.Count
+	dst.Sum += src.Sum
+	dst.Min = min(dst.Min, src.Min)
+	dst.Max = max(dst.Max, src.Max)
+}
+
+// addRollup merges r into the rollup of its metric for the same bucket,
+// creating it if there is none
+func (s *Server) addRollup(r models.Rollup) {
+	rollups := s.rollups[r.MetricID]
+	if rollups == nil {
+		rollups = make(map[rollupKey]*models.Rollup)
+		s.rollups[r.MetricID] = rollups
+	}
+
+	key := rollupKey{r.Resolution, r.Start.Unix()}
+	if existing, exists := rollups[key]; exists {
+		mergeRollup(existing, r)
+		return
+	}
+	rollups[key] = &r
+}
+
+// retentionPolicy returns the policy that applies to a metric: its own, that
+// of its kind, or the default one, in that order; nil if there is none
+func (s *Server) retentionPolicy(metric *models.Metric) *models.RetentionPolicy {
+	var byKind, byDefault *models.RetentionPolicy
+	for _, policy := range s.retention {
+		switch {
+		case policy.MetricID != nil:
+			if *policy.MetricID == metric.ID {
+				return policy
+			}
+		case policy.Kind != "":
+			if policy.Kind == metric.Kind {
+				byKind = policy
+			}
+		default:
+			byDefault = policy
+		}
+	}
+	if byKind != nil {
+		return byKind
+	}
+	return byDefault
+}
+
+// compact rolls readings up by hour once they are older than the policy of
+// their metric keeps them raw, rolls hourly rollups up by day once they are
+// older than it keeps those, and deletes daily rollups past their retention.
+// Hours and days are rolled up whole, so readings are kept a little longer
+// than the policy says. The caller must hold mu exclusively.
+func (s *Server) compact(now time.Time) models.CompactionResult {
+	result := models.CompactionResult{CompactedAt: now}
+
+	for id, metric := range s.metrics {
+		policy := s.retentionPolicy(metric)
+		if policy == nil {
+			continue
+		}
+		result.Metrics++
+		changed := false
+
+		if policy.RawDays > 0 {
+			cutoff := bucketStart(now.AddDate(0, 0, -policy.RawDays), models.ResolutionHour)
+			kept := make([]*models.MetricReading, 0, len(s.readings[id]))
+			for _, reading := range s.readings[id] {
+				if !reading.Timestamp.Before(cutoff) {
+					kept = append(kept, reading)
+					continue
+				}
+				s.addRollup(models.Rollup{
+					MetricID:   id,
+					Resolution: models.ResolutionHour,
+					Start:      bucketStart(reading.Timestamp, models.ResolutionHour),
+					Count:      1,
+					Sum:        reading.Value,
+					Min:        reading.Value,
+					Max:        reading.Value,
+				})
+				result.RawRolledUp++
+				changed = true
+			}
+			s.readings[id] = kept
+		}
+
+		if policy.HourlyDays > 0 {
+			cutoff := bucketStart(now.AddDate(0, 0, -policy.HourlyDays), models.ResolutionDay)
+			for key, rollup := range s.rollups[id] {
+				if rollup.Resolution != models.ResolutionHour || !rollup.Start.Before(cutoff) {
+					continue
+				}
+				daily := *rollup
+				daily.Resolution = models.ResolutionDay
+				daily.Start = bucketStart(rollup.Start, models.ResolutionDay)
+				delete(s.rollups[id], key)
+				s.addRollup(daily)
+				result.HourlyRolledUp++
+				changed = true
+			}
+		}
+
+		if policy.DailyDays > 0 {
+			cutoff := now.AddDate(0, 0, -policy.DailyDays)
+			for key, rollup := range s.rollups[id] {
+				if rollup.Resolution == models.ResolutionDay && !rollup.Start.AddDate(0, 0, 1).After(cutoff) {
+					delete(s.rollups[id], key)
+					result.DailyDeleted++
+					changed = true
+				}
+			}
+		}
+
+		if changed {
+			metric.ReadingsVersion++
+		}
+	}
+
+	return result
+}
+
+// CompactReadings applies the retention policies to the readings, recording
+// what was rolled up or deleted in the audit log
+func (s *Server) CompactReadings() models.CompactionResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := s.compact(time.Now())
+	if result.RawRolledUp > 0 || result.HourlyRolledUp > 0 || result.DailyDeleted > 0 {
+		s.appendAudit(models.AuditEntry{
+			Actor:      "system",
+			Action:     "readings.compact",
+			TargetType: "retention",
+		}, nil, result)
+	}
+	return result
+}
+
+// ScheduleCompaction compacts the readings every interval. It blocks until
+// ctx is done, so it is meant to run in its own goroutine.
+func (s *Server) ScheduleCompaction(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.CompactReadings()
+		}
+	}
+}
+
+// series returns the readings of a metric taken in [from, to) at the given
+// resolution, whether they are kept raw or rolled up. Rollups count when
+// their bucket starts in the range; those coarser than the resolution are
+// returned as they are.
+func (s *Server) series(metricID uuid.UUID, from, to time.Time, resolution string) []models.SeriesPoint {
+	var points []models.SeriesPoint
+	buckets := make(map[rollupKey]*models.Rollup)
+	add := func(r models.Rollup) {
+		if resolutionRank[r.Resolution] < resolutionRank[resolution] {
+			r.Resolution = resolution
+			r.Start = bucketStart(r.Start, resolution)
+		}
+		key := rollupKey{r.Resolution, r.Start.Unix()}
+		if existing, exists := buckets[key]; exists {
+			mergeRollup(existing, r)
+			return
+		}
+		buckets[key] = &r
+	}
+
+	for _, reading := range s.readings[metricID] {
+		if reading.Timestamp.Before(from) || !reading.Timestamp.Before(to) {
+			continue
+		}
+		if resolution == models.ResolutionRaw {
+			points = append(points, models.SeriesPoint{
+				Start:      reading.Timestamp,
+				Resolution: models.ResolutionRaw,
+				Count:      1,
+				Sum:        reading.Value,
+				Min:        reading.Value,
+				Max:        reading.Value,
+				Avg:        reading.Value,
+			})
+			continue
+		}
+		add(models.Rollup{
+			Resolution: models.ResolutionRaw,
+			Start:      reading.Timestamp,
+			Count:      1,
+			Sum:        reading.Value,
+			Min:        reading.Value,
+			Max:        reading.Value,
+		})
+	}
+	for _, rollup := range s.rollups[metricID] {
+		if !rollup.Start.Before(from) && rollup.Start.Before(to) {
+			add(*rollup)
+		}
+	}
+
+	for _, bucket := range buckets {
+		points = append(points, rollupPoint(bucket))
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		if !points[i].Start.Equal(points[j].Start) {
+			return points[i].Start.Before(points[j].Start)
+		}
+		return resolutionRank[points[i].Resolution] > resolutionRank[points[j].Resolution]
+	})
+	return points
+}
+
+// rollupPoint returns a rollup as a point of a series
+func rollupPoint(r *models.Rollup) models.SeriesPoint {
+	return models.SeriesPoint{
+		Start:      r.Start,
+		Resolution: r.Resolution,
+		Count:      r.Count,
+		Sum:        r.Sum,
+		Min:        r.Min,
+		Max:        r.Max,
+		Avg:        r.Sum / float64(r.Count),
+	}
+}
+
+// rollupsInPeriod returns the rollups of a metric whose bucket starts in
+// [from, to), oldest first; a zero to means no end
+func (s *Server) rollupsInPeriod(metricID uuid.UUID, from, to time.Time) []*models.Rollup {
+	var rollups []*models.Rollup
+	for _, rollup := range s.rollups[metricID] {
+		if !rollup.Start.Before(from) && (to.IsZero() || rollup.Start.Before(to)) {
+			rollups = append(rollups, rollup)
+		}
+	}
+	sort.Slice(rollups, func(i, j int) bool {
+		if !rollups[i].Start.Equal(rollups[j].Start) {
+			return rollups[i].Start.Before(rollups[j].Start)
+		}
+		return resolutionRank[rollups[i].Resolution] > resolutionRank[rollups[j].Resolution]
+	})
+	return rollups
+}
+
+// seriesResolution chooses the resolution to serve a range at: the coarsest
+// the readings in it are kept at, and coarser still for long ranges
+func (s *Server) seriesResolution(metricID uuid.UUID, from, to time.Time) string {
+	resolution := models.ResolutionRaw
+	switch span := to.Sub(from); {
+	case span > dailySeriesSpan:
+		return models.ResolutionDay
+	case span > hourlySeriesSpan:
+		resolution = models.ResolutionHour
+	}
+
+	for _, rollup := range s.rollups[metricID] {
+		if !rollup.Start.Before(from) && rollup.Start.Before(to) && resolutionRank[rollup.Resolution] > resolutionRank[resolution] {
+			resolution = rollup.Resolution
+		}
+	}
+	return resolution
+}
+
+// consumptionInPeriod sums the readings of a metric within the period,
+// including those rolled up by hour or day whose bucket starts in it, and
+// counts them
+func (s *Server) consumptionInPeriod(metricID uuid.UUID, startTime, endTime time.Time) (float64, int) {
+	total, count := 0.0, 0
+	for _, reading := range s.getReadingsInPeriod(metricID, startTime, endTime) {
+		total += reading.Value
+		count++
+	}
+	for _, rollup := range s.rollups[metricID] {
+		if !rollup.Start.Before(startTime) && rollup.Start.Before(endTime) {
+			total += rollup.Sum
+			count += rollup.Count
+		}
+	}
+	return total, count
+}
+
+// GetReadingSeries godoc
+// @Summary Get metric readings over a range
+// @Description Get the readings of a metric taken over a range, aggregated by bucket. Without a resolution, the range is served from the tier its readings are kept at: raw while they are recent, rolled up by hour or by day once compacted, and by hour or day for ranges over 7 or 90 days. Buckets are UTC hours and days, and rollups count when their bucket starts in the range.
+// @Tags metrics
+// @Accept json
+// @Produce json
+// @Param id path string true "Metric ID"
+// @Param from query string false "Range start (RFC3339), defaults to a day before the end"
+// @Param to query string false "Range end (RFC3339), defaults to now"
+// @Param resolution query string false "raw, hour or day; chosen for the range when omitted"
+// @Success 200 {object} models.SeriesResponse
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /metrics/{id}/series [get]
+func (s *Server) GetReadingSeries(w http.ResponseWriter, r *http.Request) {
+	// Check if user is authenticated
+	if _, err := s.currentUser(r); err != nil {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid metric ID")
+		return
+	}
+
+	if !s.requireMetricAccess(w, r, id) {
+		return
+	}
+
+	if _, exists := s.activeMetric(id); !exists {
+		writeError(w, r, http.StatusNotFound, "metric_not_found", "Metric not found")
+		return
+	}
+
+	query := r.URL.Query()
+	endTime := time.Now()
+	if v := query.Get("to"); v != "" {
+		if endTime, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_time", "Invalid to time")
+			return
+		}
+	}
+	startTime := endTime.Add(-24 * time.Hour)
+	if v := query.Get("from"); v != "" {
+		if startTime, err = time.Parse(time.RFC3339, v); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_time", "Invalid from time")
+			return
+		}
+	}
+	if !startTime.Before(endTime) {
+		writeError(w, r, http.StatusBadRequest, "invalid_time", "The range must start before it ends")
+		return
+	}
+
+	resolution := query.Get("resolution")
+	if resolution == "" {
+		resolution = s.seriesResolution(id, startTime, endTime)
+	}
+	if _, ok := resolutionRank[resolution]; !ok {
+		writeError(w, r, http.StatusBadRequest, "invalid_query", "Invalid resolution")
+		return
+	}
+
+	points := s.series(id, startTime, endTime, resolution)
+	if points == nil {
+		points = make([]models.SeriesPoint, 0)
+	}
+	writeJSON(w, models.SeriesResponse{
+		MetricID:   id,
+		From:       startTime,
+		To:         endTime,
+		Resolution: resolution,
+		Points:     points,
+	})
+}
+
+// ListRetentionPolicies godoc
+// @Summary List retention policies
+// @Description Get the policies saying how long readings are kept raw, rolled up by hour and rolled up by day
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.RetentionPolicyListResponse
+// @Failure 403 {object} models.Problem
+// @Router /admin/retention [get]
+func (s *Server) ListRetentionPolicies(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	policies := make([]models.RetentionPolicy, 0, len(s.retention))
+	for _, policy := range s.retention {
+		policies = append(policies, *policy)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].CreatedAt.Before(policies[j].CreatedAt)
+	})
+
+	writeJSON(w, models.RetentionPolicyListResponse{
+		Policies: policies,
+		Total:    len(policies),
+	})
+}
+
+// CreateRetentionPolicy godoc
+// @Summary Create a retention policy
+// @Description Create a policy for one metric, the metrics of a kind, or, naming neither, every metric. Readings older than raw_days are rolled up by hour, hourly rollups older than hourly_days by day, and daily rollups older than daily_days are deleted; zero keeps a tier forever. A metric follows its own policy, else that of its kind, else the default one.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body models.CreateRetentionPolicyRequest true "Retention policy"
+// @Success 200 {object} models.RetentionPolicy
+// @Failure 400 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /admin/retention [post]
+func (s *Server) CreateRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.CreateRetentionPolicyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.MetricID != nil && req.Kind != "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_policy", "A policy applies to a metric or to a kind, not both")
+		return
+	}
+	if req.MetricID != nil {
+		if _, exists := s.activeMetric(*req.MetricID); !exists {
+			writeError(w, r, http.StatusBadRequest, "metric_not_found", "Metric not found")
+			return
+		}
+	}
+
+	// A tier kept for a while cannot follow one kept forever, nor be kept
+	// shorter than the one before it
+	tiers := []int{req.RawDays, req.HourlyDays, req.DailyDays}
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i] != 0 && (tiers[i-1] == 0 || tiers[i] < tiers[i-1]) {
+			writeError(w, r, http.StatusBadRequest, "invalid_policy", "Each tier must be kept at least as long as the one before it")
+			return
+		}
+	}
+
+	for _, policy := range s.retention {
+		sameMetric := policy.MetricID != nil && req.MetricID != nil && *policy.MetricID == *req.MetricID
+		sameKind := policy.MetricID == nil && req.MetricID == nil && policy.Kind == req.Kind
+		if sameMetric || sameKind {
+			writeError(w, r, http.StatusConflict, "policy_exists", "A policy already applies to these metrics")
+			return
+		}
+	}
+
+	policy := &models.RetentionPolicy{
+		ID:         uuid.New(),
+		MetricID:   req.MetricID,
+		Kind:       req.Kind,
+		RawDays:    req.RawDays,
+		HourlyDays: req.HourlyDays,
+		DailyDays:  req.DailyDays,
+		CreatedAt:  time.Now(),
+	}
+	s.retention[policy.ID] = policy
+	s.recordAudit(r, user, "retention.create", "retention", policy.ID.String(), nil, policy)
+
+	writeJSON(w, policy)
+}
+
+// DeleteRetentionPolicy godoc
+// @Summary Delete a retention policy
+// @Description Delete a retention policy. Readings already rolled up stay so.
+// @Tags admin
+// @Produce json
+// @Param id path string true "Policy ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} models.Problem
+// @Router /admin/retention/{id} [delete]
+func (s *Server) DeleteRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid policy ID")
+		return
+	}
+
+	policy, exists := s.retention[id]
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "policy_not_found", "Retention policy not found")
+		return
+	}
+
+	delete(s.retention, id)
+	s.recordAudit(r, user, "retention.delete", "retention", id.String(), policy, nil)
+
+	writeJSON(w, map[string]string{
+		"message": "Retention policy deleted",
+	})
+}
+
+// CompactReadingsNow godoc
+// @Summary Compact readings
+// @Description Apply the retention policies now rather than waiting for the compaction job
+// @Tags admin
+// @Produce json
+// @Success 200 {object} models.CompactionResult
+// @Failure 403 {object} models.Problem
+// @Router /admin/retention/compact [post]
+func (s *Server) CompactReadingsNow(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	result := s.compact(time.Now())
+	s.recordAudit(r, user, "readings.compact", "retention", "", nil, result)
+
+	writeJSON(w, result)
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// retentionNow is when the retention tests compact readings
+var retentionNow = time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC)
+
+// newRetentionServer returns a server with one metric, kept raw for a day,
+// by hour for 3 days and by day for 10 days
+func newRetentionServer() (*Server, uuid.UUID) {
+	s := NewServer()
+	id := uuid.New()
+	s.metrics[id] = &models.Metric{ID: id, Kind: "water"}
+	s.readings[id] = nil
+	policy := &models.RetentionPolicy{ID: uuid.New(), RawDays: 1, HourlyDays: 3, DailyDays: 10}
+	s.retention[policy.ID] = policy
+	return s, id
+}
+
+// addTestReading adds a reading of value to a metric
+func (s *Server) addTestReading(metricID uuid.UUID, at time.Time, value float64) {
+	s.readings[metricID] = append(s.readings[metricID], &models.MetricReading{ID: uuid.New(), MetricID: metricID, Timestamp: at, Value: value})
+}
+
+// rollupAt returns the rollup of a metric for a bucket, or nil
+func (s *Server) rollupAt(metricID uuid.UUID, resolution string, start time.Time) *models.Rollup {
+	return s.rollups[metricID][rollupKey{resolution, start.Unix()}]
+}
+
+func TestCompact(t *testing.T) {
+	at := func(day, hour, min, sec int) time.Time {
+		return time.Date(2024, 3, day, hour, min, sec, 0, time.UTC)
+	}
+
+	// The raw cutoff is 9 March 12:00, the hourly one 7 March 00:00, and
+	// daily rollups are kept while they end after 29 February 12:30
+	tests := []struct {
+		name       string
+		resolution string    // of what is there before compacting
+		start      time.Time // of the reading or bucket
+		want       string    // resolution it is kept at; empty if deleted
+		wantStart  time.Time
+	}{
+		{"reading at the raw cutoff", models.ResolutionRaw, at(9, 12, 0, 0), models.ResolutionRaw, at(9, 12, 0, 0)},
+		{"reading before the raw cutoff", models.ResolutionRaw, at(9, 11, 59, 59), models.ResolutionHour, at(9, 11, 0, 0)},
+		{"hour at the hourly cutoff", models.ResolutionHour, at(7, 0, 0, 0), models.ResolutionHour, at(7, 0, 0, 0)},
+		{"hour before the hourly cutoff", models.ResolutionHour, at(6, 23, 0, 0), models.ResolutionDay, at(6, 0, 0, 0)},
+		{"day ending after the daily cutoff", models.ResolutionDay, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), models.ResolutionDay, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"day ending before the daily cutoff", models.ResolutionDay, time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC), "", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, id := newRetentionServer()
+			if tt.resolution == models.ResolutionRaw {
+				s.addTestReading(id, tt.start, 2)
+			} else {
+				s.addRollup(models.Rollup{MetricID: id, Resolution: tt.resolution, Start: tt.start, Count: 1, Sum: 2, Min: 2, Max: 2})
+			}
+
+			s.compact(retentionNow)
+
+			var got string
+			var gotStart time.Time
+			switch {
+			case len(s.readings[id]) == 1:
+				got, gotStart = models.ResolutionRaw, s.readings[id][0].Timestamp
+			case len(s.rollups[id]) == 1:
+				for _, rollup := range s.rollups[id] {
+					got, gotStart = rollup.Resolution, rollup.Start
+				}
+			case len(s.readings[id]) > 1 || len(s.rollups[id]) > 1:
+				t.Fatalf("got %d readings and %d rollups, want one", len(s.readings[id]), len(s.rollups[id]))
+			}
+			if got != tt.want || !gotStart.Equal(tt.wantStart) {
+				t.Errorf("got %q at %s, want %q at %s", got, gotStart, tt.want, tt.wantStart)
+			}
+			if changed := tt.want != tt.resolution; changed != (s.metrics[id].ReadingsVersion == 1) {
+				t.Errorf("got readings version %d after changing: %t", s.metrics[id].ReadingsVersion, changed)
+			}
+		})
+	}
+
+	// Readings of the same hour are merged into one rollup
+	s, id := newRetentionServer()
+	s.addTestReading(id, at(8, 10, 5, 0), 1)
+	s.addTestReading(id, at(8, 10, 55, 0), 4)
+	result := s.compact(retentionNow)
+	rollup := s.rollupAt(id, models.ResolutionHour, at(8, 10, 0, 0))
+	if result.RawRolledUp != 2 || rollup == nil || rollup.Count != 2 || rollup.Sum != 5 || rollup.Min != 1 || rollup.Max != 4 {
+		t.Errorf("got result %+v and rollup %+v, want both readings in one", result, rollup)
+	}
+}
+
+func TestSeries(t *testing.T) {
+	s, id := newRetentionServer()
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	s.addTestReading(id, day.Add(10*time.Hour), 1)
+	s.addTestReading(id, day.Add(10*time.Hour+30*time.Minute), 2)
+	s.addTestReading(id, day.Add(11*time.Hour), 3)
+	s.addRollup(models.Rollup{MetricID: id, Resolution: models.ResolutionHour, Start: day.Add(9 * time.Hour), Count: 2, Sum: 4, Min: 1, Max: 3})
+	s.addRollup(models.Rollup{MetricID: id, Resolution: models.ResolutionDay, Start: day.AddDate(0, 0, -1), Count: 24, Sum: 48, Min: 1, Max: 3})
+
+	type point struct {
+		start      time.Time
+		resolution string
+		count      int
+	}
+	tests := []struct {
+		name       string
+		from, to   time.Time
+		resolution string
+		want       []point
+	}{
+		{"raw excludes the end", day.Add(10 * time.Hour), day.Add(11 * time.Hour), models.ResolutionRaw, []point{
+			{day.Add(10 * time.Hour), models.ResolutionRaw, 1},
+			{day.Add(10*time.Hour + 30*time.Minute), models.ResolutionRaw, 1},
+		}},
+		{"raw keeps rollups as they are", day.Add(9 * time.Hour), day.Add(10*time.Hour + time.Second), models.ResolutionRaw, []point{
+			{day.Add(9 * time.Hour), models.ResolutionHour, 2},
+			{day.Add(10 * time.Hour), models.ResolutionRaw, 1},
+		}},
+		{"by hour", day.Add(9 * time.Hour), day.Add(12 * time.Hour), models.ResolutionHour, []point{
+			{day.Add(9 * time.Hour), models.ResolutionHour, 2},
+			{day.Add(10 * time.Hour), models.ResolutionHour, 2},
+			{day.Add(11 * time.Hour), models.ResolutionHour, 1},
+		}},
+		{"rollups starting before the range", day.Add(9*time.Hour + time.Second), day.Add(12 * time.Hour), models.ResolutionHour, []point{
+			{day.Add(10 * time.Hour), models.ResolutionHour, 2},
+			{day.Add(11 * time.Hour), models.ResolutionHour, 1},
+		}},
+		{"by day", day.AddDate(0, 0, -1), day.AddDate(0, 0, 1), models.ResolutionDay, []point{
+			{day.AddDate(0, 0, -1), models.ResolutionDay, 24},
+			{day, models.ResolutionDay, 5},
+		}},
+		{"empty range", day.Add(12 * time.Hour), day.AddDate(0, 0, 1), models.ResolutionHour, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := s.series(id, tt.from, tt.to, tt.resolution)
+			var got []point
+			for _, p := range points {
+				got = append(got, point{p.Start, p.Resolution, p.Count})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].start.Equal(tt.want[i].start) || got[i].resolution != tt.want[i].resolution || got[i].count != tt.want[i].count {
+					t.Errorf("point %d: got %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSeriesResolution(t *testing.T) {
+	s, id := newRetentionServer()
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	s.addRollup(models.Rollup{MetricID: id, Resolution: models.ResolutionHour, Start: day.Add(-time.Hour), Count: 1})
+	s.addRollup(models.Rollup{MetricID: id, Resolution: models.ResolutionDay, Start: day.AddDate(0, 0, -30), Count: 1})
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     string
+	}{
+		{"raw readings only", day, day.Add(time.Hour), models.ResolutionRaw},
+		{"hourly rollup at the start", day.Add(-time.Hour), day.Add(time.Hour), models.ResolutionHour},
+		{"hourly rollup at the end", day.Add(-2 * time.Hour), day.Add(-time.Hour), models.ResolutionRaw},
+		{"daily rollup in the range", day.AddDate(0, 0, -30), day.AddDate(0, 0, -29), models.ResolutionDay},
+		{"7 days", day, day.Add(hourlySeriesSpan), models.ResolutionRaw},
+		{"over 7 days", day, day.Add(hourlySeriesSpan + time.Second), models.ResolutionHour},
+		{"90 days", day, day.Add(dailySeriesSpan), models.ResolutionHour},
+		{"over 90 days", day, day.Add(dailySeriesSpan + time.Second), models.ResolutionDay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.seriesResolution(id, tt.from, tt.to); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompactedReadings(t *testing.T) {
+	api := newTestAPI(t)
+	metric := api.createMetric("Meter")
+	path := "/metrics/" + metric.ID.String() + "/readings"
+	old := time.Now().UTC().AddDate(0, 0, -3).Truncate(time.Hour)
+	for _, value := range []float64{1, 2} {
+		api.expect(http.MethodPost, path, models.AddReadingRequest{Value: &value, Timestamp: old.Add(time.Minute)}, http.StatusOK, nil)
+	}
+	recent := 5.0
+	api.expect(http.MethodPost, path, models.AddReadingRequest{Value: &recent, Timestamp: time.Now().Add(-time.Hour)}, http.StatusOK, nil)
+
+	api.expect(http.MethodPost, "/admin/retention", models.CreateRetentionPolicyRequest{MetricID: &metric.ID, RawDays: 1}, http.StatusOK, nil)
+	api.expect(http.MethodPost, "/admin/retention/compact", nil, http.StatusOK, nil)
+
+	// Compacted readings come as rollups
+	var readings models.ReadingListResponse
+	api.expect(http.MethodGet, path, nil, http.StatusOK, &readings)
+	if readings.Total != 1 || len(readings.Rollups) != 1 {
+		t.Fatalf("got %+v, want a reading and a rollup", readings)
+	}
+	if rollup := readings.Rollups[0]; rollup.Resolution != models.ResolutionHour || !rollup.Start.Equal(old) || rollup.Count != 2 || rollup.Sum != 3 {
+		t.Errorf("got rollup %+v, want 2 readings summing to 3 in the hour of %s", rollup, old)
+	}
+
+	// And so they are in the readings report
+	from := old.AddDate(0, 0, -1).Format(time.RFC3339)
+	records := api.readCSV("/reports/readings?format=csv&from=" + from)
+	if len(records) != 3 {
+		t.Fatalf("got %v, want a header and 2 rows", records)
+	}
+	if got := records[1][5:]; got[0] != models.ResolutionHour || got[1] != "2" || got[2] != "3" {
+		t.Errorf("got %v, want the hourly rollup of 2 readings summing to 3", got)
+	}
+	if got := records[2][5:]; got[0] != models.ResolutionRaw || got[1] != "1" || got[2] != "5" {
+		t.Errorf("got %v, want the raw reading", got)
+	}
+}
@@ -1,13 +1,16 @@
 package server
 
 import (
-	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/audit"
 	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/auth"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/backup"
 	_ "github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/docs"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/events"
 	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
 	"github.com/google/uuid"
 	httpSwagger "github.com/swaggo/http-swagger"
@@ -15,11 +18,68 @@ import (
 
 // Server represents the HTTP server
 type Server struct {
+	// mu guards users, roles, rooms, metrics, readings, devices, retention,
+	// rollups and telegrams
+	mu       sync.RWMutex
 	users    map[uuid.UUID]*models.User
 	roles    map[string]*models.Role
 	rooms    map[uuid.UUID]*models.Room
 	metrics  map[uuid.UUID]*models.Metric
 	readings map[uuid.UUID][]*models.MetricReading
+	devices  map[uuid.UUID]*models.Device
+
+	// retention holds the retention policies, and rollups the readings
+	// compacted by them, by metric and bucket
+	retention map[uuid.UUID]*models.RetentionPolicy
+	rollups   map[uuid.UUID]map[rollupKey]*models.Rollup
+
+	// telegrams holds when recent M-Bus telegrams were received, to record
+	// those forwarded by several gateways once
+	telegrams map[string]time.Time
+
+	reportMu   sync.Mutex
+	reportJobs map[uuid.UUID]*reportJob
+
+	importMu   sync.Mutex
+	importJobs map[uuid.UUID]*importJob
+
+	backups *backup.Store
+	audit   *audit.Log
+
+	// trashRetention is how long deleted rooms and metrics can be restored
+	trashRetention time.Duration
+
+	// authenticate identifies the user of a request, by default by the JWT
+	// issued at login
+	authenticate Authenticator
+
+	// sessionMu guards sessions, which are touched on every request
+	sessionMu sync.Mutex
+	sessions  map[uuid.UUID]*models.Session
+
+	maxBodySize int64
+	cors        corsPolicy
+	gateways    map[string]*gateway
+
+	// telemetry holds the operational metrics of the observability listener
+	telemetry *telemetry
+	// stream publishes readings to the clients streaming them
+	stream *streamHub
+	// events is where handlers publish what happened, for side effects to
+	// subscribe to; persistOutbox saves its outbox with the state
+	events        *events.Bus
+	persistOutbox bool
+
+	// modbusMu guards modbusStatus, the status of the polled Modbus devices
+	modbusMu     sync.Mutex
+	modbusStatus []*models.ModbusDeviceStatus
+
+	// stateFile, if set, is where the state is saved by Flush
+	stateFile string
+	stateKey  []byte
+
+	// jobs tracks background report and import jobs, which Shutdown waits for
+	jobs sync.WaitGroup
 }
 
 // NewServer creates a new server instance
@@ -31,87 +91,144 @@ func NewServer() *Server {
 			Name:        "admin",
 			Description: "Administrator role with full access",
 			Permissions: []string{"read", "write", "delete", "manage_users", "manage_roles", "manage_metrics", "manage_rooms"},
+			Version:     1,
 		},
 		"user": {
 			ID:          uuid.New(),
 			Name:        "user",
 			Description: "Regular user role",
 			Permissions: []string{"read", "write"},
+			Version:     1,
 		},
 	}
 
-	return &Server{
+	s := &Server{
 		users:    make(map[uuid.UUID]*models.User),
 		roles:    roles,
 		rooms:    make(map[uuid.UUID]*models.Room),
 		metrics:  make(map[uuid.UUID]*models.Metric),
 		readings: make(map[uuid.UUID][]*models.MetricReading),
+		devices:  make(map[uuid.UUID]*models.Device),
+
+		retention: make(map[uuid.UUID]*models.RetentionPolicy),
+		rollups:   make(map[uuid.UUID]map[rollupKey]*models.Rollup),
+
+		telegrams: make(map[string]time.Time),
+
+		reportJobs: make(map[uuid.UUID]*reportJob),
+		importJobs: make(map[uuid.UUID]*importJob),
+
+		backups: backup.NewStore("backups", nil),
+		audit:   audit.NewLog(),
+
+		trashRetention: 30 * 24 * time.Hour,
+
+		sessions: make(map[uuid.UUID]*models.Session),
+
+		maxBodySize: defaultMaxBodySize,
+		telemetry:   newTelemetry(),
+		stream:      newStreamHub(),
+		events:      events.NewBus(),
 	}
+	s.authenticate = s.tokenUser
+	s.subscribeEvents()
+
+	return s
 }
 
 // Register godoc
 // @Summary Register a new user
-// @Description Register a new user with the provided information
+// @Description Register a new user with the user role; administrators grant other roles
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body models.RegisterRequest true "Registration request"
 // @Success 200 {object} models.AuthResponse
-// @Failure 400 {object} map[string]string
+// @Failure 400 {object} models.Problem
 // @Router /register [post]
 func (s *Server) Register(w http.ResponseWriter, r *http.Request) {
 	var req models.RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Check if username is already taken
 	for _, user := range s.users {
 		if user.Username == req.Username {
-			http.Error(w, "Username already taken", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, "username_taken", "Username already taken")
 			return
 		}
 	}
 
-	// Create user with default role if none specified
-	userRoles := make([]models.Role, 0)
-	if len(req.Roles) == 0 {
-		userRoles = append(userRoles, *s.roles["user"])
-	} else {
-		for _, roleName := range req.Roles {
-			if role, exists := s.roles[roleName]; exists {
-				userRoles = append(userRoles, *role)
-			}
-		}
-	}
-
-	now := time.Now()
-	user := &models.User{
-		ID:        uuid.New(),
-		Username:  req.Username,
-		Password:  req.Password, // In a real application, this should be hashed
-		Email:     req.Email,
-		Roles:     userRoles,
-		CreatedAt: now,
-		UpdatedAt: now,
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		writePasswordError(w, r, err)
+		return
 	}
 
-	s.users[user.ID] = user
+	// Users registering themselves only get the user role; administrators
+	// grant others with PATCH /users/{id}
+	user := s.addUser(req.Username, hash, req.Email, "user")
+	s.recordAudit(r, user, "user.register", "user", user.ID.String(), nil, user)
 
 	// Generate JWT token
-	token, err := auth.GenerateToken(user)
+	token, err := s.issueToken(r, user)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to generate token")
 		return
 	}
 
-	json.NewEncoder(w).Encode(models.AuthResponse{
+	writeJSON(w, models.AuthResponse{
 		Token: token,
 		User:  *user,
 	})
 }
 
+// addUser adds a user with the given password hash and role
+func (s *Server) addUser(username, passwordHash, email, role string) *models.User {
+	now := time.Now()
+	user := &models.User{
+		ID:           uuid.New(),
+		Username:     username,
+		PasswordHash: passwordHash,
+		Email:        email,
+		Roles:        []models.Role{*s.roles[role]},
+		Version:      1,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	s.users[user.ID] = user
+	return user
+}
+
+// writePasswordError writes the response for a password that could not be
+// hashed
+func writePasswordError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, auth.ErrPasswordTooLong) {
+		writeError(w, r, http.StatusBadRequest, "password_too_long", "Password must be at most 72 bytes")
+		return
+	}
+	writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to hash password")
+}
+
+// EnsureAdmin adds an administrator with the given credentials unless a user
+// of that name exists, so that a new server has someone to grant roles
+func (s *Server) EnsureAdmin(username, password, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Username == username {
+			return nil
+		}
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	s.addUser(username, hash, email, "admin")
+	return nil
+}
+
 // Login godoc
 // @Summary Login user
 // @Description Login with username and password
@@ -120,37 +237,37 @@ func (s *Server) Register(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param request body models.LoginRequest true "Login request"
 // @Success 200 {object} models.AuthResponse
-// @Failure 400 {object} map[string]string
+// @Failure 400 {object} models.Problem
 // @Router /login [post]
 func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
 	var req models.LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Find user by username
 	var user *models.User
+	var hash string
 	for _, u := range s.users {
 		if u.Username == req.Username {
-			user = u
+			user, hash = u, u.PasswordHash
 			break
 		}
 	}
 
-	if user == nil || user.Password != req.Password { // In a real application, compare hashed passwords
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	if !auth.CheckPassword(hash, req.Password) || user == nil {
+		writeError(w, r, http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
 		return
 	}
 
 	// Generate JWT token
-	token, err := auth.GenerateToken(user)
+	token, err := s.issueToken(r, user)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "internal_error", "Failed to generate token")
 		return
 	}
 
-	json.NewEncoder(w).Encode(models.AuthResponse{
+	writeJSON(w, models.AuthResponse{
 		Token: token,
 		User:  *user,
 	})
@@ -165,107 +282,194 @@ func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} models.UserListResponse
 // @Router /users [get]
 func (s *Server) ListUsers(w http.ResponseWriter, r *http.Request) {
-	// Check if user has admin role
-	claims, err := auth.ValidateToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if _, ok := s.requireAdmin(w, r); !ok {
 		return
 	}
 
-	// Find user by username
-	var user *models.User
+	users := make([]models.User, 0, len(s.users))
 	for _, u := range s.users {
-		if u.Username == claims.Username {
-			user = u
-			break
-		}
+		users = append(users, *u)
 	}
 
-	if user == nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	writeJSON(w, models.UserListResponse{
+		Users: users,
+		Total: len(users),
+	})
+}
+
+// UpdateUser godoc
+// @Summary Update a user
+// @Description Update the email, password or roles of a user
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body models.UpdateUserRequest true "User update request"
+// @Param If-Match header string false "ETag of the version being changed"
+// @Success 200 {object} models.User
+// @Header 200 {string} ETag "Version of the resource"
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Router /users/{id} [patch]
+func (s *Server) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid user ID")
 		return
 	}
 
-	// Check if user has admin role
-	hasAdminRole := false
-	for _, role := range user.Roles {
-		if role.Name == "admin" {
-			hasAdminRole = true
-			break
-		}
+	user, exists := s.users[id]
+	if !exists {
+		writeError(w, r, http.StatusNotFound, "user_not_found", "User not found")
+		return
 	}
 
-	if !hasAdminRole {
-		http.Error(w, "Permission denied", http.StatusForbidden)
+	if preconditionFailed(w, r, user.Version) {
 		return
 	}
 
-	users := make([]models.User, 0, len(s.users))
-	for _, u := range s.users {
-		users = append(users, *u)
+	var req models.UpdateUserRequest
+	if !decodeJSON(w, r, &req) {
+		return
 	}
 
-	json.NewEncoder(w).Encode(models.UserListResponse{
-		Users: users,
-		Total: len(users),
-	})
+	// Administrators changing their own password still have to prove they
+	// know the current one
+	if !s.updateUser(w, r, admin, user, req, user.ID == admin.ID) {
+		return
+	}
+
+	setETag(w, user.Version)
+	writeJSON(w, user)
 }
 
-// CreateRole godoc
-// @Summary Create a new role
-// @Description Create a new role with specified permissions
-// @Tags roles
+// UpdateCurrentUser godoc
+// @Summary Update the current user
+// @Description Update the email or password of the authenticated user. Changing the password requires the current password.
+// @Tags users
 // @Accept json
 // @Produce json
-// @Param request body models.CreateRoleRequest true "Role creation request"
-// @Success 200 {object} models.Role
-// @Failure 400 {object} map[string]string
-// @Router /roles [post]
-func (s *Server) CreateRole(w http.ResponseWriter, r *http.Request) {
-	// Check if user has admin role
-	claims, err := auth.ValidateToken(r)
+// @Param request body models.UpdateUserRequest true "User update request"
+// @Param If-Match header string false "ETag of the version being changed"
+// @Success 200 {object} models.User
+// @Header 200 {string} ETag "Version of the resource"
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Router /users/me [patch]
+func (s *Server) UpdateCurrentUser(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 		return
 	}
 
-	// Find user by username
-	var user *models.User
-	for _, u := range s.users {
-		if u.Username == claims.Username {
-			user = u
-			break
-		}
+	if preconditionFailed(w, r, user.Version) {
+		return
 	}
 
-	if user == nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+	var req models.UpdateUserRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	// Check if user has admin role
-	hasAdminRole := false
-	for _, role := range user.Roles {
-		if role.Name == "admin" {
-			hasAdminRole = true
-			break
+	if req.Roles != nil && !hasRole(user, "admin") {
+		writeError(w, r, http.StatusForbidden, "forbidden", "Permission denied")
+		return
+	}
+
+	if !s.updateUser(w, r, user, user, req, true) {
+		return
+	}
+
+	setETag(w, user.Version)
+	writeJSON(w, user)
+}
+
+// updateUser applies the update to user on behalf of actor, writing an error
+// response and returning false if it is rejected
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request, actor, user *models.User, req models.UpdateUserRequest, checkPassword bool) bool {
+	if req.Password != nil && checkPassword && !auth.CheckPassword(user.PasswordHash, req.CurrentPassword) {
+		writeError(w, r, http.StatusForbidden, "wrong_password", "Current password is incorrect")
+		return false
+	}
+
+	var hash string
+	if req.Password != nil {
+		var err error
+		if hash, err = auth.HashPassword(*req.Password); err != nil {
+			writePasswordError(w, r, err)
+			return false
+		}
+	}
+
+	var roles []models.Role
+	if req.Roles != nil {
+		for _, roleName := range *req.Roles {
+			role, exists := s.roles[roleName]
+			if !exists {
+				writeError(w, r, http.StatusBadRequest, "unknown_role", "Unknown role: "+roleName)
+				return false
+			}
+			roles = append(roles, *role)
 		}
 	}
 
-	if !hasAdminRole {
-		http.Error(w, "Permission denied", http.StatusForbidden)
+	before := *user
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	if req.Password != nil {
+		user.PasswordHash = hash
+	}
+	if req.Roles != nil {
+		user.Roles = roles
+	}
+	user.UpdatedAt = time.Now()
+	user.Version++
+
+	action := "user.update"
+	if req.Password != nil {
+		action = "user.password_change"
+		// Whoever knew the old password is logged out everywhere else
+		s.endSessions(user.ID, sessionID(r))
+	}
+	s.recordAudit(r, actor, action, "user", user.ID.String(), before, user)
+	return true
+}
+
+// CreateRole godoc
+// @Summary Create a new role
+// @Description Create a new role with specified permissions
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Param request body models.CreateRoleRequest true "Role creation request"
+// @Success 200 {object} models.Role
+// @Header 200 {string} ETag "Version of the resource"
+// @Failure 400 {object} models.Problem
+// @Router /roles [post]
+func (s *Server) CreateRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
 		return
 	}
 
 	var req models.CreateRoleRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	// Check if role already exists
 	if _, exists := s.roles[req.Name]; exists {
-		http.Error(w, "Role already exists", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "role_exists", "Role already exists")
 		return
 	}
 
@@ -274,28 +478,92 @@ func (s *Server) CreateRole(w http.ResponseWriter, r *http.Request) {
 		Name:        req.Name,
 		Description: req.Description,
 		Permissions: req.Permissions,
+		Version:     1,
 	}
 
 	s.roles[role.Name] = role
+	s.recordAudit(r, user, "role.create", "role", role.ID.String(), nil, role)
 
-	json.NewEncoder(w).Encode(role)
+	setETag(w, role.Version)
+	writeJSON(w, role)
 }
 
-// GetUserIDFromToken extracts user ID from JWT token
+// GetUserIDFromToken returns the ID of the user the request is authenticated as
 func (s *Server) GetUserIDFromToken(r *http.Request) (uuid.UUID, error) {
-	claims, err := auth.ValidateToken(r)
+	user, err := s.currentUser(r)
 	if err != nil {
 		return uuid.Nil, err
 	}
 
-	// Find user by username
-	for _, user := range s.users {
-		if user.Username == claims.Username {
-			return user.ID, nil
+	return user.ID, nil
+}
+
+// currentUser returns the user the request is authenticated as
+func (s *Server) currentUser(r *http.Request) (*models.User, error) {
+	if a, ok := r.Context().Value(authenticatorKey).(Authenticator); ok {
+		return a(r)
+	}
+	return s.authenticate(r)
+}
+
+// requireAdmin writes an error response and returns false unless the request
+// is made by an administrator, who is returned otherwise
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return nil, false
+	}
+
+	if !hasRole(user, "admin") {
+		writeError(w, r, http.StatusForbidden, "forbidden", "Permission denied")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// activeRoom returns the room with the given ID unless it is in the trash
+func (s *Server) activeRoom(id uuid.UUID) (*models.Room, bool) {
+	room, exists := s.rooms[id]
+	if !exists || room.DeletedAt != nil {
+		return nil, false
+	}
+	return room, true
+}
+
+// activeMetric returns the metric with the given ID unless it is in the trash
+func (s *Server) activeMetric(id uuid.UUID) (*models.Metric, bool) {
+	metric, exists := s.metrics[id]
+	if !exists || metric.DeletedAt != nil {
+		return nil, false
+	}
+	return metric, true
+}
+
+// locked serializes access to the server state: reads share the lock while
+// requests that may modify state hold it exclusively
+func (s *Server) locked(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+		} else {
+			s.mu.Lock()
+			defer s.mu.Unlock()
 		}
+		h(w, r)
 	}
+}
 
-	return uuid.Nil, fmt.Errorf("user not found")
+// hasRole reports whether the user has been granted the named role
+func hasRole(user *models.User, name string) bool {
+	for _, role := range user.Roles {
+		if role.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
 // CreateRoom godoc
@@ -306,38 +574,18 @@ func (s *Server) GetUserIDFromToken(r *http.Request) (uuid.UUID, error) {
 // @Produce json
 // @Param request body models.CreateRoomRequest true "Room creation request"
 // @Success 200 {object} models.Room
-// @Failure 400 {object} map[string]string
+// @Header 200 {string} ETag "Version of the resource"
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
 // @Router /rooms [post]
 func (s *Server) CreateRoom(w http.ResponseWriter, r *http.Request) {
-	// Check if user has permission to manage rooms
-	userID, err := s.GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	user, exists := s.users[userID]
-	if !exists {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
-	hasPermission := false
-	for _, role := range user.Roles {
-		if role.Name == "admin" {
-			hasPermission = true
-			break
-		}
-	}
-
-	if !hasPermission {
-		http.Error(w, "Permission denied", http.StatusForbidden)
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
 		return
 	}
 
 	var req models.CreateRoomRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
@@ -346,13 +594,22 @@ func (s *Server) CreateRoom(w http.ResponseWriter, r *http.Request) {
 		ID:          uuid.New(),
 		Name:        req.Name,
 		Description: req.Description,
+		Building:    req.Building,
+		Complex:     req.Complex,
+		Area:        req.Area,
+		Occupants:   req.Occupants,
+		OwnerID:     req.OwnerID,
+		Version:     1,
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
 
 	s.rooms[room.ID] = room
+	s.recordAudit(r, user, "room.create", "room", room.ID.String(), nil, room)
+	s.publish(events.RoomCreated{Room: *room})
 
-	json.NewEncoder(w).Encode(room)
+	setETag(w, room.Version)
+	writeJSON(w, room)
 }
 
 // ListRooms godoc
@@ -367,16 +624,18 @@ func (s *Server) ListRooms(w http.ResponseWriter, r *http.Request) {
 	// Check if user is authenticated
 	_, err := s.GetUserIDFromToken(r)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 		return
 	}
 
 	rooms := make([]models.Room, 0, len(s.rooms))
 	for _, r := range s.rooms {
-		rooms = append(rooms, *r)
+		if r.DeletedAt == nil {
+			rooms = append(rooms, *r)
+		}
 	}
 
-	json.NewEncoder(w).Encode(models.RoomListResponse{
+	writeJSON(w, models.RoomListResponse{
 		Rooms: rooms,
 		Total: len(rooms),
 	})
@@ -389,551 +648,260 @@ func (s *Server) ListRooms(w http.ResponseWriter, r *http.Request) {
 // @Accept json
 // @Produce json
 // @Param id path string true "Room ID"
+// @Param If-None-Match header string false "ETag of the cached version"
 // @Success 200 {object} models.Room
-// @Failure 404 {object} map[string]string
+// @Header 200 {string} ETag "Version of the resource"
+// @Success 304 "Not modified"
+// @Failure 404 {object} models.Problem
 // @Router /rooms/{id} [get]
 func (s *Server) GetRoom(w http.ResponseWriter, r *http.Request) {
 	// Check if user is authenticated
 	_, err := s.GetUserIDFromToken(r)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 		return
 	}
 
-	idStr := r.URL.Path[len("/rooms/"):]
+	idStr := r.PathValue("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid room ID")
 		return
 	}
 
-	room, exists := s.rooms[id]
+	room, exists := s.activeRoom(id)
 	if !exists {
-		http.Error(w, "Room not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, "room_not_found", "Room not found")
 		return
 	}
 
-	json.NewEncoder(w).Encode(room)
+	if notModified(w, r, room.Version) {
+		return
+	}
+	setETag(w, room.Version)
+	writeJSON(w, room)
 }
 
-// DeleteRoom godoc
-// @Summary Delete a room
-// @Description Delete a room and all its metrics
+// UpdateRoom godoc
+// @Summary Update a room
+// @Description Update the details of a room; omitted fields are left unchanged
 // @Tags rooms
 // @Accept json
 // @Produce json
 // @Param id path string true "Room ID"
-// @Success 200 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /rooms/{id} [delete]
-func (s *Server) DeleteRoom(w http.ResponseWriter, r *http.Request) {
-	// Check if user has permission to manage rooms
-	userID, err := s.GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	user, exists := s.users[userID]
-	if !exists {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
-	hasPermission := false
-	for _, role := range user.Roles {
-		if role.Name == "admin" {
-			hasPermission = true
-			break
-		}
-	}
-
-	if !hasPermission {
-		http.Error(w, "Permission denied", http.StatusForbidden)
+// @Param request body models.UpdateRoomRequest true "Room update request"
+// @Param If-Match header string false "ETag of the version being changed"
+// @Success 200 {object} models.Room
+// @Header 200 {string} ETag "Version of the resource"
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Router /rooms/{id} [patch]
+func (s *Server) UpdateRoom(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
 		return
 	}
 
-	idStr := r.URL.Path[len("/rooms/"):]
+	idStr := r.PathValue("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid room ID")
 		return
 	}
 
-	if _, exists := s.rooms[id]; !exists {
-		http.Error(w, "Room not found", http.StatusNotFound)
-		return
-	}
-
-	// Delete all metrics associated with this room
-	for metricID, metric := range s.metrics {
-		if metric.RoomID == id {
-			delete(s.metrics, metricID)
-			delete(s.readings, metricID)
-		}
-	}
-
-	delete(s.rooms, id)
-
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Room deleted successfully",
-	})
-}
-
-// CreateMetric godoc
-// @Summary Create a new metric
-// @Description Create a new household metric
-// @Tags metrics
-// @Accept json
-// @Produce json
-// @Param request body models.CreateMetricRequest true "Metric creation request"
-// @Success 200 {object} models.Metric
-// @Failure 400 {object} map[string]string
-// @Router /metrics [post]
-func (s *Server) CreateMetric(w http.ResponseWriter, r *http.Request) {
-	// Check if user has permission to manage metrics
-	userID, err := s.GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	user, exists := s.users[userID]
+	room, exists := s.activeRoom(id)
 	if !exists {
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeError(w, r, http.StatusNotFound, "room_not_found", "Room not found")
 		return
 	}
 
-	hasPermission := false
-	for _, role := range user.Roles {
-		if role.Name == "admin" {
-			hasPermission = true
-			break
-		}
-	}
-
-	if !hasPermission {
-		http.Error(w, "Permission denied", http.StatusForbidden)
+	if preconditionFailed(w, r, room.Version) {
 		return
 	}
 
-	var req models.CreateMetricRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	var req models.UpdateRoomRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	// Check if room exists
-	if _, exists := s.rooms[req.RoomID]; !exists {
-		http.Error(w, "Room not found", http.StatusBadRequest)
-		return
+	before := *room
+	if req.Name != nil {
+		room.Name = *req.Name
 	}
-
-	now := time.Now()
-	metric := &models.Metric{
-		ID:          uuid.New(),
-		Name:        req.Name,
-		Description: req.Description,
-		Unit:        req.Unit,
-		RoomID:      req.RoomID,
-		CreatedAt:   now,
-		UpdatedAt:   now,
+	if req.Description != nil {
+		room.Description = *req.Description
 	}
-
-	s.metrics[metric.ID] = metric
-	s.readings[metric.ID] = make([]*models.MetricReading, 0)
-
-	json.NewEncoder(w).Encode(metric)
-}
-
-// ListMetrics godoc
-// @Summary List all metrics
-// @Description Get a list of all metrics
-// @Tags metrics
-// @Accept json
-// @Produce json
-// @Success 200 {object} models.MetricListResponse
-// @Router /metrics [get]
-func (s *Server) ListMetrics(w http.ResponseWriter, r *http.Request) {
-	// Check if user is authenticated
-	_, err := s.GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+	if req.Building != nil {
+		room.Building = *req.Building
 	}
-
-	metrics := make([]models.Metric, 0, len(s.metrics))
-	for _, m := range s.metrics {
-		metrics = append(metrics, *m)
+	if req.Complex != nil {
+		room.Complex = *req.Complex
 	}
-
-	json.NewEncoder(w).Encode(models.MetricListResponse{
-		Metrics: metrics,
-		Total:   len(metrics),
-	})
-}
-
-// GetMetric godoc
-// @Summary Get metric details
-// @Description Get details of a specific metric with its readings
-// @Tags metrics
-// @Accept json
-// @Produce json
-// @Param id path string true "Metric ID"
-// @Success 200 {object} models.MetricWithReadings
-// @Failure 404 {object} map[string]string
-// @Router /metrics/{id} [get]
-func (s *Server) GetMetric(w http.ResponseWriter, r *http.Request) {
-	// Check if user is authenticated
-	_, err := s.GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+	if req.Area != nil {
+		room.Area = *req.Area
 	}
-
-	idStr := r.URL.Path[len("/metrics/"):]
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		http.Error(w, "Invalid metric ID", http.StatusBadRequest)
-		return
+	if req.Occupants != nil {
+		room.Occupants = *req.Occupants
 	}
-
-	metric, exists := s.metrics[id]
-	if !exists {
-		http.Error(w, "Metric not found", http.StatusNotFound)
-		return
+	if req.OwnerID != nil {
+		room.OwnerID = *req.OwnerID
 	}
+	room.UpdatedAt = time.Now()
+	room.Version++
 
-	readings := make([]models.MetricReading, 0, len(s.readings[id]))
-	for _, r := range s.readings[id] {
-		readings = append(readings, *r)
-	}
+	s.recordAudit(r, user, "room.update", "room", id.String(), before, room)
 
-	json.NewEncoder(w).Encode(models.MetricWithReadings{
-		Metric:   *metric,
-		Readings: readings,
-	})
+	setETag(w, room.Version)
+	writeJSON(w, room)
 }
 
-// DeleteMetric godoc
-// @Summary Delete a metric
-// @Description Delete a metric and all its readings
-// @Tags metrics
+// DeleteRoom godoc
+// @Summary Delete a room
+// @Description Move a room and all its metrics to the trash, from which they can be restored until the retention window passes
+// @Tags rooms
 // @Accept json
 // @Produce json
-// @Param id path string true "Metric ID"
+// @Param id path string true "Room ID"
+// @Param If-Match header string false "ETag of the version being changed"
 // @Success 200 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /metrics/{id} [delete]
-func (s *Server) DeleteMetric(w http.ResponseWriter, r *http.Request) {
-	// Check if user has permission to manage metrics
-	userID, err := s.GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	user, exists := s.users[userID]
-	if !exists {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
-
-	hasPermission := false
-	for _, role := range user.Roles {
-		if role.Name == "admin" {
-			hasPermission = true
-			break
-		}
-	}
-
-	if !hasPermission {
-		http.Error(w, "Permission denied", http.StatusForbidden)
-		return
-	}
-
-	idStr := r.URL.Path[len("/metrics/"):]
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		http.Error(w, "Invalid metric ID", http.StatusBadRequest)
-		return
-	}
-
-	if _, exists := s.metrics[id]; !exists {
-		http.Error(w, "Metric not found", http.StatusNotFound)
-		return
-	}
-
-	delete(s.metrics, id)
-	delete(s.readings, id)
-
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Metric deleted successfully",
-	})
-}
-
-// AddReading godoc
-// @Summary Add a reading
-// @Description Add a new reading for a metric
-// @Tags metrics
-// @Accept json
-// @Produce json
-// @Param id path string true "Metric ID"
-// @Param request body models.AddReadingRequest true "Reading request"
-// @Success 200 {object} models.MetricReading
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /metrics/{id}/readings [post]
-func (s *Server) AddReading(w http.ResponseWriter, r *http.Request) {
-	// Check if user is authenticated
-	_, err := s.GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	idStr := r.URL.Path[len("/metrics/"):]
-	idStr = idStr[:len(idStr)-len("/readings")]
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		http.Error(w, "Invalid metric ID", http.StatusBadRequest)
-		return
-	}
-
-	if _, exists := s.metrics[id]; !exists {
-		http.Error(w, "Metric not found", http.StatusNotFound)
-		return
-	}
-
-	var req models.AddReadingRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// If timestamp is not provided, use current time
-	if req.Timestamp.IsZero() {
-		req.Timestamp = time.Now()
-	}
-
-	reading := &models.MetricReading{
-		ID:        uuid.New(),
-		MetricID:  id,
-		Value:     req.Value,
-		Timestamp: req.Timestamp,
-		CreatedAt: time.Now(),
-	}
-
-	s.readings[id] = append(s.readings[id], reading)
-
-	json.NewEncoder(w).Encode(reading)
-}
-
-// GetReadings godoc
-// @Summary Get metric readings
-// @Description Get all readings for a metric
-// @Tags metrics
-// @Accept json
-// @Produce json
-// @Param id path string true "Metric ID"
-// @Success 200 {object} models.ReadingListResponse
-// @Failure 404 {object} map[string]string
-// @Router /metrics/{id}/readings [get]
-func (s *Server) GetReadings(w http.ResponseWriter, r *http.Request) {
-	// Check if user is authenticated
-	_, err := s.GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Router /rooms/{id} [delete]
+func (s *Server) DeleteRoom(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
 		return
 	}
 
-	idStr := r.URL.Path[len("/metrics/"):]
-	idStr = idStr[:len(idStr)-len("/readings")]
+	idStr := r.PathValue("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		http.Error(w, "Invalid metric ID", http.StatusBadRequest)
-		return
-	}
-
-	if _, exists := s.metrics[id]; !exists {
-		http.Error(w, "Metric not found", http.StatusNotFound)
-		return
-	}
-
-	readings := make([]models.MetricReading, 0, len(s.readings[id]))
-	for _, r := range s.readings[id] {
-		readings = append(readings, *r)
-	}
-
-	json.NewEncoder(w).Encode(models.ReadingListResponse{
-		Readings: readings,
-		Total:    len(readings),
-	})
-}
-
-// CalculateCorrelation godoc
-// @Summary Calculate correlation between two metrics
-// @Description Calculate linear correlation between two metrics over a specified period
-// @Tags metrics
-// @Accept json
-// @Produce json
-// @Param request body models.CorrelationRequest true "Correlation calculation request"
-// @Success 200 {object} models.CorrelationResponse
-// @Failure 400 {object} map[string]string
-// @Router /metrics/correlation [post]
-func (s *Server) CalculateCorrelation(w http.ResponseWriter, r *http.Request) {
-	// Check if user is authenticated
-	_, err := s.GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid room ID")
 		return
 	}
 
-	var req models.CorrelationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate metrics exist
-	metric1, exists := s.metrics[req.Metric1ID]
+	room, exists := s.activeRoom(id)
 	if !exists {
-		http.Error(w, "First metric not found", http.StatusBadRequest)
+		writeError(w, r, http.StatusNotFound, "room_not_found", "Room not found")
 		return
 	}
 
-	metric2, exists := s.metrics[req.Metric2ID]
-	if !exists {
-		http.Error(w, "Second metric not found", http.StatusBadRequest)
+	if preconditionFailed(w, r, room.Version) {
 		return
 	}
 
-	// Get readings for both metrics within the time period
-	readings1 := s.getReadingsInPeriod(req.Metric1ID, req.StartTime, req.EndTime)
-	readings2 := s.getReadingsInPeriod(req.Metric2ID, req.StartTime, req.EndTime)
-
-	if len(readings1) == 0 || len(readings2) == 0 {
-		http.Error(w, "No readings found for the specified period", http.StatusBadRequest)
-		return
+	// The room and its metrics are moved to the trash together, sharing the
+	// deletion time, and are purged once the retention window has passed
+	now := time.Now()
+	deletedMetrics := make([]models.Metric, 0)
+	deletedIDs := make([]uuid.UUID, 0)
+	deletedReadings := 0
+	for metricID, metric := range s.metrics {
+		if metric.RoomID == id && metric.DeletedAt == nil {
+			deletedMetrics = append(deletedMetrics, *metric)
+			deletedIDs = append(deletedIDs, metricID)
+			deletedReadings += len(s.readings[metricID])
+			metric.DeletedAt = &now
+			metric.Version++
+		}
 	}
 
-	// Calculate correlation (simplified version with static value for demonstration)
-	correlation := 0.75 // Static value for demonstration
+	before := *room
+	room.DeletedAt = &now
+	room.Version++
+	s.recordAudit(r, user, "room.delete", "room", id.String(), map[string]interface{}{
+		"room":     before,
+		"metrics":  deletedMetrics,
+		"readings": deletedReadings,
+	}, nil)
+	s.publish(events.RoomDeleted{Room: *room, MetricIDs: deletedIDs})
 
-	response := models.CorrelationResponse{
-		Metric1Name: metric1.Name,
-		Metric2Name: metric2.Name,
-		StartTime:   req.StartTime,
-		EndTime:     req.EndTime,
-		Correlation: correlation,
-		Message:     "Correlation calculated successfully",
-	}
-
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, map[string]string{
+		"message": "Room moved to trash",
+	})
 }
 
-// getReadingsInPeriod returns readings for a metric within the specified time period
-func (s *Server) getReadingsInPeriod(metricID uuid.UUID, startTime, endTime time.Time) []*models.MetricReading {
-	readings := s.readings[metricID]
-	var filteredReadings []*models.MetricReading
+// Handler returns the HTTP handler serving the API. Every server has a mux
+// of its own, so several servers can run in one process.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
 
-	for _, reading := range readings {
-		if reading.Timestamp.After(startTime) && reading.Timestamp.Before(endTime) {
-			filteredReadings = append(filteredReadings, reading)
-		}
-	}
-
-	return filteredReadings
-}
-
-// Start starts the server
-func (s *Server) Start(addr string) error {
 	// API endpoints
-	http.HandleFunc("/register", s.Register)
-	http.HandleFunc("/login", s.Login)
-	http.HandleFunc("/users", s.ListUsers)
-	http.HandleFunc("/roles", s.CreateRole)
+	mux.HandleFunc("POST /register", s.locked(s.Register))
+	mux.HandleFunc("POST /login", s.locked(s.Login))
+	mux.HandleFunc("GET /users", s.locked(s.ListUsers))
+	mux.HandleFunc("GET /users/me", s.locked(s.GetCurrentUser))
+	mux.HandleFunc("PATCH /users/me", s.locked(s.UpdateCurrentUser))
+	mux.HandleFunc("DELETE /users/me", s.locked(s.DeleteAccount))
+	mux.HandleFunc("POST /users/me/password", s.locked(s.ChangePassword))
+	mux.HandleFunc("GET /users/me/export", s.locked(s.ExportAccount))
+	mux.HandleFunc("GET /users/me/rooms", s.locked(s.ListMyRooms))
+	mux.HandleFunc("GET /users/me/metrics", s.locked(s.ListMyMetrics))
+	mux.HandleFunc("GET /users/me/sessions", s.locked(s.ListSessions))
+	mux.HandleFunc("DELETE /users/me/sessions/{id}", s.locked(s.RevokeSession))
+	mux.HandleFunc("PATCH /users/{id}", s.locked(s.UpdateUser))
+	mux.HandleFunc("POST /roles", s.locked(s.CreateRole))
 
 	// Room endpoints
-	http.HandleFunc("/rooms", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			s.CreateRoom(w, r)
-		case http.MethodGet:
-			s.ListRooms(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	http.HandleFunc("/rooms/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/rooms/" {
-			http.Error(w, "Invalid room ID", http.StatusBadRequest)
-			return
-		}
-
-		switch r.Method {
-		case http.MethodGet:
-			s.GetRoom(w, r)
-		case http.MethodDelete:
-			s.DeleteRoom(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	mux.HandleFunc("POST /rooms", s.locked(s.CreateRoom))
+	mux.HandleFunc("GET /rooms", s.locked(s.ListRooms))
+	mux.HandleFunc("GET /rooms/{id}", s.locked(s.GetRoom))
+	mux.HandleFunc("PATCH /rooms/{id}", s.locked(s.UpdateRoom))
+	mux.HandleFunc("DELETE /rooms/{id}", s.locked(s.DeleteRoom))
+	mux.HandleFunc("GET /rooms/{id}/benchmark", s.locked(s.GetRoomBenchmark))
 
 	// Metric endpoints
-	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			s.CreateMetric(w, r)
-		case http.MethodGet:
-			s.ListMetrics(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
-
-	http.HandleFunc("/metrics/correlation", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		s.CalculateCorrelation(w, r)
-	})
-
-	http.HandleFunc("/metrics/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/metrics/" {
-			http.Error(w, "Invalid metric ID", http.StatusBadRequest)
-			return
-		}
-
-		if r.URL.Path[len(r.URL.Path)-len("/readings"):] == "/readings" {
-			switch r.Method {
-			case http.MethodPost:
-				s.AddReading(w, r)
-			case http.MethodGet:
-				s.GetReadings(w, r)
-			default:
-				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			}
-			return
-		}
-
-		switch r.Method {
-		case http.MethodGet:
-			s.GetMetric(w, r)
-		case http.MethodDelete:
-			s.DeleteMetric(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
-	})
+	s.registerMetricRoutes(mux)
+
+	// Device endpoints
+	mux.HandleFunc("POST /devices", s.locked(s.CreateDevice))
+	mux.HandleFunc("GET /devices", s.locked(s.ListDevices))
+	mux.HandleFunc("GET /devices/{id}", s.locked(s.GetDevice))
+	mux.HandleFunc("PATCH /devices/{id}", s.locked(s.UpdateDevice))
+	mux.HandleFunc("DELETE /devices/{id}", s.locked(s.DeleteDevice))
+	mux.HandleFunc("POST /devices/{id}/keys", s.locked(s.CreateDeviceKey))
+	mux.HandleFunc("DELETE /devices/{id}/keys/{keyId}", s.locked(s.RevokeDeviceKey))
+
+	// Report endpoints; reports are generated off the lock, which they take
+	// only to collect their data
+	mux.HandleFunc("GET /reports/{type}", s.GenerateReport)
+	mux.HandleFunc("GET /reports/jobs/{id}", s.locked(s.GetReportJob))
+	mux.HandleFunc("GET /reports/jobs/{id}/download", s.locked(s.DownloadReport))
+
+	// Import endpoints lock the server themselves, a batch at a time
+	mux.HandleFunc("POST /imports", s.CreateImport)
+	mux.HandleFunc("GET /imports", s.ListImports)
+	mux.HandleFunc("GET /imports/{id}", s.GetImport)
+
+	// Admin endpoints; backups and restores lock the server themselves so
+	// that file IO does not hold the lock
+	mux.HandleFunc("POST /admin/backups", s.CreateBackup)
+	mux.HandleFunc("GET /admin/backups", s.ListBackups)
+	mux.HandleFunc("GET /admin/backups/{id}", s.DownloadBackup)
+	mux.HandleFunc("POST /admin/restore", s.RestoreBackup)
+	mux.HandleFunc("GET /admin/audit", s.locked(s.ListAuditLog))
+	mux.HandleFunc("GET /admin/audit/verify", s.locked(s.VerifyAuditLog))
+	mux.HandleFunc("GET /admin/trash", s.locked(s.ListTrash))
+	mux.HandleFunc("GET /admin/modbus", s.locked(s.GetModbusStatus))
+	mux.HandleFunc("GET /admin/events", s.locked(s.GetEventStatus))
+	mux.HandleFunc("GET /admin/retention", s.locked(s.ListRetentionPolicies))
+	mux.HandleFunc("POST /admin/retention", s.locked(s.CreateRetentionPolicy))
+	mux.HandleFunc("DELETE /admin/retention/{id}", s.locked(s.DeleteRetentionPolicy))
+	mux.HandleFunc("POST /admin/retention/compact", s.locked(s.CompactReadingsNow))
+	mux.HandleFunc("POST /admin/trash/rooms/{id}/restore", s.locked(s.RestoreRoom))
+	mux.HandleFunc("POST /admin/trash/metrics/{id}/restore", s.locked(s.RestoreMetric))
 
 	// Swagger documentation
-	http.HandleFunc("/swagger/", httpSwagger.Handler(
+	mux.HandleFunc("GET /swagger/", httpSwagger.Handler(
 		httpSwagger.URL("/swagger/doc.json"),
 	))
 
-	return http.ListenAndServe(addr, nil)
+	return withRequestID(s.withTelemetry(mux, withQueryToken(s.withCORS(withBodyLimit(s.maxBodySize, s.withGateways(s.withDevices(withProblemErrors(mux))))))))
+}
+
+// Start starts the server
+func (s *Server) Start(addr string) error {
+	return http.ListenAndServe(addr, s.Handler())
 }
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/auth"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/backup"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func init() {
+	// Tests log in all the time; they need no expensive password hashes
+	auth.PasswordCost = bcrypt.MinCost
+}
+
+// testAPI sends requests to a server listening on httptest, as one user
+type testAPI struct {
+	t     *testing.T
+	s     *Server
+	url   string
+	token string
+}
+
+// newTestAPI starts a server with an administrator to send requests as
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	s := NewServer()
+	if err := s.EnsureAdmin("admin", "secret123", "admin@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+
+	api := &testAPI{t: t, s: s, url: ts.URL}
+	api.token = api.login("admin")
+	return api
+}
+
+// register registers a user and returns its token
+func (api *testAPI) register(username string) string {
+	api.t.Helper()
+	var auth models.AuthResponse
+	api.expect(http.MethodPost, "/register", models.RegisterRequest{
+		Username: username,
+		Password: "secret123",
+		Email:    username + "@example.com",
+	}, http.StatusOK, &auth)
+	return auth.Token
+}
+
+// login logs a user in and returns its token
+func (api *testAPI) login(username string) string {
+	api.t.Helper()
+	var auth models.AuthResponse
+	api.expect(http.MethodPost, "/login", models.LoginRequest{Username: username, Password: "secret123"}, http.StatusOK, &auth)
+	return auth.Token
+}
+
+// as returns a copy of api sending requests with another token
+func (api *testAPI) as(token string) *testAPI {
+	other := *api
+	other.token = token
+	return &other
+}
+
+// do sends a request with a JSON body, unless body is nil, and headers
+// given as name, value pairs
+func (api *testAPI) do(method, path string, body interface{}, headers ...string) *http.Response {
+	api.t.Helper()
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		payload = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, api.url+path, payload)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if api.token != "" {
+		req.Header.Set("Authorization", "Bearer "+api.token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	return resp
+}
+
+// expect sends a request, fails the test unless it is answered with status,
+// and decodes the response into out unless it is nil
+func (api *testAPI) expect(method, path string, body interface{}, status int, out interface{}, headers ...string) *http.Response {
+	api.t.Helper()
+	resp := api.do(method, path, body, headers...)
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		api.t.Fatalf("%s %s: got status %d, want %d: %s", method, path, resp.StatusCode, status, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			api.t.Fatalf("%s %s: decoding %s: %v", method, path, data, err)
+		}
+	}
+	return resp
+}
+
+// expectProblem sends a request and checks that it fails with a
+// problem+json response of the given status and code
+func (api *testAPI) expectProblem(method, path string, body interface{}, status int, code string, headers ...string) models.Problem {
+	api.t.Helper()
+	var problem models.Problem
+	resp := api.expect(method, path, body, status, &problem, headers...)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/problem+json" {
+		api.t.Errorf("%s %s: got Content-Type %q, want application/problem+json", method, path, ct)
+	}
+	if problem.Status != status || problem.Code != code {
+		api.t.Errorf("%s %s: got problem %d %q, want %d %q", method, path, problem.Status, problem.Code, status, code)
+	}
+	if instance, _, _ := strings.Cut(path, "?"); problem.Title != http.StatusText(status) || problem.Instance != instance || problem.RequestID == "" {
+		api.t.Errorf("%s %s: incomplete problem %+v", method, path, problem)
+	}
+	return problem
+}
+
+// createRoom creates a room as api's user
+func (api *testAPI) createRoom(name string) models.Room {
+	api.t.Helper()
+	var room models.Room
+	api.expect(http.MethodPost, "/rooms", models.CreateRoomRequest{Name: name, Building: "A", Area: 50, Occupants: 2}, http.StatusOK, &room)
+	return room
+}
+
+func TestAuthentication(t *testing.T) {
+	api := newTestAPI(t)
+
+	api.as("").expectProblem(http.MethodGet, "/rooms", nil, http.StatusUnauthorized, "unauthorized")
+	api.as("not-a-token").expectProblem(http.MethodGet, "/users/me", nil, http.StatusUnauthorized, "unauthorized")
+	api.as("").expectProblem(http.MethodPost, "/login", models.LoginRequest{Username: "admin", Password: "wrong"}, http.StatusUnauthorized, "invalid_credentials")
+
+	var auth models.AuthResponse
+	api.as("").expect(http.MethodPost, "/login", models.LoginRequest{Username: "admin", Password: "secret123"}, http.StatusOK, &auth)
+	var me models.User
+	api.as(auth.Token).expect(http.MethodGet, "/users/me", nil, http.StatusOK, &me)
+	if me.Username != "admin" {
+		t.Errorf("got user %q, want admin", me.Username)
+	}
+
+	// Revoked sessions no longer authenticate
+	var sessions models.SessionListResponse
+	api.expect(http.MethodGet, "/users/me/sessions", nil, http.StatusOK, &sessions)
+	for _, session := range sessions.Sessions {
+		if !session.Current {
+			api.expect(http.MethodDelete, "/users/me/sessions/"+session.ID.String(), nil, http.StatusNoContent, nil)
+		}
+	}
+	api.as(auth.Token).expectProblem(http.MethodGet, "/users/me", nil, http.StatusUnauthorized, "unauthorized")
+
+	// Residents may not manage rooms or see the admin endpoints
+	resident := api.as(api.register("resident"))
+	resident.expectProblem(http.MethodPost, "/rooms", models.CreateRoomRequest{Name: "Flat"}, http.StatusForbidden, "forbidden")
+	room := api.createRoom("Flat")
+	resident.expectProblem(http.MethodDelete, "/rooms/"+room.ID.String(), nil, http.StatusForbidden, "forbidden")
+	api.as("").expectProblem(http.MethodDelete, "/rooms/"+room.ID.String(), nil, http.StatusUnauthorized, "unauthorized")
+	resident.expectProblem(http.MethodGet, "/admin/audit", nil, http.StatusForbidden, "forbidden")
+}
+
+func TestRegistrationRoles(t *testing.T) {
+	api := newTestAPI(t)
+
+	// Roles cannot be asked for at registration
+	api.as("").expectProblem(http.MethodPost, "/register", map[string]interface{}{
+		"username": "mallory",
+		"password": "secret123",
+		"email":    "mallory@example.com",
+		"roles":    []string{"admin"},
+	}, http.StatusBadRequest, "unknown_field")
+
+	var auth models.AuthResponse
+	api.as("").expect(http.MethodPost, "/register", models.RegisterRequest{Username: "mallory", Password: "secret123", Email: "mallory@example.com"}, http.StatusOK, &auth)
+	if len(auth.User.Roles) != 1 || auth.User.Roles[0].Name != "user" {
+		t.Fatalf("got roles %+v, want user", auth.User.Roles)
+	}
+	mallory := api.as(auth.Token)
+	mallory.expectProblem(http.MethodGet, "/admin/backups", nil, http.StatusForbidden, "forbidden")
+	if resp := mallory.restoreSnapshot(&backup.Snapshot{}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("restore: got status %d, want 403", resp.StatusCode)
+	}
+	admin := []string{"admin"}
+	mallory.expectProblem(http.MethodPatch, "/users/me", models.UpdateUserRequest{Roles: &admin}, http.StatusForbidden, "forbidden")
+
+	// Administrators grant roles
+	var user models.User
+	api.expect(http.MethodPatch, "/users/"+auth.User.ID.String(), models.UpdateUserRequest{Roles: &admin}, http.StatusOK, &user)
+	mallory.expect(http.MethodGet, "/admin/backups", nil, http.StatusOK, nil)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	api := newTestAPI(t)
+	room := api.createRoom("Flat 1")
+	path := "/rooms/" + room.ID.String()
+	current := strconv.Quote(strconv.FormatInt(room.Version, 10))
+
+	resp := api.expect(http.MethodGet, path, nil, http.StatusOK, nil)
+	if etag := resp.Header.Get("ETag"); etag != current {
+		t.Fatalf("got ETag %q, want %q", etag, current)
+	}
+	api.expect(http.MethodGet, path, nil, http.StatusNotModified, nil, "If-None-Match", current)
+
+	name := "Flat 2"
+	var updated models.Room
+	resp = api.expect(http.MethodPatch, path, models.UpdateRoomRequest{Name: &name}, http.StatusOK, &updated, "If-Match", current)
+	if updated.Version != room.Version+1 || resp.Header.Get("ETag") == current {
+		t.Fatalf("update did not change the version: %d, ETag %s", updated.Version, resp.Header.Get("ETag"))
+	}
+
+	// The copy the client had is stale now
+	api.expectProblem(http.MethodPatch, path, models.UpdateRoomRequest{Name: &name}, http.StatusPreconditionFailed, "precondition_failed", "If-Match", current)
+	api.expectProblem(http.MethodDelete, path, nil, http.StatusPreconditionFailed, "precondition_failed", "If-Match", current)
+	api.expect(http.MethodDelete, path, nil, http.StatusOK, nil, "If-Match", "*")
+}
+
+func TestProblemErrors(t *testing.T) {
+	api := newTestAPI(t)
+
+	api.expectProblem(http.MethodGet, "/rooms/not-an-id", nil, http.StatusBadRequest, "invalid_id")
+	api.expectProblem(http.MethodGet, "/rooms/00000000-0000-0000-0000-000000000000", nil, http.StatusNotFound, "room_not_found")
+	api.expectProblem(http.MethodGet, "/no-such-route", nil, http.StatusNotFound, "route_not_found")
+
+	problem := api.expectProblem(http.MethodPost, "/rooms", models.CreateRoomRequest{Area: -1}, http.StatusBadRequest, "validation_failed")
+	if len(problem.Details) == 0 {
+		t.Error("validation problem has no details")
+	}
+
+	resp := api.do(http.MethodPost, "/rooms", nil, "Content-Type", "application/json")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Content-Type") != "application/problem+json" {
+		t.Errorf("empty body: got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestSessionAudit(t *testing.T) {
+	api := newTestAPI(t)
+	var login models.AuthResponse
+	api.as("").expect(http.MethodPost, "/login", models.LoginRequest{Username: "admin", Password: "secret123"}, http.StatusOK, &login)
+	var sessions models.SessionListResponse
+	api.as(login.Token).expect(http.MethodGet, "/users/me/sessions", nil, http.StatusOK, &sessions)
+	var id string
+	for _, session := range sessions.Sessions {
+		if session.Current {
+			id = session.ID.String()
+		}
+	}
+	api.expect(http.MethodDelete, "/users/me/sessions/"+id, nil, http.StatusNoContent, nil)
+
+	for _, action := range []string{"session.create", "session.revoke"} {
+		var entries models.AuditLogResponse
+		api.expect(http.MethodGet, "/admin/audit?target_id="+id+"&action="+action, nil, http.StatusOK, &entries)
+		if entries.Total != 1 || entries.Entries[0].Actor != "admin" || entries.Entries[0].TargetType != "session" {
+			t.Errorf("%s: got %+v, want one entry by admin", action, entries.Entries)
+		}
+	}
+}
+
+func TestAuditPagination(t *testing.T) {
+	api := newTestAPI(t)
+	for i := range 5 {
+		api.createRoom(fmt.Sprintf("Flat %d", i))
+	}
+
+	var all models.AuditLogResponse
+	api.expect(http.MethodGet, "/admin/audit?action=room.create", nil, http.StatusOK, &all)
+	if all.Total != 5 || len(all.Entries) != 5 {
+		t.Fatalf("got %d of %d entries, want 5", len(all.Entries), all.Total)
+	}
+
+	var page models.AuditLogResponse
+	api.expect(http.MethodGet, "/admin/audit?action=room.create&limit=2&offset=1", nil, http.StatusOK, &page)
+	if page.Total != 5 || len(page.Entries) != 2 {
+		t.Fatalf("got %d of %d entries, want 2 of 5", len(page.Entries), page.Total)
+	}
+	if page.Entries[0].Sequence != all.Entries[1].Sequence || page.Entries[1].Sequence != all.Entries[2].Sequence {
+		t.Error("page does not continue where the offset says")
+	}
+
+	var past models.AuditLogResponse
+	api.expect(http.MethodGet, "/admin/audit?action=room.create&offset=10", nil, http.StatusOK, &past)
+	if past.Total != 5 || len(past.Entries) != 0 {
+		t.Errorf("got %d of %d entries past the end", len(past.Entries), past.Total)
+	}
+
+	api.expectProblem(http.MethodGet, "/admin/audit?limit=0", nil, http.StatusBadRequest, "invalid_query")
+	api.expectProblem(http.MethodGet, "/admin/audit?offset=-1", nil, http.StatusBadRequest, "invalid_query")
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/backup"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+// OpenState makes the server keep its state in the file at path, loading it
+// now if the file exists. The file is written by Flush and encrypted with
+// key, a backup key, when one is given. Unlike backups it holds the
+// sessions, so that logins survive a restart.
+func (s *Server) OpenState(path string, key []byte) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+	if err == nil {
+		snap, err := backup.Decode(data, key)
+		if err != nil {
+			return fmt.Errorf("failed to load state from %s: %w", path, err)
+		}
+		s.mu.Lock()
+		s.restore(snap)
+		s.mu.Unlock()
+		s.loadSessions(snap.Sessions)
+		if s.persistOutbox {
+			s.events.LoadOutbox(snap.Outbox)
+		}
+	}
+
+	s.stateFile = path
+	s.stateKey = key
+	return nil
+}
+
+// Flush saves the state to the file given to OpenState, if any
+func (s *Server) Flush() error {
+	if s.stateFile == "" {
+		return nil
+	}
+
+	s.mu.RLock()
+	snap := s.snapshot()
+	s.mu.RUnlock()
+	snap.Sessions = s.activeSessions()
+
+	// The previous state stays in place until the new one is complete
+	tmp, err := os.CreateTemp(filepath.Dir(s.stateFile), ".state-*")
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := backup.Encode(tmp, snap, s.stateKey); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.stateFile); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+
+// activeSessions returns copies of the sessions that have not expired
+func (s *Server) activeSessions() []models.Session {
+	now := time.Now()
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+
+	var sessions []models.Session
+	for _, session := range s.sessions {
+		if now.Before(session.ExpiresAt) {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions
+}
+
+// loadSessions adds the saved sessions of users that exist and that have not
+// expired
+func (s *Server) loadSessions(sessions []models.Session) {
+	now := time.Now()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+
+	for i := range sessions {
+		session := sessions[i]
+		if s.users[session.UserID] != nil && now.Before(session.ExpiresAt) {
+			s.sessions[session.ID] = &session
+		}
+	}
+}
+
+// ScheduleFlush saves the state every interval, so that little is lost
+// should the process die without shutting down. It blocks until ctx is
+// done, so it is meant to run in its own goroutine.
+func (s *Server) ScheduleFlush(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				log.Printf("Flushing state failed: %v", err)
+			}
+		}
+	}
+}
+
+// Shutdown waits for background report and import jobs to finish and for
+// asynchronous event subscribers to handle what is queued, or for ctx to be
+// done, and then saves the state. Requests must have been drained before,
+// e.g. by http.Server.Shutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.jobs.Wait()
+		close(done)
+	}()
+
+	var errs []error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("background jobs still running: %w", ctx.Err()))
+	}
+
+	if err := s.events.Close(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	// The state is saved even if jobs are cut short, as requests have
+	// completed already
+	if err := s.Flush(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
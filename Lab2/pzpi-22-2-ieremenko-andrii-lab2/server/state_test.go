@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// currentSession returns the ID of the session api's token belongs to
+func (api *testAPI) currentSession() uuid.UUID {
+	api.t.Helper()
+	var sessions models.SessionListResponse
+	api.expect(http.MethodGet, "/users/me/sessions", nil, http.StatusOK, &sessions)
+	for _, session := range sessions.Sessions {
+		if session.Current {
+			return session.ID
+		}
+	}
+	api.t.Fatal("no current session")
+	return uuid.Nil
+}
+
+func TestStateSessions(t *testing.T) {
+	api := newTestAPI(t)
+	resident := api.as(api.register("resident"))
+
+	revoked := api.login("resident")
+	resident.expect(http.MethodDelete, "/users/me/sessions/"+api.as(revoked).currentSession().String(), nil, http.StatusNoContent, nil)
+	expired := api.login("resident")
+	id := api.as(expired).currentSession()
+	api.s.sessionMu.Lock()
+	api.s.sessions[id].ExpiresAt = time.Now().Add(-time.Minute)
+	api.s.sessionMu.Unlock()
+
+	path := filepath.Join(t.TempDir(), "state.hmbk")
+	if err := api.s.OpenState(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted server accepts the tokens of the sessions that were active
+	restarted := NewServer()
+	if err := restarted.OpenState(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(restarted.Handler())
+	defer ts.Close()
+	after := &testAPI{t: t, s: restarted, url: ts.URL}
+	after.as(api.token).expect(http.MethodGet, "/users/me", nil, http.StatusOK, nil)
+	after.as(resident.token).expect(http.MethodGet, "/users/me", nil, http.StatusOK, nil)
+	after.as(revoked).expectProblem(http.MethodGet, "/users/me", nil, http.StatusUnauthorized, "unauthorized")
+	after.as(expired).expectProblem(http.MethodGet, "/users/me", nil, http.StatusUnauthorized, "unauthorized")
+
+	// Backups do not hold sessions
+	api.s.mu.RLock()
+	snap := api.s.snapshot()
+	api.s.mu.RUnlock()
+	if len(snap.Sessions) != 0 {
+		t.Errorf("backup snapshot holds %d sessions", len(snap.Sessions))
+	}
+}
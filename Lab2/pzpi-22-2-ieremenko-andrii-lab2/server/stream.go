@@ -0,0 +1,535 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/validation"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/websocket"
+	"github.com/google/uuid"
+)
+
+const (
+	// streamBacklog is how many events are kept for clients that resume
+	// after the ID of the last event they received
+	streamBacklog = 4096
+	// streamQueue is how many events may wait for a slow client before it
+	// is disconnected; it resumes from the backlog when it reconnects
+	streamQueue = 256
+	// streamHeartbeat is how often idle streams are pinged, so that proxies
+	// keep them open and dead clients are noticed
+	streamHeartbeat = 25 * time.Second
+	// streamWriteTimeout bounds each write to a client
+	streamWriteTimeout = 10 * time.Second
+)
+
+var errStreamsClosed = errors.New("streams are closed")
+
+// streamHub fans the events of readings out to the streams subscribed to
+// them. Event IDs start at the time the hub is created, in microseconds,
+// so that they keep growing across restarts.
+type streamHub struct {
+	mu          sync.Mutex
+	nextID      uint64
+	backlog     []models.StreamEvent // ring of the latest events
+	head        int                  // index of the oldest event once the ring is full
+	subscribers map[*subscriber]bool
+	closed      bool
+}
+
+// subscriber is a stream and the metrics and rooms it follows
+type subscriber struct {
+	metrics map[uuid.UUID]bool
+	rooms   map[uuid.UUID]bool
+	// allowed applies the access restrictions of the requester to each
+	// event; it is called with the server's mu held
+	allowed func(metricID uuid.UUID) bool
+	events  chan models.StreamEvent
+	// overflowed is set when the subscriber is dropped for not keeping up
+	overflowed bool
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{
+		nextID:      uint64(time.Now().UnixMicro()),
+		backlog:     make([]models.StreamEvent, 0, streamBacklog),
+		subscribers: make(map[*subscriber]bool),
+	}
+}
+
+// newSubscriber creates a subscriber for the streaming request r
+func (s *Server) newSubscriber(r *http.Request) *subscriber {
+	return &subscriber{
+		metrics: make(map[uuid.UUID]bool),
+		rooms:   make(map[uuid.UUID]bool),
+		allowed: func(id uuid.UUID) bool { return s.metricAllowed(r, id) },
+		events:  make(chan models.StreamEvent, streamQueue),
+	}
+}
+
+func (sub *subscriber) follows(e models.StreamEvent) bool {
+	return (sub.metrics[*e.MetricID] || sub.rooms[*e.RoomID]) && sub.allowed(*e.MetricID)
+}
+
+// publish sends an event about a reading of the metric to its subscribers.
+// Subscribers whose queue is full are dropped. The caller must hold the
+// server's mu.
+func (h *streamHub) publish(typ string, metric *models.Metric, reading models.MetricReading) {
+	now := time.Now()
+	metricID, roomID := metric.ID, metric.RoomID
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	e := models.StreamEvent{ID: h.nextID, Type: typ, MetricID: &metricID, RoomID: &roomID, Reading: &reading, Time: &now}
+	h.nextID++
+	if len(h.backlog) < streamBacklog {
+		h.backlog = append(h.backlog, e)
+	} else {
+		h.backlog[h.head] = e
+		h.head = (h.head + 1) % streamBacklog
+	}
+
+	for sub := range h.subscribers {
+		if !sub.follows(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			sub.overflowed = true
+			close(sub.events)
+			delete(h.subscribers, sub)
+		}
+	}
+}
+
+// subscribe adds metrics and rooms to what sub follows, registering it if
+// needed. With lastID it returns the kept events after that one for the
+// added metrics and rooms, and whether events were missed because they are
+// no longer kept. The caller must hold the server's mu.
+func (h *streamHub) subscribe(sub *subscriber, metrics, rooms []uuid.UUID, lastID uint64) ([]models.StreamEvent, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, false, errStreamsClosed
+	}
+
+	added := &subscriber{metrics: make(map[uuid.UUID]bool), rooms: make(map[uuid.UUID]bool), allowed: sub.allowed}
+	for _, id := range metrics {
+		if !sub.metrics[id] {
+			added.metrics[id], sub.metrics[id] = true, true
+		}
+	}
+	for _, id := range rooms {
+		if !sub.rooms[id] {
+			added.rooms[id], sub.rooms[id] = true, true
+		}
+	}
+	h.subscribers[sub] = true
+
+	if lastID == 0 {
+		return nil, false, nil
+	}
+	oldest := h.nextID
+	if len(h.backlog) > 0 {
+		oldest = h.backlog[h.head].ID
+	}
+	gap := lastID+1 < oldest || lastID >= h.nextID
+
+	var replay []models.StreamEvent
+	for i := range h.backlog {
+		e := h.backlog[(h.head+i)%len(h.backlog)]
+		if e.ID > lastID && added.follows(e) {
+			replay = append(replay, e)
+		}
+	}
+	return replay, gap, nil
+}
+
+// unsubscribe removes metrics and rooms from what sub follows
+func (h *streamHub) unsubscribe(sub *subscriber, metrics, rooms []uuid.UUID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, id := range metrics {
+		delete(sub.metrics, id)
+	}
+	for _, id := range rooms {
+		delete(sub.rooms, id)
+	}
+}
+
+// remove drops sub once its stream ends
+func (h *streamHub) remove(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, sub)
+}
+
+// close ends every stream
+func (h *streamHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for sub := range h.subscribers {
+		close(sub.events)
+	}
+	h.subscribers = nil
+}
+
+// CloseStreams ends the reading streams, so that shutdown does not wait for
+// clients that never disconnect
+func (s *Server) CloseStreams() {
+	s.stream.close()
+}
+
+// withQueryToken lets streaming requests authenticate with an access_token
+// query parameter, since browsers cannot set headers on EventSource and
+// WebSocket connections
+func withQueryToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("access_token")
+		streaming := websocket.IsWebSocket(r) || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+		if token != "" && streaming && r.Method == http.MethodGet && r.Header.Get("Authorization") == "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StreamReadings godoc
+// @Summary Stream the readings of a metric
+// @Description Follow the readings of a metric as Server-Sent Events as they are recorded or corrected. Each event has an ID; a client that reconnects with Last-Event-ID gets the events it missed while they are kept, or a gap event when some are gone. Clients that do not keep up are sent an error event with code slow_consumer and disconnected, and resume by reconnecting. Browsers may pass the token as access_token.
+// @Tags metrics
+// @Produce text/event-stream
+// @Param id path string true "Metric ID"
+// @Param Last-Event-ID header string false "ID of the last event received"
+// @Param last_event_id query string false "ID of the last event received, for clients that cannot set headers"
+// @Param access_token query string false "Token, for clients that cannot set headers"
+// @Success 200 {object} models.StreamEvent
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 503 {object} models.Problem
+// @Router /metrics/{id}/stream [get]
+func (s *Server) StreamReadings(w http.ResponseWriter, r *http.Request) {
+	sub, replay, gap, ok := s.subscribeMetric(w, r)
+	if !ok {
+		return
+	}
+	defer s.stream.remove(sub)
+
+	rc := http.NewResponseController(w)
+	write := func(e models.StreamEvent) error {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := writeEvent(w, e); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if gap {
+		if write(models.StreamEvent{Type: models.StreamGap, Message: "Some events are no longer kept; fetch the readings to catch up"}) != nil {
+			return
+		}
+	}
+	for _, e := range replay {
+		if write(e) != nil {
+			return
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case e, ok := <-sub.events:
+			if !ok {
+				write(streamEnd(sub))
+				return
+			}
+			if write(e) != nil {
+				return
+			}
+		}
+	}
+}
+
+// subscribeMetric authorizes a stream of the metric of the request and
+// subscribes to it, writing an error response if that fails
+func (s *Server) subscribeMetric(w http.ResponseWriter, r *http.Request) (*subscriber, []models.StreamEvent, bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, err := s.currentUser(r); err != nil {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return nil, nil, false, false
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid metric ID")
+		return nil, nil, false, false
+	}
+	if !s.requireMetricAccess(w, r, id) {
+		return nil, nil, false, false
+	}
+	if _, exists := s.activeMetric(id); !exists {
+		writeError(w, r, http.StatusNotFound, "metric_not_found", "Metric not found")
+		return nil, nil, false, false
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var last uint64
+	if lastID != "" {
+		if last, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_event_id", "Invalid last event ID")
+			return nil, nil, false, false
+		}
+	}
+
+	sub := s.newSubscriber(r)
+	replay, gap, err := s.stream.subscribe(sub, []uuid.UUID{id}, nil, last)
+	if err != nil {
+		writeError(w, r, http.StatusServiceUnavailable, "shutting_down", "The server is shutting down")
+		return nil, nil, false, false
+	}
+	return sub, replay, gap, true
+}
+
+// StreamWebSocket godoc
+// @Summary Stream readings over a WebSocket
+// @Description Upgrade to a WebSocket on which the client subscribes to metrics and rooms by sending {"action": "subscribe", "metric_ids": [...], "room_ids": [...], "last_event_id": 42} and unsubscribes likewise. Each change is confirmed with a subscribed message listing what is followed; a request that cannot be granted is answered with an error message and changes nothing. Events, their IDs, resuming and slow clients are as for the Server-Sent Events stream; a slow client is closed with code 1013. Browsers may pass the token as access_token.
+// @Tags metrics
+// @Param access_token query string false "Token, for clients that cannot set headers"
+// @Success 101 {object} models.StreamEvent
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Router /metrics/stream [get]
+func (s *Server) StreamWebSocket(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	_, err := s.currentUser(r)
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+	if !websocket.IsWebSocket(r) {
+		writeError(w, r, http.StatusBadRequest, "websocket_required", "Connect with a WebSocket")
+		return
+	}
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_handshake", err.Error())
+		return
+	}
+	defer conn.Close(websocket.CloseGoingAway, "")
+
+	sub := s.newSubscriber(r)
+	defer s.stream.remove(sub)
+
+	write := func(e models.StreamEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		return conn.WriteMessage(websocket.TextMessage, data)
+	}
+
+	// Requests are read on their own goroutine but applied here, so that
+	// events replayed for a subscription come before live ones
+	requests := make(chan models.StreamEvent)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(requests)
+		for {
+			req, err := readStreamRequest(conn)
+			var invalid *invalidStreamRequest
+			if err != nil && !errors.As(err, &invalid) {
+				return
+			}
+			e := models.StreamEvent{Type: req.Action, MetricIDs: req.MetricIDs, RoomIDs: req.RoomIDs, ID: req.LastEventID}
+			if invalid != nil {
+				e = models.StreamEvent{Type: models.StreamError, Code: "invalid_request", Message: invalid.message}
+			}
+			select {
+			case requests <- e:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				return
+			}
+			for _, e := range s.applyStreamRequest(r, sub, req) {
+				if write(e) != nil {
+					return
+				}
+			}
+		case <-heartbeat.C:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if conn.Ping() != nil {
+				return
+			}
+		case e, ok := <-sub.events:
+			if !ok {
+				end := streamEnd(sub)
+				write(end)
+				if sub.overflowed {
+					conn.Close(websocket.CloseTryAgainLater, end.Code)
+				}
+				return
+			}
+			if write(e) != nil {
+				return
+			}
+		}
+	}
+}
+
+// invalidStreamRequest is a message of a WebSocket client that is not a
+// valid request; the client is told and the stream goes on
+type invalidStreamRequest struct {
+	message string
+}
+
+func (e *invalidStreamRequest) Error() string {
+	return "invalid stream request: " + e.message
+}
+
+// readStreamRequest reads the next request of a WebSocket client
+func readStreamRequest(conn *websocket.Conn) (models.StreamRequest, error) {
+	var req models.StreamRequest
+	typ, data, err := conn.ReadMessage()
+	if err != nil {
+		return req, err
+	}
+	if typ != websocket.TextMessage {
+		return req, &invalidStreamRequest{"requests are JSON text messages"}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, &invalidStreamRequest{err.Error()}
+	}
+	if err := validation.Struct(req); err != nil {
+		return req, &invalidStreamRequest{err.Error()}
+	}
+	return req, nil
+}
+
+// applyStreamRequest changes the subscriptions of a WebSocket as asked by
+// req, a request or an error reading one, and returns the messages to send
+// in reply
+func (s *Server) applyStreamRequest(r *http.Request, sub *subscriber, req models.StreamEvent) []models.StreamEvent {
+	if req.Type == models.StreamError {
+		return []models.StreamEvent{req}
+	}
+	if req.Type == "unsubscribe" {
+		s.stream.unsubscribe(sub, req.MetricIDs, req.RoomIDs)
+		return []models.StreamEvent{subscribedEvent(s.stream, sub)}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, id := range req.MetricIDs {
+		if !s.metricAllowed(r, id) {
+			return []models.StreamEvent{{Type: models.StreamError, Code: "forbidden", Message: fmt.Sprintf("Not allowed to access metric %s", id)}}
+		}
+		if _, exists := s.activeMetric(id); !exists {
+			return []models.StreamEvent{{Type: models.StreamError, Code: "metric_not_found", Message: fmt.Sprintf("Metric %s not found", id)}}
+		}
+	}
+	for _, id := range req.RoomIDs {
+		if _, exists := s.activeRoom(id); !exists {
+			return []models.StreamEvent{{Type: models.StreamError, Code: "room_not_found", Message: fmt.Sprintf("Room %s not found", id)}}
+		}
+	}
+
+	replay, gap, err := s.stream.subscribe(sub, req.MetricIDs, req.RoomIDs, req.ID)
+	if err != nil {
+		return []models.StreamEvent{{Type: models.StreamError, Code: "shutting_down", Message: "The server is shutting down"}}
+	}
+	var out []models.StreamEvent
+	if gap {
+		out = append(out, models.StreamEvent{Type: models.StreamGap, Message: "Some events are no longer kept; fetch the readings to catch up"})
+	}
+	out = append(out, replay...)
+	return append(out, subscribedEvent(s.stream, sub))
+}
+
+// subscribedEvent lists what sub follows
+func subscribedEvent(h *streamHub, sub *subscriber) models.StreamEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e := models.StreamEvent{Type: models.StreamSubscribed, MetricIDs: make([]uuid.UUID, 0, len(sub.metrics)), RoomIDs: make([]uuid.UUID, 0, len(sub.rooms))}
+	for id := range sub.metrics {
+		e.MetricIDs = append(e.MetricIDs, id)
+	}
+	for id := range sub.rooms {
+		e.RoomIDs = append(e.RoomIDs, id)
+	}
+	return e
+}
+
+// streamEnd is the last message of a stream the hub ended
+func streamEnd(sub *subscriber) models.StreamEvent {
+	if sub.overflowed {
+		return models.StreamEvent{Type: models.StreamError, Code: "slow_consumer", Message: "Events were not read fast enough; reconnect to resume"}
+	}
+	return models.StreamEvent{Type: models.StreamError, Code: "shutting_down", Message: "The server is shutting down"}
+}
+
+// writeEvent writes an event in the Server-Sent Events format
+func writeEvent(w io.Writer, e models.StreamEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if e.ID != 0 {
+		fmt.Fprintf(&b, "id: %d\n", e.ID)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", e.Type, data)
+	_, err = w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// testSubscriber returns a subscriber allowed to follow every metric
+func testSubscriber() *subscriber {
+	return &subscriber{
+		metrics: make(map[uuid.UUID]bool),
+		rooms:   make(map[uuid.UUID]bool),
+		allowed: func(uuid.UUID) bool { return true },
+		events:  make(chan models.StreamEvent, streamQueue),
+	}
+}
+
+// publishReadings publishes n readings of the metric and returns the IDs of
+// their events
+func publishReadings(h *streamHub, metric *models.Metric, n int) []uint64 {
+	var ids []uint64
+	for i := range n {
+		ids = append(ids, h.nextID)
+		h.publish(models.StreamReading, metric, models.MetricReading{ID: uuid.New(), MetricID: metric.ID, Value: float64(i)})
+	}
+	return ids
+}
+
+func TestStreamHubReplay(t *testing.T) {
+	h := newStreamHub()
+	metric := &models.Metric{ID: uuid.New(), RoomID: uuid.New()}
+	other := &models.Metric{ID: uuid.New(), RoomID: uuid.New()}
+	ids := publishReadings(h, metric, 3)
+	publishReadings(h, other, 2)
+
+	tests := []struct {
+		name          string
+		metrics       []uuid.UUID
+		rooms         []uuid.UUID
+		lastID        uint64
+		wantIDs       []uint64
+		wantGap       bool
+		wantFollowing bool
+	}{
+		{"without a last ID", []uuid.UUID{metric.ID}, nil, 0, nil, false, true},
+		{"after the first event", []uuid.UUID{metric.ID}, nil, ids[0], ids[1:], false, true},
+		{"by room", nil, []uuid.UUID{metric.RoomID}, ids[1], ids[2:], false, true},
+		{"after the latest event", []uuid.UUID{metric.ID}, nil, h.nextID - 1, nil, false, true},
+		{"just before the backlog", []uuid.UUID{metric.ID}, nil, ids[0] - 1, ids, false, true},
+		{"before the backlog", []uuid.UUID{metric.ID}, nil, ids[0] - 2, ids, true, true},
+		{"from another hub", []uuid.UUID{metric.ID}, nil, h.nextID + 100, nil, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := testSubscriber()
+			replay, gap, err := h.subscribe(sub, tt.metrics, tt.rooms, tt.lastID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []uint64
+			for _, e := range replay {
+				got = append(got, e.ID)
+			}
+			if gap != tt.wantGap || len(got) != len(tt.wantIDs) {
+				t.Fatalf("got events %v and gap %t, want %v and %t", got, gap, tt.wantIDs, tt.wantGap)
+			}
+			for i := range got {
+				if got[i] != tt.wantIDs[i] {
+					t.Errorf("got events %v, want %v", got, tt.wantIDs)
+					break
+				}
+			}
+			if h.subscribers[sub] != tt.wantFollowing {
+				t.Errorf("got subscribed %t", h.subscribers[sub])
+			}
+		})
+	}
+
+	// Only metrics added by the subscription are replayed
+	sub := testSubscriber()
+	h.subscribe(sub, []uuid.UUID{metric.ID}, nil, 0)
+	replay, _, _ := h.subscribe(sub, []uuid.UUID{metric.ID, other.ID}, nil, ids[0])
+	for _, e := range replay {
+		if *e.MetricID != other.ID {
+			t.Errorf("got event %d of a metric followed before", e.ID)
+		}
+	}
+
+	// Once the backlog wraps around, the oldest events are gone
+	publishReadings(h, metric, streamBacklog)
+	if _, gap, _ := h.subscribe(testSubscriber(), []uuid.UUID{metric.ID}, nil, ids[2]); !gap {
+		t.Error("got no gap after the backlog wrapped around")
+	}
+	oldest := h.backlog[h.head].ID
+	replay, gap, _ := h.subscribe(testSubscriber(), []uuid.UUID{other.ID, metric.ID}, nil, oldest-1)
+	if gap || len(replay) != streamBacklog || replay[0].ID != oldest {
+		t.Errorf("got %d events from %d and gap %t, want the %d kept from %d", len(replay), replay[0].ID, gap, streamBacklog, oldest)
+	}
+}
+
+func TestStreamHubSlowConsumer(t *testing.T) {
+	h := newStreamHub()
+	metric := &models.Metric{ID: uuid.New(), RoomID: uuid.New()}
+	slow := testSubscriber()
+	h.subscribe(slow, []uuid.UUID{metric.ID}, nil, 0)
+	other := testSubscriber()
+	h.subscribe(other, []uuid.UUID{uuid.New()}, nil, 0)
+
+	publishReadings(h, metric, streamQueue)
+	if slow.overflowed || !h.subscribers[slow] {
+		t.Fatal("dropped a subscriber whose queue is just full")
+	}
+	publishReadings(h, metric, 1)
+	if !slow.overflowed || h.subscribers[slow] {
+		t.Fatal("kept a subscriber whose queue overflowed")
+	}
+	if !h.subscribers[other] || other.overflowed {
+		t.Error("dropped a subscriber following another metric")
+	}
+
+	// What was queued is still read, then the stream ends
+	for range streamQueue {
+		if _, ok := <-slow.events; !ok {
+			t.Fatal("queued events were lost")
+		}
+	}
+	if _, ok := <-slow.events; ok {
+		t.Fatal("got more events than were queued")
+	}
+	if end := streamEnd(slow); end.Code != "slow_consumer" {
+		t.Errorf("got end %+v, want slow_consumer", end)
+	}
+
+	h.close()
+	if _, ok := <-other.events; ok || other.overflowed {
+		t.Error("the stream of the other subscriber did not end")
+	}
+	if end := streamEnd(other); end.Code != "shutting_down" {
+		t.Errorf("got end %+v, want shutting_down", end)
+	}
+	if _, _, err := h.subscribe(testSubscriber(), nil, nil, 0); err != errStreamsClosed {
+		t.Errorf("subscribing once closed: got %v", err)
+	}
+}
+
+func TestStreamForbidden(t *testing.T) {
+	api := newTestAPI(t)
+	allowed := api.createMetric("Allowed")
+	other := api.createMetric("Other")
+	key := api.createDevice("SN-1", allowed.ID)
+	api.s.mu.RLock()
+	device := api.s.deviceBySerial("SN-1")
+	api.s.mu.RUnlock()
+
+	requests := map[string]*http.Request{
+		"gateway": httptest.NewRequest(http.MethodGet, "/metrics/stream", nil).WithContext(
+			context.WithValue(context.Background(), gatewayKey, &gateway{metrics: map[uuid.UUID]bool{allowed.ID: true}})),
+		"device": httptest.NewRequest(http.MethodGet, "/metrics/stream", nil).WithContext(
+			context.WithValue(context.Background(), deviceCredentialKey, deviceCredential{deviceID: device.ID})),
+	}
+	for name, r := range requests {
+		t.Run(name, func(t *testing.T) {
+			sub := api.s.newSubscriber(r)
+			subscribe := func(metrics, rooms []uuid.UUID) models.StreamEvent {
+				out := api.s.applyStreamRequest(r, sub, models.StreamEvent{Type: "subscribe", MetricIDs: metrics, RoomIDs: rooms})
+				return out[len(out)-1]
+			}
+
+			if e := subscribe([]uuid.UUID{allowed.ID, other.ID}, nil); e.Type != models.StreamError || e.Code != "forbidden" {
+				t.Fatalf("got %+v, want forbidden", e)
+			}
+			if e := subscribe([]uuid.UUID{allowed.ID}, []uuid.UUID{other.RoomID}); e.Type != models.StreamSubscribed || len(e.MetricIDs) != 1 || len(e.RoomIDs) != 1 {
+				t.Fatalf("got %+v, want the allowed metric and the room followed", e)
+			}
+
+			// Following a room does not let readings of other metrics through
+			api.s.mu.RLock()
+			api.s.stream.publish(models.StreamReading, api.s.metrics[other.ID], models.MetricReading{MetricID: other.ID})
+			api.s.stream.publish(models.StreamReading, api.s.metrics[allowed.ID], models.MetricReading{MetricID: allowed.ID})
+			api.s.mu.RUnlock()
+			if e := <-sub.events; *e.MetricID != allowed.ID {
+				t.Errorf("got an event of metric %s", *e.MetricID)
+			}
+			if len(sub.events) != 0 {
+				t.Errorf("got %d more events, want none", len(sub.events))
+			}
+			api.s.stream.remove(sub)
+		})
+	}
+
+	// Over HTTP, gateways are refused streams of other metrics, and devices
+	// any stream
+	api.s.SetGateways(map[string][]uuid.UUID{"gw-1": {allowed.ID}})
+	rec := gatewayRequest(api.s.Handler(), http.MethodGet, "/metrics/"+other.ID.String()+"/stream", "gw-1")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("gateway: got status %d, want 403", rec.Code)
+	}
+	api.as(key.Key).expectProblem(http.MethodGet, "/metrics/"+allowed.ID.String()+"/stream", nil, http.StatusForbidden, "forbidden")
+}
+
+func TestStreamResume(t *testing.T) {
+	api := newTestAPI(t)
+	metric := api.createMetric("Meter")
+	path := "/metrics/" + metric.ID.String()
+	for _, value := range []float64{1, 2, 3} {
+		api.expect(http.MethodPost, path+"/readings", models.AddReadingRequest{Value: &value}, http.StatusOK, nil)
+	}
+	api.s.stream.mu.Lock()
+	first := api.s.stream.backlog[0].ID
+	api.s.stream.mu.Unlock()
+
+	// stream connects after lastID and returns the first n events
+	stream := func(lastID uint64, n int) []models.StreamEvent {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, api.url+path+"/stream", nil)
+		req.Header.Set("Authorization", "Bearer "+api.token)
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(lastID, 10))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var events []models.StreamEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for len(events) < n && scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var e models.StreamEvent
+				if err := json.Unmarshal([]byte(data), &e); err != nil {
+					t.Fatal(err)
+				}
+				events = append(events, e)
+			}
+		}
+		if len(events) < n {
+			t.Fatalf("got %d events, want %d: %v", len(events), n, scanner.Err())
+		}
+		return events
+	}
+
+	events := stream(first, 2)
+	if events[0].ID != first+1 || events[1].ID != first+2 || events[1].Reading.Value != 3 {
+		t.Errorf("got %+v, want the two readings after the first", events)
+	}
+	events = stream(first-2, 2)
+	if events[0].Type != models.StreamGap || events[1].ID != first {
+		t.Errorf("got %+v, want a gap, then the kept readings", events)
+	}
+
+	api.expectProblem(http.MethodGet, path+"/stream", nil, http.StatusBadRequest, "invalid_event_id", "Last-Event-ID", "last")
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/mbus"
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// telegramWindow is how long a received telegram is remembered; meters
+// send again every few minutes at most, with a new access number
+const telegramWindow = 10 * time.Minute
+
+// ReceiveTelegram godoc
+// @Summary Record readings from an M-Bus telegram
+// @Description Decode a wireless M-Bus/OMS or wired M-Bus telegram forwarded by a gateway and record its current values as readings of the metrics whose meter_serial is the meter's identification number. Each metric gets the first current value whose unit converts to the metric's unit. Encrypted telegrams are decrypted with the OMS key of the registered device with that serial. A telegram received again within ten minutes, e.g. through another gateway, is not recorded twice.
+// @Tags metrics
+// @Accept json
+// @Produce json
+// @Param request body models.TelegramRequest true "Telegram"
+// @Success 200 {object} models.TelegramResponse
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Router /metrics/telegrams [post]
+func (s *Server) ReceiveTelegram(w http.ResponseWriter, r *http.Request) {
+	user, err := s.currentUser(r)
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	var req models.TelegramRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	telegram, err := mbus.DecodeHex(req.Telegram, s.omsKey)
+	switch {
+	case errors.Is(err, mbus.ErrNoKey):
+		writeError(w, r, http.StatusBadRequest, "oms_key_missing", "Telegram is encrypted and no device with an OMS key is registered for the meter")
+		return
+	case errors.Is(err, mbus.ErrDecryption):
+		writeError(w, r, http.StatusBadRequest, "decryption_failed", "Telegram does not decrypt with the OMS key of the meter")
+		return
+	case err != nil:
+		writeError(w, r, http.StatusBadRequest, "invalid_telegram", err.Error())
+		return
+	}
+
+	metrics := s.metricsBySerial(telegram.ID)
+	if len(metrics) == 0 {
+		writeError(w, r, http.StatusNotFound, "unknown_meter", fmt.Sprintf("No metric is measured by meter %s", telegram.ID))
+		return
+	}
+	for _, metric := range metrics {
+		if !s.requireMetricAccess(w, r, metric.ID) {
+			return
+		}
+	}
+
+	response := telegramResponse(telegram)
+	now := time.Now()
+	key := telegramKey(req.Telegram)
+	if s.seenTelegram(key, now) {
+		response.Duplicate = true
+		writeJSON(w, response)
+		return
+	}
+
+	timestamp := req.ReceivedAt
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+
+	// Every reading goes through the checks of AddReading before any is
+	// stored, so that a rejected telegram records nothing and may be sent
+	// again
+	var readings []*models.MetricReading
+	var records []int
+	for _, metric := range metrics {
+		i, value, ok := currentValue(telegram.Records, metric.Unit)
+		if !ok {
+			response.Unmatched = append(response.Unmatched, metric.ID)
+			continue
+		}
+		reading, err := s.newReading(metric.ID, models.AddReadingRequest{Value: &value, Timestamp: timestamp})
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_reading", err.Error())
+			return
+		}
+		readings = append(readings, reading)
+		records = append(records, i)
+	}
+
+	for j, reading := range readings {
+		s.storeReading(reading, sourceTelegram)
+		s.recordAudit(r, user, "reading.create", "reading", reading.ID.String(), nil, reading)
+
+		i := records[j]
+		response.Records[i].MetricIDs = append(response.Records[i].MetricIDs, reading.MetricID)
+		response.Readings = append(response.Readings, *reading)
+	}
+	s.rememberTelegram(key, now)
+
+	s.touchDevice(r)
+	for _, device := range s.devices {
+		if device.Serial == telegram.ID {
+			device.LastSeenAt = &now
+		}
+	}
+
+	writeJSON(w, response)
+}
+
+// omsKey returns the OMS key of the registered device with the meter's
+// serial; the caller must hold mu. Devices do not record their
+// manufacturer, so the serial alone identifies the meter.
+func (s *Server) omsKey(_, id string) []byte {
+	for _, device := range s.devices {
+		if device.Serial == id && device.OMSKey != "" {
+			key, _ := hex.DecodeString(device.OMSKey)
+			return key
+		}
+	}
+	return nil
+}
+
+// metricsBySerial returns the metrics measured by the meter with the serial
+// number, oldest first; the caller must hold mu
+func (s *Server) metricsBySerial(serial string) []*models.Metric {
+	var metrics []*models.Metric
+	for _, metric := range s.metrics {
+		if metric.DeletedAt == nil && metric.MeterSerial == serial {
+			metrics = append(metrics, metric)
+		}
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].CreatedAt.Before(metrics[j].CreatedAt)
+	})
+	return metrics
+}
+
+// telegramKey returns what a telegram is remembered by: its hex, without
+// spaces, in lower case
+func telegramKey(telegram string) string {
+	return strings.ToLower(strings.Join(strings.Fields(telegram), ""))
+}
+
+// seenTelegram reports whether the telegram with the key was recorded
+// within telegramWindow, forgetting those recorded before; the caller must
+// hold mu exclusively
+func (s *Server) seenTelegram(key string, now time.Time) bool {
+	for k, at := range s.telegrams {
+		if now.Sub(at) > telegramWindow {
+			delete(s.telegrams, k)
+		}
+	}
+	_, seen := s.telegrams[key]
+	return seen
+}
+
+// rememberTelegram records that the telegram with the key was recorded, so
+// that it is not again within telegramWindow; the caller must hold mu
+// exclusively
+func (s *Server) rememberTelegram(key string, now time.Time) {
+	s.telegrams[key] = now
+}
+
+// currentValue returns the index and value, converted to unit, of the
+// first record holding a current value in a unit that converts to unit
+func currentValue(records []mbus.Record, unit string) (int, float64, bool) {
+	for i, record := range records {
+		if record.Function != mbus.Instantaneous || record.Storage != 0 || record.Tariff != 0 || record.Subunit != 0 || record.Qualified {
+			continue
+		}
+		if value, ok := record.Convert(unit); ok {
+			return i, value, true
+		}
+	}
+	return 0, 0, false
+}
+
+// telegramResponse describes a decoded telegram
+func telegramResponse(t *mbus.Telegram) models.TelegramResponse {
+	response := models.TelegramResponse{
+		Manufacturer: t.Manufacturer,
+		Serial:       t.ID,
+		Version:      int(t.Version),
+		DeviceType:   t.DeviceType.String(),
+		AccessNumber: int(t.AccessNumber),
+		Status:       int(t.Status),
+		Encrypted:    t.Encrypted,
+		Records:      make([]models.TelegramRecord, len(t.Records)),
+		Readings:     make([]models.MetricReading, 0),
+		Unmatched:    make([]uuid.UUID, 0),
+	}
+	for i, record := range t.Records {
+		r := models.TelegramRecord{
+			Quantity: record.Quantity,
+			Unit:     record.Unit,
+			Function: string(record.Function),
+			Storage:  record.Storage,
+			Tariff:   record.Tariff,
+			Subunit:  record.Subunit,
+		}
+		if record.Time.IsZero() {
+			r.Value = &record.Value
+		} else {
+			r.Time = &record.Time
+		}
+		response.Records[i] = r
+	}
+	return response
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+)
+
+// waterTelegram is an unencrypted wireless telegram of a water meter
+// 12345678 with a volume of 12345.678 m3
+const waterTelegram = "10442D2C7856341233 07780C1378563412"
+
+func TestReceiveTelegram(t *testing.T) {
+	api := newTestAPI(t)
+	req := models.TelegramRequest{Telegram: waterTelegram}
+
+	// A rejected telegram is not remembered, so it is recorded once sent
+	// again
+	api.expectProblem(http.MethodPost, "/metrics/telegrams", req, http.StatusNotFound, "unknown_meter")
+
+	room := api.createRoom("Flat")
+	var water, power models.Metric
+	api.expect(http.MethodPost, "/metrics", models.CreateMetricRequest{Name: "Water", Unit: "l", Kind: "water", MeterSerial: "12345678", RoomID: room.ID}, http.StatusOK, &water)
+	api.expect(http.MethodPost, "/metrics", models.CreateMetricRequest{Name: "Power", Unit: "kWh", Kind: "electricity", MeterSerial: "12345678", RoomID: room.ID}, http.StatusOK, &power)
+
+	var response models.TelegramResponse
+	api.expect(http.MethodPost, "/metrics/telegrams", req, http.StatusOK, &response)
+	if response.Duplicate || len(response.Readings) != 1 || response.Readings[0].Value != 12345678 {
+		t.Fatalf("got %+v, want 12345678 l recorded", response)
+	}
+	if len(response.Unmatched) != 1 || response.Unmatched[0] != power.ID {
+		t.Errorf("got unmatched %v, want the power metric", response.Unmatched)
+	}
+	if ids := response.Records[0].MetricIDs; len(ids) != 1 || ids[0] != water.ID {
+		t.Errorf("got the record recorded to %v, want the water metric", ids)
+	}
+
+	// The same telegram through another gateway is recorded once
+	api.expect(http.MethodPost, "/metrics/telegrams", models.TelegramRequest{Telegram: "10442d2c78563412330778 0c1378563412"}, http.StatusOK, &response)
+	if !response.Duplicate || len(response.Readings) != 0 {
+		t.Errorf("got %+v, want a duplicate", response)
+	}
+	if readings := currentReadings(api.s, water.ID); len(readings) != 1 {
+		t.Errorf("got %d readings, want 1", len(readings))
+	}
+
+	// Once the window has passed, it is recorded again
+	api.s.mu.Lock()
+	for key := range api.s.telegrams {
+		api.s.telegrams[key] = time.Now().Add(-telegramWindow - time.Second)
+	}
+	api.s.mu.Unlock()
+	api.expect(http.MethodPost, "/metrics/telegrams", req, http.StatusOK, &response)
+	if response.Duplicate || len(currentReadings(api.s, water.ID)) != 2 {
+		t.Errorf("got %+v, want it recorded after the window", response)
+	}
+}
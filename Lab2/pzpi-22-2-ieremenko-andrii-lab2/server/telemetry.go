@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/prom"
+)
+
+// telemetry holds the operational metrics the observability listener
+// exposes. The collectors have locks of their own, so recording does not
+// need mu.
+type telemetry struct {
+	requests    *prom.Histogram // request durations by method, route and status
+	ingested    *prom.Counter   // readings stored, by how they arrived
+	remoteWrite *prom.Counter   // remote write samples, by what became of them
+}
+
+func newTelemetry() *telemetry {
+	return &telemetry{
+		requests: prom.NewHistogram("hm_http_request_duration_seconds", "Time taken to serve API requests.",
+			prom.DefaultBuckets, "method", "route", "code"),
+		ingested: prom.NewCounter("hm_readings_ingested_total", "Readings stored, by the path they arrived on.",
+			"source"),
+		remoteWrite: prom.NewCounter("hm_remote_write_samples_total", "Samples received by remote write, by result.",
+			"result"),
+	}
+}
+
+// Sources of ingested readings
+const (
+	sourceAPI         = "api"
+	sourceImport      = "import"
+	sourceMQTT        = "mqtt"
+	sourceModbus      = "modbus"
+	sourceTelegram    = "telegram"
+	sourceRemoteWrite = "remote_write"
+)
+
+// withTelemetry records how long each request to mux takes. Requests are
+// labelled by the route pattern they matched rather than their path, so
+// that IDs do not make a series each.
+func (s *Server) withTelemetry(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		method, route := "other", "unmatched"
+		if _, pattern := mux.Handler(r); pattern != "" {
+			method = r.Method
+			if _, path, ok := strings.Cut(pattern, " "); ok {
+				route = path
+			} else {
+				route = pattern
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		s.telemetry.requests.Observe(time.Since(start).Seconds(), method, route, strconv.Itoa(rec.status))
+	})
+}
+
+// statusRecorder remembers the status of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if !sr.wroteHeader {
+		sr.status, sr.wroteHeader = status, true
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	sr.wroteHeader = true
+	return sr.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/andrii/apz-pzpi-22-2-ieremenko-andrii/Lab2/pzpi-22-2-ieremenko-andrii-lab2/models"
+	"github.com/google/uuid"
+)
+
+// SetTrashRetention sets how long deleted rooms and metrics are kept before
+// they are purged
+func (s *Server) SetTrashRetention(retention time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trashRetention = retention
+}
+
+// ListTrash godoc
+// @Summary List the trash
+// @Description Get deleted rooms and metrics that can still be restored
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {object} models.TrashResponse
+// @Failure 403 {object} models.Problem
+// @Router /admin/trash [get]
+func (s *Server) ListTrash(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireAdmin(w, r); !ok {
+		return
+	}
+
+	response := models.TrashResponse{
+		Rooms:   make([]models.TrashedRoom, 0),
+		Metrics: make([]models.TrashedMetric, 0),
+	}
+	for _, room := range s.rooms {
+		if room.DeletedAt != nil {
+			response.Rooms = append(response.Rooms, models.TrashedRoom{
+				Room:    *room,
+				PurgeAt: room.DeletedAt.Add(s.trashRetention),
+			})
+		}
+	}
+	for _, metric := range s.metrics {
+		if metric.DeletedAt != nil {
+			response.Metrics = append(response.Metrics, models.TrashedMetric{
+				Metric:   *metric,
+				Readings: len(s.readings[metric.ID]),
+				PurgeAt:  metric.DeletedAt.Add(s.trashRetention),
+			})
+		}
+	}
+
+	sort.Slice(response.Rooms, func(i, j int) bool {
+		return response.Rooms[i].DeletedAt.After(*response.Rooms[j].DeletedAt)
+	})
+	sort.Slice(response.Metrics, func(i, j int) bool {
+		return response.Metrics[i].DeletedAt.After(*response.Metrics[j].DeletedAt)
+	})
+
+	writeJSON(w, response)
+}
+
+// RestoreRoom godoc
+// @Summary Restore a deleted room
+// @Description Restore a room from the trash together with the metrics deleted along with it and all their readings
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Room ID"
+// @Success 200 {object} models.Room
+// @Failure 404 {object} models.Problem
+// @Router /admin/trash/rooms/{id}/restore [post]
+func (s *Server) RestoreRoom(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid room ID")
+		return
+	}
+
+	room, exists := s.rooms[id]
+	if !exists || room.DeletedAt == nil {
+		writeError(w, r, http.StatusNotFound, "not_in_trash", "Room not found in trash")
+		return
+	}
+
+	// Metrics deleted separately before the room stay in the trash
+	deletedAt := *room.DeletedAt
+	for _, metric := range s.metrics {
+		if metric.RoomID == id && metric.DeletedAt != nil && metric.DeletedAt.Equal(deletedAt) {
+			metric.DeletedAt = nil
+			metric.Version++
+		}
+	}
+
+	before := *room
+	room.DeletedAt = nil
+	room.Version++
+	s.recordAudit(r, user, "room.restore", "room", id.String(), before, room)
+
+	setETag(w, room.Version)
+	writeJSON(w, room)
+}
+
+// RestoreMetric godoc
+// @Summary Restore a deleted metric
+// @Description Restore a metric from the trash with all its readings. The room of the metric must not be in the trash.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "Metric ID"
+// @Success 200 {object} models.Metric
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Router /admin/trash/metrics/{id}/restore [post]
+func (s *Server) RestoreMetric(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	idStr := r.PathValue("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_id", "Invalid metric ID")
+		return
+	}
+
+	metric, exists := s.metrics[id]
+	if !exists || metric.DeletedAt == nil {
+		writeError(w, r, http.StatusNotFound, "not_in_trash", "Metric not found in trash")
+		return
+	}
+
+	if _, exists := s.activeRoom(metric.RoomID); !exists {
+		writeError(w, r, http.StatusConflict, "room_deleted", "The room of this metric is deleted; restore the room first")
+		return
+	}
+
+	before := *metric
+	metric.DeletedAt = nil
+	metric.Version++
+	s.recordAudit(r, user, "metric.restore", "metric", id.String(), before, metric)
+
+	setETag(w, metric.Version)
+	writeJSON(w, metric)
+}
+
+// PurgeTrash permanently deletes rooms and metrics, with their readings,
+// that have been in the trash for longer than the retention window
+func (s *Server) PurgeTrash() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-s.trashRetention)
+	for id, metric := range s.metrics {
+		if metric.DeletedAt == nil || metric.DeletedAt.After(cutoff) {
+			continue
+		}
+		s.appendAudit(models.AuditEntry{
+			Actor:      "system",
+			Action:     "metric.purge",
+			TargetType: "metric",
+			TargetID:   id.String(),
+		}, map[string]interface{}{"metric": metric, "readings": len(s.readings[id])}, nil)
+		delete(s.metrics, id)
+		delete(s.readings, id)
+		delete(s.rollups, id)
+		for policyID, policy := range s.retention {
+			if policy.MetricID != nil && *policy.MetricID == id {
+				delete(s.retention, policyID)
+			}
+		}
+
+		// Devices cannot record readings of a purged metric
+		for _, device := range s.devices {
+			device.MetricIDs = slices.DeleteFunc(device.MetricIDs, func(m uuid.UUID) bool { return m == id })
+		}
+	}
+
+	for id, room := range s.rooms {
+		if room.DeletedAt == nil || room.DeletedAt.After(cutoff) {
+			continue
+		}
+
+		// Never leave metrics pointing at a purged room
+		inUse := false
+		for _, metric := range s.metrics {
+			if metric.RoomID == id {
+				inUse = true
+				break
+			}
+		}
+		if inUse {
+			continue
+		}
+
+		s.appendAudit(models.AuditEntry{
+			Actor:      "system",
+			Action:     "room.purge",
+			TargetType: "room",
+			TargetID:   id.String(),
+		}, room, nil)
+		delete(s.rooms, id)
+	}
+}
+
+// SchedulePurge purges the trash every interval. It blocks until ctx is
+// done, so it is meant to run in its own goroutine.
+func (s *Server) SchedulePurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.PurgeTrash()
+		}
+	}
+}
@@ -0,0 +1,376 @@
+// Package validation checks request payloads against the rules in their
+// binding struct tags, e.g. `binding:"required,email"`.
+//
+// Supported rules:
+//
+//	required      the field must not be empty; pointers must not be nil
+//	email         a plain email address, such as john@example.com
+//	min=N, max=N  bounds on numbers, and on the length of strings and slices
+//	oneof=a b c   one of the space separated values
+//	uuid          a string holding a UUID
+//
+// Rules other than required are not applied to empty fields, so optional
+// fields can carry them. Pointer fields that are set are always checked.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// FieldError describes a field that failed a rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Errors lists every field of a payload that failed validation
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Field + " " + fe.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	uuidType = reflect.TypeOf(uuid.UUID{})
+)
+
+// Struct validates v, a struct or a pointer to one. It returns nil or
+// Errors listing every field that failed. It panics if the binding tags of
+// v are invalid, which CheckTags reports as an error instead.
+func Struct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs Errors
+	validateStruct(rv, "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// CheckTags parses the binding tags of v, a struct or a pointer to one, and
+// of the structs it nests, returning an error describing the first invalid
+// tag: an unknown rule, a bad parameter, or a rule that does not apply to
+// the type of its field. Tests call it on every payload type, so that such
+// mistakes fail them rather than requests.
+func CheckTags(v interface{}) error {
+	rt := reflect.TypeOf(v)
+	for rt != nil && rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+	if rt == nil || rt.Kind() != reflect.Struct {
+		return nil
+	}
+	return checkTags(rt, make(map[reflect.Type]bool))
+}
+
+// checkTags checks the tags of rt and the structs it nests, skipping those
+// already seen
+func checkTags(rt reflect.Type, seen map[reflect.Type]bool) error {
+	if seen[rt] {
+		return nil
+	}
+	seen[rt] = true
+	rules := rulesOf(rt)
+	if rules.err != nil {
+		return rules.err
+	}
+	for _, field := range rules.fields {
+		if field.nested != nil {
+			if err := checkTags(field.nested, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rule is a single rule of a binding tag
+type rule struct {
+	name  string
+	param string
+	limit float64 // of min and max
+}
+
+// fieldRules are the rules a field of a struct is checked against
+type fieldRules struct {
+	index     int
+	name      string // in JSON
+	anonymous bool
+	rules     []rule
+	nested    reflect.Type // of the struct the field holds or points to, if any
+}
+
+// structRules are the parsed binding tags of a struct type
+type structRules struct {
+	fields []fieldRules
+	err    error // of the first invalid tag
+}
+
+// typeRules caches the rules of each struct type, so that tags are parsed
+// and checked once per type rather than on every request
+var typeRules sync.Map // reflect.Type -> *structRules
+
+// rulesOf returns the rules of a struct type
+func rulesOf(rt reflect.Type) *structRules {
+	if cached, ok := typeRules.Load(rt); ok {
+		return cached.(*structRules)
+	}
+	cached, _ := typeRules.LoadOrStore(rt, parseStruct(rt))
+	return cached.(*structRules)
+}
+
+// parseStruct parses the binding tags of the fields of rt
+func parseStruct(rt reflect.Type) *structRules {
+	rules := &structRules{}
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := fieldName(field)
+		if name == "-" {
+			continue
+		}
+
+		fr := fieldRules{index: i, name: name, anonymous: field.Anonymous}
+		if tag := field.Tag.Get("binding"); tag != "" {
+			parsed, err := parseTag(field.Type, tag)
+			if err != nil {
+				rules.err = fmt.Errorf("validation: %s.%s: %w", rt, field.Name, err)
+				return rules
+			}
+			fr.rules = parsed
+		}
+
+		// Nested payloads are validated too
+		t := field.Type
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct && t != timeType {
+			fr.nested = t
+		}
+		rules.fields = append(rules.fields, fr)
+	}
+	return rules
+}
+
+// parseTag parses the rules of a binding tag on a field of type t
+func parseTag(t reflect.Type, tag string) ([]rule, error) {
+	// Rules apply to what pointers point to
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var rules []rule
+	for _, part := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+		r := rule{name: name, param: param}
+		switch name {
+		case "required":
+		case "email":
+			if t.Kind() != reflect.String {
+				return nil, fmt.Errorf("email does not apply to %s", t)
+			}
+		case "uuid":
+			if t != uuidType && t.Kind() != reflect.String {
+				return nil, fmt.Errorf("uuid does not apply to %s", t)
+			}
+		case "min", "max":
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s parameter %q", name, param)
+			}
+			if !measurable(t.Kind()) {
+				return nil, fmt.Errorf("%s does not apply to %s", name, t)
+			}
+			r.limit = limit
+		case "oneof":
+			if len(strings.Fields(param)) == 0 {
+				return nil, fmt.Errorf("oneof needs values")
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule %q", name)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// validateStruct checks the fields of rv, naming them by their JSON names
+// under prefix
+func validateStruct(rv reflect.Value, prefix string, errs *Errors) {
+	rules := rulesOf(rv.Type())
+	if rules.err != nil {
+		panic(rules.err.Error())
+	}
+	for _, field := range rules.fields {
+		name := field.name
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		value := rv.Field(field.index)
+		if len(field.rules) > 0 {
+			validateField(value, name, field.rules, errs)
+		}
+
+		if field.nested == nil {
+			continue
+		}
+		for value.Kind() == reflect.Ptr && !value.IsNil() {
+			value = value.Elem()
+		}
+		if value.Kind() == reflect.Struct {
+			nested := name
+			if field.anonymous {
+				nested = prefix
+			}
+			validateStruct(value, nested, errs)
+		}
+	}
+}
+
+// fieldName returns the name of a field in JSON
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// validateField applies the rules of a field to its value
+func validateField(value reflect.Value, name string, rules []rule, errs *Errors) {
+	// Pointers mark optional fields: nil is missing, anything else is checked
+	var empty bool
+	if value.Kind() == reflect.Ptr {
+		empty = value.IsNil()
+		if !empty {
+			value = value.Elem()
+		}
+	} else {
+		empty = isEmpty(value)
+	}
+
+	for _, r := range rules {
+		if r.name == "required" {
+			if empty {
+				*errs = append(*errs, FieldError{Field: name, Rule: r.name, Message: "is required"})
+				return
+			}
+			continue
+		}
+		if empty {
+			continue
+		}
+
+		if message, ok := check(value, r); !ok {
+			*errs = append(*errs, FieldError{Field: name, Rule: r.name, Message: message})
+		}
+	}
+}
+
+// isEmpty reports whether a value counts as missing for the required rule
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	default:
+		return value.IsZero()
+	}
+}
+
+// check applies a single rule other than required, returning a message
+// describing the failure. parseTag made sure the rule applies to the value.
+func check(value reflect.Value, r rule) (string, bool) {
+	switch r.name {
+	case "email":
+		s := value.String()
+		addr, err := mail.ParseAddress(s)
+		if err != nil || addr.Address != s {
+			return "must be a valid email address", false
+		}
+
+	case "uuid":
+		if value.Type() == uuidType {
+			return "", true
+		}
+		if _, err := uuid.Parse(value.String()); err != nil {
+			return "must be a valid UUID", false
+		}
+
+	case "min", "max":
+		n, unit := measure(value)
+		if r.name == "min" && n < r.limit {
+			return "must be at least " + r.param + unit, false
+		}
+		if r.name == "max" && n > r.limit {
+			return "must be at most " + r.param + unit, false
+		}
+
+	case "oneof":
+		allowed := strings.Fields(r.param)
+		s := fmt.Sprint(value.Interface())
+		for _, a := range allowed {
+			if s == a {
+				return "", true
+			}
+		}
+		return "must be one of: " + strings.Join(allowed, ", "), false
+	}
+	return "", true
+}
+
+// measurable reports whether min and max apply to values of a kind
+func measurable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// measure returns what min and max compare: the value of numbers and the
+// length of strings, slices and maps, with the unit to report it in
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	}
+	return value.Float(), ""
+}
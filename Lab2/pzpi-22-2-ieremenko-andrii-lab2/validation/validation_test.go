@@ -0,0 +1,192 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// Place is nested and embedded by the payloads of the tests
+type Place struct {
+	City string `json:"city" binding:"required"`
+}
+
+type base struct {
+	Kind string `json:"kind" binding:"omitted,oneof=a b"`
+}
+
+// payload exercises every rule
+type payload struct {
+	Name     string    `json:"name" binding:"required,min=2,max=5"`
+	Email    string    `json:"email" binding:"email"`
+	Age      *int      `json:"age" binding:"min=0,max=150"`
+	Note     *string   `json:"note" binding:"required,max=3"`
+	Tags     []string  `json:"tags" binding:"required,max=2"`
+	Role     string    `json:"role" binding:"oneof=admin user"`
+	Level    int       `json:"level" binding:"oneof=1 2"`
+	RoomID   string    `json:"room_id" binding:"uuid"`
+	MetricID uuid.UUID `json:"metric_id" binding:"required,uuid"`
+	Address  Place     `json:"address"`
+	Billing  *Place    `json:"billing"`
+	Ignored  string    `json:"-" binding:"required"`
+	internal string    `binding:"required"`
+}
+
+// valid returns a payload that passes every rule
+func valid() payload {
+	age, note := 30, "hi"
+	return payload{
+		Name:     "John",
+		Age:      &age,
+		Note:     &note,
+		Tags:     []string{"a"},
+		MetricID: uuid.New(),
+		Address:  Place{City: "Kharkiv"},
+	}
+}
+
+func TestStruct(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(p *payload)
+		want   string // field that fails, with its rule; empty if none
+	}{
+		{"valid", func(p *payload) {}, ""},
+		{"required string", func(p *payload) { p.Name = "" }, "name required"},
+		{"min length", func(p *payload) { p.Name = "J" }, "name min"},
+		{"min counts characters", func(p *payload) { p.Name = "Їж" }, ""},
+		{"max length", func(p *payload) { p.Name = "Johnny" }, "name max"},
+		{"email", func(p *payload) { p.Email = "john@example.com" }, ""},
+		{"invalid email", func(p *payload) { p.Email = "john" }, "email email"},
+		{"email with a name", func(p *payload) { p.Email = "John <john@example.com>" }, "email email"},
+		{"nil pointer is optional", func(p *payload) { p.Age = nil }, ""},
+		{"zero pointer is checked", func(p *payload) { zero := 0; p.Age = &zero }, ""},
+		{"min number", func(p *payload) { n := -1; p.Age = &n }, "age min"},
+		{"max number", func(p *payload) { n := 151; p.Age = &n }, "age max"},
+		{"required pointer", func(p *payload) { p.Note = nil }, "note required"},
+		{"required pointer to empty", func(p *payload) { empty := ""; p.Note = &empty }, ""},
+		{"required slice", func(p *payload) { p.Tags = []string{} }, "tags required"},
+		{"max items", func(p *payload) { p.Tags = []string{"a", "b", "c"} }, "tags max"},
+		{"oneof", func(p *payload) { p.Role = "user" }, ""},
+		{"not oneof", func(p *payload) { p.Role = "root" }, "role oneof"},
+		{"oneof number", func(p *payload) { p.Level = 3 }, "level oneof"},
+		{"uuid string", func(p *payload) { p.RoomID = uuid.NewString() }, ""},
+		{"invalid uuid", func(p *payload) { p.RoomID = "room" }, "room_id uuid"},
+		{"required uuid", func(p *payload) { p.MetricID = uuid.Nil }, "metric_id required"},
+		{"nested", func(p *payload) { p.Address.City = "" }, "address.city required"},
+		{"nested pointer", func(p *payload) { p.Billing = &Place{} }, "billing.city required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid()
+			tt.change(&p)
+			err := Struct(&p)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("got %v", err)
+				}
+				return
+			}
+			errs, ok := err.(Errors)
+			if !ok || len(errs) != 1 || errs[0].Field+" "+errs[0].Rule != tt.want {
+				t.Fatalf("got %v, want %s to fail", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStructErrors(t *testing.T) {
+	// Every failing field is listed, required stopping the other rules
+	err := Struct(payload{Name: "", Role: "root", Tags: []string{"a"}})
+	errs, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("got %v", err)
+	}
+	var got []string
+	for _, fe := range errs {
+		got = append(got, fe.Field+" "+fe.Rule)
+	}
+	want := "name required, note required, role oneof, metric_id required, address.city required"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, ", "), want)
+	}
+	if msg := errs[2].Message; msg != "must be one of: admin, user" {
+		t.Errorf("got message %q", msg)
+	}
+
+	// Anything but a struct is not validated
+	var nilPayload *payload
+	for _, v := range []interface{}{nil, nilPayload, "text"} {
+		if err := Struct(v); err != nil {
+			t.Errorf("%v: got %v", v, err)
+		}
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	type embedding struct {
+		Base struct {
+			Kind string `json:"kind" binding:"oneof=a b"`
+		} `json:"base"`
+		Place
+	}
+	var v embedding
+	v.Base.Kind = "c"
+	errs, _ := Struct(v).(Errors)
+	if len(errs) != 2 || errs[0].Field != "base.kind" || errs[1].Field != "city" {
+		t.Errorf("got %v, want base.kind and the embedded city to fail", errs)
+	}
+}
+
+func TestCheckTags(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string // in the error; empty if none
+	}{
+		{"valid", &payload{}, ""},
+		{"not a struct", 1, ""},
+		{"unknown rule", base{}, `unknown rule "omitted"`},
+		{"nested", struct {
+			Base *base `json:"base"`
+		}{}, "base.Kind"},
+		{"bad parameter", struct {
+			N int `binding:"min=one"`
+		}{}, `invalid min parameter "one"`},
+		{"min on a bool", struct {
+			B bool `binding:"min=1"`
+		}{}, "min does not apply to bool"},
+		{"email on a number", struct {
+			N *int `binding:"email"`
+		}{}, "email does not apply to int"},
+		{"uuid on a number", struct {
+			N int `binding:"uuid"`
+		}{}, "uuid does not apply to int"},
+		{"oneof without values", struct {
+			S string `binding:"oneof="`
+		}{}, "oneof needs values"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckTags(tt.v)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+
+	// Struct panics on invalid tags rather than letting requests through
+	defer func() {
+		if recover() == nil {
+			t.Error("Struct did not panic on an unknown rule")
+		}
+	}()
+	Struct(base{Kind: "a"})
+}
@@ -0,0 +1,215 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// frame encodes a frame as a client sends it, masked unless unmasked
+func frame(fin bool, op byte, payload []byte, unmasked ...bool) []byte {
+	b := []byte{op}
+	if fin {
+		b[0] |= 0x80
+	}
+	maskBit := byte(0x80)
+	if len(unmasked) > 0 && unmasked[0] {
+		maskBit = 0
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b = append(b, maskBit|byte(n))
+	case n <= 0xFFFF:
+		b = append(b, maskBit|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b = append(b, maskBit|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	if maskBit == 0 {
+		return append(b, payload...)
+	}
+	mask := []byte{1, 2, 3, 4}
+	b = append(b, mask...)
+	for i, c := range payload {
+		b = append(b, c^mask[i%4])
+	}
+	return b
+}
+
+// concat joins frames
+func concat(frames ...[]byte) []byte {
+	return bytes.Join(frames, nil)
+}
+
+// closePayload encodes the payload of a close frame
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// pipe returns a connection reading what the client sends, and a channel
+// receiving what it wrote back once it is closed
+func pipe(t *testing.T, client []byte) (*Conn, <-chan []byte) {
+	t.Helper()
+	server, peer := net.Pipe()
+	t.Cleanup(func() { server.Close() })
+	go func() {
+		peer.Write(client)
+	}()
+	written := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(peer)
+		written <- data
+	}()
+	return &Conn{conn: server, br: bufio.NewReader(server), MaxMessageSize: 1024}, written
+}
+
+func TestReadMessage(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 300)
+	tests := []struct {
+		name      string
+		client    []byte
+		wantType  int
+		want      []byte
+		wantClose int    // code of the CloseError returned
+		wantReply []byte // frame written back first
+	}{
+		{"text", frame(true, TextMessage, []byte("hello")), TextMessage, []byte("hello"), 0, nil},
+		{"binary", frame(true, BinaryMessage, []byte{0, 1}), BinaryMessage, []byte{0, 1}, 0, nil},
+		{"16-bit length", frame(true, TextMessage, long), TextMessage, long, 0, nil},
+		{"fragmented", concat(frame(false, TextMessage, []byte("hel")), frame(true, opContinuation, []byte("lo"))), TextMessage, []byte("hello"), 0, nil},
+		{"ping between fragments", concat(frame(false, TextMessage, []byte("hel")), frame(true, opPing, []byte("p")), frame(true, opContinuation, []byte("lo"))), TextMessage, []byte("hello"), 0, []byte{0x80 | opPong, 1, 'p'}},
+		{"pong ignored", concat(frame(true, opPong, nil), frame(true, TextMessage, []byte("a"))), TextMessage, []byte("a"), 0, nil},
+		{"close", frame(true, opClose, closePayload(CloseNormal, "bye")), 0, nil, CloseNormal, []byte{0x80 | opClose, 2, 0x03, 0xE8}},
+		{"close without code", frame(true, opClose, nil), 0, nil, CloseNormal, nil},
+		{"unmasked", frame(true, TextMessage, []byte("a"), true), 0, nil, CloseProtocolError, nil},
+		{"reserved bits", append([]byte{0x80 | 0x40 | TextMessage}, frame(true, TextMessage, nil)[1:]...), 0, nil, CloseProtocolError, nil},
+		{"continuation first", frame(true, opContinuation, []byte("a")), 0, nil, CloseProtocolError, nil},
+		{"message inside a message", concat(frame(false, TextMessage, []byte("a")), frame(true, TextMessage, []byte("b"))), 0, nil, CloseProtocolError, nil},
+		{"unknown opcode", frame(true, 3, nil), 0, nil, CloseProtocolError, nil},
+		{"long control frame", frame(true, opPing, long[:126]), 0, nil, CloseProtocolError, nil},
+		{"fragmented control frame", frame(false, opPing, nil), 0, nil, CloseProtocolError, nil},
+		{"frame too big", frame(true, TextMessage, bytes.Repeat(long, 4)), 0, nil, CloseTooBig, nil},
+		{"message too big", concat(frame(false, TextMessage, long), frame(false, opContinuation, long), frame(false, opContinuation, long), frame(true, opContinuation, long)), 0, nil, CloseTooBig, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, written := pipe(t, tt.client)
+			typ, data, err := conn.ReadMessage()
+			conn.conn.Close()
+
+			var closeErr *CloseError
+			switch {
+			case tt.wantClose != 0:
+				if !errors.As(err, &closeErr) || closeErr.Code != tt.wantClose {
+					t.Fatalf("got %v, want close code %d", err, tt.wantClose)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case typ != tt.wantType || !bytes.Equal(data, tt.want):
+				t.Fatalf("got %d %q, want %d %q", typ, data, tt.wantType, tt.want)
+			}
+
+			reply := <-written
+			if tt.wantReply != nil && !bytes.HasPrefix(reply, tt.wantReply) {
+				t.Errorf("wrote %x, want it to start with %x", reply, tt.wantReply)
+			}
+			// Protocol errors are answered with a close frame of their code
+			if tt.wantClose != 0 && tt.wantReply == nil && len(reply) > 0 {
+				if len(reply) < 4 || reply[0] != 0x80|opClose || int(binary.BigEndian.Uint16(reply[2:4])) != tt.wantClose {
+					t.Errorf("wrote %x, want a close frame with code %d", reply, tt.wantClose)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	tests := []struct {
+		size   int
+		header []byte
+	}{
+		{0, []byte{0x81, 0}},
+		{125, []byte{0x81, 125}},
+		{126, []byte{0x81, 126, 0, 126}},
+		{0xFFFF, []byte{0x81, 126, 0xFF, 0xFF}},
+		{0x10000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		conn, written := pipe(t, nil)
+		payload := bytes.Repeat([]byte("x"), tt.size)
+		if err := conn.WriteMessage(TextMessage, payload); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close(CloseNormal, "")
+		data := <-written
+		if !bytes.HasPrefix(data, tt.header) || !bytes.Equal(data[len(tt.header):len(tt.header)+tt.size], payload) {
+			t.Errorf("size %d: got header %x, want %x", tt.size, data[:min(len(data), 10)], tt.header)
+		}
+		// Nothing is sent once the close frame is
+		if err := conn.WriteMessage(TextMessage, nil); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("size %d: writing after close: got %v", tt.size, err)
+		}
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	upgraded := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		upgraded <- err
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		conn.Close(CloseNormal, "")
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		ok      bool
+	}{
+		{"handshake", map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}, true},
+		{"not a websocket", map[string]string{"Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}, false},
+		{"old version", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}, false},
+		{"no key", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if err := <-upgraded; (err == nil) != tt.ok {
+				t.Fatalf("got %v", err)
+			}
+			if !tt.ok {
+				if resp.StatusCode != http.StatusBadRequest {
+					t.Errorf("got status %d, want 400", resp.StatusCode)
+				}
+				return
+			}
+			// The accept value of the example in RFC 6455
+			if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+				t.Errorf("got status %d, accept %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+			}
+			if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+				t.Errorf("got Upgrade %q", resp.Header.Get("Upgrade"))
+			}
+		})
+	}
+}